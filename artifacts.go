@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version is the version of the installer, set at build time with
+// -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// artifactRunID is the ID of the run report of this process, written into
+// the header of the generated manifests.
+var artifactRunID string
+
+const (
+	artifactHeaderPrefix = "# Generated by odfdr-installer"
+	// artifactIndexFileName lists the files written by the installer to the
+	// current directory, so that they can be cleaned up later.
+	artifactIndexFileName = ".odfdr-installer-artifacts"
+)
+
+var artifactIndexMu sync.Mutex
+
+func artifactHeader(clusterName string) string {
+	var header strings.Builder
+	fmt.Fprintf(&header, "%s %s\n", artifactHeaderPrefix, version)
+	fmt.Fprintf(&header, "# time: %s\n", time.Now().Format(time.RFC3339))
+	if clusterName != "" {
+		fmt.Fprintf(&header, "# cluster: %s\n", clusterName)
+	}
+	if artifactRunID != "" {
+		fmt.Fprintf(&header, "# run: %s\n", artifactRunID)
+	}
+
+	return header.String()
+}
+
+// writeArtifact writes a manifest with a comment header that records where it
+// came from. JSON has no comments, .json manifests are written without the
+// header so that they stay valid JSON for jq and other tools, and are only
+// found by the artifact index.
+func writeArtifact(clusterName, fileName string, data []byte) error {
+	if isJSONFile(fileName) {
+		return writeRawArtifact(clusterName, fileName, data)
+	}
+
+	header := []byte(artifactHeader(clusterName))
+	return writeRawArtifact(clusterName, fileName, append(header, data...))
+}
+
+func isJSONFile(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".json")
+}
+
+// writeRawArtifact writes a file that is read by other tools as is, e.g. a
+// pull secret or a report, and can not have a header.
+func writeRawArtifact(clusterName, fileName string, data []byte) error {
+	if err := writePrivateFile(fileName, data); err != nil {
+		return err
+	}
+
+	return recordArtifact(clusterName, fileName)
+}
+
+// recordArtifact adds a file or directory written by the installer, or by oc
+// on its behalf, to the artifact index, and restricts it to the user.
+func recordArtifact(clusterName, fileName string) error {
+	if err := makePrivate(fileName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error restricting permissions of %s: %v", fileName, err)
+	}
+
+	artifactIndexMu.Lock()
+	defer artifactIndexMu.Unlock()
+
+	entries, err := readArtifactIndex()
+	if err != nil {
+		return err
+	}
+
+	entry := artifactEntry{cluster: clusterName, fileName: fileName}
+	if slices.Contains(entries, entry) {
+		return nil
+	}
+
+	file, err := openPrivateFile(artifactIndexFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("error opening artifact index: %v", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\n", clusterName, fileName)
+	if err != nil {
+		return fmt.Errorf("error writing artifact index: %v", err)
+	}
+
+	return nil
+}
+
+type artifactEntry struct {
+	cluster  string
+	fileName string
+}
+
+func readArtifactIndex() ([]artifactEntry, error) {
+	file, err := os.Open(artifactIndexFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening artifact index: %v", err)
+	}
+	defer file.Close()
+
+	entries := []artifactEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		cluster, fileName, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok || fileName == "" {
+			continue
+		}
+
+		entry := artifactEntry{cluster: cluster, fileName: fileName}
+		if !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading artifact index: %v", err)
+	}
+
+	return entries, nil
+}
+
+// readArtifactHeader returns the cluster of a file with an artifact header.
+func readArtifactHeader(fileName string) (string, bool) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), artifactHeaderPrefix) {
+		return "", false
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+
+		if cluster, ok := strings.CutPrefix(line, "# cluster: "); ok {
+			return cluster, true
+		}
+	}
+
+	return "", true
+}
+
+// findArtifacts returns the artifacts in the index and the files of the
+// current directory with an artifact header, e.g. when the index was removed.
+func findArtifacts() ([]artifactEntry, error) {
+	entries, err := readArtifactIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading current directory: %v", err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() {
+			continue
+		}
+
+		cluster, ok := readArtifactHeader(dirEntry.Name())
+		if !ok {
+			continue
+		}
+
+		alreadyIndexed := slices.ContainsFunc(entries, func(e artifactEntry) bool { return e.fileName == dirEntry.Name() })
+		if !alreadyIndexed {
+			entries = append(entries, artifactEntry{cluster: cluster, fileName: dirEntry.Name()})
+		}
+	}
+
+	return entries, nil
+}
+
+func runCleanArtifacts(args []string) {
+	flags := flag.NewFlagSet("clean-artifacts", flag.ExitOnError)
+	clusterFlag := flags.String("cluster", "", "Only remove the artifacts of this cluster")
+	dryRunFlag := flags.Bool("dry-run", false, "Only list the artifacts that would be removed")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	entries, err := findArtifacts()
+	if err != nil {
+		slog.Error("error finding artifacts", "error", err)
+		os.Exit(1)
+	}
+
+	kept := []artifactEntry{}
+	for _, entry := range entries {
+		if *clusterFlag != "" && entry.cluster != *clusterFlag {
+			kept = append(kept, entry)
+			continue
+		}
+
+		if _, err := os.Stat(entry.fileName); os.IsNotExist(err) {
+			continue
+		}
+
+		if *dryRunFlag {
+			fmt.Println(entry.fileName)
+			kept = append(kept, entry)
+			continue
+		}
+
+		if err := os.RemoveAll(entry.fileName); err != nil {
+			slog.Error("error removing artifact", "file", entry.fileName, "error", err)
+			kept = append(kept, entry)
+			continue
+		}
+
+		slog.Info("removed artifact", "file", entry.fileName, "cluster", entry.cluster)
+	}
+
+	if *dryRunFlag {
+		return
+	}
+
+	if len(kept) == 0 {
+		err = os.Remove(artifactIndexFileName)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("error removing artifact index", "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	var index strings.Builder
+	for _, entry := range kept {
+		fmt.Fprintf(&index, "%s\t%s\n", entry.cluster, entry.fileName)
+	}
+
+	err = writePrivateFile(artifactIndexFileName, []byte(index.String()))
+	if err != nil {
+		slog.Error("error writing artifact index", "error", err)
+		os.Exit(1)
+	}
+}
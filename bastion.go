@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func addBastionFlag(flags *flag.FlagSet) *string {
+	return flags.String("ssh-bastion", "", "SSH jump host in user@host[:port] form used to reach the cluster API")
+}
+
+// startBastionProxy opens a SOCKS proxy through the SSH bastion and routes all
+// oc commands of this process through it.
+func startBastionProxy(bastion string) error {
+	if err := checkCommandExists("ssh"); err != nil {
+		return err
+	}
+
+	destination, port := bastion, ""
+	if i := strings.LastIndex(bastion, ":"); i != -1 {
+		destination, port = bastion[:i], bastion[i+1:]
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("error finding a free local port: %v", err)
+	}
+	proxyAddr := listener.Addr().String()
+	listener.Close()
+
+	sshArgs := []string{"-D", proxyAddr, "-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=30"}
+	if port != "" {
+		sshArgs = append(sshArgs, "-p", port)
+	}
+	// Running cat remotely keeps the tunnel open for as long as its stdin is
+	// open, which is until this process exits, however it exits.
+	sshArgs = append(sshArgs, destination, "cat")
+
+	sshCmd := exec.Command("ssh", sshArgs...)
+	sshCmd.Stderr = os.Stderr
+	if _, err := sshCmd.StdinPipe(); err != nil {
+		return fmt.Errorf("error creating SSH stdin: %v", err)
+	}
+
+	slog.Info("starting SSH tunnel", "bastion", bastion, "proxy", proxyAddr)
+
+	err = sshCmd.Start()
+	if err != nil {
+		return fmt.Errorf("error starting SSH tunnel: %v", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- sshCmd.Wait()
+	}()
+
+	err = waitFor("", "SSH tunnel to "+bastion, 30*time.Second, time.Second, func() (bool, error) {
+		select {
+		case err := <-exited:
+			return false, fmt.Errorf("SSH tunnel exited: %v", err)
+		default:
+		}
+
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	proxyURL := "socks5://" + proxyAddr
+	os.Setenv("HTTPS_PROXY", proxyURL)
+	os.Setenv("HTTP_PROXY", proxyURL)
+
+	return nil
+}
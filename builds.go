@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultCatalogRepository = "quay.io/rhceph-dev/ocs-registry"
+
+type quayTag struct {
+	Name           string `json:"name"`
+	StartTS        int64  `json:"start_ts"`
+	ManifestDigest string `json:"manifest_digest"`
+}
+
+type quayTagList struct {
+	Tags          []quayTag `json:"tags"`
+	HasAdditional bool      `json:"has_additional"`
+}
+
+// queryQuayTags returns the active tags of a quay repository matching the
+// query parameters.
+func queryQuayTags(repository, token, query string) (*quayTagList, error) {
+	host, path, ok := strings.Cut(repository, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid repository %q, expected <registry>/<namespace>/<name>", repository)
+	}
+
+	url := fmt.Sprintf("https://%s/api/v1/repository/%s/tag/?onlyActiveTags=true&%s", host, path, query)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating registry request: %v", err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying registry: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tagList quayTagList
+	err = json.NewDecoder(resp.Body).Decode(&tagList)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error querying registry: %s", resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing registry response: %v", err)
+	}
+
+	return &tagList, nil
+}
+
+// listBuilds returns the tags of a quay repository that contain filter, newest
+// first, stopping once limit tags have been found.
+func listBuilds(repository, token, filter string, limit int) ([]quayTag, error) {
+	tags := []quayTag{}
+
+	for page := 1; len(tags) < limit; page++ {
+		tagList, err := queryQuayTags(repository, token, fmt.Sprintf("limit=100&page=%d", page))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range tagList.Tags {
+			if strings.Contains(tag.Name, filter) {
+				tags = append(tags, tag)
+			}
+		}
+
+		if !tagList.HasAdditional {
+			break
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].StartTS > tags[j].StartTS
+	})
+
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, nil
+}
+
+func runListBuilds(args []string) {
+	flags := flag.NewFlagSet("list-builds", flag.ExitOnError)
+	repositoryFlag := flags.String("repository", defaultCatalogRepository, "Catalog image repository to list")
+	tokenFlag := flags.String("token", os.Getenv("QUAY_TOKEN"), "Quay API token for private repositories (default: $QUAY_TOKEN)")
+	filterFlag := flags.String("filter", "", "Only list tags containing this text, e.g. 4.19")
+	limitFlag := flags.Int("limit", 20, "Maximum number of builds to list")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	tags, err := listBuilds(*repositoryFlag, *tokenFlag, *filterFlag, *limitFlag)
+	if err != nil {
+		slog.Error("error listing builds", "error", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tCREATED\tCATALOG IMAGE")
+	for _, tag := range tags {
+		created := time.Unix(tag.StartTS, 0).Format(time.DateTime)
+		fmt.Fprintf(w, "%s\t%s\t%s:%s\n", tag.Name, created, *repositoryFlag, tag.Name)
+	}
+	w.Flush()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+type catalogSource struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Status struct {
+		ConnectionState struct {
+			LastObservedState string `json:"lastObservedState"`
+		} `json:"connectionState"`
+	} `json:"status"`
+}
+
+// catalogConfig tunes the CatalogSource of the installer.
+type catalogConfig struct {
+	// PollInterval makes OLM poll the catalog image for a new digest, like
+	// 15m, for catalogs whose tags are moved to new builds. Without it, the
+	// registry keeps serving the image it started with.
+	PollInterval string `json:"pollInterval,omitempty"`
+	// Priority orders the CatalogSource against the other catalogs
+	// offering the same packages, higher first.
+	Priority *int `json:"priority,omitempty"`
+}
+
+func (c *catalogConfig) validate() error {
+	if c.PollInterval != "" {
+		interval, err := time.ParseDuration(c.PollInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid catalog poll interval %q, expected e.g. 15m", c.PollInterval)
+		}
+	}
+
+	return nil
+}
+
+// setCatalogSpecField sets a field of the CatalogSource spec, replacing the
+// field if it is already present. The value is written in JSON flow style,
+// which is valid YAML.
+func setCatalogSpecField(catalogSourceYAML, key string, value any) string {
+	return manifest.SetYAMLField(catalogSourceYAML, "spec", key, value)
+}
+
+// catalogSourceName returns the name of the CatalogSource manifest.
+func catalogSourceName(catalogSourceYAML string) string {
+	inMetadata := false
+	for _, line := range strings.Split(catalogSourceYAML, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			inMetadata = line == "metadata:"
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "  name:"); ok && inMetadata {
+			return strings.TrimSpace(name)
+		}
+	}
+
+	return ""
+}
+
+// getCatalogSource returns the live CatalogSource of a manifest file.
+func getCatalogSource(kconfig, catalogSourceFileName string) (*catalogSource, bool, error) {
+	var catalog catalogSource
+	found, err := getJSON(kconfig, &catalog, "-f", catalogSourceFileName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &catalog, found, nil
+}
+
+// waitForCatalogSourceReady waits for the CatalogSource to report READY. It
+// follows the status of the CatalogSource rather than its registry pod, which
+// OLM recreates when a node reboots, e.g. while the mirror sets roll out.
+func waitForCatalogSourceReady(kconfig, catalogSourceFileName string, timeout time.Duration) error {
+	return waitAcrossReboots(kconfig, "CatalogSource to be READY", timeout, 10*time.Second, func() (bool, error) {
+		catalog, found, err := getCatalogSource(kconfig, catalogSourceFileName)
+		if err != nil || !found {
+			return false, err
+		}
+
+		return catalog.Status.ConnectionState.LastObservedState == "READY", nil
+	})
+}
+
+// recreateCatalogSource deletes the CatalogSource, waits for its registry pod
+// to go away and creates it again, waiting for the new registry to be served.
+func recreateCatalogSource(clusterName, kconfig, catalogSourceYAML string) error {
+	catalogSourceFileName, err := writeCatalogSource(clusterName, catalogSourceYAML)
+	if err != nil {
+		return err
+	}
+
+	catalog, found, err := getCatalogSource(kconfig, catalogSourceFileName)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		slog.Info("deleting CatalogSource", "name", catalog.Metadata.Name)
+
+		deleteCmd := ocCommand(kconfig, "delete", "-f", catalogSourceFileName, "--ignore-not-found", "--wait=true")
+		err = deleteCmd.Run()
+		if err != nil {
+			return fmt.Errorf("error deleting CatalogSource: %v", err)
+		}
+
+		err = waitFor(kconfig, "registry pods of CatalogSource "+catalog.Metadata.Name+" to be deleted", 5*time.Minute, 5*time.Second, func() (bool, error) {
+			var pods objectList
+			_, err := getJSON(kconfig, &pods, "pods", "-n", catalog.Metadata.Namespace, "-l", "olm.catalogSource="+catalog.Metadata.Name)
+			return len(pods.Items) == 0, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return addCatalogSource(clusterName, kconfig, catalogSourceYAML)
+}
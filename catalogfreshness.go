@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultMaxCatalogAge is how old the tag of the RHCEPH catalog image may be
+// before a run warns about it.
+const defaultMaxCatalogAge = 14 * 24 * time.Hour
+
+func addMaxCatalogAgeFlag(flags *flag.FlagSet) *time.Duration {
+	return flags.Duration("max-catalog-age", defaultMaxCatalogAge, "Warn before the run when the tag of the RHCEPH catalog image is older than this, e.g. 336h for two weeks; 0 disables the checks of the RHCEPH repository")
+}
+
+// splitImageReference returns the repository of an image and its tag, or ""
+// for an image referenced by digest.
+func splitImageReference(image string) (string, string) {
+	if repository, _, found := strings.Cut(image, "@"); found {
+		return repository, ""
+	}
+
+	// A colon after the last slash separates the tag, others a port.
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+
+	return image, "latest"
+}
+
+func formatAge(age time.Duration) string {
+	if days := int(age.Hours() / 24); days > 0 {
+		return fmt.Sprintf("%d days", days)
+	}
+
+	return age.Round(time.Hour).String()
+}
+
+// checkCatalogFreshness checks before the run that the repository of the
+// RHCEPH catalog image is available, and warns when the tag of the image is
+// older than maxAge, as old dev builds are a common cause of DR failures. The
+// checks only warn, the catalog may still be pulled through a mirror, and are
+// skipped for public catalogs and replayed runs. The RHCEPH repositories are
+// private and the Quay API does not take the registry credentials, so the
+// checks are skipped without a Quay API token in $QUAY_TOKEN.
+func checkCatalogFreshness(catalogSourceYAML string, maxAge time.Duration) {
+	if maxAge == 0 || fixtureMode == fixtureModeReplay {
+		return
+	}
+
+	image := catalogSourceImage(catalogSourceYAML)
+	if !strings.HasPrefix(image, rhcephRegistry+"/") {
+		return
+	}
+
+	token := os.Getenv("QUAY_TOKEN")
+	if token == "" {
+		slog.Info("not checking the age of the RHCEPH catalog image without a Quay API token in $QUAY_TOKEN", "image", image)
+		return
+	}
+
+	repository, tag := splitImageReference(image)
+	query := "limit=1"
+	if tag != "" {
+		query = "specificTag=" + url.QueryEscape(tag)
+	}
+
+	tags, err := queryQuayTags(repository, token, query)
+	if err != nil {
+		notify(messageWarning, "rhceph-unavailable", "repository", repository, "error", err.Error())
+		return
+	}
+
+	if tag == "" {
+		slog.Info("RHCEPH repository is available, the age of a catalog image referenced by digest is not checked", "image", image)
+		return
+	}
+
+	if len(tags.Tags) == 0 {
+		notify(messageWarning, "catalog-tag-missing", "image", image)
+		return
+	}
+
+	created := time.Unix(tags.Tags[0].StartTS, 0)
+	age := time.Since(created)
+	if age > maxAge {
+		notify(messageWarning, "catalog-stale", "image", image, "age", formatAge(age), "maxAge", formatAge(maxAge))
+		notify(messageHint, "catalog-stale-hint")
+		return
+	}
+
+	slog.Info("RHCEPH catalog image is recent", "image", image, "created", created.Format(time.DateTime))
+}
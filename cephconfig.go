@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+const (
+	// rookConfigOverride is the ConfigMap of the storage namespace whose
+	// config key rook adds to the ceph.conf of the Ceph daemons.
+	rookConfigOverride = "rook-config-override"
+	// The overrides of the installer are kept between these markers, so
+	// that they can be updated and removed without touching the rest.
+	cephConfigBegin = "# BEGIN odfdr-installer"
+	cephConfigEnd   = "# END odfdr-installer"
+)
+
+// cephConfigProfiles are the recommended overrides, by section, that
+// storage.cephConfig.profiles selects.
+var cephConfigProfiles = map[string]map[string]map[string]string{
+	// dr syncs more images in parallel, for DR pairs protecting many
+	// volumes.
+	"dr": {
+		"client.rbd-mirror": {
+			"rbd_mirror_concurrent_image_syncs":     "10",
+			"rbd_mirror_concurrent_image_deletions": "5",
+		},
+	},
+	// small-lab lowers the memory targets of the OSDs and MDSs, for lab
+	// clusters with small nodes.
+	"small-lab": {
+		"osd": {
+			"osd_memory_target": "2147483648",
+		},
+		"mds": {
+			"mds_cache_memory_limit": "1073741824",
+		},
+	},
+}
+
+// cephConfig are Ceph settings applied through the rook-config-override
+// ConfigMap.
+type cephConfig struct {
+	// Profiles select recommended overrides, dr or small-lab.
+	Profiles []string `json:"profiles,omitempty"`
+	// Settings are options by section, like global, osd or
+	// client.rbd-mirror, which take precedence over the profiles.
+	Settings map[string]map[string]string `json:"settings,omitempty"`
+}
+
+func (c *cephConfig) validate() error {
+	for _, profile := range c.Profiles {
+		if _, found := cephConfigProfiles[profile]; !found {
+			return fmt.Errorf("unknown ceph config profile %q, expected one of: %s", profile, strings.Join(cephConfigProfileNames(), ", "))
+		}
+	}
+
+	for section, options := range c.Settings {
+		if section == "" || strings.ContainsAny(section, "[]\n") {
+			return fmt.Errorf("invalid ceph config section %q", section)
+		}
+		for option, value := range options {
+			if option == "" || strings.ContainsAny(option, "=\n") || strings.Contains(value, "\n") {
+				return fmt.Errorf("invalid ceph config option %q in section %s", option, section)
+			}
+		}
+	}
+
+	return nil
+}
+
+func cephConfigProfileNames() []string {
+	names := []string{}
+	for name := range cephConfigProfiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// overrides returns the options of the profiles and the settings by section.
+func (c *cephConfig) overrides() map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	add := func(from map[string]map[string]string) {
+		for section, options := range from {
+			if sections[section] == nil {
+				sections[section] = map[string]string{}
+			}
+			for option, value := range options {
+				sections[section][option] = value
+			}
+		}
+	}
+
+	for _, profile := range c.Profiles {
+		add(cephConfigProfiles[profile])
+	}
+	add(c.Settings)
+
+	return sections
+}
+
+// cephConfigBlock renders the overrides as ceph.conf sections between the
+// markers of the installer.
+func cephConfigBlock(sections map[string]map[string]string) string {
+	var block strings.Builder
+	block.WriteString(cephConfigBegin + "\n")
+
+	names := []string{}
+	for section := range sections {
+		names = append(names, section)
+	}
+	slices.Sort(names)
+
+	for _, section := range names {
+		fmt.Fprintf(&block, "[%s]\n", section)
+		options := []string{}
+		for option := range sections[section] {
+			options = append(options, option)
+		}
+		slices.Sort(options)
+		for _, option := range options {
+			fmt.Fprintf(&block, "%s = %s\n", option, sections[section][option])
+		}
+	}
+	block.WriteString(cephConfigEnd + "\n")
+
+	return block.String()
+}
+
+// withCephConfigBlock returns the config with the block of the installer
+// replaced by block, or removed when block is empty. Ceph merges repeated
+// sections, so the rest of the config is kept as it is.
+func withCephConfigBlock(config, block string) string {
+	begin := strings.Index(config, cephConfigBegin)
+	end := strings.Index(config, cephConfigEnd)
+	if begin != -1 && end > begin {
+		config = config[:begin] + strings.TrimPrefix(config[end+len(cephConfigEnd):], "\n")
+	}
+
+	if block == "" {
+		return config
+	}
+	if config != "" && !strings.HasSuffix(config, "\n") {
+		config += "\n"
+	}
+
+	return config + block
+}
+
+// addCephConfigOverrides writes the configured Ceph overrides into the
+// rook-config-override ConfigMap. The ODF operator resets the ConfigMap unless
+// the cephConfig reconcile strategy of the StorageCluster is ignore, which is
+// set first.
+func addCephConfigOverrides(clusterName, kconfig string, cfg *storageConfig) error {
+	if cfg == nil || cfg.CephConfig == nil {
+		slog.Info("no Ceph config overrides configured")
+		return nil
+	}
+
+	var storageClusters struct {
+		Items []storageClusterStatus `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &storageClusters, "storageclusters.ocs.openshift.io", "-n", cfg.Namespace); err != nil {
+		return err
+	}
+	if len(storageClusters.Items) == 0 {
+		return fmt.Errorf("no StorageCluster in %s to apply the Ceph config overrides to", cfg.Namespace)
+	}
+
+	storageCluster := storageClusters.Items[0].Metadata.Name
+	patchCmd := ocCommand(kconfig, "patch", "storageclusters.ocs.openshift.io", storageCluster, "-n", cfg.Namespace,
+		"--type", "merge", "-p", `{"spec":{"managedResources":{"cephConfig":{"reconcileStrategy":"ignore"}}}}`)
+	if err := patchCmd.Run(); err != nil {
+		return fmt.Errorf("error setting the cephConfig reconcile strategy of StorageCluster %s: %v", storageCluster, err)
+	}
+
+	var cm configMap
+	found, err := getJSON(kconfig, &cm, "configmap", rookConfigOverride, "-n", cfg.Namespace)
+	if err != nil {
+		return err
+	}
+
+	config := withCephConfigBlock(cm.Data["config"], cephConfigBlock(cfg.CephConfig.overrides()))
+	if found && cm.Data["config"] == config {
+		slog.Info("Ceph config overrides already applied", "cluster", clusterName)
+		return nil
+	}
+
+	if err := setRookConfigOverride(clusterName, kconfig, cfg.Namespace, config, found); err != nil {
+		return err
+	}
+
+	slog.Info("applied Ceph config overrides, the Ceph daemons use them once they restart", "cluster", clusterName,
+		"configMap", cfg.Namespace+"/"+rookConfigOverride, "profiles", cfg.CephConfig.Profiles)
+
+	return nil
+}
+
+// removeCephConfigOverrides removes the overrides of the installer from the
+// rook-config-override ConfigMap. The cephConfig reconcile strategy of the
+// StorageCluster is left as it is, the ODF operator would otherwise reset the
+// overrides of others too.
+func removeCephConfigOverrides(clusterName, kconfig, namespace string) error {
+	var cm configMap
+	found, err := getJSON(kconfig, &cm, "configmap", rookConfigOverride, "-n", namespace)
+	if err != nil {
+		return err
+	}
+	if !found || !strings.Contains(cm.Data["config"], cephConfigBegin) {
+		slog.Info("no Ceph config overrides of the installer found")
+		return nil
+	}
+
+	if err := setRookConfigOverride(clusterName, kconfig, namespace, withCephConfigBlock(cm.Data["config"], ""), true); err != nil {
+		return err
+	}
+
+	slog.Info("removed Ceph config overrides", "configMap", namespace+"/"+rookConfigOverride)
+
+	return nil
+}
+
+// setRookConfigOverride sets the config of the rook-config-override ConfigMap.
+// An existing ConfigMap is patched, its other keys and its owner are kept.
+func setRookConfigOverride(clusterName, kconfig, namespace, config string, exists bool) error {
+	if exists {
+		patch, err := json.Marshal(map[string]any{"data": map[string]string{"config": config}})
+		if err != nil {
+			return fmt.Errorf("error encoding %s patch: %v", rookConfigOverride, err)
+		}
+
+		patchCmd := ocCommand(kconfig, "patch", "configmap", rookConfigOverride, "-n", namespace, "--type", "merge", "-p", string(patch))
+		if err := patchCmd.Run(); err != nil {
+			return fmt.Errorf("error patching %s: %v", rookConfigOverride, err)
+		}
+
+		return nil
+	}
+
+	cm := configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   objectMeta{Name: rookConfigOverride, Namespace: namespace},
+		Data:       map[string]string{"config": config},
+	}
+	data, err := json.MarshalIndent(cm, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", rookConfigOverride, err)
+	}
+
+	fileName := clusterName + "-" + rookConfigOverride + ".json"
+	if err := writeArtifact(clusterName, fileName, data); err != nil {
+		return fmt.Errorf("error writing %s to file: %v", rookConfigOverride, err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying %s: %v", rookConfigOverride, err)
+	}
+
+	return nil
+}
+
+// describeCephConfig describes the overrides the ceph-config step applies.
+func describeCephConfig(cfg *storageConfig) string {
+	if cfg == nil || cfg.CephConfig == nil {
+		return "No Ceph config overrides are configured."
+	}
+
+	return fmt.Sprintf("Set the cephConfig reconcile strategy of the StorageCluster in %s to ignore and add to %s/%s:\n%s",
+		cfg.Namespace, cfg.Namespace, rookConfigOverride, cephConfigBlock(cfg.CephConfig.overrides()))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+const (
+	cephToolsDeployment    = "rook-ceph-tools"
+	rookOperatorDeployment = "rook-ceph-operator"
+	// ocsInitialization is created by the ODF operator and enables the
+	// toolbox.
+	ocsInitialization = "ocsinit"
+)
+
+// cephCLIs are the Ceph commands ceph-cmd runs as given, any other command
+// is passed to ceph.
+var cephCLIs = []string{"ceph", "rbd", "rados", "radosgw-admin"}
+
+// enableCephTools enables the rook-ceph toolbox in namespace and waits for it
+// to be available.
+func enableCephTools(kconfig, namespace string) error {
+	patchCmd := ocCommand(kconfig, "patch", "ocsinitializations.ocs.openshift.io", ocsInitialization, "-n", namespace,
+		"--type", "merge", "-p", `{"spec":{"enableCephTools":true}}`)
+	if err := patchCmd.Run(); err != nil {
+		return fmt.Errorf("error enabling the Ceph toolbox: %v", err)
+	}
+
+	err := waitFor(kconfig, "Ceph toolbox to be available", 5*time.Minute, 5*time.Second, func() (bool, error) {
+		var deployment struct {
+			Status struct {
+				AvailableReplicas int `json:"availableReplicas"`
+			} `json:"status"`
+		}
+		found, err := getJSON(kconfig, &deployment, "deployment", cephToolsDeployment, "-n", namespace)
+		if err != nil || !found {
+			return false, err
+		}
+
+		return deployment.Status.AvailableReplicas > 0, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("enabled Ceph toolbox", "namespace", namespace)
+
+	return nil
+}
+
+// cephCommand returns the command that runs a Ceph command in the toolbox.
+// Without the toolbox, it is run in the rook operator with the config rook
+// writes for the cluster.
+func cephCommand(kconfig, namespace string, args []string) (*exec.Cmd, error) {
+	if !slices.Contains(cephCLIs, args[0]) {
+		args = append([]string{"ceph"}, args...)
+	}
+
+	var deployment struct{}
+	found, err := getJSON(kconfig, &deployment, "deployment", cephToolsDeployment, "-n", namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		execArgs := append([]string{"exec", "-n", namespace, "deploy/" + cephToolsDeployment, "--"}, args...)
+		return ocCommand(kconfig, execArgs...), nil
+	}
+
+	slog.Debug("Ceph toolbox not found, running the command in the rook operator", "namespace", namespace)
+	conf := fmt.Sprintf("/var/lib/rook/%s/%s.config", namespace, namespace)
+	execArgs := append([]string{"exec", "-n", namespace, "deploy/" + rookOperatorDeployment, "--", args[0], "-c", conf}, args[1:]...)
+
+	return ocCommand(kconfig, execArgs...), nil
+}
+
+func runCephCmd(args []string) {
+	flags := flag.NewFlagSet("ceph-cmd", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the cluster to run the command on")
+	storageNamespaceFlag := flags.String("storage-namespace", defaultStorageNamespace, "Namespace of the StorageCluster")
+	enableToolboxFlag := flags.Bool("enable-toolbox", false, "Enable the rook-ceph toolbox before running the command")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *kubeconfigFlag == "" || flags.NArg() == 0 {
+		slog.Error("error: kubeconfig and a command are required")
+		showUsageAndExit()
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	kconfig := *kubeconfigFlag
+	namespace := *storageNamespaceFlag
+
+	if *enableToolboxFlag {
+		if err := enableCephTools(kconfig, namespace); err != nil {
+			slog.Error("error enabling the Ceph toolbox", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	cmd, err := cephCommand(kconfig, namespace, flags.Args())
+	if err != nil {
+		slog.Error("error finding where to run the Ceph command", "error", err)
+		os.Exit(1)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+
+		slog.Error("error running the Ceph command", "error", err)
+		os.Exit(1)
+	}
+}
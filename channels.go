@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// packageManifestTimeout is how long the package of an operator is waited for
+// to be listed from its catalog, which the packageserver does some time after
+// the CatalogSource is ready.
+const packageManifestTimeout = 5 * time.Minute
+
+func addChannelOverrideFlag(flags *flag.FlagSet) *stringList {
+	var overrides stringList
+	flags.Var(&overrides, "channel-override", "Channel of an operator in package=channel form, overriding the configuration file (can be repeated)")
+
+	return &overrides
+}
+
+// applyChannelOverrides returns the operators with the channels of the
+// overrides, which are given in package=channel form for configured operators.
+func applyChannelOverrides(operators []operatorConfig, overrides []string) ([]operatorConfig, error) {
+	if len(overrides) == 0 {
+		return operators, nil
+	}
+
+	operators = slices.Clone(operators)
+	for _, override := range overrides {
+		pkg, channel, ok := strings.Cut(override, "=")
+		if !ok || pkg == "" || channel == "" {
+			return nil, fmt.Errorf("invalid channel override %q, expected package=channel", override)
+		}
+
+		i := slices.IndexFunc(operators, func(o operatorConfig) bool { return o.Package == pkg })
+		if i == -1 {
+			return nil, fmt.Errorf("channel override for %s, which is not a configured operator", pkg)
+		}
+		operators[i].Channel = channel
+	}
+
+	return operators, nil
+}
+
+// packageManifest lists the channels of a package of a catalog. The
+// packageserver serves it from the gRPC registry API of the CatalogSource.
+type packageManifest struct {
+	Metadata objectMeta `json:"metadata"`
+	Status   struct {
+		CatalogSource  string `json:"catalogSource"`
+		DefaultChannel string `json:"defaultChannel"`
+		Channels       []struct {
+			Name string `json:"name"`
+		} `json:"channels"`
+	} `json:"status"`
+}
+
+// validateChannel checks that the channel of an operator exists in the
+// catalog it is installed from, before the Subscription is created, and fails
+// with the channels of the catalog otherwise. Operators without a channel
+// follow the default channel of the package and are not checked.
+func validateChannel(kconfig, catalogName string, operator operatorConfig) error {
+	if operator.Channel == "" {
+		return nil
+	}
+	if operator.Source != "" {
+		catalogName = operator.Source
+	}
+
+	var manifest packageManifest
+	err := waitFor(kconfig, "package "+operator.Package+" in catalog "+catalogName, packageManifestTimeout, 10*time.Second, func() (bool, error) {
+		var manifests struct {
+			Items []packageManifest `json:"items"`
+		}
+		if _, err := getJSON(kconfig, &manifests, "packagemanifests.packages.operators.coreos.com", "-n", "openshift-marketplace",
+			"-l", "catalog="+catalogName); err != nil {
+			return false, err
+		}
+
+		i := slices.IndexFunc(manifests.Items, func(m packageManifest) bool { return m.Metadata.Name == operator.Package })
+		if i == -1 {
+			return false, nil
+		}
+		manifest = manifests.Items[i]
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	channels := []string{}
+	for _, channel := range manifest.Status.Channels {
+		channels = append(channels, channel.Name)
+	}
+	if !slices.Contains(channels, operator.Channel) {
+		return fmt.Errorf("channel %s of %s is not in catalog %s, valid channels: %s (default: %s)",
+			operator.Channel, operator.Package, catalogName, strings.Join(channels, ", "), manifest.Status.DefaultChannel)
+	}
+
+	slog.Info("channel found in catalog", "package", operator.Package, "channel", operator.Channel, "catalog", catalogName)
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// dependentResources are the resources that break when the installed
+// operators are removed from under them.
+var dependentResources = []string{
+	"storageclusters.ocs.openshift.io",
+	"drplacementcontrols.ramendr.openshift.io",
+	"volumereplicationgroups.ramendr.openshift.io",
+}
+
+type subscriptionList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Source string `json:"source"`
+		} `json:"spec"`
+		Status struct {
+			InstalledCSV string `json:"installedCSV"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// listResources returns the names of all resources of a type in namespace, or
+// in all namespaces if namespace is empty. Resource types that the cluster
+// does not serve have no resources.
+func listResources(kconfig, resource, namespace string) ([]string, error) {
+	scope := []string{"--all-namespaces"}
+	if namespace != "" {
+		scope = []string{"-n", namespace}
+	}
+
+	getArgs := append([]string{"get", resource, "-o", "name", "--ignore-not-found"}, scope...)
+	getCmd := ocCommand(kconfig, getArgs...)
+	output, err := getCmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "doesn't have a resource type") {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error listing %s: %v", resource, err)
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// findDependents returns the resources that depend on the installed operators.
+func findDependents(kconfig string) ([]string, error) {
+	dependents := []string{}
+
+	for _, resource := range dependentResources {
+		names, err := listResources(kconfig, resource, "")
+		if err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, names...)
+	}
+
+	return dependents, nil
+}
+
+// removeSubscriptions deletes the Subscriptions installed from the catalog
+// together with their ClusterServiceVersions.
+func removeSubscriptions(kconfig, catalogName string) error {
+	var subscriptions subscriptionList
+	if _, err := getJSON(kconfig, &subscriptions, "subscriptions.operators.coreos.com", "--all-namespaces"); err != nil {
+		return err
+	}
+
+	for _, sub := range subscriptions.Items {
+		if sub.Spec.Source != catalogName {
+			continue
+		}
+
+		deleteCmd := ocCommand(kconfig, "delete", "subscriptions.operators.coreos.com", sub.Metadata.Name, "-n", sub.Metadata.Namespace, "--ignore-not-found")
+		if err := deleteCmd.Run(); err != nil {
+			return fmt.Errorf("error deleting Subscription %s: %v", sub.Metadata.Name, err)
+		}
+
+		if sub.Status.InstalledCSV != "" {
+			deleteCmd = ocCommand(kconfig, "delete", "clusterserviceversions", sub.Status.InstalledCSV, "-n", sub.Metadata.Namespace, "--ignore-not-found")
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting ClusterServiceVersion %s: %v", sub.Status.InstalledCSV, err)
+			}
+		}
+
+		slog.Info("removed operator", "subscription", sub.Metadata.Name, "namespace", sub.Metadata.Namespace, "csv", sub.Status.InstalledCSV)
+	}
+
+	return nil
+}
+
+func removeCatalogSource(clusterName, kconfig, catalogSourceYAML string) error {
+	catalogSourceFileName, err := writeCatalogSource(clusterName, catalogSourceYAML)
+	if err != nil {
+		return err
+	}
+
+	catalog, found, err := getCatalogSource(kconfig, catalogSourceFileName)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		slog.Info("CatalogSource does not exist")
+		return nil
+	}
+
+	if err := removeSubscriptions(kconfig, catalog.Metadata.Name); err != nil {
+		return err
+	}
+
+	deleteCmd := ocCommand(kconfig, "delete", "-f", catalogSourceFileName, "--ignore-not-found")
+	err = deleteCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error deleting CatalogSource: %v", err)
+	}
+
+	return nil
+}
+
+func removeMirrorSets(kconfig string) error {
+	deleteCmd := ocCommand(kconfig, "delete", "imagecontentsourcepolicies", "-l", mirrorSetLabel, "--ignore-not-found")
+	err := deleteCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error deleting mirror sets: %v", err)
+	}
+
+	return nil
+}
+
+func runCleanup(args []string) {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the cluster to clean up")
+	cascadeFlag := flags.Bool("cascade", false, "Clean up even if StorageClusters or DR protected workloads depend on the operators")
+	cleanupPolicyFlag := flags.String("namespace-cleanup-policy", "", "Tear down the storage system first, keeping (retain) or deleting (delete) its data and PVs")
+	wipeDisksFlag := flags.Bool("wipe-disks", false, "Wipe the Local Storage Operator disks after the teardown, needs the delete namespace cleanup policy")
+	storageNamespaceFlag := flags.String("storage-namespace", defaultStorageNamespace, "Namespace of the storage system to tear down")
+	manifests := addManifestFlags(flags)
+	configFlag := addConfigFlag(flags)
+	addStepFlag(flags)
+	addDebugCaptureFlag(flags)
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addTracingFlag(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if err := startTracing(); err != nil {
+		slog.Error("error starting trace", "error", err)
+		os.Exit(1)
+	}
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if err := validateCleanupPolicy(*cleanupPolicyFlag, *wipeDisksFlag); err != nil {
+		slog.Error("error: invalid cleanup flags", "error", err)
+		showUsageAndExit()
+	}
+	teardown := *cleanupPolicyFlag != ""
+
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
+		os.Exit(1)
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	kconfig := *kubeconfigFlag
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to cluster", "error", err)
+		os.Exit(1)
+	}
+
+	clusterName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting cluster name", "error", err)
+		os.Exit(1)
+	}
+
+	dependents, err := findDependents(kconfig)
+	if err != nil {
+		slog.Error("error checking for resources that depend on the operators", "error", err)
+		os.Exit(1)
+	}
+
+	// The teardown deletes the StorageClusters before the operators.
+	if teardown {
+		dependents = slices.DeleteFunc(dependents, func(name string) bool {
+			return strings.HasPrefix(name, "storagecluster.ocs.openshift.io/")
+		})
+	}
+
+	if len(dependents) > 0 {
+		if !*cascadeFlag {
+			slog.Error("refusing to clean up, resources depend on the installed operators, use -cascade to clean up anyway",
+				"cluster", clusterName, "dependents", dependents)
+			os.Exit(1)
+		}
+
+		slog.Warn("cleaning up although resources depend on the installed operators", "cluster", clusterName, "dependents", dependents)
+	}
+
+	report := newRunReport("cleanup")
+	reportFileName := clusterName + "-cleanup-report.json"
+	report.cluster(clusterName).URL = url
+
+	storageNamespace := *storageNamespaceFlag
+	policy := *cleanupPolicyFlag
+
+	// volumes are recorded by the storage-cluster step for the wipe-disks
+	// step.
+	volumes := &deviceSetVolumes{}
+	steps := []step{}
+	// The overrides go away with the storage namespace on a teardown.
+	if !teardown {
+		steps = append(steps, step{name: "ceph-config", run: func() error {
+			return removeCephConfigOverrides(clusterName, kconfig, storageNamespace)
+		}, describe: func() string {
+			return fmt.Sprintf("Remove the Ceph config overrides of the installer from %s/%s.", storageNamespace, rookConfigOverride)
+		}})
+	}
+	if teardown {
+		steps = append(steps, step{name: "storage-cluster", run: func() error {
+			return deleteStorageCluster(kconfig, storageNamespace, policy, *cascadeFlag, volumes, report.cluster(clusterName))
+		}, describe: func() string {
+			return fmt.Sprintf("Delete the StorageSystems and StorageClusters in %s with the %s cleanup policy.", storageNamespace, policy)
+		}})
+	}
+
+	steps = append(steps,
+		step{name: "catalog", run: func() error {
+			return removeCatalogSource(clusterName, kconfig, manifests.catalogSourceYAML(cfg))
+		}, describe: func() string {
+			return "Delete the Subscriptions and ClusterServiceVersions installed from this CatalogSource and the CatalogSource:\n" +
+				manifests.catalogSourceYAML(cfg)
+		}},
+	)
+
+	if teardown {
+		steps = append(steps, step{name: "storage-namespace", run: func() error {
+			return deleteStorageNamespace(kconfig, storageNamespace, policy, report.cluster(clusterName))
+		}, describe: func() string {
+			return fmt.Sprintf("Remove the finalizers left in %s, delete its storage classes and the namespace, and %s its PVs.", storageNamespace, policy)
+		}})
+	}
+
+	if *wipeDisksFlag {
+		steps = append(steps, step{name: "wipe-disks", run: func() error {
+			return wipeLocalDisks(kconfig, volumes, report.cluster(clusterName))
+		}, describe: func() string {
+			return "Delete the Local Storage Operator volume sets of the storage classes of the device sets and wipe the disks of the unbound PVs the device sets claimed on the nodes."
+		}})
+	}
+
+	steps = append(steps,
+		step{name: "mirror-sets", run: func() error {
+			return removeMirrorSets(kconfig)
+		}, describe: func() string {
+			return "Delete the ImageContentSourcePolicies labeled " + mirrorSetLabel + "."
+		}},
+		step{name: "pull-secret", run: func() error {
+			if err := removeNamespacePullSecrets(kconfig, pullSecretNamespaces(cfg.Operators)); err != nil {
+				return err
+			}
+
+			return removeRHCEPHAuth(clusterName, kconfig, globalPullSecret)
+		}, describe: func() string {
+			return fmt.Sprintf("Delete the %s secrets in %s and remove the %s auth from the global pull secret.",
+				namespacePullSecretName, strings.Join(pullSecretNamespaces(cfg.Operators), ", "), rhcephRegistry)
+		}},
+		step{name: "progress", run: func() error {
+			return removeProgress(kconfig)
+		}, describe: func() string {
+			return fmt.Sprintf("Delete the %s ConfigMap in %s.", progressConfigMap, globalOperatorsNamespace)
+		}},
+	)
+
+	if err := runSteps(clusterName, kconfig, steps, nil, report.cluster(clusterName)); err != nil {
+		exitWithFailedRun(report, reportFileName, "error cleaning up cluster", err)
+	}
+
+	if err := report.finish(reportFileName, nil); err != nil {
+		slog.Error("error writing report", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+var (
+	// clusterNames maps the cluster names of the run to the cluster they
+	// were given to, so that two clusters never share a name, which names
+	// their artifacts, logs and report entries.
+	clusterNames   = map[string]string{}
+	clusterNamesMu sync.Mutex
+)
+
+// clusterDomain returns the part of the host of an API URL after the cluster
+// name, e.g. example.com for https://api.ocp.example.com:6443.
+func clusterDomain(url string) string {
+	host := url
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	host, _, _ = strings.Cut(host, "/")
+	host, _, _ = strings.Cut(host, ":")
+
+	parts := strings.SplitN(host, ".", 3)
+	if len(parts) < 3 {
+		return host
+	}
+
+	return parts[2]
+}
+
+// uniqueClusterName returns the name of the cluster of the API URL, which is
+// the short name unless another cluster of the run, in another domain, has it
+// already. The name is then suffixed with a hash of the domain, e.g.
+// ocp-1a2b3c, the same for the cluster in every run.
+func uniqueClusterName(short, url string) string {
+	clusterNamesMu.Lock()
+	defer clusterNamesMu.Unlock()
+
+	domain := clusterDomain(url)
+	if owner, taken := clusterNames[short]; !taken || owner == domain {
+		clusterNames[short] = domain
+		return short
+	}
+
+	sum := sha256.Sum256([]byte(domain))
+	name := short + "-" + hex.EncodeToString(sum[:])[:6]
+	if owner, taken := clusterNames[name]; !taken {
+		clusterNames[name] = domain
+		slog.Warn("cluster name is used by another cluster, disambiguating it with the domain", "cluster", short, "domain", domain, "name", name)
+	} else if owner != domain {
+		// Hash collisions of six hex digits are left to explicit names.
+		slog.Warn("cluster name is used by another cluster", "cluster", name, "domain", domain)
+	}
+
+	return name
+}
+
+// reserveClusterName reserves an explicit cluster name, like the names of a
+// fleet file, for the cluster identified by id. Derived names colliding with
+// it are disambiguated, two clusters with the same explicit name are an error.
+func reserveClusterName(name, id string) error {
+	clusterNamesMu.Lock()
+	defer clusterNamesMu.Unlock()
+
+	if owner, taken := clusterNames[name]; taken && owner != id {
+		return fmt.Errorf("cluster name %s is given to two clusters", name)
+	}
+	clusterNames[name] = id
+
+	return nil
+}
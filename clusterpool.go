@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// clusterPoolRef is a Hive ClusterPool on the hub to claim the cluster to
+// prepare from.
+type clusterPoolRef struct {
+	kubeconfig string
+	namespace  string
+	pool       string
+	// claim is the name of the ClusterClaim, which is reused when it
+	// exists already.
+	claim   string
+	timeout time.Duration
+	// releaseOnFailure deletes the ClusterClaim when the cluster cannot be
+	// claimed or prepared. Hive then deletes the cluster, and the pool
+	// replaces it.
+	releaseOnFailure bool
+}
+
+// addClusterPoolFlags adds the flags that claim the cluster from a ClusterPool
+// and returns a function that parses them.
+func addClusterPoolFlags(flags *flag.FlagSet) func() (*clusterPoolRef, error) {
+	poolFlag := flags.String("claim-from-pool", "", "Hive ClusterPool on the hub in namespace/name form to claim the cluster from, instead of -api-url and -password")
+	kubeconfigFlag := flags.String("pool-kubeconfig", "", "Kubeconfig of the hub with the ClusterPool")
+	claimFlag := flags.String("claim-name", "", "Name of the ClusterClaim (default: generated)")
+	timeoutFlag := flags.Duration("pool-timeout", time.Hour, "How long to wait for the claimed cluster to be running")
+	releaseFlag := flags.Bool("release-claim-on-failure", false, "Delete the ClusterClaim when claiming or preparing the cluster fails")
+
+	return func() (*clusterPoolRef, error) {
+		if *poolFlag == "" {
+			if *kubeconfigFlag != "" || *claimFlag != "" || *releaseFlag {
+				return nil, fmt.Errorf("-pool-kubeconfig, -claim-name and -release-claim-on-failure need -claim-from-pool")
+			}
+			return nil, nil
+		}
+
+		if *kubeconfigFlag == "" {
+			return nil, fmt.Errorf("-claim-from-pool needs -pool-kubeconfig")
+		}
+
+		namespace, pool, ok := strings.Cut(*poolFlag, "/")
+		if !ok || namespace == "" || pool == "" {
+			return nil, fmt.Errorf("cluster pool %q is not in namespace/name form", *poolFlag)
+		}
+
+		claim := *claimFlag
+		if claim == "" {
+			// The random suffix of a run ID.
+			claim = "odfdr-" + pool + "-" + newRunID()[len("20060102-150405-"):]
+		}
+
+		return &clusterPoolRef{kubeconfig: *kubeconfigFlag, namespace: namespace, pool: pool, claim: claim,
+			timeout: *timeoutFlag, releaseOnFailure: *releaseFlag}, nil
+	}
+}
+
+type clusterClaim struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		ClusterPoolName string `json:"clusterPoolName"`
+	} `json:"spec"`
+}
+
+type clusterClaimStatus struct {
+	Spec struct {
+		// Namespace is the namespace of the ClusterDeployment of the
+		// claimed cluster, set once a cluster is assigned.
+		Namespace string `json:"namespace"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []condition `json:"conditions"`
+	} `json:"status"`
+}
+
+type clusterDeployment struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ClusterMetadata struct {
+			AdminKubeconfigSecretRef struct {
+				Name string `json:"name"`
+			} `json:"adminKubeconfigSecretRef"`
+		} `json:"clusterMetadata"`
+	} `json:"spec"`
+	Status struct {
+		APIURL string `json:"apiURL"`
+	} `json:"status"`
+}
+
+// adminKubeconfigSecretKey is the key of the admin kubeconfig in its Secret
+// created by Hive.
+const adminKubeconfigSecretKey = "kubeconfig"
+
+// claimedCluster is the API URL and the admin kubeconfig of a claimed
+// cluster. The kubeconfig carries the CA of the self-signed certificates of
+// the freshly installed cluster, which a password login could not verify.
+type claimedCluster struct {
+	url        string
+	kubeconfig []byte
+}
+
+// claimCluster claims a cluster from the pool, waits for it to be running and
+// returns its admin kubeconfig.
+func claimCluster(ref *clusterPoolRef) (*claimedCluster, error) {
+	claimed, err := claimPoolCluster(ref)
+	if err != nil && ref.releaseOnFailure {
+		releaseClaim(ref)
+	}
+
+	return claimed, err
+}
+
+func claimPoolCluster(ref *clusterPoolRef) (*claimedCluster, error) {
+	url, err := getServerURL(ref.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	hubName, err := getClusterName(url)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := clusterClaim{
+		APIVersion: "hive.openshift.io/v1",
+		Kind:       "ClusterClaim",
+		Metadata:   objectMeta{Name: ref.claim, Namespace: ref.namespace},
+	}
+	claim.Spec.ClusterPoolName = ref.pool
+
+	data, err := json.MarshalIndent(claim, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding ClusterClaim: %v", err)
+	}
+
+	fileName := hubName + "-" + ref.claim + "-clusterclaim.json"
+	if err := writeArtifact(hubName, fileName, data); err != nil {
+		return nil, fmt.Errorf("error writing ClusterClaim to file: %v", err)
+	}
+
+	if _, err := applyManifest(ref.kubeconfig, fileName); err != nil {
+		return nil, fmt.Errorf("error applying ClusterClaim: %v", err)
+	}
+	slog.Info("claimed cluster from pool", "pool", ref.namespace+"/"+ref.pool, "claim", ref.claim)
+
+	var status clusterClaimStatus
+	err = waitFor(ref.kubeconfig, "ClusterClaim "+ref.namespace+"/"+ref.claim+" to be running", ref.timeout, 30*time.Second, func() (bool, error) {
+		found, err := getJSON(ref.kubeconfig, &status, "clusterclaims.hive.openshift.io", ref.claim, "-n", ref.namespace)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, fmt.Errorf("ClusterClaim %s/%s was deleted", ref.namespace, ref.claim)
+		}
+
+		running, ok := conditionStatus(status.Status.Conditions, "ClusterRunning")
+
+		return status.Spec.Namespace != "" && ok && running.Status == "True", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The ClusterDeployment is named like its namespace.
+	ns := status.Spec.Namespace
+	var deployment clusterDeployment
+	found, err := getJSON(ref.kubeconfig, &deployment, "clusterdeployments.hive.openshift.io", ns, "-n", ns)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("ClusterDeployment %s/%s of the claimed cluster not found", ns, ns)
+	}
+
+	if deployment.Status.APIURL == "" || deployment.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name == "" {
+		return nil, fmt.Errorf("ClusterDeployment %s/%s has no API URL or admin kubeconfig", ns, ns)
+	}
+
+	kubeconfig, err := secretValue(ref.kubeconfig, ns, deployment.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name, adminKubeconfigSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("claimed cluster is running", "claim", ref.claim, "clusterDeployment", ns, "url", deployment.Status.APIURL)
+
+	return &claimedCluster{url: deployment.Status.APIURL, kubeconfig: kubeconfig}, nil
+}
+
+// releaseClaim deletes the ClusterClaim.
+func releaseClaim(ref *clusterPoolRef) {
+	deleteCmd := ocCommand(ref.kubeconfig, "delete", "clusterclaims.hive.openshift.io", ref.claim, "-n", ref.namespace,
+		"--ignore-not-found", "--wait=false")
+	if err := deleteCmd.Run(); err != nil {
+		slog.Error("error releasing ClusterClaim", "claim", ref.namespace+"/"+ref.claim, "error", err)
+		return
+	}
+
+	slog.Info("released ClusterClaim", "claim", ref.namespace+"/"+ref.claim)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	clusterSetLabel    = "cluster.open-cluster-management.io/clusterset"
+	drPlacementName    = "odfdr-dr-placement"
+	placementNameLabel = "cluster.open-cluster-management.io/placement"
+)
+
+// clusterSetConfig puts the DR clusters into a ManagedClusterSet and binds it
+// to the namespaces of the DR protected applications on the hub.
+type clusterSetConfig struct {
+	Name string `json:"name"`
+	// Namespaces get a ManagedClusterSetBinding and a Placement of the two
+	// clusters, which a DRPlacementControl in the namespace can use.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+func (c *clusterSetConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("DR cluster set has no name")
+	}
+
+	return nil
+}
+
+type managedClusterSetBinding struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		ClusterSet string `json:"clusterSet"`
+	} `json:"spec"`
+}
+
+type placementToleration struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+}
+
+type placementPredicate struct {
+	RequiredClusterSelector struct {
+		LabelSelector struct {
+			MatchExpressions []nodeSelectorRequirement `json:"matchExpressions"`
+		} `json:"labelSelector"`
+	} `json:"requiredClusterSelector"`
+}
+
+type ocmPlacement struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		ClusterSets      []string              `json:"clusterSets"`
+		NumberOfClusters *int                  `json:"numberOfClusters,omitempty"`
+		Predicates       []placementPredicate  `json:"predicates,omitempty"`
+		Tolerations      []placementToleration `json:"tolerations,omitempty"`
+	} `json:"spec"`
+}
+
+type managedClusterSet struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+}
+
+func newClusterSetBinding(clusterSet, ns string) managedClusterSetBinding {
+	binding := managedClusterSetBinding{
+		APIVersion: "cluster.open-cluster-management.io/v1beta2",
+		Kind:       "ManagedClusterSetBinding",
+		Metadata:   objectMeta{Name: clusterSet, Namespace: ns},
+	}
+	binding.Spec.ClusterSet = clusterSet
+
+	return binding
+}
+
+// newPlacement returns a Placement of the cluster set that keeps its
+// decisions while a cluster is down, so a failover does not remove the
+// workloads of the failed cluster.
+func newPlacement(name, ns, clusterSet string) ocmPlacement {
+	placement := ocmPlacement{
+		APIVersion: "cluster.open-cluster-management.io/v1beta1",
+		Kind:       "Placement",
+		Metadata:   objectMeta{Name: name, Namespace: ns},
+	}
+	placement.Spec.ClusterSets = []string{clusterSet}
+	placement.Spec.Tolerations = []placementToleration{
+		{Key: "cluster.open-cluster-management.io/unreachable", Operator: "Exists"},
+		{Key: "cluster.open-cluster-management.io/unavailable", Operator: "Exists"},
+	}
+
+	return placement
+}
+
+// clusterSetManifests returns the ManagedClusterSet and, for every
+// namespace, the binding and a Placement that selects one of the clusters.
+func clusterSetManifests(cfg *clusterSetConfig, clusters []string) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	manifests.Items = append(manifests.Items, managedClusterSet{
+		APIVersion: "cluster.open-cluster-management.io/v1beta2",
+		Kind:       "ManagedClusterSet",
+		Metadata:   objectMeta{Name: cfg.Name},
+	})
+
+	for _, ns := range cfg.Namespaces {
+		placement := newPlacement(drPlacementName, ns, cfg.Name)
+		one := 1
+		placement.Spec.NumberOfClusters = &one
+		var predicate placementPredicate
+		predicate.RequiredClusterSelector.LabelSelector.MatchExpressions = []nodeSelectorRequirement{
+			{Key: "name", Operator: "In", Values: clusters},
+		}
+		placement.Spec.Predicates = []placementPredicate{predicate}
+
+		manifests.Items = append(manifests.Items,
+			namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: ns}},
+			newClusterSetBinding(cfg.Name, ns),
+			placement)
+	}
+
+	return manifests
+}
+
+// checkClusterSet returns an error if a cluster is not in the cluster set.
+func checkClusterSet(kconfig, clusterSet string, clusters []string) error {
+	for _, name := range clusters {
+		cluster, err := getManagedCluster(kconfig, name)
+		if err != nil {
+			return err
+		}
+
+		if set := cluster.Metadata.Labels[clusterSetLabel]; set != clusterSet {
+			return fmt.Errorf("ManagedCluster %s is in cluster set %q, not in %q", name, set, clusterSet)
+		}
+	}
+
+	return nil
+}
+
+// addClusterSet creates the ManagedClusterSet, moves the clusters into it
+// and binds it to the namespaces, then checks that the Placement of every
+// namespace selects one of the clusters, as a DRPlacementControl cannot
+// progress otherwise.
+func addClusterSet(hubName, kconfig string, clusters []string, cfg *clusterSetConfig) error {
+	data, err := json.MarshalIndent(clusterSetManifests(cfg, clusters), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ManagedClusterSet: %v", err)
+	}
+
+	fileName := hubName + "-" + strings.Join(clusters, "-") + "-clusterset.json"
+	err = writeArtifact(hubName, fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing ManagedClusterSet to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying ManagedClusterSet: %v", err)
+	}
+
+	for _, cluster := range clusters {
+		if err := labelManagedCluster(kconfig, cluster, map[string]string{clusterSetLabel: cfg.Name}); err != nil {
+			return err
+		}
+	}
+
+	if err := checkClusterSet(kconfig, cfg.Name, clusters); err != nil {
+		return err
+	}
+
+	for _, ns := range cfg.Namespaces {
+		err := waitFor(kconfig, "PlacementDecision of "+ns+"/"+drPlacementName, 2*time.Minute, 5*time.Second, func() (bool, error) {
+			var decisions struct {
+				Items []struct {
+					Status struct {
+						Decisions []struct {
+							ClusterName string `json:"clusterName"`
+						} `json:"decisions"`
+					} `json:"status"`
+				} `json:"items"`
+			}
+			_, err := getJSON(kconfig, &decisions, "placementdecisions", "-n", ns, "-l", placementNameLabel+"="+drPlacementName)
+			if err != nil {
+				return false, err
+			}
+
+			for _, decision := range decisions.Items {
+				if len(decision.Status.Decisions) > 0 {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%v, check the ManagedClusterSetBinding and Placement in namespace %s", err, ns)
+		}
+	}
+
+	slog.Info("added DR clusters to cluster set", "clusterSet", cfg.Name, "clusters", clusters, "namespaces", cfg.Namespaces)
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	ramenConfigNamespace = "openshift-dr-system"
+	ramenConfigMap       = "ramen-dr-cluster-operator-config"
+	ramenConfigKey       = "ramen_manager_config.yaml"
+)
+
+// flattenJSON flattens a decoded JSON value into dotted paths and their
+// values.
+func flattenJSON(prefix string, value any, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			flattenJSON(prefix+"."+key, child, out)
+		}
+	case []any:
+		for i, child := range v {
+			flattenJSON(fmt.Sprintf("%s[%d]", prefix, i), child, out)
+		}
+	default:
+		data, _ := json.Marshal(v)
+		out[prefix] = string(data)
+	}
+}
+
+// getDRConfiguration returns the DR relevant configuration of a cluster as
+// flat keys and values, so two clusters can be compared key by key.
+func getDRConfiguration(kconfig string) (map[string]string, error) {
+	config := map[string]string{}
+
+	var catalogSources struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Image string `json:"image"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &catalogSources, "catalogsources", "-n", "openshift-marketplace"); err != nil {
+		return nil, err
+	}
+	for _, catalog := range catalogSources.Items {
+		if catalog.Spec.Image != "" {
+			config["catalogsource/"+catalog.Metadata.Name+" image"] = catalog.Spec.Image
+		}
+	}
+
+	versions, err := getOperatorVersions(kconfig)
+	if err != nil {
+		return nil, err
+	}
+	for pkg, version := range versions {
+		config["operator/"+pkg+" version"] = version
+	}
+
+	var icsps struct {
+		Items []struct {
+			Spec struct {
+				RepositoryDigestMirrors []struct {
+					Source  string   `json:"source"`
+					Mirrors []string `json:"mirrors"`
+				} `json:"repositoryDigestMirrors"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &icsps, "imagecontentsourcepolicies"); err != nil {
+		return nil, err
+	}
+	for _, icsp := range icsps.Items {
+		for _, mirror := range icsp.Spec.RepositoryDigestMirrors {
+			key := "mirror/" + mirror.Source
+			mirrors := strings.Split(config[key], ",")
+			for _, m := range mirror.Mirrors {
+				if !slices.Contains(mirrors, m) {
+					mirrors = append(mirrors, m)
+				}
+			}
+			mirrors = slices.DeleteFunc(mirrors, func(m string) bool { return m == "" })
+			sort.Strings(mirrors)
+			config[key] = strings.Join(mirrors, ",")
+		}
+	}
+
+	var storageClusters struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec map[string]any `json:"spec"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &storageClusters, "storageclusters", "--all-namespaces"); err != nil {
+		slog.Warn("error getting StorageClusters, not comparing them", "error", err)
+	}
+	for _, storageCluster := range storageClusters.Items {
+		flattenJSON("storagecluster/"+storageCluster.Metadata.Name+" spec", storageCluster.Spec, config)
+	}
+
+	var ramenConfig struct {
+		Data map[string]string `json:"data"`
+	}
+	found, err := getJSON(kconfig, &ramenConfig, "configmap", ramenConfigMap, "-n", ramenConfigNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		for _, line := range strings.Split(ramenConfig.Data[ramenConfigKey], "\n") {
+			if line = strings.TrimRight(line, " "); strings.TrimSpace(line) != "" {
+				config["ramen-config/"+line] = "present"
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// compareConfigurations returns the keys whose values differ between the two
+// configurations, sorted.
+func compareConfigurations(a, b map[string]string) []string {
+	keys := []string{}
+	for key, value := range a {
+		if b[key] != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func runCompare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+	addReadSelectorFlag(flags)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		slog.Error("error: kubeconfigs of exactly two clusters are required")
+		showUsageAndExit()
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	names := []string{}
+	configs := []map[string]string{}
+	for _, kconfig := range flags.Args() {
+		url, err := getServerURL(kconfig)
+		if err != nil {
+			slog.Error("error connecting to cluster", "kubeconfig", kconfig, "error", err)
+			os.Exit(1)
+		}
+
+		name, err := getClusterName(url)
+		if err != nil {
+			slog.Error("error getting cluster name", "error", err)
+			os.Exit(1)
+		}
+
+		config, err := getDRConfiguration(kconfig)
+		if err != nil {
+			slog.Error("error getting DR configuration", "cluster", name, "error", err)
+			os.Exit(1)
+		}
+
+		names = append(names, name)
+		configs = append(configs, config)
+	}
+
+	differences := compareConfigurations(configs[0], configs[1])
+	if len(differences) == 0 {
+		fmt.Println("No differences found.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SETTING\t%s\t%s\n", names[0], names[1])
+	for _, key := range differences {
+		a, ok := configs[0][key]
+		if !ok {
+			a = "-"
+		}
+		b, ok := configs[1][key]
+		if !ok {
+			b = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, a, b)
+	}
+	w.Flush()
+
+	os.Exit(1)
+}
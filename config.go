@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+// config is the optional installer configuration file. It is JSON so that it
+// can be read without any dependencies.
+type config struct {
+	// Version is the schema version of the file, files of older versions
+	// are upgraded by migrate-config.
+	Version int `json:"version,omitempty"`
+	// Scheduling is applied to the pods of the CatalogSource and of the
+	// installed operators, for clusters with infra nodes or taints.
+	Scheduling *scheduling `json:"scheduling,omitempty"`
+	// Operators are installed from the CatalogSource by the operators step.
+	Operators []operatorConfig `json:"operators,omitempty"`
+	// DR configures the protection of the DR pairs set up by configure-dr
+	// and fleet.
+	DR *drConfig `json:"dr,omitempty"`
+	// Storage adds block pools and filesystems to the StorageCluster.
+	Storage *storageConfig `json:"storage,omitempty"`
+	// Redact are regular expressions whose matches are replaced in the
+	// logs and reports, for sharing them outside the team.
+	Redact []string `json:"redact,omitempty"`
+	// Waits are extra readiness gates of the environment evaluated during
+	// prepare.
+	Waits []waitCondition `json:"waits,omitempty"`
+	// Identity is a cluster-admin user created by the identity step and
+	// used for the following steps.
+	Identity *identityConfig `json:"identity,omitempty"`
+	// Releases locate the catalog and mirror sets of release streams,
+	// selected with -release, on top of the default streams.
+	Releases map[string]releaseStream `json:"releases,omitempty"`
+	// Connection tunes the timeouts of the connections to the clusters.
+	Connection *connectionConfig `json:"connection,omitempty"`
+	// Catalog tunes the CatalogSource, like how often its image is polled.
+	Catalog *catalogConfig `json:"catalog,omitempty"`
+	// Overlays patch the generated manifests before they are applied.
+	Overlays []manifestOverlay `json:"overlays,omitempty"`
+	// LogForwarding keeps the log of every run with the logs of a cluster.
+	LogForwarding *logForwardingConfig `json:"logForwarding,omitempty"`
+	// Registry eases the image pulls from rate limited registries.
+	Registry *registryConfig `json:"registry,omitempty"`
+}
+
+type scheduling = manifest.Scheduling
+
+type toleration = manifest.Toleration
+
+type operatorConfig struct {
+	Package   string `json:"package"`
+	Namespace string `json:"namespace,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	// Source is the CatalogSource in openshift-marketplace the operator is
+	// installed from, the installer's CatalogSource by default.
+	Source string `json:"source,omitempty"`
+	// Resources overrides the resource requests and limits of the operator
+	// pods, e.g. to fit small lab clusters.
+	Resources *resourceRequirements `json:"resources,omitempty"`
+}
+
+type resourceRequirements struct {
+	Limits   map[string]string `json:"limits,omitempty"`
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+func addConfigFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "Installer configuration file (JSON)")
+}
+
+// loadConfig reads the configuration file. Without a file the configuration
+// is empty.
+func loadConfig(fileName string) (*config, error) {
+	if fileName == "" {
+		return &config{}, nil
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	return parseConfig(data)
+}
+
+// parseConfig decodes and validates a configuration.
+func parseConfig(data []byte) (*config, error) {
+	data, applied, err := migrateConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) > 0 {
+		slog.Warn("config file uses an older schema, upgrade it with migrate-config", "migrations", applied)
+	}
+
+	cfg := &config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	for i, operator := range cfg.Operators {
+		if operator.Package == "" {
+			return nil, fmt.Errorf("operator %d in config file has no package", i)
+		}
+	}
+
+	if cfg.DR != nil {
+		if err := cfg.DR.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Storage != nil {
+		if err := cfg.Storage.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Catalog != nil {
+		if err := cfg.Catalog.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Identity != nil {
+		if err := cfg.Identity.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateReleaseStreams(cfg.Releases); err != nil {
+		return nil, err
+	}
+
+	if err := validateWaitConditions(cfg.Waits); err != nil {
+		return nil, err
+	}
+
+	if err := setRedactPatterns(cfg.Redact); err != nil {
+		return nil, err
+	}
+
+	if err := setConnectionConfig(cfg.Connection); err != nil {
+		return nil, err
+	}
+
+	if err := setManifestOverlays(cfg.Overlays); err != nil {
+		return nil, err
+	}
+
+	if err := setRegistryConfig(cfg.Registry); err != nil {
+		return nil, err
+	}
+
+	if cfg.LogForwarding != nil {
+		if err := cfg.LogForwarding.validate(); err != nil {
+			return nil, err
+		}
+	}
+	logForwarding = cfg.LogForwarding
+
+	// LVM Storage has no mirroring, its volumes can only be replicated by
+	// VolSync.
+	if cfg.storageBackend() == storageBackendLVMS {
+		for _, sc := range cfg.drStorageClasses() {
+			if sc.Replication != replicationVolSync {
+				return nil, fmt.Errorf("DR storage class %s uses %s replication, the %s backend only supports %s",
+					sc.Name, sc.Replication, storageBackendLVMS, replicationVolSync)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// drStorageClasses returns the configured DR storage classes. With the lvms
+// backend, the LVMS storage class is replicated by VolSync unless configured
+// otherwise.
+func (c *config) drStorageClasses() []storageClassDR {
+	if c.DR != nil && len(c.DR.StorageClasses) > 0 {
+		return c.DR.StorageClasses
+	}
+
+	if c.storageBackend() == storageBackendLVMS {
+		name := c.Storage.LVMS.storageClass()
+		sc := storageClassDR{Name: name, Replication: replicationVolSync, VolumeSnapshotClass: name, DailyChangePercent: 10}
+		if c.DR != nil {
+			sc.SchedulingInterval = c.DR.SchedulingInterval
+		}
+		return []storageClassDR{sc}
+	}
+
+	return nil
+}
+
+// drOptions returns the DR options of the clusters with the settings of the
+// configuration.
+func (c *config) drOptions(clusters []string) drOptions {
+	opts := defaultDROptions(clusters)
+	opts.storageClasses = c.drStorageClasses()
+	opts.storageBackend = c.storageBackend()
+	if c.DR != nil {
+		opts.clusterSet = c.DR.ClusterSet
+		opts.gitops = c.DR.GitOps
+		opts.network = c.DR.Network
+		opts.standbyHub = c.DR.StandbyHub
+	}
+
+	return opts
+}
+
+// storageBackend returns the storage backend of the clusters.
+func (c *config) storageBackend() string {
+	if c.Storage == nil {
+		return storageBackendODF
+	}
+
+	return c.Storage.Backend
+}
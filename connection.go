@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// connectionSettings accommodate slow links to remote clusters. Durations
+// are like 30s or 2m.
+type connectionSettings struct {
+	// RequestTimeout is passed to oc as --request-timeout and bounds the
+	// requests the installer sends itself, like the OAuth login.
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+	// DialTimeout and KeepAlive apply to the connections the installer opens
+	// itself, as oc has no such settings.
+	DialTimeout string `json:"dialTimeout,omitempty"`
+	KeepAlive   string `json:"keepAlive,omitempty"`
+	// CertificateAuthority is a PEM file of the CAs the API and OAuth
+	// servers are verified with, like the self-signed CA of a lab cluster.
+	// InsecureSkipTLSVerify skips the verification instead. Both are
+	// written into the kubeconfigs of the logins.
+	CertificateAuthority  string `json:"certificateAuthority,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+
+	requestTimeout time.Duration
+	dialTimeout    time.Duration
+	keepAlive      time.Duration
+	caData         []byte
+}
+
+type connectionConfig struct {
+	connectionSettings
+	// Clusters overrides the settings of single clusters by name, like
+	// the clusters of a fleet behind a slower link. Unset settings are
+	// inherited.
+	Clusters map[string]connectionSettings `json:"clusters,omitempty"`
+}
+
+// streamingCommands are the oc commands that stream or wait for as long as
+// they need to, which a request timeout would cut short.
+var streamingCommands = []string{"adm", "debug", "exec", "login", "logs", "port-forward", "rsh", "rsync", "wait"}
+
+var (
+	connectionMu sync.Mutex
+	// defaultConnection applies to all clusters, and kubeconfigConnections
+	// to the clusters with settings of their own by kubeconfig.
+	defaultConnection     connectionSettings
+	clusterConnections    map[string]connectionSettings
+	kubeconfigConnections = map[string]connectionSettings{}
+	// tlsFlags are the TLS settings of the flags, which override those of
+	// the configuration file for all clusters.
+	tlsFlags connectionSettings
+)
+
+func addTLSFlags(flags *flag.FlagSet) {
+	flags.Func("certificate-authority", "PEM file of the CAs to verify the API and OAuth servers of the clusters with, like the self-signed CA of a lab cluster", func(path string) error {
+		tlsFlags.CertificateAuthority = path
+		return tlsFlags.readCA()
+	})
+	flags.BoolVar(&tlsFlags.InsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Do not verify the certificates of the API and OAuth servers of the clusters, like oc login --insecure-skip-tls-verify")
+}
+
+// readCA reads the CAs of the certificate authority file.
+func (s *connectionSettings) readCA() error {
+	data, err := os.ReadFile(s.CertificateAuthority)
+	if err != nil {
+		return fmt.Errorf("error reading certificate authority: %v", err)
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificates in certificate authority %s", s.CertificateAuthority)
+	}
+	s.caData = data
+
+	return nil
+}
+
+// parse parses the durations, inheriting the unset ones from defaults.
+func (s *connectionSettings) parse(defaults connectionSettings) error {
+	for _, d := range []struct {
+		name      string
+		value     string
+		field     *time.Duration
+		inherited time.Duration
+	}{
+		{"requestTimeout", s.RequestTimeout, &s.requestTimeout, defaults.requestTimeout},
+		{"dialTimeout", s.DialTimeout, &s.dialTimeout, defaults.dialTimeout},
+		{"keepAlive", s.KeepAlive, &s.keepAlive, defaults.keepAlive},
+	} {
+		if d.value == "" {
+			*d.field = d.inherited
+			continue
+		}
+
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = duration
+	}
+
+	if s.CertificateAuthority == "" {
+		s.CertificateAuthority = defaults.CertificateAuthority
+		s.caData = defaults.caData
+	} else if err := s.readCA(); err != nil {
+		return err
+	}
+	s.InsecureSkipTLSVerify = s.InsecureSkipTLSVerify || defaults.InsecureSkipTLSVerify
+
+	return nil
+}
+
+// setConnectionConfig validates the connection settings and makes them the
+// settings of all clusters.
+func setConnectionConfig(c *connectionConfig) error {
+	if c == nil {
+		c = &connectionConfig{}
+	}
+
+	if err := c.parse(connectionSettings{}); err != nil {
+		return fmt.Errorf("connection: %v", err)
+	}
+
+	clusters := map[string]connectionSettings{}
+	for name, settings := range c.Clusters {
+		if err := settings.parse(c.connectionSettings); err != nil {
+			return fmt.Errorf("connection of cluster %s: %v", name, err)
+		}
+		clusters[name] = settings
+	}
+
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+
+	defaultConnection = c.connectionSettings
+	clusterConnections = clusters
+
+	return nil
+}
+
+// useClusterConnection applies the settings of the named cluster, if it has
+// settings of its own, to the commands run with kconfig.
+func useClusterConnection(name, kconfig string) {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+
+	if settings, found := clusterConnections[name]; found {
+		kubeconfigConnections[kconfig] = settings
+	}
+}
+
+func connectionFor(kconfig string) connectionSettings {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+
+	settings, found := kubeconfigConnections[kconfig]
+	if !found {
+		settings = defaultConnection
+	}
+
+	if tlsFlags.CertificateAuthority != "" {
+		settings.CertificateAuthority = tlsFlags.CertificateAuthority
+		settings.caData = tlsFlags.caData
+	}
+	settings.InsecureSkipTLSVerify = settings.InsecureSkipTLSVerify || tlsFlags.InsecureSkipTLSVerify
+
+	return settings
+}
+
+// loginArgs returns the TLS arguments of oc login.
+func (s connectionSettings) loginArgs() []string {
+	args := []string{}
+	if s.CertificateAuthority != "" {
+		args = append(args, "--certificate-authority="+s.CertificateAuthority)
+	}
+	if s.InsecureSkipTLSVerify {
+		args = append(args, "--insecure-skip-tls-verify=true")
+	}
+
+	return args
+}
+
+// ocArgs adds the request timeout to the arguments of oc.
+func (s connectionSettings) ocArgs(args []string) []string {
+	// Watches run for as long as they are needed, like streaming commands.
+	if s.requestTimeout == 0 || len(args) == 0 || slices.Contains(streamingCommands, args[0]) || slices.Contains(args, "--watch-only") {
+		return args
+	}
+
+	return append([]string{"--request-timeout=" + s.requestTimeout.String()}, args...)
+}
+
+// httpClient returns a client for requests to the cluster.
+func (s connectionSettings) httpClient() *http.Client {
+	timeout := 30 * time.Second
+	if s.requestTimeout > 0 {
+		timeout = s.requestTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if s.dialTimeout > 0 {
+		dialer.Timeout = s.dialTimeout
+	}
+	if s.keepAlive > 0 {
+		dialer.KeepAlive = s.keepAlive
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: s.InsecureSkipTLSVerify}
+	if s.caData != nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(s.caData)
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
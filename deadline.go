@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// deadlineSamples is how many of the latest successful runs of a step its
+// estimate is based on.
+const deadlineSamples = 10
+
+// errDeadline is returned by runSteps when the steps left cannot finish
+// before the deadline.
+var errDeadline = errors.New("cannot finish within deadline")
+
+var (
+	// runDeadline, when set, is when the run must be done by. Steps that
+	// cannot finish before it are not started.
+	runDeadline time.Time
+
+	stepEstimatesOnce sync.Once
+	stepEstimates     map[string]time.Duration
+)
+
+func addDeadlineFlag(flags *flag.FlagSet) {
+	flags.Func("deadline", "Time the run must be done in, like 45m; the run stops before a step when the steps left took longer in earlier runs", func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("deadline must be positive")
+		}
+		runDeadline = time.Now().Add(d)
+
+		return nil
+	})
+}
+
+// estimateSteps returns the median duration of the latest successful runs of
+// every step in the run history.
+func estimateSteps() map[string]time.Duration {
+	runs, err := loadRuns()
+	if err != nil {
+		slog.Warn("error loading run history, steps are not estimated", "error", err)
+		return map[string]time.Duration{}
+	}
+
+	durations := map[string][]time.Duration{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		for _, cluster := range runs[i].Clusters {
+			for _, record := range cluster.Steps {
+				if record.Skipped || record.Error != "" || len(durations[record.Name]) == deadlineSamples {
+					continue
+				}
+				durations[record.Name] = append(durations[record.Name], record.Duration)
+			}
+		}
+	}
+
+	estimates := map[string]time.Duration{}
+	for name, samples := range durations {
+		slices.Sort(samples)
+		estimates[name] = samples[len(samples)/2]
+	}
+
+	return estimates
+}
+
+// checkDeadline returns an error when the steps left are expected to take
+// longer than the time left until the deadline. Steps that never succeeded
+// before are not counted, so a run is only stopped early based on its
+// history.
+func checkDeadline(clusterName string, left []step) error {
+	if runDeadline.IsZero() {
+		return nil
+	}
+
+	stepEstimatesOnce.Do(func() {
+		stepEstimates = estimateSteps()
+	})
+
+	var remaining time.Duration
+	for _, s := range left {
+		remaining += stepEstimates[s.name]
+	}
+
+	available := time.Until(runDeadline)
+	if available <= 0 || remaining > available {
+		return fmt.Errorf("%w at step %s: the steps left took %v in earlier runs, %v left until the deadline",
+			errDeadline, left[0].name, remaining.Round(time.Second), max(available, 0).Round(time.Second))
+	}
+
+	slog.Debug("steps left fit the deadline", "cluster", clusterName, "step", left[0].name,
+		"estimate", remaining.Round(time.Second), "left", available.Round(time.Second))
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// debugCapture makes runSteps record the oc commands of every step, and keep
+// them with the resources the step applied when the step fails.
+var debugCapture bool
+
+func addDebugCaptureFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&debugCapture, "debug-capture", false, "Keep the redacted oc commands and responses of a failed step and the resources it applied in <cluster>-debug")
+}
+
+var (
+	// captures are the fixture files recording the running step of each
+	// kubeconfig.
+	captures   = map[string]string{}
+	capturesMu sync.Mutex
+)
+
+// captureFile returns the fixture file recording the oc commands of kconfig,
+// or "" when they are not captured.
+func captureFile(kconfig string) string {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+
+	return captures[kconfig]
+}
+
+func debugCaptureDir(clusterName string) string {
+	return clusterName + "-debug"
+}
+
+// stepCapture records the oc commands of a step in a fixture, the format of
+// -record, so that a failed step can be replayed with -replay.
+type stepCapture struct {
+	clusterName string
+	kconfig     string
+	step        string
+	file        string
+}
+
+// startCapture starts recording the oc commands of a step. It returns nil
+// when steps are not captured, or when all commands are recorded with -record
+// or replayed with -replay anyway.
+func startCapture(clusterName, kconfig, stepName string) *stepCapture {
+	if !debugCapture || fixtureMode != "" {
+		return nil
+	}
+
+	file, err := createTempFile("odfdr-capture-*.json")
+	if err != nil {
+		slog.Warn("error starting debug capture", "cluster", clusterName, "step", stepName, "error", err)
+		return nil
+	}
+	defer file.Close()
+
+	data, _ := json.Marshal(fixture{Entries: []fixtureEntry{}})
+	if _, err := file.Write(data); err != nil {
+		slog.Warn("error starting debug capture", "cluster", clusterName, "step", stepName, "error", err)
+		os.Remove(file.Name())
+		return nil
+	}
+
+	capturesMu.Lock()
+	captures[kconfig] = file.Name()
+	capturesMu.Unlock()
+
+	return &stepCapture{clusterName: clusterName, kconfig: kconfig, step: stepName, file: file.Name()}
+}
+
+// end stops recording. When the step failed with err, the recorded commands
+// and the resources of the manifests applied by the step are written to
+// <cluster>-debug/<step>, replacing the capture of an earlier run. Errors are
+// only logged, the step has already failed.
+func (c *stepCapture) end(err error) {
+	if c == nil {
+		return
+	}
+
+	capturesMu.Lock()
+	delete(captures, c.kconfig)
+	capturesMu.Unlock()
+	defer os.Remove(c.file)
+
+	if err == nil {
+		return
+	}
+
+	dir := filepath.Join(debugCaptureDir(c.clusterName), c.step)
+	if err := c.write(dir); err != nil {
+		slog.Error("error writing debug capture", "cluster", c.clusterName, "step", c.step, "error", err)
+		return
+	}
+
+	slog.Info("wrote debug capture of failed step", "cluster", c.clusterName, "step", c.step, "dir", dir)
+}
+
+func (c *stepCapture) write(dir string) error {
+	f, err := readFixture(c.file)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := mkdirPrivate(filepath.Join(dir, "resources")); err != nil {
+		return err
+	}
+	if err := recordArtifact(c.clusterName, debugCaptureDir(c.clusterName)); err != nil {
+		return err
+	}
+
+	applied := []string{}
+	for i, entry := range f.Entries {
+		f.Entries[i] = redactFixtureEntry(entry)
+		if len(entry.Args) > 0 && entry.Args[0] == "apply" {
+			applied = append(applied, appliedFiles(entry.Args)...)
+		}
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding debug capture: %v", err)
+	}
+	if err := writePrivateFile(filepath.Join(dir, "oc.json"), data); err != nil {
+		return err
+	}
+
+	// The resources are read as they are now, after the step failed.
+	for _, fileName := range applied {
+		output, err := ocCommand(c.kconfig, "get", "-f", fileName, "-o", "json").Output()
+		if err != nil {
+			slog.Warn("error dumping resources of failed step", "cluster", c.clusterName, "file", fileName, "error", err)
+			continue
+		}
+
+		name := filepath.Join(dir, "resources", filepath.Base(fileName))
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+		if err := writePrivateFile(name, []byte(redactOutput(string(output)))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appliedFiles returns the manifest files of the arguments of oc apply.
+func appliedFiles(args []string) []string {
+	files := []string{}
+	for i, arg := range args {
+		if arg == "-f" && i+1 < len(args) && args[i+1] != "-" {
+			files = append(files, args[i+1])
+		}
+	}
+
+	return files
+}
+
+// redactFixtureEntry redacts the secrets from the output of a recorded oc
+// command and the files it wrote, which are registry credentials. Docker
+// configs keep their registries, so that replayed runs can parse them.
+// Secrets read in another format than JSON, e.g. with a template, are
+// dropped otherwise.
+func redactFixtureEntry(entry fixtureEntry) fixtureEntry {
+	if readsSecrets(entry.Args) && !slices.Contains(entry.Args, "json") {
+		if config, ok := redactDockerConfig([]byte(entry.Stdout)); ok {
+			entry.Stdout = string(config)
+		} else {
+			entry.Stdout = redacted
+		}
+	} else {
+		entry.Stdout = redactOutput(entry.Stdout)
+	}
+	entry.Stderr = redactText(entry.Stderr)
+	for fileName, data := range entry.Files {
+		if config, ok := redactDockerConfig([]byte(data)); ok {
+			entry.Files[fileName] = string(config)
+		} else {
+			entry.Files[fileName] = redacted
+		}
+	}
+
+	return entry
+}
+
+// registryCredentialKeys are the keys of the credentials of a registry in a
+// Docker config.
+var registryCredentialKeys = []string{"auth", "password", "identitytoken", "registrytoken"}
+
+// redactDockerConfig redacts the credentials of a Docker config and keeps its
+// registries. The auths are replaced by a placeholder that still decodes like
+// an auth. It returns false for data that is not a Docker config.
+func redactDockerConfig(data []byte) ([]byte, bool) {
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, false
+	}
+
+	auths, ok := config["auths"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	for _, auth := range auths {
+		credentials, ok := auth.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		for _, key := range registryCredentialKeys {
+			if _, ok := credentials[key]; !ok {
+				continue
+			}
+
+			credentials[key] = redacted
+			if key == "auth" {
+				credentials[key] = base64.StdEncoding.EncodeToString([]byte(redacted + ":" + redacted))
+			}
+		}
+	}
+
+	redactedData, err := json.Marshal(config)
+	if err != nil {
+		return nil, false
+	}
+
+	return redactedData, true
+}
+
+// readsSecrets tells whether oc is run with Secrets as a resource.
+func readsSecrets(args []string) bool {
+	for _, arg := range args {
+		for _, resource := range strings.Split(arg, ",") {
+			resource, _, _ = strings.Cut(resource, "/")
+			if resource == "secret" || resource == "secrets" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// redactOutput redacts the data of the Secrets in the JSON output of an oc
+// command and the matches of the redact patterns.
+func redactOutput(output string) string {
+	var obj any
+	if err := json.Unmarshal([]byte(output), &obj); err != nil {
+		return redactText(output)
+	}
+
+	redactSecretData(obj)
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(obj); err != nil {
+		return redactText(output)
+	}
+
+	data, err := redactJSON(encoded.Bytes())
+	if err != nil {
+		return redacted
+	}
+
+	return string(data)
+}
+
+// redactSecretData replaces the values of the Secrets in a decoded object,
+// which can also be a List of Secrets.
+func redactSecretData(obj any) {
+	m, ok := obj.(map[string]any)
+	if !ok {
+		return
+	}
+
+	if m["kind"] == "Secret" {
+		if data, ok := m["data"].(map[string]any); ok {
+			for k, v := range data {
+				data[k] = redacted
+				// Pull secrets keep their registries.
+				value, _ := v.(string)
+				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+					if config, ok := redactDockerConfig(decoded); ok {
+						data[k] = base64.StdEncoding.EncodeToString(config)
+					}
+				}
+			}
+		}
+		if data, ok := m["stringData"].(map[string]any); ok {
+			for k, v := range data {
+				data[k] = redacted
+				value, _ := v.(string)
+				if config, ok := redactDockerConfig([]byte(value)); ok {
+					data[k] = string(config)
+				}
+			}
+		}
+
+		// Secrets applied client side keep their data in an annotation.
+		if metadata, ok := m["metadata"].(map[string]any); ok {
+			if annotations, ok := metadata["annotations"].(map[string]any); ok {
+				if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+					annotations["kubectl.kubernetes.io/last-applied-configuration"] = redacted
+				}
+			}
+		}
+	}
+
+	if items, ok := m["items"].([]any); ok {
+		for _, item := range items {
+			redactSecretData(item)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// deprecatedCommands maps the old names of moved commands to their current
+// names. Old invocations keep working with a warning.
+var deprecatedCommands = map[string]string{}
+
+// deprecatedFlag keeps the old name of a renamed flag working, with a
+// warning, by setting the flag of the current name.
+type deprecatedFlag struct {
+	flags   *flag.FlagSet
+	old     string
+	current string
+}
+
+func (f *deprecatedFlag) String() string {
+	return ""
+}
+
+func (f *deprecatedFlag) Set(value string) error {
+	slog.Warn("flag is deprecated", "flag", "-"+f.old, "use", "-"+f.current)
+	return f.flags.Set(f.current, value)
+}
+
+// IsBoolFlag lets the old name of a bool flag be given without a value.
+func (f *deprecatedFlag) IsBoolFlag() bool {
+	target := f.flags.Lookup(f.current)
+	if target == nil {
+		return false
+	}
+
+	b, ok := target.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// addDeprecatedFlag registers old as a deprecated name of the current flag,
+// which must be defined already.
+func addDeprecatedFlag(flags *flag.FlagSet, old, current string) {
+	flags.Var(&deprecatedFlag{flags: flags, old: old, current: current}, old, "Deprecated, use -"+current)
+}
+
+// configVersion is the schema version of the configuration file. Files
+// without a version predate versioning.
+const configVersion = 1
+
+// configMigration upgrades a configuration file to version.
+type configMigration struct {
+	version     int
+	description string
+	migrate     func(cfg map[string]any) error
+}
+
+// configMigrations are the migrations in order. A field renamed in the
+// schema gets a migration that moves its old key.
+var configMigrations = []configMigration{
+	{version: 1, description: "add the schema version", migrate: func(map[string]any) error { return nil }},
+}
+
+// migrateConfig upgrades the configuration file to the current schema. It
+// returns the migrated file and the descriptions of the applied migrations.
+func migrateConfig(data []byte) ([]byte, []string, error) {
+	raw := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	version := 0
+	if v, found := raw["version"]; found {
+		number, ok := v.(json.Number)
+		n, err := number.Int64()
+		if !ok || err != nil {
+			return nil, nil, fmt.Errorf("invalid config file version %v", v)
+		}
+		version = int(n)
+	}
+
+	if version > configVersion {
+		return nil, nil, fmt.Errorf("config file version %d is newer than the version %d of this installer", version, configVersion)
+	}
+
+	applied := []string{}
+	for _, migration := range configMigrations {
+		if migration.version <= version {
+			continue
+		}
+
+		if err := migration.migrate(raw); err != nil {
+			return nil, nil, fmt.Errorf("error migrating config file to version %d: %v", migration.version, err)
+		}
+		raw["version"] = migration.version
+		applied = append(applied, fmt.Sprintf("version %d: %s", migration.version, migration.description))
+	}
+
+	if len(applied) == 0 {
+		return data, nil, nil
+	}
+
+	migrated, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding config file: %v", err)
+	}
+
+	return append(migrated, '\n'), applied, nil
+}
+
+func runMigrateConfig(args []string) {
+	flags := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	inPlaceFlag := flags.Bool("in-place", false, "Rewrite the file, keeping the old one as <file>.bak, instead of printing the migrated file")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		slog.Error("error: a config file is required")
+		showUsageAndExit()
+	}
+	fileName := flags.Arg(0)
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		slog.Error("error reading config file", "error", err)
+		os.Exit(1)
+	}
+
+	migrated, applied, err := migrateConfig(data)
+	if err != nil {
+		slog.Error("error migrating config file", "error", err)
+		os.Exit(1)
+	}
+
+	if _, err := parseConfig(migrated); err != nil {
+		slog.Error("error: the migrated config file is not valid", "error", err)
+		os.Exit(1)
+	}
+
+	for _, migration := range applied {
+		slog.Info("migrated config file", "file", fileName, "migration", migration)
+	}
+
+	if !*inPlaceFlag {
+		os.Stdout.Write(migrated)
+		return
+	}
+
+	if len(applied) == 0 {
+		slog.Info("config file is up to date", "file", fileName)
+		return
+	}
+
+	if err := writePrivateFile(fileName+".bak", data); err != nil {
+		slog.Error("error writing backup of config file", "error", err)
+		os.Exit(1)
+	}
+
+	if err := writePrivateFile(fileName, migrated); err != nil {
+		slog.Error("error writing config file", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	mcoNamespace         = "openshift-operators"
+	mcoDeployment        = "odfmo-controller-manager"
+	tokenExchangeAddon   = "tokenexchange"
+	addonAgentNamespace  = "open-cluster-management-agent-addon"
+	peeringSecretTypeKey = "multicluster.odf.openshift.io/secret-type"
+)
+
+// finding is a problem found while diagnosing a setup, with the steps that
+// usually fix it.
+type finding struct {
+	Cluster     string `json:"cluster"`
+	Problem     string `json:"problem"`
+	Remediation string `json:"remediation"`
+}
+
+type condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type mirrorPeerStatus struct {
+	Status struct {
+		Phase   string `json:"phase"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type objectList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+type conditionedObject struct {
+	Status struct {
+		Conditions []condition `json:"conditions"`
+	} `json:"status"`
+}
+
+// errForbidden is returned by getJSON when the user may not read the
+// resource, so that optional reads can be skipped with a scoped service
+// account.
+var errForbidden = errors.New("forbidden, see print-rbac for the permissions")
+
+// getJSON runs oc get against the cluster of kconfig and decodes the output
+// into obj. found is false when the resource does not exist.
+func getJSON(kconfig string, obj any, args ...string) (bool, error) {
+	getArgs := append([]string{"get", "--ignore-not-found", "-o", "json"}, withReadSelector(args)...)
+	getCmd := ocCommand(kconfig, getArgs...)
+	output, err := getCmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "Forbidden") {
+			return false, fmt.Errorf("error getting %s: %w", strings.Join(args, " "), errForbidden)
+		}
+
+		return false, fmt.Errorf("error getting %s: %v", strings.Join(args, " "), err)
+	}
+
+	if len(strings.TrimSpace(string(output))) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(output, obj)
+	if err != nil {
+		return false, fmt.Errorf("error parsing %s: %v", strings.Join(args, " "), err)
+	}
+
+	return true, nil
+}
+
+func conditionStatus(conditions []condition, conditionType string) (condition, bool) {
+	for _, c := range conditions {
+		if c.Type == conditionType {
+			return c, true
+		}
+	}
+
+	return condition{}, false
+}
+
+func diagnoseMirrorPeer(kconfig, name string) ([]finding, string, error) {
+	var peer mirrorPeerStatus
+	found, err := getJSON(kconfig, &peer, "mirrorpeer", name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !found {
+		return []finding{{
+			Cluster:     "hub",
+			Problem:     "MirrorPeer " + name + " does not exist",
+			Remediation: "create the MirrorPeer with the configure-dr command",
+		}}, "", nil
+	}
+
+	slog.Info("MirrorPeer status", "name", name, "phase", peer.Status.Phase, "message", peer.Status.Message)
+
+	return nil, peer.Status.Phase, nil
+}
+
+func diagnoseHubCluster(kconfig, cluster string) ([]finding, error) {
+	findings := []finding{}
+
+	var addon conditionedObject
+	found, err := getJSON(kconfig, &addon, "managedclusteraddon", tokenExchangeAddon, "-n", cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		findings = append(findings, finding{
+			Cluster:     cluster,
+			Problem:     "ManagedClusterAddOn " + tokenExchangeAddon + " is missing in namespace " + cluster,
+			Remediation: "check that the ODF multicluster orchestrator is running on the hub, it creates the addon for every peered cluster",
+		})
+	} else if available, ok := conditionStatus(addon.Status.Conditions, "Available"); !ok || available.Status != "True" {
+		findings = append(findings, finding{
+			Cluster:     cluster,
+			Problem:     "ManagedClusterAddOn " + tokenExchangeAddon + " is not available: " + available.Message,
+			Remediation: "check the token-exchange-agent pods in " + addonAgentNamespace + " on the managed cluster and the klusterlet connection to the hub",
+		})
+	}
+
+	var secrets objectList
+	_, err = getJSON(kconfig, &secrets, "secrets", "-n", cluster, "-l", peeringSecretTypeKey)
+	if errors.Is(err, errForbidden) {
+		slog.Warn("not checking the peering token secrets, listing Secrets is forbidden", "namespace", cluster, "error", err)
+		return findings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secrets.Items) == 0 {
+		findings = append(findings, finding{
+			Cluster:     cluster,
+			Problem:     "no peering token secrets were exchanged to the hub namespace " + cluster,
+			Remediation: "the token exchange agent on the managed cluster has not reported the storage cluster token, check its logs and that the StorageCluster is Ready",
+		})
+	}
+
+	return findings, nil
+}
+
+func diagnoseManagedCluster(kconfig, cluster, storageNamespace string) ([]finding, error) {
+	findings := []finding{}
+
+	var pods objectList
+	_, err := getJSON(kconfig, &pods, "pods", "-n", addonAgentNamespace, "-l", "app=token-exchange-agent")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods.Items) == 0 {
+		findings = append(findings, finding{
+			Cluster:     cluster,
+			Problem:     "token exchange agent is not running in " + addonAgentNamespace,
+			Remediation: "check the ManifestWork for the " + tokenExchangeAddon + " addon on the hub and the klusterlet status of the cluster",
+		})
+	}
+
+	var secrets objectList
+	_, err = getJSON(kconfig, &secrets, "secrets", "-n", storageNamespace, "-l", peeringSecretTypeKey)
+	if errors.Is(err, errForbidden) {
+		slog.Warn("not checking the peering token secrets, listing Secrets is forbidden", "namespace", storageNamespace, "error", err)
+		return findings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secrets.Items) == 0 {
+		findings = append(findings, finding{
+			Cluster:     cluster,
+			Problem:     "no peering token secrets found in " + storageNamespace,
+			Remediation: "the peer token has not been delivered yet, check the hub side secrets and the odfmo-controller-manager logs",
+		})
+	}
+
+	return findings, nil
+}
+
+// getControllerErrors returns the recent error lines of the MCO controller.
+func getControllerErrors(kconfig string, tail int) ([]string, error) {
+	logsCmd := ocCommand(kconfig, "logs", "-n", mcoNamespace, "deployment/"+mcoDeployment, fmt.Sprintf("--tail=%d", tail))
+	logsOutput, err := logsCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s logs: %v", mcoDeployment, err)
+	}
+
+	errors := []string{}
+	for _, line := range strings.Split(string(logsOutput), "\n") {
+		if strings.Contains(strings.ToLower(line), "error") {
+			errors = append(errors, line)
+		}
+	}
+
+	return errors, nil
+}
+
+func printFindings(findings []finding) {
+	if len(findings) == 0 {
+		fmt.Println("No problems found.")
+		return
+	}
+
+	for i, f := range findings {
+		fmt.Printf("%d. [%s] %s\n   Suggestion: %s\n", i+1, f.Cluster, f.Problem, f.Remediation)
+	}
+}
+
+func runDiagnosePeering(args []string) {
+	flags := flag.NewFlagSet("diagnose-peering", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the hub cluster")
+	var clusters stringList
+	flags.Var(&clusters, "cluster", "Name of a peered ManagedCluster (must be given twice)")
+	var clusterKubeconfigs stringList
+	flags.Var(&clusterKubeconfigs, "cluster-kubeconfig", "Kubeconfig of a managed cluster in <cluster>=<kubeconfig> form (can be repeated)")
+	mirrorPeerFlag := flags.String("mirror-peer", "", "Name of the MirrorPeer (default: the name used by configure-dr)")
+	storageNamespaceFlag := flags.String("storage-namespace", "openshift-storage", "Namespace of the StorageCluster on the managed clusters")
+	tailFlag := flags.Int("tail", 500, "Number of MCO controller log lines to inspect")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+	addReadSelectorFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: hub kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if len(clusters) != 2 {
+		slog.Error("error: exactly two clusters are required")
+		showUsageAndExit()
+	}
+
+	managedKubeconfigs := map[string]string{}
+	for _, value := range clusterKubeconfigs {
+		cluster, kconfig, ok := strings.Cut(value, "=")
+		if !ok {
+			slog.Error("error: invalid cluster kubeconfig, expected <cluster>=<kubeconfig>", "value", value)
+			showUsageAndExit()
+		}
+		managedKubeconfigs[cluster] = kconfig
+	}
+
+	kconfig := *kubeconfigFlag
+	name := *mirrorPeerFlag
+	if name == "" {
+		name = mirrorPeerName(clusters)
+	}
+
+	findings, phase, err := diagnoseMirrorPeer(kconfig, name)
+	if err != nil {
+		slog.Error("error diagnosing MirrorPeer", "error", err)
+		os.Exit(1)
+	}
+
+	for _, cluster := range clusters {
+		hubFindings, err := diagnoseHubCluster(kconfig, cluster)
+		if err != nil {
+			slog.Error("error diagnosing hub", "cluster", cluster, "error", err)
+			os.Exit(1)
+		}
+		findings = append(findings, hubFindings...)
+
+		managedKubeconfig, ok := managedKubeconfigs[cluster]
+		if !ok {
+			slog.Info("no kubeconfig for managed cluster, skipping managed cluster checks", "cluster", cluster)
+			continue
+		}
+
+		managedFindings, err := diagnoseManagedCluster(managedKubeconfig, cluster, *storageNamespaceFlag)
+		if err != nil {
+			slog.Error("error diagnosing managed cluster", "cluster", cluster, "error", err)
+			os.Exit(1)
+		}
+		findings = append(findings, managedFindings...)
+	}
+
+	controllerErrors, err := getControllerErrors(kconfig, *tailFlag)
+	if err != nil {
+		slog.Warn("error inspecting MCO controller logs", "error", err)
+	}
+
+	if len(controllerErrors) > 0 {
+		fmt.Printf("Recent %s errors:\n", mcoDeployment)
+		for _, line := range controllerErrors {
+			fmt.Println("  " + line)
+		}
+		fmt.Println()
+	}
+
+	if phase == "ExchangingSecret" && len(findings) == 0 {
+		findings = append(findings, finding{
+			Cluster:     "hub",
+			Problem:     "MirrorPeer is stuck in ExchangingSecret but no component reports a problem",
+			Remediation: "restart the " + mcoDeployment + " deployment in " + mcoNamespace + " to retrigger the exchange",
+		})
+	}
+
+	printFindings(findings)
+
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
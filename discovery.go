@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// groupVersionKind is the apiVersion and kind of a manifest.
+type groupVersionKind struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+func (k groupVersionKind) String() string {
+	return k.Kind + " (" + k.APIVersion + ")"
+}
+
+// kindHints point to the replacement of kinds that are not served by every
+// OpenShift version.
+var kindHints = map[string]string{
+	"ImageContentSourcePolicy": "use an ImageDigestMirrorSet (config.openshift.io/v1) mirror set file with -mirror-set-file",
+	"ImageDigestMirrorSet":     "clusters before OpenShift 4.13 need an ImageContentSourcePolicy (operator.openshift.io/v1alpha1) mirror set file",
+}
+
+// manifestKind returns the apiVersion and kind of a manifest document. Only
+// JSON and YAML with top-level apiVersion and kind lines are understood.
+func manifestKind(doc string) (groupVersionKind, error) {
+	var kind groupVersionKind
+	if strings.HasPrefix(strings.TrimSpace(doc), "{") {
+		if err := json.Unmarshal([]byte(doc), &kind); err != nil {
+			return kind, fmt.Errorf("error parsing manifest: %v", err)
+		}
+	} else {
+		for _, line := range strings.Split(doc, "\n") {
+			if value, ok := strings.CutPrefix(line, "apiVersion:"); ok {
+				kind.APIVersion = strings.Trim(strings.TrimSpace(value), `"'`)
+			}
+			if value, ok := strings.CutPrefix(line, "kind:"); ok {
+				kind.Kind = strings.Trim(strings.TrimSpace(value), `"'`)
+			}
+		}
+	}
+
+	if kind.APIVersion == "" || kind.Kind == "" {
+		return kind, fmt.Errorf("manifest has no apiVersion or kind")
+	}
+
+	return kind, nil
+}
+
+// preparedKinds returns the kinds of the manifests that prepare applies.
+func preparedKinds(opts prepareOptions) ([]groupVersionKind, error) {
+	docs := []string{opts.catalogSourceYAML}
+	// The mirror sets of a hosted control plane cluster are set in the
+	// HostedCluster.
+	if opts.hostedCluster == nil {
+		for _, set := range opts.mirrorSets {
+			docs = append(docs, set.YAML)
+		}
+	}
+
+	kinds := []groupVersionKind{}
+	for _, doc := range docs {
+		kind, err := manifestKind(doc)
+		if err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+
+	if len(opts.operators) > 0 {
+		kinds = append(kinds,
+			groupVersionKind{APIVersion: "operators.coreos.com/v1alpha1", Kind: "Subscription"},
+			groupVersionKind{APIVersion: "operators.coreos.com/v1", Kind: "OperatorGroup"})
+	}
+
+	return kinds, nil
+}
+
+var (
+	servedKindsMu sync.Mutex
+	// servedKindsCache are the kinds served by group version, by
+	// kubeconfig.
+	servedKindsCache = map[string]map[string][]string{}
+)
+
+// servedKinds returns the kinds the cluster serves in a group version, which
+// is empty if the group version is not served.
+func servedKinds(kconfig, apiVersion string) ([]string, error) {
+	servedKindsMu.Lock()
+	defer servedKindsMu.Unlock()
+
+	if kinds, found := servedKindsCache[kconfig][apiVersion]; found {
+		return kinds, nil
+	}
+
+	path := "/apis/" + apiVersion
+	if !strings.Contains(apiVersion, "/") {
+		path = "/api/" + apiVersion
+	}
+
+	kinds := []string{}
+	output, err := ocCommand(kconfig, "get", "--raw", path).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || !strings.Contains(string(exitErr.Stderr), "NotFound") &&
+			!strings.Contains(string(exitErr.Stderr), "could not find the requested resource") {
+			return nil, fmt.Errorf("error discovering %s: %v", apiVersion, err)
+		}
+	} else {
+		var resources struct {
+			Resources []struct {
+				Kind string `json:"kind"`
+			} `json:"resources"`
+		}
+		if err := json.Unmarshal(output, &resources); err != nil {
+			return nil, fmt.Errorf("error parsing discovery of %s: %v", apiVersion, err)
+		}
+		for _, resource := range resources.Resources {
+			kinds = append(kinds, resource.Kind)
+		}
+	}
+
+	if servedKindsCache[kconfig] == nil {
+		servedKindsCache[kconfig] = map[string][]string{}
+	}
+	servedKindsCache[kconfig][apiVersion] = kinds
+
+	return kinds, nil
+}
+
+// checkServedKinds fails if the cluster does not serve one of the kinds, so
+// that a manifest of the wrong OpenShift version fails before anything is
+// applied rather than with an apply error halfway through. The kinds whose
+// group version could not be discovered are logged and returned as
+// unverified, the others are still checked.
+func checkServedKinds(clusterName, kconfig string, kinds []groupVersionKind) ([]string, error) {
+	unsupported := []string{}
+	unverified := []string{}
+	for _, kind := range kinds {
+		served, err := servedKinds(kconfig, kind.APIVersion)
+		if err != nil {
+			slog.Warn("error checking the kinds served by the cluster", "cluster", clusterName, "kind", kind.String(), "error", err)
+			if !slices.Contains(unverified, kind.String()) {
+				unverified = append(unverified, kind.String())
+			}
+			continue
+		}
+
+		if slices.Contains(served, kind.Kind) {
+			continue
+		}
+
+		message := kind.String()
+		if hint, found := kindHints[kind.Kind]; found {
+			message += ", " + hint
+		}
+		if !slices.Contains(unsupported, message) {
+			unsupported = append(unsupported, message)
+		}
+	}
+
+	if len(unverified) > 0 {
+		slog.Warn("kinds not verified to be served by the cluster", "cluster", clusterName, "kinds", unverified)
+	}
+
+	if len(unsupported) > 0 {
+		return unverified, fmt.Errorf("kind not supported on this cluster version: %s", strings.Join(unsupported, "; "))
+	}
+
+	return unverified, nil
+}
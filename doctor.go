@@ -0,0 +1,449 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// doctorNamespaces are the namespaces whose pods pull the images installed by
+// prepare.
+var doctorNamespaces = []string{"openshift-marketplace", "openshift-operators", "openshift-storage"}
+
+// doctorCheck looks for the signature of a known failure on a cluster.
+type doctorCheck struct {
+	name string
+	run  func(clusterName, kconfig string) ([]finding, error)
+}
+
+var doctorChecks = []doctorCheck{
+	{name: "catalog-image", run: checkCatalogImage},
+	{name: "machine-config-pools", run: checkMachineConfigPools},
+	{name: "mirror-auth", run: checkMirrorAuth},
+	{name: "clock-skew", run: checkClockSkew},
+	{name: "install-plans", run: checkInstallPlans},
+	{name: "mirror-peer-tokens", run: checkMirrorPeerTokens},
+}
+
+type podStatusList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Status struct {
+			ContainerStatuses []struct {
+				Image string `json:"image"`
+				State struct {
+					Waiting *struct {
+						Reason  string `json:"reason"`
+						Message string `json:"message"`
+					} `json:"waiting"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// imagePullFailures returns the image pull errors of the pods, by pod.
+func imagePullFailures(pods podStatusList) map[string]string {
+	failures := map[string]string{}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			waiting := container.State.Waiting
+			if waiting == nil || (waiting.Reason != "ErrImagePull" && waiting.Reason != "ImagePullBackOff") {
+				continue
+			}
+			failures[pod.Metadata.Namespace+"/"+pod.Metadata.Name] = waiting.Message
+		}
+	}
+
+	return failures
+}
+
+func isAuthFailure(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "401") || strings.Contains(message, "unauthorized") ||
+		strings.Contains(message, "authentication required")
+}
+
+// checkCatalogImage finds CatalogSources whose registry pod cannot pull the
+// index image because it does not exist, usually a build that was pruned
+// from quay.io or not mirrored.
+func checkCatalogImage(clusterName, kconfig string) ([]finding, error) {
+	var pods podStatusList
+	_, err := getJSON(kconfig, &pods, "pods", "-n", "openshift-marketplace", "-l", "olm.catalogSource")
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []finding{}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			waiting := container.State.Waiting
+			if waiting == nil || (waiting.Reason != "ErrImagePull" && waiting.Reason != "ImagePullBackOff") || isAuthFailure(waiting.Message) {
+				continue
+			}
+
+			findings = append(findings, finding{
+				Cluster: clusterName,
+				Problem: fmt.Sprintf("registry pod of CatalogSource %s cannot pull %s: %s",
+					pod.Metadata.Labels["olm.catalogSource"], container.Image, waiting.Message),
+				Remediation: "check that the build exists with list-builds and rerun prepare with -force catalog and an existing -catalog-image, or mirror the image if the cluster is disconnected",
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// checkMachineConfigPools finds pools that are degraded or nodes stuck in a
+// machine config update, which blocks the mirror sets and the pull secret
+// from reaching the nodes.
+func checkMachineConfigPools(clusterName, kconfig string) ([]finding, error) {
+	var pools struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Status   struct {
+				Conditions           []condition `json:"conditions"`
+				DegradedMachineCount int         `json:"degradedMachineCount"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	_, err := getJSON(kconfig, &pools, "machineconfigpools")
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []finding{}
+	for _, pool := range pools.Items {
+		if degraded, ok := conditionStatus(pool.Status.Conditions, "Degraded"); ok && degraded.Status == "True" {
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     fmt.Sprintf("MachineConfigPool %s is degraded with %d degraded machines: %s", pool.Metadata.Name, pool.Status.DegradedMachineCount, degraded.Message),
+				Remediation: "check the machine-config-daemon logs of the degraded node, a node that cannot drain is usually blocked by a PodDisruptionBudget",
+			})
+		}
+	}
+
+	var nodes struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	_, err = getJSON(kconfig, &nodes, "nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes.Items {
+		annotations := node.Metadata.Annotations
+		if annotations["machineconfiguration.openshift.io/state"] != "Degraded" {
+			continue
+		}
+
+		findings = append(findings, finding{
+			Cluster:     clusterName,
+			Problem:     "node " + node.Metadata.Name + " is stuck applying its machine config: " + annotations["machineconfiguration.openshift.io/reason"],
+			Remediation: "fix the reason above and delete the machine-config-daemon pod of the node, or reboot the node if the config was partially applied",
+		})
+	}
+
+	return findings, nil
+}
+
+// checkMirrorAuth finds pods that cannot pull their images because the
+// registry rejects the credentials, usually a rhceph-dev password that was
+// rotated or a pull secret that did not reach the nodes yet.
+func checkMirrorAuth(clusterName, kconfig string) ([]finding, error) {
+	findings := []finding{}
+	for _, ns := range doctorNamespaces {
+		var pods podStatusList
+		_, err := getJSON(kconfig, &pods, "pods", "-n", ns)
+		if err != nil {
+			return nil, err
+		}
+
+		failures := imagePullFailures(pods)
+		for _, pod := range sortedClusterNames(failures) {
+			if !isAuthFailure(failures[pod]) {
+				continue
+			}
+
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     "pod " + pod + " is rejected by the registry: " + failures[pod],
+				Remediation: "check the -rhceph-password and rerun prepare with -force pull-secret, then wait for the MachineConfigPools to roll out the pull secret",
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// maxClockSkew is the clock difference above which certificates and the
+// peering tokens are rejected.
+const maxClockSkew = time.Minute
+
+// checkClockSkew compares the clocks of the nodes, as seen in the renewals of
+// their leases, with each other and with the local clock.
+func checkClockSkew(clusterName, kconfig string) ([]finding, error) {
+	var leases struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Spec     struct {
+				RenewTime string `json:"renewTime"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	_, err := getJSON(kconfig, &leases, "leases", "-n", "kube-node-lease")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	offsets := map[string]time.Duration{}
+	for _, lease := range leases.Items {
+		renewed, err := time.Parse(time.RFC3339Nano, lease.Spec.RenewTime)
+		if err != nil {
+			continue
+		}
+		offsets[lease.Metadata.Name] = renewed.Sub(now)
+	}
+
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+
+	sorted := []time.Duration{}
+	for _, offset := range offsets {
+		sorted = append(sorted, offset)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	findings := []finding{}
+	for _, node := range sortedClusterNames(offsets) {
+		// Leases are renewed every 10 seconds, and a lease of a node that is
+		// down is old rather than skewed.
+		if skew := offsets[node] - median; skew > maxClockSkew || skew < -maxClockSkew && skew > -5*time.Minute {
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     fmt.Sprintf("clock of node %s is %s off from the other nodes", node, skew.Round(time.Second)),
+				Remediation: "check the chrony configuration of the node and that it can reach its NTP servers",
+			})
+		}
+	}
+
+	if median > maxClockSkew || median < -maxClockSkew {
+		findings = append(findings, finding{
+			Cluster:     clusterName,
+			Problem:     fmt.Sprintf("clock of the cluster is %s off from the local clock", median.Round(time.Second)),
+			Remediation: "compare the clocks of the DR clusters and the hub, a skew between them makes the peering tokens and S3 requests fail, fix the NTP servers of the skewed side",
+		})
+	}
+
+	return findings, nil
+}
+
+// stuckInstallPlanAge is the age after which an InstallPlan that is not
+// Complete is considered stuck.
+const stuckInstallPlanAge = 15 * time.Minute
+
+// checkInstallPlans finds InstallPlans that wait for approval, failed or do
+// not finish.
+func checkInstallPlans(clusterName, kconfig string) ([]finding, error) {
+	var plans struct {
+		Items []struct {
+			Metadata struct {
+				Name              string `json:"name"`
+				Namespace         string `json:"namespace"`
+				CreationTimestamp string `json:"creationTimestamp"`
+			} `json:"metadata"`
+			Spec struct {
+				ClusterServiceVersionNames []string `json:"clusterServiceVersionNames"`
+				Approval                   string   `json:"approval"`
+				Approved                   bool     `json:"approved"`
+			} `json:"spec"`
+			Status struct {
+				Phase      string      `json:"phase"`
+				Conditions []condition `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	_, err := getJSON(kconfig, &plans, "installplans", "-A")
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []finding{}
+	for _, plan := range plans.Items {
+		name := plan.Metadata.Namespace + "/" + plan.Metadata.Name
+		csvs := strings.Join(plan.Spec.ClusterServiceVersionNames, ", ")
+
+		switch {
+		case plan.Status.Phase == "Complete":
+			continue
+		case plan.Spec.Approval == "Manual" && !plan.Spec.Approved:
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     "InstallPlan " + name + " of " + csvs + " waits for manual approval",
+				Remediation: "approve it with oc patch installplan " + plan.Metadata.Name + " -n " + plan.Metadata.Namespace + " --type merge -p '{\"spec\":{\"approved\":true}}', or set the Subscription to Automatic",
+			})
+		case plan.Status.Phase == "Failed":
+			message := ""
+			if installed, ok := conditionStatus(plan.Status.Conditions, "Installed"); ok {
+				message = ": " + installed.Message
+			}
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     "InstallPlan " + name + " of " + csvs + " failed" + message,
+				Remediation: "delete the InstallPlan and the failed ClusterServiceVersion, OLM creates a new InstallPlan for the Subscription",
+			})
+		default:
+			created, err := time.Parse(time.RFC3339, plan.Metadata.CreationTimestamp)
+			if err != nil || time.Since(created) < stuckInstallPlanAge {
+				continue
+			}
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     fmt.Sprintf("InstallPlan %s of %s is %s for %s", name, csvs, plan.Status.Phase, time.Since(created).Round(time.Minute)),
+				Remediation: "check the catalog-operator logs in openshift-operator-lifecycle-manager, restarting the catalog-operator pod usually unblocks it",
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// checkMirrorPeerTokens finds MirrorPeers on a hub whose clusters have not
+// exchanged their peering tokens.
+func checkMirrorPeerTokens(clusterName, kconfig string) ([]finding, error) {
+	var peers struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Spec     struct {
+				Items []mirrorPeerItem `json:"items"`
+			} `json:"spec"`
+			mirrorPeerStatus
+		} `json:"items"`
+	}
+	// Managed clusters do not have the MirrorPeer CRD.
+	found, err := getJSON(kconfig, &objectList{}, "crd", "mirrorpeers.multicluster.odf.openshift.io")
+	if err != nil || !found {
+		return nil, err
+	}
+	_, err = getJSON(kconfig, &peers, "mirrorpeers")
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []finding{}
+	for _, peer := range peers.Items {
+		if peer.Status.Phase == "ExchangedSecret" || peer.Status.Phase == "S3ProfileSynced" {
+			continue
+		}
+
+		peerFindings := []finding{}
+		for _, item := range peer.Spec.Items {
+			hubFindings, err := diagnoseHubCluster(kconfig, item.ClusterName)
+			if err != nil {
+				return nil, err
+			}
+			peerFindings = append(peerFindings, hubFindings...)
+		}
+		findings = append(findings, peerFindings...)
+
+		if len(peerFindings) == 0 {
+			findings = append(findings, finding{
+				Cluster:     clusterName,
+				Problem:     "MirrorPeer " + peer.Metadata.Name + " is in phase " + peer.Status.Phase + " but every token was exchanged",
+				Remediation: "run diagnose-peering with the managed cluster kubeconfigs to check the managed cluster side",
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	var kubeconfigs stringList
+	flags.Var(&kubeconfigs, "kubeconfig", "Kubeconfig of a cluster to check (can be repeated)")
+	var only stringList
+	flags.Var(&only, "check", "Name of a check to run (can be repeated, default: all checks)")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+	addReadSelectorFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(kubeconfigs) == 0 {
+		slog.Error("error: at least one kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	checks := doctorChecks
+	if len(only) > 0 {
+		checks = []doctorCheck{}
+		for _, name := range only {
+			i := 0
+			for i < len(doctorChecks) && doctorChecks[i].name != name {
+				i++
+			}
+			if i == len(doctorChecks) {
+				slog.Error("error: unknown check", "check", name)
+				showUsageAndExit()
+			}
+			checks = append(checks, doctorChecks[i])
+		}
+	}
+
+	findings := []finding{}
+	for _, kconfig := range kubeconfigs {
+		url, err := getServerURL(kconfig)
+		if err != nil {
+			slog.Error("error checking cluster", "kubeconfig", kconfig, "error", err)
+			os.Exit(1)
+		}
+
+		clusterName, err := getClusterName(url)
+		if err != nil {
+			slog.Error("error getting cluster name", "error", err)
+			os.Exit(1)
+		}
+
+		for _, check := range checks {
+			checkFindings, err := check.run(clusterName, kconfig)
+			if err != nil {
+				slog.Warn("error running check", "cluster", clusterName, "check", check.name, "error", err)
+				continue
+			}
+			findings = append(findings, checkFindings...)
+		}
+	}
+
+	printFindings(findings)
+
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
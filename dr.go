@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// odfInfoClaim is the ClusterClaim published by the ODF multicluster
+// orchestrator addon once it has discovered the storage system of a managed
+// cluster. MirrorPeer creation fails until it is present on both clusters.
+const odfInfoClaim = "odfinfo.odf.openshift.io"
+
+type managedCluster struct {
+	Metadata struct {
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		ManagedClusterClientConfigs []struct {
+			URL string `json:"url"`
+		} `json:"managedClusterClientConfigs"`
+	} `json:"spec"`
+	Status struct {
+		ClusterClaims []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"clusterClaims"`
+	} `json:"status"`
+}
+
+type storageClusterRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type mirrorPeerItem struct {
+	ClusterName       string            `json:"clusterName"`
+	StorageClusterRef storageClusterRef `json:"storageClusterRef"`
+}
+
+type mirrorPeer struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Items    []mirrorPeerItem `json:"items"`
+		ManageS3 bool             `json:"manageS3"`
+		Type     string           `json:"type"`
+	} `json:"spec"`
+}
+
+func getManagedCluster(kconfig, name string) (*managedCluster, error) {
+	getCmd := ocCommand(kconfig, "get", "managedcluster", name, "-o", "json")
+	output, err := getCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting ManagedCluster %s: %v", name, err)
+	}
+
+	var cluster managedCluster
+	err = json.Unmarshal(output, &cluster)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ManagedCluster %s: %v", name, err)
+	}
+
+	return &cluster, nil
+}
+
+// labelManagedCluster adds the labels that are missing or different on the
+// ManagedCluster.
+func labelManagedCluster(kconfig, name string, labels map[string]string) error {
+	cluster, err := getManagedCluster(kconfig, name)
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for key, value := range labels {
+		if cluster.Metadata.Labels[key] != value {
+			missing = append(missing, key+"="+value)
+		}
+	}
+
+	if len(missing) == 0 {
+		slog.Info("ManagedCluster already has the required labels", "cluster", name)
+		return nil
+	}
+
+	slices.Sort(missing)
+	labelArgs := append([]string{"label", "--overwrite", "managedcluster", name}, missing...)
+	labelCmd := ocCommand(kconfig, labelArgs...)
+	err = labelCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error labeling ManagedCluster %s: %v", name, err)
+	}
+
+	slog.Info("labeled ManagedCluster", "cluster", name, "labels", missing)
+
+	return nil
+}
+
+func waitForClusterClaim(kconfig, name, claim string, timeout time.Duration) error {
+	return waitFor(kconfig, "ClusterClaim "+claim+" on ManagedCluster "+name, timeout, 10*time.Second, func() (bool, error) {
+		cluster, err := getManagedCluster(kconfig, name)
+		if err != nil {
+			return false, err
+		}
+
+		for _, clusterClaim := range cluster.Status.ClusterClaims {
+			if clusterClaim.Name == claim {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+}
+
+func mirrorPeerName(clusters []string) string {
+	return "mirrorpeer-" + strings.Join(clusters, "-")
+}
+
+func addMirrorPeer(hubName, kconfig string, clusters []string, ref storageClusterRef) error {
+	var peer mirrorPeer
+	peer.APIVersion = "multicluster.odf.openshift.io/v1alpha1"
+	peer.Kind = "MirrorPeer"
+	peer.Metadata.Name = mirrorPeerName(clusters)
+	peer.Spec.ManageS3 = true
+	peer.Spec.Type = "async"
+	for _, cluster := range clusters {
+		peer.Spec.Items = append(peer.Spec.Items, mirrorPeerItem{ClusterName: cluster, StorageClusterRef: ref})
+	}
+
+	data, err := json.MarshalIndent(peer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding MirrorPeer: %v", err)
+	}
+
+	mirrorPeerFileName := hubName + "-mirrorpeer.json"
+	err = writeArtifact(hubName, mirrorPeerFileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing MirrorPeer to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, mirrorPeerFileName)
+	if err != nil {
+		return fmt.Errorf("error applying MirrorPeer: %v", err)
+	}
+
+	slog.Info("applied MirrorPeer", "name", peer.Metadata.Name)
+
+	return nil
+}
+
+// drOptions describe how a pair of managed clusters is peered on the hub.
+type drOptions struct {
+	clusters          []string
+	clusterLabels     map[string]string
+	clusterClaim      string
+	claimTimeout      time.Duration
+	storageClusterRef storageClusterRef
+	// storageClasses configure the replication of the storage classes of
+	// the clusters.
+	storageClasses []storageClassDR
+	// storageBackend is the storage of the clusters. Only ODF clusters are
+	// peered with a MirrorPeer.
+	storageBackend string
+	// clusterSet, when set, puts the clusters into a ManagedClusterSet.
+	clusterSet *clusterSetConfig
+	// gitops, when set, registers the clusters with OpenShift GitOps.
+	gitops *gitopsConfig
+	// network, when set, allows the DR traffic between the clusters
+	// through their NetworkPolicies and EgressFirewalls.
+	network *drNetworkConfig
+	// manifests are files with a MirrorPeer, DRClusters and DRPolicies of
+	// the user, applied instead of generating them.
+	manifests []string
+	// standbyHub, when set, gets a copy of the DR resources of the pair.
+	standbyHub *standbyHubConfig
+}
+
+func defaultDROptions(clusters []string) drOptions {
+	return drOptions{
+		clusters:          clusters,
+		clusterLabels:     map[string]string{},
+		clusterClaim:      odfInfoClaim,
+		claimTimeout:      10 * time.Minute,
+		storageClusterRef: storageClusterRef{Name: "ocs-storagecluster", Namespace: "openshift-storage"},
+		storageBackend:    storageBackendODF,
+	}
+}
+
+// configureDR peers the managed clusters once they are labeled and report
+// their storage systems to the hub.
+func configureDR(hubName, kconfig string, opts drOptions) error {
+	var user *userDRManifests
+	if len(opts.manifests) > 0 {
+		var err error
+		user, err = loadDRManifests(hubName, kconfig, opts.manifests, opts.clusters)
+		if err != nil {
+			return fmt.Errorf("error loading DR manifests: %v", err)
+		}
+		if user.mirrorPeerFile != "" && opts.storageBackend == storageBackendLVMS {
+			return fmt.Errorf("a MirrorPeer was given, but LVM Storage clusters are not peered")
+		}
+	}
+
+	for _, cluster := range opts.clusters {
+		if err := labelManagedCluster(kconfig, cluster, opts.clusterLabels); err != nil {
+			return err
+		}
+	}
+
+	if opts.clusterSet != nil {
+		if err := addClusterSet(hubName, kconfig, opts.clusters, opts.clusterSet); err != nil {
+			return fmt.Errorf("error adding cluster set: %v", err)
+		}
+	}
+
+	// The traffic between the clusters is allowed before they are peered.
+	if opts.network != nil {
+		if err := applyDRNetworkPolicies(hubName, kconfig, opts.clusters, opts.network); err != nil {
+			return fmt.Errorf("error allowing DR traffic: %v", err)
+		}
+	}
+
+	// LVM Storage clusters report no storage systems and have no mirroring
+	// to peer, their volumes are replicated by VolSync.
+	if opts.storageBackend == storageBackendLVMS {
+		slog.Info("not peering LVM Storage clusters, volumes are replicated by VolSync", "clusters", opts.clusters)
+
+		if err := applyUserDRResources(kconfig, user); err != nil {
+			return err
+		}
+	} else {
+		// Ramen propagates the S3 secrets of the peers into the namespace of
+		// its cluster operator, which is created before them.
+		if err := addDRNamespaces(hubName, kconfig, opts.clusters); err != nil {
+			return fmt.Errorf("error adding DR namespaces: %v", err)
+		}
+
+		for _, cluster := range opts.clusters {
+			if err := waitForClusterClaim(kconfig, cluster, opts.clusterClaim, opts.claimTimeout); err != nil {
+				return err
+			}
+		}
+
+		if user != nil && user.mirrorPeerFile != "" {
+			if _, err := applyManifest(kconfig, user.mirrorPeerFile); err != nil {
+				return fmt.Errorf("error applying MirrorPeer: %v", err)
+			}
+			opts.storageClusterRef = user.storageClusterRef
+			slog.Info("applied MirrorPeer of the user", "file", user.mirrorPeerFile)
+		} else if err := addMirrorPeer(hubName, kconfig, opts.clusters, opts.storageClusterRef); err != nil {
+			return fmt.Errorf("error adding MirrorPeer: %v", err)
+		}
+
+		// Ramen propagates the S3 secrets once a DRPolicy covers the
+		// clusters, which the secrets are checked for.
+		if err := applyUserDRResources(kconfig, user); err != nil {
+			return err
+		}
+
+		if err := checkDRSecrets(hubName, kconfig, opts.clusters, opts.storageClusterRef.Name, opts.claimTimeout); err != nil {
+			return fmt.Errorf("error checking DR secrets: %v", err)
+		}
+	}
+
+	if user != nil {
+		if err := waitForDRResources(kconfig, user, opts.claimTimeout); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.storageClasses) > 0 {
+		summarizeDR(os.Stdout, opts.storageClasses)
+
+		if err := applyStorageClassDR(hubName, kconfig, opts.clusters, opts.storageClasses); err != nil {
+			return fmt.Errorf("error configuring DR storage classes: %v", err)
+		}
+	}
+
+	if opts.gitops != nil {
+		if err := configureGitOps(hubName, kconfig, opts.clusters, opts.gitops); err != nil {
+			return fmt.Errorf("error configuring OpenShift GitOps: %v", err)
+		}
+	}
+
+	if err := recordDRInventory(hubName, kconfig, opts); err != nil {
+		return fmt.Errorf("error recording DR inventory: %v", err)
+	}
+
+	if opts.standbyHub != nil {
+		if err := replicateToStandbyHub(hubName, kconfig, opts); err != nil {
+			return fmt.Errorf("error replicating to standby hub: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func runConfigureDR(args []string) {
+	flags := flag.NewFlagSet("configure-dr", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the hub cluster")
+	var clusters stringList
+	flags.Var(&clusters, "cluster", "Name of a ManagedCluster to peer (must be given twice)")
+	var clusterLabels stringList
+	var clusterKubeconfigs stringList
+	flags.Var(&clusterKubeconfigs, "cluster-kubeconfig", "Kubeconfig of a managed cluster in <cluster>=<kubeconfig> form, required by dr.network (can be repeated)")
+	flags.Var(&clusterLabels, "cluster-label", "Label in key=value form required on both ManagedClusters (can be repeated)")
+	var drManifests stringList
+	flags.Var(&drManifests, "dr-manifest", "File with a MirrorPeer, DRClusters or DRPolicies to apply instead of the generated ones (can be repeated)")
+	claimFlag := flags.String("cluster-claim", odfInfoClaim, "ClusterClaim to wait for on both ManagedClusters")
+	claimTimeoutFlag := flags.Duration("claim-timeout", 10*time.Minute, "How long to wait for the ClusterClaims")
+	storageClusterFlag := flags.String("storage-cluster", "ocs-storagecluster", "Name of the StorageCluster on the managed clusters")
+	storageNamespaceFlag := flags.String("storage-namespace", "openshift-storage", "Namespace of the StorageCluster on the managed clusters")
+	configFlag := addConfigFlag(flags)
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: hub kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if len(clusters) != 2 {
+		slog.Error("error: exactly two clusters are required")
+		showUsageAndExit()
+	}
+
+	labels := map[string]string{}
+	for _, label := range clusterLabels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			slog.Error("error: invalid cluster label, expected key=value", "label", label)
+			showUsageAndExit()
+		}
+		labels[key] = value
+	}
+
+	managedKubeconfigs := map[string]string{}
+	for _, value := range clusterKubeconfigs {
+		cluster, managedKconfig, ok := strings.Cut(value, "=")
+		if !ok {
+			slog.Error("error: invalid cluster kubeconfig, expected <cluster>=<kubeconfig>", "value", value)
+			showUsageAndExit()
+		}
+		managedKubeconfigs[cluster] = managedKconfig
+	}
+
+	kconfig := *kubeconfigFlag
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to hub", "error", err)
+		os.Exit(1)
+	}
+
+	hubName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting cluster name", "error", err)
+		os.Exit(1)
+	}
+
+	opts := cfg.drOptions(clusters)
+	opts.clusterLabels = labels
+	opts.clusterClaim = *claimFlag
+	opts.claimTimeout = *claimTimeoutFlag
+	opts.storageClusterRef = storageClusterRef{Name: *storageClusterFlag, Namespace: *storageNamespaceFlag}
+	opts.manifests = drManifests
+
+	if opts.network != nil {
+		kconfigs := []string{}
+		for _, cluster := range clusters {
+			managedKconfig, ok := managedKubeconfigs[cluster]
+			if !ok {
+				slog.Error("error: dr.network needs the kubeconfig of every cluster to read its NetworkPolicies", "cluster", cluster)
+				showUsageAndExit()
+			}
+			kconfigs = append(kconfigs, managedKconfig)
+		}
+
+		network, err := opts.network.withDiscoveredCIDRs(clusters, kconfigs)
+		if err == nil {
+			network, err = network.withPolicies(clusters, kconfigs)
+		}
+		if err != nil {
+			slog.Error("error reading the networks of the clusters", "error", err)
+			os.Exit(1)
+		}
+		opts.network = network
+	}
+
+	if err := configureDR(hubName, kconfig, opts); err != nil {
+		slog.Error("error configuring DR", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+// drManifestKinds are the kinds of the DR manifests configure-dr accepts from
+// the user instead of generating them.
+var drManifestKinds = []string{"MirrorPeer", "DRCluster", "DRPolicy"}
+
+// drManifestObject is a DR manifest as the hub would store it.
+type drManifestObject struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Items      []mirrorPeerItem `json:"items"`
+		DRClusters []string         `json:"drClusters"`
+	} `json:"spec"`
+}
+
+// userDRManifests are the DR manifests of the user, split into the file of the
+// MirrorPeer, which replaces the generated one, and the file of the DRClusters
+// and DRPolicies, which are applied after it. A file is empty when the user
+// gave no manifest for it.
+type userDRManifests struct {
+	mirrorPeerFile string
+	// storageClusterRef is the StorageCluster of the MirrorPeer of the
+	// user, which names the S3 store profiles.
+	storageClusterRef storageClusterRef
+
+	resourcesFile string
+	// validated are the DRClusters and DRPolicies, as resource/name, that are
+	// waited for to be validated.
+	validated []string
+}
+
+// loadDRManifests reads the DR manifests of the user, writes them into the
+// artifacts of the hub and checks them against the hub with a server side
+// dry run, which validates them against the CRDs. The MirrorPeer must peer
+// exactly the clusters, the DRClusters must be among them and the DRPolicies
+// must cover them.
+func loadDRManifests(hubName, kconfig string, fileNames, clusters []string) (*userDRManifests, error) {
+	mirrorPeerDocs := []string{}
+	resourceDocs := []string{}
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+		}
+
+		docs, err := manifest.SplitDocuments(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+		}
+
+		for _, doc := range docs {
+			kind, err := manifestKind(doc)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+			}
+			if !slices.Contains(drManifestKinds, kind.Kind) {
+				return nil, fmt.Errorf("%s has a %s, expected only %s", fileName, kind.Kind, strings.Join(drManifestKinds, ", "))
+			}
+
+			if kind.Kind == "MirrorPeer" {
+				mirrorPeerDocs = append(mirrorPeerDocs, doc)
+			} else {
+				resourceDocs = append(resourceDocs, doc)
+			}
+		}
+	}
+
+	if len(mirrorPeerDocs) > 1 {
+		return nil, fmt.Errorf("%d MirrorPeers given, expected one for the clusters", len(mirrorPeerDocs))
+	}
+
+	user := &userDRManifests{}
+	if len(mirrorPeerDocs) > 0 {
+		user.mirrorPeerFile = hubName + "-" + strings.Join(clusters, "-") + "-user-mirrorpeer.yaml"
+		objects, err := writeDRManifests(hubName, kconfig, user.mirrorPeerFile, mirrorPeerDocs)
+		if err != nil {
+			return nil, err
+		}
+
+		peer := objects[0]
+		peered := []string{}
+		for _, item := range peer.Spec.Items {
+			peered = append(peered, item.ClusterName)
+		}
+		if len(peered) != len(clusters) || !coversClusters(peered, clusters) {
+			return nil, fmt.Errorf("MirrorPeer %s peers %s, expected %s", peer.Metadata.Name, strings.Join(peered, ", "), strings.Join(clusters, ", "))
+		}
+		user.storageClusterRef = peer.Spec.Items[0].StorageClusterRef
+	}
+
+	if len(resourceDocs) > 0 {
+		user.resourcesFile = hubName + "-" + strings.Join(clusters, "-") + "-user-dr-resources.yaml"
+		objects, err := writeDRManifests(hubName, kconfig, user.resourcesFile, resourceDocs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range objects {
+			switch obj.Kind {
+			case "DRCluster":
+				if !slices.Contains(clusters, obj.Metadata.Name) {
+					return nil, fmt.Errorf("DRCluster %s is not one of %s", obj.Metadata.Name, strings.Join(clusters, ", "))
+				}
+			case "DRPolicy":
+				if !coversClusters(obj.Spec.DRClusters, clusters) {
+					return nil, fmt.Errorf("DRPolicy %s covers %s, expected %s", obj.Metadata.Name,
+						strings.Join(obj.Spec.DRClusters, ", "), strings.Join(clusters, ", "))
+				}
+			}
+			user.validated = append(user.validated, strings.ToLower(obj.Kind)+".ramendr.openshift.io/"+obj.Metadata.Name)
+		}
+	}
+
+	slog.Info("loaded DR manifests", "files", fileNames, "mirrorPeer", user.mirrorPeerFile != "", "resources", user.validated)
+
+	return user, nil
+}
+
+// writeDRManifests writes manifest documents into an artifact of the hub and
+// returns them as the hub would store them, from a server side dry run.
+func writeDRManifests(hubName, kconfig, fileName string, docs []string) ([]drManifestObject, error) {
+	if err := writeArtifact(hubName, fileName, []byte(strings.Join(docs, "---\n"))); err != nil {
+		return nil, fmt.Errorf("error writing %s: %v", fileName, err)
+	}
+
+	dryRunCmd := ocCommand(kconfig, "apply", "--server-side", "--dry-run=server", "--field-manager="+fieldManager, "-f", fileName, "-o", "json")
+	output, err := dryRunCmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("invalid DR manifests: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	applied, err := manifest.SplitDocuments(string(output))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []drManifestObject{}
+	for _, doc := range applied {
+		var obj drManifestObject
+		if err := json.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, fmt.Errorf("error parsing dry run of %s: %v", fileName, err)
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// applyUserDRResources applies the DRClusters and DRPolicies of the user, if
+// any.
+func applyUserDRResources(kconfig string, user *userDRManifests) error {
+	if user == nil || user.resourcesFile == "" {
+		return nil
+	}
+
+	if _, err := applyManifest(kconfig, user.resourcesFile); err != nil {
+		return fmt.Errorf("error applying DR resources: %v", err)
+	}
+	slog.Info("applied DR resources of the user", "resources", user.validated)
+
+	return nil
+}
+
+// waitForDRResources waits for the DRClusters and DRPolicies of the user to
+// be validated by Ramen.
+func waitForDRResources(kconfig string, user *userDRManifests, timeout time.Duration) error {
+	for _, resource := range user.validated {
+		var message string
+		err := waitFor(kconfig, resource+" to be validated", timeout, 10*time.Second, func() (bool, error) {
+			var obj conditionedObject
+			found, err := getJSON(kconfig, &obj, resource)
+			if err != nil || !found {
+				return false, err
+			}
+
+			validated, ok := conditionStatus(obj.Status.Conditions, "Validated")
+			message = validated.Message
+
+			return ok && validated.Status == "True", nil
+		})
+		if err != nil {
+			if message != "" {
+				return fmt.Errorf("%v: %s", err, message)
+			}
+			return err
+		}
+
+		slog.Info("DR resource validated", "resource", resource)
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// s3StoreProfile is an S3 store of the Ramen hub configuration. The ODF
+// multicluster orchestrator adds a profile named
+// s3profile-<cluster>-<StorageCluster> for every peered cluster, with the
+// credentials in a Secret of the hub operator namespace, which Ramen
+// propagates to the openshift-dr-system namespace of the DR clusters.
+type s3StoreProfile struct {
+	Name       string
+	SecretName string
+	Endpoint   string
+	Bucket     string
+	Region     string
+}
+
+func s3ProfileName(cluster, storageCluster string) string {
+	return "s3profile-" + cluster + "-" + storageCluster
+}
+
+// parseS3StoreProfiles returns the s3StoreProfiles of a Ramen configuration.
+// Only the YAML block style written by the operators is understood.
+func parseS3StoreProfiles(ramenConfig string) []s3StoreProfile {
+	profiles := []s3StoreProfile{}
+	inProfiles := false
+	inSecretRef := false
+
+	for _, line := range strings.Split(ramenConfig, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			inProfiles = trimmed == "s3StoreProfiles:"
+			continue
+		}
+		if !inProfiles {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok {
+			profiles = append(profiles, s3StoreProfile{})
+			inSecretRef = false
+			trimmed = item
+		}
+		if len(profiles) == 0 {
+			continue
+		}
+		profile := &profiles[len(profiles)-1]
+
+		key, value, _ := strings.Cut(trimmed, ":")
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch key {
+		case "s3ProfileName":
+			profile.Name = value
+			inSecretRef = false
+		case "s3SecretRef":
+			inSecretRef = true
+		case "s3CompatibleEndpoint":
+			profile.Endpoint = value
+			inSecretRef = false
+		case "s3Bucket":
+			profile.Bucket = value
+			inSecretRef = false
+		case "s3Region":
+			profile.Region = value
+			inSecretRef = false
+		case "name":
+			if inSecretRef {
+				profile.SecretName = value
+			}
+		default:
+			inSecretRef = false
+		}
+	}
+
+	return profiles
+}
+
+// drNamespacesManifestWork returns the ManifestWork that creates the
+// namespace of the Ramen cluster operator on a managed cluster, so that the
+// S3 secrets can be propagated before the operator is deployed.
+func drNamespacesManifestWork(cluster string) manifestWork {
+	work := manifestWork{
+		APIVersion: "work.open-cluster-management.io/v1",
+		Kind:       "ManifestWork",
+		Metadata:   objectMeta{Name: "odfdr-installer-dr-namespaces", Namespace: cluster},
+	}
+	// The DR operators live in the namespace, it must survive the deletion
+	// of the ManifestWork.
+	work.Spec.DeleteOption.PropagationPolicy = "Orphan"
+	work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests,
+		namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: ramenConfigNamespace}})
+
+	return work
+}
+
+// addDRNamespaces creates the namespace of the Ramen cluster operator on the
+// managed clusters.
+func addDRNamespaces(hubName, kconfig string, clusters []string) error {
+	works := list{APIVersion: "v1", Kind: "List"}
+	for _, cluster := range clusters {
+		works.Items = append(works.Items, drNamespacesManifestWork(cluster))
+	}
+
+	data, err := json.MarshalIndent(works, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ManifestWorks: %v", err)
+	}
+
+	fileName := hubName + "-" + strings.Join(clusters, "-") + "-dr-namespaces-manifestworks.json"
+	if err := writeArtifact(hubName, fileName, data); err != nil {
+		return fmt.Errorf("error writing ManifestWorks to file: %v", err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying ManifestWorks: %v", err)
+	}
+
+	return nil
+}
+
+// waitForS3StoreProfiles waits for the S3 store profiles of the clusters in
+// the Ramen hub configuration and for their Secrets on the hub, and returns
+// the profiles.
+func waitForS3StoreProfiles(kconfig string, clusters []string, storageCluster string, timeout time.Duration) ([]s3StoreProfile, error) {
+	profiles := []s3StoreProfile{}
+	missing := []string{}
+
+	err := waitFor(kconfig, "S3 store profiles of "+strings.Join(clusters, ", "), timeout, 15*time.Second, func() (bool, error) {
+		var cm configMap
+		if _, err := getJSON(kconfig, &cm, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace); err != nil {
+			return false, err
+		}
+		configured := parseS3StoreProfiles(cm.Data[ramenConfigKey])
+
+		profiles = profiles[:0]
+		missing = missing[:0]
+		for _, cluster := range clusters {
+			name := s3ProfileName(cluster, storageCluster)
+			i := slices.IndexFunc(configured, func(p s3StoreProfile) bool { return p.Name == name })
+			if i == -1 || configured[i].SecretName == "" {
+				missing = append(missing, "profile "+name)
+				continue
+			}
+
+			var s secret
+			found, err := getJSON(kconfig, &s, "secret", configured[i].SecretName, "-n", globalOperatorsNamespace)
+			if err != nil {
+				return false, err
+			}
+			if !found {
+				missing = append(missing, "secret "+configured[i].SecretName+" of profile "+name)
+				continue
+			}
+			profiles = append(profiles, configured[i])
+		}
+
+		return len(missing) == 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%v, missing: %s", err, strings.Join(missing, ", "))
+	}
+
+	return profiles, nil
+}
+
+// managedClusterView reads a resource of a managed cluster through the hub.
+type managedClusterView struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Scope struct {
+			APIGroup  string `json:"apiGroup,omitempty"`
+			Kind      string `json:"kind"`
+			Version   string `json:"version"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"scope"`
+	} `json:"spec"`
+}
+
+// viewOnCluster reads a resource of a managed cluster into obj through the
+// ManagedClusterView named name, which is deleted afterwards, and reports
+// whether the resource exists. obj may be nil to only check that it exists.
+func viewOnCluster(hubName, kconfig, cluster, name, group, version, kind, ns, resourceName string, obj any) (bool, error) {
+	view := managedClusterView{
+		APIVersion: "view.open-cluster-management.io/v1beta1",
+		Kind:       "ManagedClusterView",
+		Metadata:   objectMeta{Name: name, Namespace: cluster},
+	}
+	view.Spec.Scope.APIGroup = group
+	view.Spec.Scope.Kind = kind
+	view.Spec.Scope.Version = version
+	view.Spec.Scope.Name = resourceName
+	view.Spec.Scope.Namespace = ns
+
+	data, err := json.MarshalIndent(view, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("error encoding ManagedClusterView: %v", err)
+	}
+
+	fileName := hubName + "-" + cluster + "-" + name + "-managedclusterview.json"
+	if err := writeArtifact(hubName, fileName, data); err != nil {
+		return false, fmt.Errorf("error writing ManagedClusterView to file: %v", err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return false, fmt.Errorf("error applying ManagedClusterView: %v", err)
+	}
+	defer func() {
+		if err := ocCommand(kconfig, "delete", "-f", fileName, "--ignore-not-found").Run(); err != nil {
+			slog.Warn("error deleting ManagedClusterView", "cluster", cluster, "name", name, "error", err)
+		}
+	}()
+
+	exists := false
+	err = waitFor(kconfig, "ManagedClusterView of "+kind+" "+resourceName+" on "+cluster, 2*time.Minute, 5*time.Second, func() (bool, error) {
+		var status struct {
+			Status struct {
+				Conditions []condition     `json:"conditions"`
+				Result     json.RawMessage `json:"result"`
+			} `json:"status"`
+		}
+		found, err := getJSON(kconfig, &status, "managedclusterview", name, "-n", cluster)
+		if err != nil || !found {
+			return false, err
+		}
+
+		processing, ok := conditionStatus(status.Status.Conditions, "Processing")
+		if !ok {
+			return false, nil
+		}
+		exists = processing.Status == "True"
+
+		if exists && obj != nil {
+			if err := json.Unmarshal(status.Status.Result, obj); err != nil {
+				return false, fmt.Errorf("error parsing %s %s of cluster %s: %v", kind, resourceName, cluster, err)
+			}
+		}
+
+		return true, nil
+	})
+
+	return exists, err
+}
+
+// secretOnCluster reports whether a Secret exists on a managed cluster, read
+// through a ManagedClusterView.
+func secretOnCluster(hubName, kconfig, cluster, ns, name string) (bool, error) {
+	return viewOnCluster(hubName, kconfig, cluster, "odfdr-secret-"+name, "", "v1", "Secret", ns, name, nil)
+}
+
+// hasDRPolicy reports whether a DRPolicy of the hub covers the clusters.
+func hasDRPolicy(kconfig string, clusters []string) (bool, error) {
+	var policies struct {
+		Items []struct {
+			Spec struct {
+				DRClusters []string `json:"drClusters"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &policies, "drpolicies.ramendr.openshift.io"); err != nil {
+		return false, err
+	}
+
+	for _, policy := range policies.Items {
+		covered := true
+		for _, cluster := range clusters {
+			covered = covered && slices.Contains(policy.Spec.DRClusters, cluster)
+		}
+		if covered {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkDRSecrets checks that the S3 store profiles of the clusters follow the
+// naming of the ODF multicluster orchestrator and that their Secrets exist on
+// the hub. Once a DRPolicy covers the clusters, Ramen propagates the Secrets
+// of all profiles to every DR cluster, which is waited for as well. Without a
+// DRPolicy the Secrets on the clusters are reported as unverified.
+func checkDRSecrets(hubName, kconfig string, clusters []string, storageCluster string, timeout time.Duration) error {
+	profiles, err := waitForS3StoreProfiles(kconfig, clusters, storageCluster, timeout)
+	if err != nil {
+		return err
+	}
+	slog.Info("S3 store profiles configured", "clusters", clusters, "profiles", len(profiles))
+
+	covered, err := hasDRPolicy(kconfig, clusters)
+	if err != nil {
+		return err
+	}
+	if !covered {
+		slog.Warn("S3 secrets on the DR clusters unverified: no DRPolicy covers the clusters, Ramen propagates the secrets once one is created; "+
+			"run configure-dr again after creating the DRPolicy to verify them", "clusters", clusters, "namespace", ramenConfigNamespace)
+		return nil
+	}
+
+	missing := []string{}
+	err = waitFor(kconfig, "S3 secrets on the DR clusters", timeout, 10*time.Second, func() (bool, error) {
+		missing = []string{}
+		for _, cluster := range clusters {
+			for _, profile := range profiles {
+				exists, err := secretOnCluster(hubName, kconfig, cluster, ramenConfigNamespace, profile.SecretName)
+				if err != nil {
+					return false, err
+				}
+				if !exists {
+					missing = append(missing, fmt.Sprintf("secret %s of profile %s on cluster %s", profile.SecretName, profile.Name, cluster))
+				}
+			}
+		}
+
+		return len(missing) == 0, nil
+	})
+	if err != nil {
+		if len(missing) > 0 {
+			return fmt.Errorf("S3 secrets were not propagated: %s", strings.Join(missing, ", "))
+		}
+		return err
+	}
+
+	slog.Info("S3 secrets propagated", "clusters", clusters)
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+const (
+	replicationAsync   = "async"
+	replicationVolSync = "volsync"
+
+	storageIDLabel     = "ramendr.openshift.io/storageid"
+	replicationIDLabel = "ramendr.openshift.io/replicationid"
+
+	defaultRBDProvisioner = "openshift-storage.rbd.csi.ceph.com"
+
+	ramenHubConfigMap = "ramen-hub-operator-config"
+)
+
+// drConfig configures how the workloads of the DR pairs are protected.
+type drConfig struct {
+	// SchedulingInterval is the interval of the DRPolicy, which is the
+	// default of the async storage classes and the interval of the volsync
+	// ones.
+	SchedulingInterval string `json:"schedulingInterval,omitempty"`
+	// StorageClasses selects the replication of each protected storage
+	// class, e.g. async replication for RBD and VolSync for CephFS.
+	StorageClasses []storageClassDR `json:"storageClasses,omitempty"`
+	// ClusterSet puts the DR clusters into a ManagedClusterSet.
+	ClusterSet *clusterSetConfig `json:"clusterSet,omitempty"`
+	// GitOps registers the DR clusters with OpenShift GitOps on the hub.
+	GitOps *gitopsConfig `json:"gitops,omitempty"`
+	// Network allows the DR traffic between the clusters of a pair through
+	// restrictive NetworkPolicies and EgressFirewalls.
+	Network *drNetworkConfig `json:"network,omitempty"`
+	// StandbyHub is a passive hub the DR resources of the pairs are
+	// replicated to, for hub recovery.
+	StandbyHub *standbyHubConfig `json:"standbyHub,omitempty"`
+}
+
+type storageClassDR struct {
+	Name string `json:"name"`
+	// Replication is async, for storage mirrored by Ceph, or volsync, for
+	// storage replicated by VolSync from snapshots.
+	Replication string `json:"replication"`
+	// SchedulingInterval is the async replication interval, like 5m, 1h or
+	// 1d.
+	SchedulingInterval string `json:"schedulingInterval,omitempty"`
+	// Provisioner is the CSI driver of an async storage class.
+	Provisioner string `json:"provisioner,omitempty"`
+	// VolumeSnapshotClass is the snapshot class VolSync uses for a volsync
+	// storage class.
+	VolumeSnapshotClass string `json:"volumeSnapshotClass,omitempty"`
+	// DataSize is the expected size of the protected volumes, like 500Gi,
+	// used to estimate the replication traffic.
+	DataSize string `json:"dataSize,omitempty"`
+	// DailyChangePercent is the share of the data changed per day, 10 by
+	// default.
+	DailyChangePercent float64 `json:"dailyChangePercent,omitempty"`
+}
+
+var schedulingIntervalPattern = regexp.MustCompile(`^[0-9]+[mhd]$`)
+
+// validate checks the storage classes and fills in the defaults.
+func (c *drConfig) validate() error {
+	if c.SchedulingInterval != "" && !schedulingIntervalPattern.MatchString(c.SchedulingInterval) {
+		return fmt.Errorf("invalid DR scheduling interval %q, expected e.g. 5m, 1h or 1d", c.SchedulingInterval)
+	}
+
+	if c.ClusterSet != nil {
+		if err := c.ClusterSet.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.GitOps != nil {
+		// GitOps uses the cluster set the DR clusters are put into.
+		if c.GitOps.ClusterSet == "" && c.ClusterSet != nil {
+			c.GitOps.ClusterSet = c.ClusterSet.Name
+		}
+		c.GitOps.validate()
+	}
+
+	if c.Network != nil {
+		if err := c.Network.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.StandbyHub != nil {
+		if err := c.StandbyHub.validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.StorageClasses {
+		sc := &c.StorageClasses[i]
+		if sc.Name == "" {
+			return fmt.Errorf("DR storage class %d has no name", i)
+		}
+
+		if sc.DataSize != "" {
+			if _, err := parseQuantity(sc.DataSize); err != nil {
+				return fmt.Errorf("DR storage class %s has invalid data size: %v", sc.Name, err)
+			}
+		}
+		if sc.DailyChangePercent == 0 {
+			sc.DailyChangePercent = 10
+		}
+		if sc.DailyChangePercent < 0 || sc.DailyChangePercent > 100 {
+			return fmt.Errorf("DR storage class %s has daily change %v%%, expected a value between 0 and 100", sc.Name, sc.DailyChangePercent)
+		}
+
+		switch sc.Replication {
+		case replicationAsync:
+			if sc.SchedulingInterval == "" {
+				sc.SchedulingInterval = c.SchedulingInterval
+			}
+			if sc.SchedulingInterval == "" {
+				sc.SchedulingInterval = "5m"
+			}
+			if !schedulingIntervalPattern.MatchString(sc.SchedulingInterval) {
+				return fmt.Errorf("DR storage class %s has invalid scheduling interval %q, expected e.g. 5m, 1h or 1d", sc.Name, sc.SchedulingInterval)
+			}
+			if sc.Provisioner == "" {
+				sc.Provisioner = defaultRBDProvisioner
+			}
+		case replicationVolSync:
+			if sc.SchedulingInterval != "" {
+				return fmt.Errorf("DR storage class %s uses volsync, the scheduling interval is set by the DRPolicy", sc.Name)
+			}
+			// VolSync replicates at the interval of the DRPolicy.
+			sc.SchedulingInterval = c.SchedulingInterval
+		default:
+			return fmt.Errorf("DR storage class %s has unknown replication %q, expected %s or %s", sc.Name, sc.Replication, replicationAsync, replicationVolSync)
+		}
+	}
+
+	return nil
+}
+
+type resourceIdentifier struct {
+	Group     string `json:"group"`
+	Resource  string `json:"resource"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type manifestConfig struct {
+	ResourceIdentifier resourceIdentifier `json:"resourceIdentifier"`
+	UpdateStrategy     struct {
+		Type            string `json:"type"`
+		ServerSideApply struct {
+			Force bool `json:"force"`
+		} `json:"serverSideApply"`
+	} `json:"updateStrategy"`
+}
+
+// manifestWork makes the work agent of a managed cluster apply the manifests.
+type manifestWork struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Workload struct {
+			Manifests []any `json:"manifests"`
+		} `json:"workload"`
+		DeleteOption struct {
+			PropagationPolicy string `json:"propagationPolicy"`
+		} `json:"deleteOption"`
+		ManifestConfigs []manifestConfig `json:"manifestConfigs,omitempty"`
+	} `json:"spec"`
+}
+
+type labeledObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+type volumeReplicationClass struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Provisioner string            `json:"provisioner"`
+		Parameters  map[string]string `json:"parameters"`
+	} `json:"spec"`
+}
+
+// serverSideApply adds a labeled object that is merged into the existing
+// object of a managed cluster instead of replacing it.
+func (w *manifestWork) serverSideApply(group, resource, kind, apiVersion, name string, labels map[string]string) {
+	obj := labeledObject{APIVersion: apiVersion, Kind: kind}
+	obj.Metadata.Name = name
+	obj.Metadata.Labels = labels
+	w.Spec.Workload.Manifests = append(w.Spec.Workload.Manifests, obj)
+
+	config := manifestConfig{ResourceIdentifier: resourceIdentifier{Group: group, Resource: resource, Name: name}}
+	config.UpdateStrategy.Type = "ServerSideApply"
+	config.UpdateStrategy.ServerSideApply.Force = true
+	w.Spec.ManifestConfigs = append(w.Spec.ManifestConfigs, config)
+}
+
+// drStorageID is the Ramen storage ID of a DR storage class of a managed
+// cluster.
+func drStorageID(cluster, storageClass string) string {
+	return cluster + "-" + storageClass
+}
+
+// storageClassManifestWork returns the ManifestWork that labels the storage
+// classes of a managed cluster for Ramen and adds the
+// VolumeReplicationClasses of the async storage classes.
+func storageClassManifestWork(cluster string, storageClasses []storageClassDR) manifestWork {
+	work := manifestWork{
+		APIVersion: "work.open-cluster-management.io/v1",
+		Kind:       "ManifestWork",
+		Metadata:   objectMeta{Name: "odfdr-installer-dr-storage", Namespace: cluster},
+	}
+	// The storage classes existed before the ManifestWork and must survive
+	// its deletion.
+	work.Spec.DeleteOption.PropagationPolicy = "Orphan"
+
+	for _, sc := range storageClasses {
+		storageID := drStorageID(cluster, sc.Name)
+		work.serverSideApply("storage.k8s.io", "storageclasses", "StorageClass", "storage.k8s.io/v1", sc.Name,
+			map[string]string{storageIDLabel: storageID})
+
+		switch sc.Replication {
+		case replicationAsync:
+			vrc := volumeReplicationClass{APIVersion: "replication.storage.openshift.io/v1alpha1", Kind: "VolumeReplicationClass"}
+			vrc.Metadata.Name = sc.Name + "-replication-" + sc.SchedulingInterval
+			// The replication ID is the same on both clusters, so that Ramen
+			// pairs the classes.
+			vrc.Metadata.Labels = map[string]string{replicationIDLabel: sc.Name + "-" + sc.SchedulingInterval}
+			vrc.Spec.Provisioner = sc.Provisioner
+			vrc.Spec.Parameters = map[string]string{
+				"mirroringMode":      "snapshot",
+				"schedulingInterval": sc.SchedulingInterval,
+				"replication.storage.openshift.io/replication-secret-name":      "rook-csi-rbd-provisioner",
+				"replication.storage.openshift.io/replication-secret-namespace": "openshift-storage",
+			}
+			work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests, vrc)
+		case replicationVolSync:
+			if sc.VolumeSnapshotClass != "" {
+				work.serverSideApply("snapshot.storage.k8s.io", "volumesnapshotclasses", "VolumeSnapshotClass",
+					"snapshot.storage.k8s.io/v1", sc.VolumeSnapshotClass, map[string]string{storageIDLabel: storageID})
+			}
+		}
+	}
+
+	return work
+}
+
+// applyStorageClassDR configures the storage classes of the managed clusters
+// through ManifestWorks on the hub, and enables VolSync in the Ramen hub
+// configuration when a storage class uses it.
+func applyStorageClassDR(hubName, kconfig string, clusters []string, storageClasses []storageClassDR) error {
+	works := list{APIVersion: "v1", Kind: "List"}
+	for _, cluster := range clusters {
+		works.Items = append(works.Items, storageClassManifestWork(cluster, storageClasses))
+	}
+
+	data, err := json.MarshalIndent(works, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ManifestWorks: %v", err)
+	}
+
+	// The pairs of a fleet are configured in parallel on the same hub.
+	fileName := hubName + "-" + strings.Join(clusters, "-") + "-dr-storage-manifestworks.json"
+	err = writeArtifact(hubName, fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing ManifestWorks to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying ManifestWorks: %v", err)
+	}
+
+	slog.Info("applied DR storage class configuration", "clusters", clusters, "storageClasses", len(storageClasses))
+
+	for _, sc := range storageClasses {
+		if sc.Replication == replicationVolSync {
+			return enableRamenVolSync(kconfig)
+		}
+	}
+
+	return nil
+}
+
+type configMap struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+// enableRamenVolSync turns on VolSync in the Ramen hub operator
+// configuration, which the hub propagates to the managed clusters.
+func enableRamenVolSync(kconfig string) error {
+	var cm configMap
+	found, err := getJSON(kconfig, &cm, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace)
+	if err != nil {
+		return err
+	}
+
+	if !found || cm.Data[ramenConfigKey] == "" {
+		slog.Warn("Ramen hub configuration not found, VolSync must be enabled once the ODR hub operator is installed",
+			"configMap", globalOperatorsNamespace+"/"+ramenHubConfigMap)
+		return nil
+	}
+
+	ramenConfig := manifest.SetYAMLField(cm.Data[ramenConfigKey], "volSync", "disabled", false)
+	if strings.TrimRight(ramenConfig, "\n") == strings.TrimRight(cm.Data[ramenConfigKey], "\n") {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{"data": map[string]string{ramenConfigKey: ramenConfig}})
+	if err != nil {
+		return fmt.Errorf("error encoding Ramen configuration patch: %v", err)
+	}
+
+	patchCmd := ocCommand(kconfig, "patch", "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace,
+		"--type=merge", "-p", string(patch))
+	err = patchCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error enabling VolSync in the Ramen configuration: %v", err)
+	}
+
+	slog.Info("enabled VolSync in the Ramen hub configuration")
+
+	return nil
+}
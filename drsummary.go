@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// parseSchedulingInterval returns the duration of a DRPolicy scheduling
+// interval like 5m, 1h or 1d.
+func parseSchedulingInterval(interval string) (time.Duration, error) {
+	if !schedulingIntervalPattern.MatchString(interval) {
+		return 0, fmt.Errorf("invalid scheduling interval %q", interval)
+	}
+
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid scheduling interval %q", interval)
+	}
+
+	unit := map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}[interval[len(interval)-1]]
+
+	return time.Duration(n) * unit, nil
+}
+
+// drImplications are the expected costs of replicating a storage class at its
+// scheduling interval.
+type drImplications struct {
+	storageClass   storageClassDR
+	interval       time.Duration
+	syncsPerDay    float64
+	dataBytes      int64
+	changedPerDay  int64
+	changedPerSync int64
+	// scannedPerDay is the data VolSync reads to find the changes, it copies
+	// every volume from a snapshot on every sync.
+	scannedPerDay int64
+}
+
+func drImplicationsOf(sc storageClassDR) (drImplications, bool) {
+	if sc.SchedulingInterval == "" {
+		return drImplications{}, false
+	}
+
+	interval, err := parseSchedulingInterval(sc.SchedulingInterval)
+	if err != nil || interval <= 0 {
+		return drImplications{}, false
+	}
+
+	impl := drImplications{storageClass: sc, interval: interval, syncsPerDay: float64(24*time.Hour) / float64(interval)}
+	if sc.DataSize != "" {
+		impl.dataBytes, _ = parseQuantity(sc.DataSize)
+		impl.changedPerDay = int64(float64(impl.dataBytes) * sc.DailyChangePercent / 100)
+		// Data overwritten within an interval is only sent once, so short
+		// intervals send at most the daily change.
+		impl.changedPerSync = int64(float64(impl.changedPerDay) / impl.syncsPerDay)
+		if sc.Replication == replicationVolSync {
+			impl.scannedPerDay = int64(float64(impl.dataBytes) * impl.syncsPerDay)
+		}
+	}
+
+	return impl, true
+}
+
+// warnings returns the problems of the combination of replication and
+// interval.
+func (impl drImplications) warnings() []string {
+	warnings := []string{}
+	sc := impl.storageClass
+
+	switch sc.Replication {
+	case replicationVolSync:
+		if impl.interval < 5*time.Minute {
+			warnings = append(warnings, fmt.Sprintf("%s: VolSync copies every volume from a snapshot on every sync, a %s interval rarely completes in time, use 5m or more",
+				sc.Name, sc.SchedulingInterval))
+		}
+		if impl.dataBytes > 0 && impl.scannedPerDay > 100*impl.dataBytes {
+			warnings = append(warnings, fmt.Sprintf("%s: VolSync reads %s per day to find the changes of %s of data",
+				sc.Name, formatBytes(impl.scannedPerDay), sc.DataSize))
+		}
+	case replicationAsync:
+		if impl.interval < 2*time.Minute {
+			warnings = append(warnings, fmt.Sprintf("%s: a %s interval creates %.0f mirror snapshots per image and day, which slows down RBD",
+				sc.Name, sc.SchedulingInterval, impl.syncsPerDay))
+		}
+	}
+
+	return warnings
+}
+
+func formatBytes(bytes int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// printDRSummary prints what the scheduling intervals of the storage classes
+// imply, before they are applied, and returns the warnings.
+func printDRSummary(w io.Writer, storageClasses []storageClassDR) []string {
+	warnings := []string{}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORAGE CLASS\tREPLICATION\tINTERVAL\tSYNCS/DAY\tCHANGED/SYNC\tCHANGED/DAY")
+	for _, sc := range storageClasses {
+		impl, ok := drImplicationsOf(sc)
+		if !ok {
+			fmt.Fprintf(tw, "%s\t%s\tset by DRPolicy\t-\t-\t-\n", sc.Name, sc.Replication)
+			continue
+		}
+
+		perSync, perDay := "-", "-"
+		if impl.dataBytes > 0 {
+			perSync, perDay = formatBytes(impl.changedPerSync), formatBytes(impl.changedPerDay)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.0f\t%s\t%s\n", sc.Name, sc.Replication, sc.SchedulingInterval, impl.syncsPerDay, perSync, perDay)
+
+		warnings = append(warnings, impl.warnings()...)
+	}
+	tw.Flush()
+
+	return warnings
+}
+
+// summarizeDR prints the DR summary and logs its warnings.
+func summarizeDR(w io.Writer, storageClasses []storageClassDR) {
+	for _, warning := range printDRSummary(w, storageClasses) {
+		slog.Warn("problematic DR scheduling interval", "warning", warning)
+	}
+}
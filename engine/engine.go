@@ -0,0 +1,143 @@
+// Package engine is the step engine of the odfdr-installer. It runs the steps
+// of a cluster in order and tells observers about the progress of the steps
+// and of the waits inside of them, so that programs embedding the engine can
+// show the progress in their own UI instead of parsing the logs.
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Step is a unit of work applied to a cluster.
+type Step struct {
+	Name string
+	Run  func() error
+}
+
+// Observer is notified of the progress of the steps and waits. Nil functions
+// are skipped. The functions are called from the goroutines running the
+// steps, which run in parallel for the clusters of a fleet, and must not
+// block.
+type Observer struct {
+	OnStepStart func(cluster, step string)
+	// OnStepEnd is called with the error of the step, nil if it succeeded.
+	// Skipped steps neither start nor end.
+	OnStepEnd func(cluster, step string, duration time.Duration, err error)
+	// OnRetry is called before a failed step is run again, with the
+	// attempt about to start, counting from 1.
+	OnRetry func(cluster, step string, attempt int, err error)
+	// OnWaitProgress is called every interval of a wait that is not done
+	// yet. The cluster is empty for waits outside of a cluster.
+	OnWaitProgress func(cluster, description string, remaining time.Duration)
+}
+
+var (
+	observersMu sync.Mutex
+	observers   []Observer
+)
+
+// AddObserver registers an observer of all steps and waits for the rest of
+// the process.
+func AddObserver(o Observer) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+
+	observers = append(observers, o)
+}
+
+// notify calls f for the registered observers followed by extra.
+func notify(extra []Observer, f func(o Observer)) {
+	observersMu.Lock()
+	all := append(append([]Observer{}, observers...), extra...)
+	observersMu.Unlock()
+
+	for _, o := range all {
+		f(o)
+	}
+}
+
+// WaitProgress tells the observers that a wait is not done yet, with the
+// time left until it times out. Waits call it every interval.
+func WaitProgress(cluster, description string, remaining time.Duration) {
+	notify(nil, func(o Observer) {
+		if o.OnWaitProgress != nil {
+			o.OnWaitProgress(cluster, description, remaining)
+		}
+	})
+}
+
+// Runner runs the steps of a cluster.
+type Runner struct {
+	Cluster string
+	// Observers are notified of the steps of this runner only, after the
+	// observers added with AddObserver.
+	Observers []Observer
+	// Before, if set, is called before every step with the steps not run
+	// yet, the step first, and stops the run with its error.
+	Before func(remaining []Step) error
+	// Confirm, if set, is called before every step and skips it when it
+	// returns false. An error stops the run.
+	Confirm func(step Step) (bool, error)
+	// Retry, if set, is called with the error of a failed step and the
+	// attempt that would follow, and runs the step again if it returns
+	// true.
+	Retry func(step Step, attempt int, err error) bool
+}
+
+// Run runs the steps in order and stops at the first failing step.
+func (r *Runner) Run(steps []Step) error {
+	for i, s := range steps {
+		if r.Before != nil {
+			if err := r.Before(steps[i:]); err != nil {
+				return err
+			}
+		}
+
+		if r.Confirm != nil {
+			confirmed, err := r.Confirm(s)
+			if err != nil {
+				return fmt.Errorf("step %s: %w", s.Name, err)
+			}
+			if !confirmed {
+				continue
+			}
+		}
+
+		if err := r.runStep(s); err != nil {
+			return fmt.Errorf("step %s failed: %v", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// runStep runs a step, again as long as Retry asks for it.
+func (r *Runner) runStep(s Step) error {
+	start := time.Now()
+	notify(r.Observers, func(o Observer) {
+		if o.OnStepStart != nil {
+			o.OnStepStart(r.Cluster, s.Name)
+		}
+	})
+
+	err := s.Run()
+	for attempt := 2; err != nil && r.Retry != nil && r.Retry(s, attempt, err); attempt++ {
+		notify(r.Observers, func(o Observer) {
+			if o.OnRetry != nil {
+				o.OnRetry(r.Cluster, s.Name, attempt, err)
+			}
+		})
+		err = s.Run()
+	}
+
+	duration := time.Since(start)
+	notify(r.Observers, func(o Observer) {
+		if o.OnStepEnd != nil {
+			o.OnStepEnd(r.Cluster, s.Name, duration, err)
+		}
+	})
+
+	return err
+}
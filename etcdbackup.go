@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	etcdBackupStep = "etcd-backup"
+	// etcdBackupScript is the backup script of OpenShift on the control
+	// plane nodes, which saves an etcd snapshot and the static pod
+	// resources to a directory of the node.
+	etcdBackupScript = "/usr/local/bin/cluster-backup.sh"
+	etcdBackupDir    = "/home/core/backup"
+)
+
+func addBackupFlags(flags *flag.FlagSet) (*bool, *string) {
+	backup := flags.Bool("backup-before-changes", false, "Back up etcd before the pull secret and the mirror sets are changed, for long-lived clusters")
+	command := flags.String("backup-command", "", "Shell command backing up the cluster instead of the etcd backup script of OpenShift, run with $KUBECONFIG and $ODFDR_CLUSTER set; the last line it prints is recorded as the backup location. Implies -backup-before-changes")
+
+	return backup, command
+}
+
+// withEtcdBackup adds the etcd-backup step before the pull-secret step, the
+// first step changing the configuration of the nodes, and makes the steps
+// changing it require the backup.
+func withEtcdBackup(clusterName, kconfig string, steps []step, opts prepareOptions, report *clusterReport) []step {
+	if !opts.backupBeforeChanges {
+		return steps
+	}
+
+	backup := step{
+		name: etcdBackupStep,
+		run: func() error {
+			if opts.hostedCluster != nil {
+				slog.Info("not backing up etcd of a hosted control plane cluster, it is backed up with its HostedCluster", "cluster", clusterName)
+				return nil
+			}
+
+			location, err := backUpCluster(clusterName, kconfig, opts.backupCommand)
+			if err != nil {
+				return err
+			}
+			report.EtcdBackup = location
+
+			return nil
+		},
+		describe: func() string {
+			if opts.backupCommand != "" {
+				return "Back up the cluster with: " + opts.backupCommand
+			}
+
+			return fmt.Sprintf("Run %s on a control plane node to back up etcd to %s on the node.", etcdBackupScript, etcdBackupDir)
+		},
+	}
+
+	result := []step{}
+	for _, s := range steps {
+		if s.name == "pull-secret" {
+			result = append(result, backup)
+		}
+		if s.name == "pull-secret" || s.name == "mirror-sets" {
+			s.after = append(slices.Clone(s.after), etcdBackupStep)
+		}
+		result = append(result, s)
+	}
+
+	return result
+}
+
+// backUpCluster backs up the cluster with the backup command, or with the
+// etcd backup script on a ready control plane node, and returns the location
+// of the backup.
+func backUpCluster(clusterName, kconfig, command string) (string, error) {
+	if command != "" {
+		return runBackupCommand(clusterName, kconfig, command)
+	}
+
+	node, err := readyControlPlaneNode(kconfig)
+	if err != nil {
+		return "", err
+	}
+
+	dir := etcdBackupDir + "/odfdr-" + time.Now().UTC().Format("20060102-150405")
+	slog.Info("backing up etcd", "cluster", clusterName, "node", node, "dir", dir)
+
+	debugCmd := ocCommand(kconfig, "debug", "node/"+node, "-q", "--", "chroot", "/host", etcdBackupScript, dir)
+	if output, err := debugCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("error backing up etcd on node %s: %v: %s", node, err, strings.TrimSpace(string(output)))
+	}
+
+	location := "node/" + node + ":" + dir
+	slog.Info("backed up etcd", "cluster", clusterName, "location", location)
+
+	return location, nil
+}
+
+// runBackupCommand runs a backup command provided by the user and returns the
+// last line it printed.
+func runBackupCommand(clusterName, kconfig, command string) (string, error) {
+	slog.Info("backing up cluster with backup command", "cluster", clusterName)
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig, "ODFDR_CLUSTER="+clusterName)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running backup command: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	location := strings.TrimSpace(lines[len(lines)-1])
+	if location == "" {
+		return "", fmt.Errorf("backup command printed no backup location")
+	}
+	slog.Info("backed up cluster", "cluster", clusterName, "location", location)
+
+	return location, nil
+}
+
+// readyControlPlaneNode returns a ready control plane node to run the etcd
+// backup script on.
+func readyControlPlaneNode(kconfig string) (string, error) {
+	var nodes struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			conditionedObject
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &nodes, "nodes", "-l", "node-role.kubernetes.io/master"); err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes.Items {
+		if ready, _ := conditionStatus(node.Status.Conditions, "Ready"); ready.Status == "True" {
+			return node.Metadata.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no ready control plane node to back up etcd on")
+}
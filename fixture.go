@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// With a fixture, every oc command is run through the installer itself as
+// fixtureShimCommand, which either runs oc and records its output to the
+// fixture or replays the output recorded for the same arguments, without a
+// cluster.
+const (
+	fixtureShimCommand = "__oc-fixture"
+	fixtureModeRecord  = "record"
+	fixtureModeReplay  = "replay"
+
+	fixtureModeEnv    = "ODFDR_FIXTURE_MODE"
+	fixtureFileEnv    = "ODFDR_FIXTURE_FILE"
+	fixtureEntryEnv   = "ODFDR_FIXTURE_ENTRY"
+	fixtureClusterEnv = "ODFDR_FIXTURE_CLUSTER"
+	// fixturePrefixEnv is the number of connection arguments, like
+	// --request-timeout, before the arguments of the command. They are
+	// passed to oc but are not part of the recorded command.
+	fixturePrefixEnv = "ODFDR_FIXTURE_PREFIX"
+)
+
+// fixture holds the recorded oc commands of one or more runs.
+type fixture struct {
+	Entries []fixtureEntry `json:"entries"`
+}
+
+type fixtureEntry struct {
+	// Cluster is the cluster the command was run against, see
+	// fixtureCluster, as the same command returns different output on the
+	// clusters of a run.
+	Cluster string `json:"cluster,omitempty"`
+	// Args are the oc arguments with secrets redacted.
+	Args     []string `json:"args"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exitCode"`
+	// Files are the files written by oc, e.g. by oc registry login --to.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+var (
+	fixtureMode string
+	fixtureFile string
+
+	// replayed counts how often each command was replayed, so repeated
+	// commands replay their recorded responses in order.
+	replayed   = map[string]int{}
+	replayedMu sync.Mutex
+	replaying  *fixture
+
+	// fixtureClusters are the names of the clusters of the kubeconfigs.
+	fixtureClusters   = map[string]string{}
+	fixtureClustersMu sync.Mutex
+)
+
+func addFixtureFlags(flags *flag.FlagSet) {
+	flags.Func("record", "Record the oc commands and their output to a fixture file", func(fileName string) error {
+		return startFixture(fixtureModeRecord, fileName)
+	})
+	flags.Func("replay", "Replay the oc commands from a fixture file instead of running them against a cluster", func(fileName string) error {
+		return startFixture(fixtureModeReplay, fileName)
+	})
+}
+
+func startFixture(mode, fileName string) error {
+	if fixtureMode != "" {
+		return fmt.Errorf("only one of -record and -replay can be given")
+	}
+
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return err
+	}
+
+	if mode == fixtureModeRecord {
+		data, _ := json.Marshal(fixture{Entries: []fixtureEntry{}})
+		if err := writePrivateFile(path, data); err != nil {
+			return fmt.Errorf("error creating fixture: %v", err)
+		}
+	} else {
+		f, err := readFixture(path)
+		if err != nil {
+			return err
+		}
+		replaying = f
+	}
+
+	fixtureMode, fixtureFile = mode, path
+
+	return nil
+}
+
+func readFixture(path string) (*fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixture: %v", err)
+	}
+
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing fixture: %v", err)
+	}
+
+	return &f, nil
+}
+
+// nameFixtureCluster names the cluster of kconfig in the fixtures.
+func nameFixtureCluster(kconfig, name string) {
+	fixtureClustersMu.Lock()
+	defer fixtureClustersMu.Unlock()
+
+	fixtureClusters[kconfig] = name
+}
+
+// fixtureCluster returns the cluster of kconfig in the fixtures: the name of
+// the cluster the installer logged in to, or the file name of a kubeconfig
+// given on the command line, like hub.kubeconfig.
+func fixtureCluster(kconfig string) string {
+	fixtureClustersMu.Lock()
+	defer fixtureClustersMu.Unlock()
+
+	if name, found := fixtureClusters[kconfig]; found {
+		return name
+	}
+	if kconfig == "" {
+		return ""
+	}
+
+	return filepath.Base(kconfig)
+}
+
+func fixtureKey(cluster string, args []string) string {
+	return strings.Join(append([]string{cluster}, redactArgs(args)...), "\x00")
+}
+
+// replayEntry returns the index of the recorded entry to replay for the next
+// run of the command against the cluster, or -1 if the command was not
+// recorded. When a command is run more often than it was recorded, its last
+// response is replayed again, e.g. while polling. Entries of fixtures
+// recorded without clusters match the command on every cluster.
+func replayEntry(cluster string, args []string) int {
+	replayedMu.Lock()
+	defer replayedMu.Unlock()
+
+	key := fixtureKey(cluster, args)
+	matches := []int{}
+	for i, entry := range replaying.Entries {
+		entryCluster := entry.Cluster
+		if entryCluster == "" {
+			entryCluster = cluster
+		}
+		if fixtureKey(entryCluster, entry.Args) == key {
+			matches = append(matches, i)
+		}
+	}
+
+	if len(matches) == 0 {
+		return -1
+	}
+
+	n := min(replayed[key], len(matches)-1)
+	replayed[key]++
+
+	return matches[n]
+}
+
+// fixtureCommand returns the command that records or replays the oc command
+// against the cluster.
+func fixtureCommand(cluster string, args, ocArgs []string) *exec.Cmd {
+	cmd := fixtureShim(fixtureMode, fixtureFile, cluster, args, ocArgs)
+	if fixtureMode == fixtureModeReplay {
+		entry := replayEntry(cluster, args)
+		if entry == -1 {
+			slog.Warn("no recorded response in fixture", "cluster", cluster, "command", "oc "+strings.Join(redactArgs(args), " "))
+		}
+		cmd.Env = append(cmd.Env, fixtureEntryEnv+"="+strconv.Itoa(entry))
+	}
+
+	return cmd
+}
+
+// fixtureShim returns the command that runs the oc command through the
+// fixture shim in mode, with the fixture file path. oc is run with ocArgs,
+// the args with the connection arguments of the cluster prepended, while the
+// fixture records the cluster and the args alone.
+func fixtureShim(mode, path, cluster string, args, ocArgs []string) *exec.Cmd {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+
+	cmd := exec.Command(executable, append([]string{fixtureShimCommand}, ocArgs...)...)
+	cmd.Env = append(os.Environ(), fixtureModeEnv+"="+mode, fixtureFileEnv+"="+path, fixtureClusterEnv+"="+cluster,
+		fixturePrefixEnv+"="+strconv.Itoa(len(ocArgs)-len(args)))
+
+	return cmd
+}
+
+// runFixtureShim is run in place of oc and exits with the exit code of oc.
+func runFixtureShim(args []string) {
+	var exitCode int
+	var err error
+
+	prefix, _ := strconv.Atoi(os.Getenv(fixturePrefixEnv))
+	if prefix < 0 || prefix > len(args) {
+		prefix = 0
+	}
+
+	switch os.Getenv(fixtureModeEnv) {
+	case fixtureModeRecord:
+		exitCode, err = recordOC(os.Getenv(fixtureFileEnv), os.Getenv(fixtureClusterEnv), args, args[prefix:])
+	case fixtureModeReplay:
+		exitCode, err = replayOC(os.Getenv(fixtureFileEnv), os.Getenv(fixtureEntryEnv), args[prefix:])
+	default:
+		err = fmt.Errorf("%s is only run by the installer itself", fixtureShimCommand)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "odfdr-installer: %v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(exitCode)
+}
+
+// recordOC runs oc with ocArgs and records its output for the cluster and
+// args, the ocArgs without the connection arguments.
+func recordOC(path, cluster string, ocArgs, args []string) (int, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("oc", ocArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, fmt.Errorf("error running oc: %v", err)
+	}
+
+	entry := fixtureEntry{
+		Cluster:  cluster,
+		Args:     redactArgs(args),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+	}
+
+	for _, arg := range args {
+		if fileName, ok := strings.CutPrefix(arg, "--to="); ok {
+			data, err := os.ReadFile(fileName)
+			if err == nil {
+				entry.Files = map[string]string{fileName: string(data)}
+			}
+		}
+	}
+
+	// Fixtures are shared and replayed elsewhere.
+	return entry.ExitCode, appendFixtureEntry(path, redactFixtureEntry(entry))
+}
+
+// appendFixtureEntry adds an entry to the fixture. The fixture is locked, as
+// oc commands run concurrently when clusters are handled in parallel.
+func appendFixtureEntry(path string, entry fixtureEntry) error {
+	file, err := openPrivateFile(path, os.O_RDWR)
+	if err != nil {
+		return fmt.Errorf("error opening fixture: %v", err)
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("error locking fixture: %v", err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	var f fixture
+	if err := json.NewDecoder(file).Decode(&f); err != nil {
+		return fmt.Errorf("error parsing fixture: %v", err)
+	}
+	f.Entries = append(f.Entries, entry)
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding fixture: %v", err)
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("error writing fixture: %v", err)
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("error writing fixture: %v", err)
+	}
+
+	return nil
+}
+
+func replayOC(path, index string, args []string) (int, error) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("no recorded response for oc %s", strings.Join(redactArgs(args), " "))
+	}
+
+	f, err := readFixture(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if i >= len(f.Entries) {
+		return 0, fmt.Errorf("fixture entry %d does not exist", i)
+	}
+	entry := f.Entries[i]
+
+	for fileName, data := range entry.Files {
+		if err := writePrivateFile(fileName, []byte(data)); err != nil {
+			return 0, fmt.Errorf("error writing %s: %v", fileName, err)
+		}
+	}
+
+	os.Stdout.WriteString(entry.Stdout)
+	os.Stderr.WriteString(entry.Stderr)
+
+	return entry.ExitCode, nil
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fleetCluster is a cluster of the fleet file. Either a kubeconfig, an
+// openshift-install directory or a URL and password to log in with must be
+// given.
+type fleetCluster struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Kubeconfig string `json:"kubeconfig"`
+	InstallDir string `json:"installDir"`
+	// ManagementKubeconfig and HostedCluster point to the HostedCluster of
+	// a hosted control plane cluster.
+	ManagementKubeconfig string `json:"managementKubeconfig"`
+	HostedCluster        string `json:"hostedCluster"`
+}
+
+// fleetPair is a pair of managed clusters peered for DR. The cluster names
+// must match their ManagedCluster names on the hub.
+type fleetPair struct {
+	Clusters []fleetCluster `json:"clusters"`
+}
+
+type fleetHub struct {
+	fleetCluster
+	ClusterLabels map[string]string `json:"clusterLabels"`
+	Pairs         []fleetPair       `json:"pairs"`
+}
+
+// fleet describes a set of DR pairs managed by one or more hubs.
+type fleet struct {
+	Hubs []fleetHub `json:"hubs"`
+}
+
+func loadFleet(fileName string) (*fleet, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fleet file: %v", err)
+	}
+
+	var f fleet
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fleet file: %v", err)
+	}
+
+	for _, hub := range f.Hubs {
+		for i, pair := range hub.Pairs {
+			if len(pair.Clusters) != 2 {
+				return nil, fmt.Errorf("pair %d of hub %s must have exactly two clusters", i, hub.Name)
+			}
+		}
+	}
+
+	if err := f.reserveClusterNames(); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// reserveClusterNames reserves the names of the clusters in the order of the
+// fleet file, before the clusters are connected to in parallel, so that the
+// same cluster keeps its short name when derived names collide.
+func (f *fleet) reserveClusterNames() error {
+	clusters := []fleetCluster{}
+	for _, hub := range f.Hubs {
+		clusters = append(clusters, hub.fleetCluster)
+		for _, pair := range hub.Pairs {
+			clusters = append(clusters, pair.Clusters...)
+		}
+	}
+
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			continue
+		}
+		if err := reserveClusterName(cluster.Name, cluster.identity()); err != nil {
+			return err
+		}
+	}
+
+	// Names derived from URLs are taken in file order, names derived from
+	// kubeconfigs once connected.
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			cluster.displayName()
+		}
+	}
+
+	return nil
+}
+
+// identity tells clusters of the fleet file apart by what they connect with.
+func (c *fleetCluster) identity() string {
+	switch {
+	case c.URL != "":
+		url := c.URL
+		if apiURL, isConsole := consoleAPIURL(url); isConsole {
+			url = apiURL
+		}
+		return clusterDomain(url)
+	case c.Kubeconfig != "":
+		return "kubeconfig " + c.Kubeconfig
+	default:
+		return "installDir " + c.InstallDir
+	}
+}
+
+// displayName returns the name of the cluster for the report before it is
+// connected to.
+func (c *fleetCluster) displayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	url := c.URL
+	if apiURL, isConsole := consoleAPIURL(url); isConsole {
+		url = apiURL
+	}
+	if name, err := getClusterName(url); err == nil {
+		return name
+	}
+
+	if c.Kubeconfig != "" {
+		return c.Kubeconfig
+	}
+
+	return c.InstallDir
+}
+
+// connect returns the name and a kubeconfig of the cluster, logging in when
+// no kubeconfig is given.
+func (c *fleetCluster) connect() (string, string, error) {
+	if c.Kubeconfig != "" {
+		name := c.Name
+		if name == "" {
+			url, err := getServerURL(c.Kubeconfig)
+			if err != nil {
+				return "", "", err
+			}
+
+			name, err = getClusterName(url)
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		useClusterConnection(name, c.Kubeconfig)
+		nameFixtureCluster(c.Kubeconfig, name)
+
+		return name, c.Kubeconfig, nil
+	}
+
+	if c.InstallDir != "" {
+		url, password, err := readInstallDir(c.InstallDir)
+		if err != nil {
+			return "", "", err
+		}
+
+		if c.URL == "" {
+			c.URL = url
+		}
+		if c.Password == "" {
+			c.Password = password
+		}
+	}
+
+	if c.URL == "" || c.Password == "" {
+		return "", "", fmt.Errorf("cluster %q needs either a kubeconfig, an install directory or a url and password", c.Name)
+	}
+
+	url, err := resolveAPIURL(c.URL)
+	if err != nil {
+		return "", "", err
+	}
+	c.URL = url
+
+	name := c.Name
+	if name == "" {
+		name, err = getClusterName(c.URL)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	kconfig, err := getKubeconfig(name)
+	if err != nil {
+		return "", "", fmt.Errorf("error creating kubeconfig file: %v", err)
+	}
+
+	username := c.Username
+	if username == "" {
+		username = "kubeadmin"
+	}
+
+	useClusterConnection(name, kconfig.Name())
+	nameFixtureCluster(kconfig.Name(), name)
+	if err := login(c.URL, username, c.Password, kconfig.Name()); err != nil {
+		return "", "", err
+	}
+
+	return name, kconfig.Name(), nil
+}
+
+// runParallel runs all functions concurrently and returns their joined
+// errors.
+func runParallel(fns ...func() error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(fns))
+
+	for i, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn()
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// exitPartialSuccess is the exit code of a fleet run where some managed
+// clusters were set up and others failed, without -min-success.
+const exitPartialSuccess = 3
+
+// minSuccessThreshold is the -min-success flag, a count or a percentage of the
+// managed clusters.
+type minSuccessThreshold struct {
+	value   string
+	count   int
+	percent bool
+}
+
+func (m *minSuccessThreshold) String() string {
+	return m.value
+}
+
+func (m *minSuccessThreshold) Set(value string) error {
+	number, percent := strings.CutSuffix(value, "%")
+	count, err := strconv.Atoi(number)
+	if err != nil || count < 1 || (percent && count > 100) {
+		return fmt.Errorf("expected a positive count or a percentage like 80%%")
+	}
+
+	*m = minSuccessThreshold{value: value, count: count, percent: percent}
+
+	return nil
+}
+
+func (m *minSuccessThreshold) set() bool {
+	return m.value != ""
+}
+
+// required returns the number of clusters out of total that must be set up.
+func (m *minSuccessThreshold) required(total int) int {
+	if !m.percent {
+		return m.count
+	}
+
+	return (total*m.count + 99) / 100
+}
+
+// fleetRun holds the settings shared by all clusters of a fleet run.
+type fleetRun struct {
+	opts   prepareOptions
+	report *runReport
+	// gather, when set, limits the diagnostics gathered from clusters that
+	// fail to prepare.
+	gather *gatherOptions
+	// config holds the DR settings of every pair.
+	config *config
+	// network, when set, measures the network between the clusters of every
+	// pair before they are peered.
+	network *networkOptions
+}
+
+// prepare prepares a cluster of a pair. The cluster stops waiting once a
+// cluster of its siblings failed.
+func (r *fleetRun) prepare(cluster fleetCluster, siblings *siblingGroup) (string, string, error) {
+	name, kconfig, err := cluster.connect()
+	if err != nil {
+		return "", "", fmt.Errorf("error connecting to cluster %s: %v", cluster.Name, err)
+	}
+	r.report.cluster(name).URL = cluster.URL
+	siblings.join(kconfig)
+
+	opts := r.opts
+	opts.hostedCluster, err = parseHostedClusterRef(cluster.ManagementKubeconfig, cluster.HostedCluster)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid hosted cluster of cluster %s: %v", name, err)
+	}
+
+	err = prepareCluster(name, kconfig, opts, r.report.cluster(name))
+	if err != nil {
+		// A cluster cancelled for a failed sibling did not fail itself.
+		if r.gather != nil && !errors.Is(err, errStepAborted) && !errors.Is(err, errDeadline) && siblingFailed(kconfig) == nil {
+			gatherOnFailure(name, kconfig, opts.operators, r.gather)
+		}
+		return "", "", fmt.Errorf("error preparing cluster %s: %v", name, err)
+	}
+
+	notify(messageInfo, "cluster-prepared", "cluster", name)
+
+	return name, kconfig, nil
+}
+
+// runPair prepares both clusters of a pair in parallel and then peers them on
+// the hub.
+func (r *fleetRun) runPair(hubName, hubKconfig string, hub fleetHub, pair fleetPair) error {
+	names := make([]string, len(pair.Clusters))
+	kconfigs := make([]string, len(pair.Clusters))
+
+	clusterErrs := make([]error, len(pair.Clusters))
+
+	siblings := &siblingGroup{}
+	fns := []func() error{}
+	for i, cluster := range pair.Clusters {
+		fns = append(fns, func() error {
+			names[i], kconfigs[i], clusterErrs[i] = r.prepare(cluster, siblings)
+			if clusterErrs[i] != nil {
+				siblings.cancel(fmt.Errorf("cluster %s of the DR pair failed", cluster.displayName()))
+			}
+			return clusterErrs[i]
+		})
+	}
+
+	err := r.setUpPair(hubName, hubKconfig, hub, names, kconfigs, runParallel(fns...))
+	r.recordPair(pair, names, clusterErrs, err)
+	if err != nil {
+		return err
+	}
+
+	notify(messageInfo, "pair-configured", "hub", hubName, "clusters", strings.Join(names, ", "))
+
+	return nil
+}
+
+// setUpPair peers the prepared clusters of a pair, unless preparing them
+// failed with err.
+func (r *fleetRun) setUpPair(hubName, hubKconfig string, hub fleetHub, names, kconfigs []string, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if r.network != nil {
+		if err := checkPeerNetwork(r.report, names[0], kconfigs[0], names[1], kconfigs[1], r.network); err != nil {
+			return err
+		}
+	}
+
+	opts := r.config.drOptions(names)
+	if hub.ClusterLabels != nil {
+		opts.clusterLabels = hub.ClusterLabels
+	}
+	if opts.network != nil {
+		network, err := opts.network.withDiscoveredCIDRs(names, kconfigs)
+		if err != nil {
+			return err
+		}
+		network, err = network.withPolicies(names, kconfigs)
+		if err != nil {
+			return err
+		}
+		opts.network = network
+	}
+
+	if err := configureDR(hubName, hubKconfig, opts); err != nil {
+		return fmt.Errorf("error configuring DR for %v: %v", names, err)
+	}
+
+	return nil
+}
+
+// recordPair records the outcome of the clusters of a pair. A cluster that
+// was prepared fails with its pair when the pair could not be set up.
+func (r *fleetRun) recordPair(pair fleetPair, names []string, clusterErrs []error, pairErr error) {
+	for i, cluster := range pair.Clusters {
+		name := cluster.displayName()
+		if names != nil && names[i] != "" {
+			name = names[i]
+		}
+
+		report := r.report.cluster(name)
+		report.Outcome = outcomeSucceeded
+		report.Error = ""
+		if clusterErrs != nil && clusterErrs[i] != nil {
+			report.Outcome = outcomeFailed
+			report.Error = clusterErrs[i].Error()
+		} else if pairErr != nil {
+			report.Outcome = outcomeFailed
+			report.Error = "DR pair not set up: " + pairErr.Error()
+		}
+	}
+}
+
+// runHub prepares the hub before any of its pairs, which are then set up in
+// parallel.
+func (r *fleetRun) runHub(hub fleetHub) error {
+	hubName, hubKconfig, err := hub.connect()
+	if err != nil {
+		return r.hubFailed(hub, fmt.Errorf("error connecting to hub %s: %v", hub.Name, err))
+	}
+	r.report.cluster(hubName).URL = hub.URL
+
+	err = prepareCluster(hubName, hubKconfig, r.opts, r.report.cluster(hubName))
+	if err != nil {
+		return r.hubFailed(hub, fmt.Errorf("error preparing hub %s: %v", hubName, err))
+	}
+
+	fns := []func() error{}
+	for _, pair := range hub.Pairs {
+		fns = append(fns, func() error {
+			return r.runPair(hubName, hubKconfig, hub, pair)
+		})
+	}
+
+	return runParallel(fns...)
+}
+
+// hubFailed records the clusters of all pairs of the hub as failed with err.
+func (r *fleetRun) hubFailed(hub fleetHub, err error) error {
+	for _, pair := range hub.Pairs {
+		r.recordPair(pair, nil, nil, err)
+	}
+
+	return err
+}
+
+func runFleet(args []string) {
+	flags := flag.NewFlagSet("fleet", flag.ExitOnError)
+	fileFlag := flags.String("file", "", "Fleet file describing the hubs and their DR pairs")
+	rhcephPasswordFlag := flags.String("rhceph-password", "", "RHCEPH repository password")
+	reportFlag := flags.String("report", "fleet-report.json", "File to write the fleet report to")
+	manifests := addManifestFlags(flags)
+	configFlag := addConfigFlag(flags)
+	var force stringList
+	flags.Var(&force, "force", "Recreate the resources of a step even if they exist: "+strings.Join(prepareStepNames(), ", ")+" (can be repeated)")
+	selection := addStepSelectionFlags(flags)
+	pullSecretModeFlag := addPullSecretModeFlag(flags)
+	pullSecretConflictFlag := addPullSecretConflictFlag(flags)
+	registryAuthMatchFlag := addRegistryAuthMatchFlag(flags)
+	skipRegistryAuthFlag := addSkipRegistryAuthFlag(flags)
+	maxCatalogAgeFlag := addMaxCatalogAgeFlag(flags)
+	backupFlag, backupCommandFlag := addBackupFlags(flags)
+	previousRunFlag := addPreviousRunFlag(flags)
+	snapshotFlag := addSnapshotFlag(flags)
+	pruneFlag := addPruneFlag(flags)
+	terminatingNamespacesFlag := addTerminatingNamespacesFlag(flags)
+	channelOverrides := addChannelOverrideFlag(flags)
+	addStepFlag(flags)
+	addDebugCaptureFlag(flags)
+	addDeadlineFlag(flags)
+	addMessageFlags(flags)
+	gatherOnFailureFlag := flags.Bool("gather-on-failure", true, "Gather diagnostics into <cluster>-diagnostics when preparing a cluster fails")
+	gatherOpts := addGatherFlags(flags, "gather-")
+	measureNetworkFlag := flags.Bool("measure-network", false, "Measure the network between the clusters of every pair before peering them")
+	networkOpts := addNetworkFlags(flags, "network-")
+	bastionFlag := addBastionFlag(flags)
+	addTLSFlags(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addTracingFlag(flags)
+	var minSuccess minSuccessThreshold
+	flags.Var(&minSuccess, "min-success", "Managed clusters that must be set up for a partially set up fleet to succeed, as a count or a percentage like 80%")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if err := startTracing(); err != nil {
+		slog.Error("error starting trace", "error", err)
+		os.Exit(1)
+	}
+
+	if err := validateStepNames(prepareStepNames(), force); err != nil {
+		slog.Error("error: invalid -force", "error", err)
+		showUsageAndExit()
+	}
+
+	if *measureNetworkFlag && networkOpts.interval <= 0 {
+		slog.Error("error: -network-interval must be positive")
+		showUsageAndExit()
+	}
+
+	if err := validatePullSecretMode(*pullSecretModeFlag); err != nil {
+		slog.Error("error: invalid -pull-secret-mode", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := validatePullSecretConflict(*pullSecretConflictFlag); err != nil {
+		slog.Error("error: invalid -pull-secret-conflict", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := validateRegistryAuthMatch(*registryAuthMatchFlag); err != nil {
+		slog.Error("error: invalid -registry-auth-match", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := validatePreviousRunAction(*previousRunFlag); err != nil {
+		slog.Error("error: invalid -previous-run", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := validateTerminatingNamespaceAction(*terminatingNamespacesFlag); err != nil {
+		slog.Error("error: invalid -terminating-namespaces", "error", err)
+		showUsageAndExit()
+	}
+
+	if selection.printPlan {
+		selection.writePlan(*configFlag, *backupFlag || *backupCommandFlag != "")
+		return
+	}
+
+	if *fileFlag == "" {
+		slog.Error("error: fleet file is required")
+		showUsageAndExit()
+	}
+
+	f, err := loadFleet(*fileFlag)
+	if err != nil {
+		slog.Error("error loading fleet", "error", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	cfg.Operators, err = applyChannelOverrides(cfg.Operators, *channelOverrides)
+	if err != nil {
+		slog.Error("error: invalid -channel-override", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := selection.check(cfg, *backupFlag || *backupCommandFlag != ""); err != nil {
+		slog.Error("error: invalid -only", "error", err)
+		showUsageAndExit()
+	}
+
+	// The Placements of the namespaces select the clusters of a single pair.
+	if cfg.DR != nil && cfg.DR.ClusterSet != nil && len(cfg.DR.ClusterSet.Namespaces) > 0 {
+		for _, hub := range f.Hubs {
+			if len(hub.Pairs) > 1 {
+				slog.Error("error: dr.clusterSet.namespaces can only be used with one pair per hub", "hub", hub.Name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if err := manifests.applyRelease(cfg); err != nil {
+		slog.Error("error selecting release", "error", err)
+		os.Exit(1)
+	}
+
+	// A public release stream implies -skip-registry-auth.
+	skipRegistryAuth := *skipRegistryAuthFlag || manifests.publicCatalog
+	if *rhcephPasswordFlag == "" && !skipRegistryAuth {
+		slog.Error("error: RHCEPH password is required unless -skip-registry-auth is set")
+		showUsageAndExit()
+	}
+
+	mirrorSets, err := manifests.loadMirrorSets()
+	if err != nil {
+		slog.Error("error loading mirror sets", "error", err)
+		os.Exit(1)
+	}
+
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
+		os.Exit(1)
+	}
+
+	checkCatalogFreshness(manifests.catalogSourceYAML(cfg), *maxCatalogAgeFlag)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	r := &fleetRun{
+		opts: prepareOptions{
+			rhcephPassword:        *rhcephPasswordFlag,
+			catalogSourceYAML:     manifests.catalogSourceYAML(cfg),
+			mirrorSets:            mirrorSets,
+			operators:             cfg.Operators,
+			scheduling:            cfg.Scheduling,
+			storage:               cfg.Storage,
+			waits:                 cfg.Waits,
+			identity:              cfg.Identity,
+			previousRun:           *previousRunFlag,
+			snapshot:              *snapshotFlag,
+			prune:                 *pruneFlag,
+			terminatingNamespaces: *terminatingNamespacesFlag,
+			pullSecretMode:        *pullSecretModeFlag,
+			pullSecretConflict:    *pullSecretConflictFlag,
+			registryAuthMatch:     *registryAuthMatchFlag,
+			backupBeforeChanges:   *backupFlag || *backupCommandFlag != "",
+			backupCommand:         *backupCommandFlag,
+			skipRegistryAuth:      skipRegistryAuth,
+			force:                 force,
+			only:                  selection.only,
+			onlyStrict:            selection.strict,
+		},
+		report: newRunReport("fleet"),
+		config: cfg,
+	}
+	if *gatherOnFailureFlag {
+		r.gather = gatherOpts
+	}
+	if *measureNetworkFlag {
+		r.network = networkOpts
+	}
+
+	fns := []func() error{}
+	for _, hub := range f.Hubs {
+		fns = append(fns, func() error {
+			return r.runHub(hub)
+		})
+	}
+
+	runErr := runParallel(fns...)
+	if runErr == nil {
+		if err := r.report.finish(*reportFlag, nil); err != nil {
+			slog.Error("error writing report", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	succeeded, failed := r.report.completion()
+	if len(succeeded) == 0 || len(failed) == 0 {
+		exitWithFailedRun(r.report, *reportFlag, "error setting up fleet", runErr)
+	}
+
+	if err := r.report.finish(*reportFlag, fmt.Errorf("error setting up fleet: %v", runErr)); err != nil {
+		slog.Error("error writing report", "error", err)
+	}
+
+	for _, name := range failed {
+		notify(messageError, "cluster-failed", "cluster", name, "error", r.report.cluster(name).Error)
+	}
+
+	total := len(succeeded) + len(failed)
+	required := minSuccess.required(total)
+	notify(messageWarning, "fleet-partial", "succeeded", strconv.Itoa(len(succeeded)), "total", strconv.Itoa(total))
+	if minSuccess.set() {
+		notify(messageInfo, "fleet-required", "required", strconv.Itoa(required))
+	}
+
+	switch {
+	case minSuccess.set() && len(succeeded) >= required:
+		return
+	case minSuccess.set():
+		os.Exit(1)
+	default:
+		os.Exit(exitPartialSuccess)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const mebibyte = 1024 * 1024
+
+// gatherOptions limit how much is gathered, so that gathering from a cluster
+// with large logs cannot fill up the disk of the host.
+type gatherOptions struct {
+	since     time.Duration
+	maxLogMB  int64
+	maxSizeMB int64
+}
+
+func addGatherFlags(flags *flag.FlagSet, prefix string) *gatherOptions {
+	opts := &gatherOptions{}
+	flags.DurationVar(&opts.since, prefix+"since", time.Hour, "Only gather pod logs newer than this")
+	flags.Int64Var(&opts.maxLogMB, prefix+"max-log-mb", 10, "Maximum size of each gathered pod log in MiB")
+	flags.Int64Var(&opts.maxSizeMB, prefix+"max-mb", 500, "Maximum size of the diagnostics bundle in MiB")
+	return opts
+}
+
+// gatherNamespaces returns the namespaces to gather from.
+func gatherNamespaces(operators []operatorConfig) []string {
+	return append(pullSecretNamespaces(operators), "openshift-operator-lifecycle-manager")
+}
+
+func diagnosticsDir(clusterName string) string {
+	return clusterName + "-diagnostics"
+}
+
+// limitedWriter writes up to n bytes and silently drops the rest, so that a
+// command writing to it is never blocked or failed by the limit.
+type limitedWriter struct {
+	w         io.Writer
+	n         int64
+	written   int64
+	truncated bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	remaining := l.n - l.written
+	if int64(len(p)) > remaining {
+		l.truncated = true
+		p = p[:max(remaining, 0)]
+	}
+
+	written, err := l.w.Write(p)
+	l.written += int64(written)
+	if err != nil {
+		return written, err
+	}
+
+	return n, nil
+}
+
+// bundle is a diagnostics directory filled one file at a time. Files are
+// written under a temporary name and renamed when complete, so an interrupted
+// gather can be resumed by gathering into the same directory again.
+type bundle struct {
+	dir     string
+	size    int64
+	maxSize int64
+}
+
+func openBundle(dir string, maxSize int64) (*bundle, error) {
+	b := &bundle{dir: dir, maxSize: maxSize}
+
+	if err := mkdirPrivate(dir); err != nil {
+		return nil, fmt.Errorf("error creating diagnostics directory: %v", err)
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if strings.HasSuffix(path, ".partial") {
+			return os.Remove(path)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b.size += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading diagnostics directory: %v", err)
+	}
+
+	return b, nil
+}
+
+var errBundleFull = errors.New("diagnostics bundle size limit reached")
+
+// add streams the output of an oc command to a file of the bundle, keeping at
+// most maxSize bytes. Files gathered by a previous run are kept.
+func (b *bundle) add(name string, maxSize int64, kconfig string, args ...string) error {
+	path := filepath.Join(b.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if b.size >= b.maxSize {
+		return errBundleFull
+	}
+	maxSize = min(maxSize, b.maxSize-b.size)
+
+	if err := mkdirPrivate(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("error creating diagnostics directory: %v", err)
+	}
+
+	file, err := openPrivateFile(path+".partial", os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", name, err)
+	}
+	defer file.Close()
+
+	if args[0] == "get" {
+		args = append([]string{"get"}, withReadSelector(args[1:])...)
+	}
+
+	output := &limitedWriter{w: file, n: maxSize}
+	cmd := ocCommand(kconfig, args...)
+	cmd.Stdout = output
+	err = cmd.Run()
+	if err != nil {
+		os.Remove(path + ".partial")
+		return fmt.Errorf("error gathering %s: %v", name, err)
+	}
+
+	if output.truncated {
+		slog.Warn("truncated gathered output", "file", name, "limit", maxSize)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+
+	if err := os.Rename(path+".partial", path); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	b.size += output.written
+
+	return nil
+}
+
+type podList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+		Spec     struct {
+			InitContainers []struct {
+				Name string `json:"name"`
+			} `json:"initContainers"`
+			Containers []struct {
+				Name string `json:"name"`
+			} `json:"containers"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// gatherDiagnostics gathers the OLM resources and the pods, events and pod
+// logs of the namespaces into dir. Failures to gather a single file are
+// logged and skipped.
+func gatherDiagnostics(clusterName, kconfig, dir string, namespaces []string, opts *gatherOptions) error {
+	b, err := openBundle(dir, opts.maxSizeMB*mebibyte)
+	if err != nil {
+		return err
+	}
+
+	if err := recordArtifact(clusterName, dir); err != nil {
+		return err
+	}
+
+	slog.Info("gathering diagnostics", "cluster", clusterName, "dir", dir)
+
+	gather := func(name string, maxSize int64, args ...string) error {
+		err := b.add(name, maxSize, kconfig, args...)
+		if err != nil && !errors.Is(err, errBundleFull) {
+			slog.Warn("error gathering diagnostics", "cluster", clusterName, "error", err)
+			return nil
+		}
+
+		return err
+	}
+
+	err = gather("olm.json", b.maxSize, "get", "catalogsources,subscriptions,installplans,clusterserviceversions",
+		"--all-namespaces", "-o", "json")
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		if err := gather(filepath.Join(ns, "pods.json"), b.maxSize, "get", "pods", "-n", ns, "-o", "json"); err != nil {
+			return err
+		}
+		if err := gather(filepath.Join(ns, "events.txt"), b.maxSize, "get", "events", "-n", ns); err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, ns, "pods.json"))
+		if err != nil {
+			continue
+		}
+
+		var pods podList
+		if err := json.Unmarshal(data, &pods); err != nil {
+			slog.Warn("error parsing gathered pods", "cluster", clusterName, "namespace", ns, "error", err)
+			continue
+		}
+
+		for _, pod := range pods.Items {
+			containers := pod.Spec.InitContainers
+			containers = append(containers, pod.Spec.Containers...)
+
+			for _, container := range containers {
+				maxLogSize := opts.maxLogMB * mebibyte
+				err := gather(filepath.Join(ns, pod.Metadata.Name, container.Name+".log"), maxLogSize,
+					"logs", "-n", ns, pod.Metadata.Name, "-c", container.Name,
+					"--since="+opts.since.String(), fmt.Sprintf("--limit-bytes=%d", maxLogSize))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	slog.Info("gathered diagnostics", "cluster", clusterName, "dir", dir, "sizeMB", b.size/mebibyte)
+
+	return nil
+}
+
+// gatherOnFailure gathers diagnostics after a failed run, replacing the
+// diagnostics of an earlier run. Errors are only logged, the run has already
+// failed.
+func gatherOnFailure(clusterName, kconfig string, operators []operatorConfig, opts *gatherOptions) {
+	if err := os.RemoveAll(diagnosticsDir(clusterName)); err != nil {
+		slog.Error("error removing old diagnostics", "cluster", clusterName, "error", err)
+		return
+	}
+
+	err := gatherDiagnostics(clusterName, kconfig, diagnosticsDir(clusterName), gatherNamespaces(operators), opts)
+	if errors.Is(err, errBundleFull) {
+		slog.Warn("diagnostics bundle size limit reached, not all diagnostics were gathered", "cluster", clusterName)
+	} else if err != nil {
+		slog.Error("error gathering diagnostics", "cluster", clusterName, "error", err)
+	}
+}
+
+func runGather(args []string) {
+	flags := flag.NewFlagSet("gather", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the cluster to gather diagnostics from")
+	dirFlag := flags.String("dir", "", "Directory to gather into, an interrupted gather into the same directory is resumed (default: <cluster>-diagnostics)")
+	configFlag := addConfigFlag(flags)
+	opts := addGatherFlags(flags, "")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+	addReadSelectorFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	kconfig := *kubeconfigFlag
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
+		os.Exit(1)
+	}
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to cluster", "error", err)
+		os.Exit(1)
+	}
+
+	clusterName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting cluster name", "error", err)
+		os.Exit(1)
+	}
+
+	dir := *dirFlag
+	if dir == "" {
+		dir = diagnosticsDir(clusterName)
+	}
+
+	err = gatherDiagnostics(clusterName, kconfig, dir, gatherNamespaces(cfg.Operators), opts)
+	if errors.Is(err, errBundleFull) {
+		slog.Warn("diagnostics bundle size limit reached, not all diagnostics were gathered", "cluster", clusterName)
+	} else if err != nil {
+		slog.Error("error gathering diagnostics", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	gitopsPackage       = "openshift-gitops-operator"
+	gitopsNamespace     = "openshift-gitops"
+	gitopsPlacementName = "odfdr-gitops-placement"
+)
+
+// gitopsConfig sets up OpenShift GitOps on the hub for ApplicationSet based
+// DR protection.
+type gitopsConfig struct {
+	// Channel of the openshift-gitops-operator in the redhat-operators
+	// catalog, the default channel of the package if empty.
+	Channel string `json:"channel,omitempty"`
+	// ClusterSet is the ManagedClusterSet of the DR clusters, default by
+	// default.
+	ClusterSet string `json:"clusterSet,omitempty"`
+}
+
+func (c *gitopsConfig) validate() {
+	if c.ClusterSet == "" {
+		c.ClusterSet = "default"
+	}
+}
+
+type gitopsCluster struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		ArgoServer struct {
+			Cluster       string `json:"cluster"`
+			ArgoNamespace string `json:"argoNamespace"`
+		} `json:"argoServer"`
+		PlacementRef struct {
+			Kind       string `json:"kind"`
+			APIVersion string `json:"apiVersion"`
+			Name       string `json:"name"`
+		} `json:"placementRef"`
+	} `json:"spec"`
+}
+
+// gitopsManifests returns the ManagedClusterSetBinding, Placement and
+// GitOpsCluster that register the clusters of the cluster set with the Argo
+// CD of the hub.
+func gitopsManifests(cfg *gitopsConfig) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+
+	gitops := gitopsCluster{
+		APIVersion: "apps.open-cluster-management.io/v1beta1",
+		Kind:       "GitOpsCluster",
+		Metadata:   objectMeta{Name: "odfdr-gitops-cluster", Namespace: gitopsNamespace},
+	}
+	gitops.Spec.ArgoServer.Cluster = "local-cluster"
+	gitops.Spec.ArgoServer.ArgoNamespace = gitopsNamespace
+	gitops.Spec.PlacementRef.Kind = "Placement"
+	gitops.Spec.PlacementRef.APIVersion = "cluster.open-cluster-management.io/v1beta1"
+	gitops.Spec.PlacementRef.Name = gitopsPlacementName
+
+	manifests.Items = append(manifests.Items,
+		newClusterSetBinding(cfg.ClusterSet, gitopsNamespace),
+		newPlacement(gitopsPlacementName, gitopsNamespace, cfg.ClusterSet),
+		gitops)
+
+	return manifests
+}
+
+// configureGitOps installs OpenShift GitOps on the hub, unless it is
+// installed, and registers the DR clusters with its Argo CD, so that
+// ApplicationSets can place DR protected workloads on them.
+func configureGitOps(hubName, kconfig string, clusters []string, cfg *gitopsConfig) error {
+	if err := checkClusterSet(kconfig, cfg.ClusterSet, clusters); err != nil {
+		return err
+	}
+
+	// The operator only supports being installed for all namespaces.
+	operator := operatorConfig{Package: gitopsPackage, Channel: cfg.Channel, Source: redHatOperatorsCatalog}
+	if err := installOperators(hubName, kconfig, "", []operatorConfig{operator}, nil, nil, nil); err != nil {
+		return fmt.Errorf("error installing OpenShift GitOps: %v", err)
+	}
+
+	err := waitFor(kconfig, "Argo CD "+gitopsNamespace+" to be Available", 10*time.Minute, 10*time.Second, func() (bool, error) {
+		var argoCD struct {
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		}
+		found, err := getJSON(kconfig, &argoCD, "argocds.argoproj.io", gitopsNamespace, "-n", gitopsNamespace)
+		if err != nil || !found {
+			return false, err
+		}
+
+		return argoCD.Status.Phase == "Available", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(gitopsManifests(cfg), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding GitOpsCluster: %v", err)
+	}
+
+	fileName := hubName + "-gitops.json"
+	err = writeArtifact(hubName, fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing GitOpsCluster to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying GitOpsCluster: %v", err)
+	}
+
+	// The GitOpsCluster adds an Argo CD cluster secret for every cluster
+	// selected by the Placement.
+	for _, cluster := range clusters {
+		secret := cluster + "-application-manager-cluster-secret"
+		err := waitFor(kconfig, "Argo CD cluster secret of "+cluster, 5*time.Minute, 10*time.Second, func() (bool, error) {
+			var s objectList
+			return getJSON(kconfig, &s, "secret", secret, "-n", gitopsNamespace)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("registered DR clusters with OpenShift GitOps", "clusters", clusters, "clusterSet", cfg.ClusterSet)
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyDir returns the directory that keeps the reports of past runs.
+func historyDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error finding home directory: %v", err)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dataHome, "odfdr-installer", "runs"), nil
+}
+
+func saveRun(r *runReport) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+
+	err = mkdirPrivate(dir)
+	if err != nil {
+		return fmt.Errorf("error creating history directory: %v", err)
+	}
+
+	return r.write(filepath.Join(dir, r.ID+".json"))
+}
+
+func loadRun(id string) (*runReport, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading run %s: %v", id, err)
+	}
+
+	var r runReport
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing run %s: %v", id, err)
+	}
+
+	return &r, nil
+}
+
+// loadRuns returns all recorded runs, oldest first.
+func loadRuns() ([]*runReport, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading history directory: %v", err)
+	}
+
+	runs := []*runReport{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		r, err := loadRun(id)
+		if err != nil {
+			slog.Warn("skipping unreadable run", "error", err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartTime.Before(runs[j].StartTime)
+	})
+
+	return runs, nil
+}
+
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	clusterFlag := flags.String("cluster", "", "Only list runs that targeted this cluster")
+	limitFlag := flags.Int("limit", 20, "Maximum number of runs to list")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	runs, err := loadRuns()
+	if err != nil {
+		slog.Error("error loading run history", "error", err)
+		os.Exit(1)
+	}
+
+	if *clusterFlag != "" {
+		filtered := []*runReport{}
+		for _, r := range runs {
+			if _, ok := r.Clusters[*clusterFlag]; ok {
+				filtered = append(filtered, r)
+			}
+		}
+		runs = filtered
+	}
+
+	if len(runs) > *limitFlag {
+		runs = runs[len(runs)-*limitFlag:]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMMAND\tSTARTED\tDURATION\tOUTCOME\tCLUSTERS")
+	for _, r := range runs {
+		duration := r.EndTime.Sub(r.StartTime).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%s\n", r.ID, r.Command, r.StartTime.Format(time.DateTime), duration,
+			r.Outcome, strings.Join(sortedClusterNames(r.Clusters), ","))
+	}
+	w.Flush()
+}
+
+func runShowRun(args []string) {
+	flags := flag.NewFlagSet("show-run", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "Print the full run report as JSON")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		slog.Error("error: run ID is required")
+		showUsageAndExit()
+	}
+
+	r, err := loadRun(flags.Arg(0))
+	if err != nil {
+		slog.Error("error loading run", "error", err)
+		os.Exit(1)
+	}
+
+	if *jsonFlag {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			slog.Error("error encoding run", "error", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("Run:      %s\n", r.ID)
+	fmt.Printf("Command:  %s\n", r.Command)
+	fmt.Printf("Started:  %s\n", r.StartTime.Format(time.DateTime))
+	fmt.Printf("Finished: %s\n", r.EndTime.Format(time.DateTime))
+	fmt.Printf("Outcome:  %s\n", r.Outcome)
+	if r.Error != "" {
+		fmt.Printf("Error:    %s\n", r.Error)
+	}
+	if len(r.FailedClusters) > 0 {
+		fmt.Printf("Failed:   %s\n", strings.Join(r.FailedClusters, ", "))
+	}
+
+	for _, name := range sortedClusterNames(r.Clusters) {
+		cluster := r.Clusters[name]
+		fmt.Printf("\nCluster %s %s\n", name, cluster.URL)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  STEP\tSTARTED\tDURATION\tERROR")
+		for _, s := range cluster.Steps {
+			fmt.Fprintf(w, "  %s\t%s\t%v\t%s\n", s.Name, s.Start.Format(time.DateTime), s.Duration.Round(time.Second), s.Error)
+		}
+		w.Flush()
+
+		if cluster.Changes != nil {
+			fmt.Printf("\n  Changes:\n%s", describeChanges(cluster.Changes))
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// hostedClusterRef identifies the HostedCluster of a hosted control plane
+// cluster on its management cluster.
+type hostedClusterRef struct {
+	kubeconfig string
+	namespace  string
+	name       string
+}
+
+// addHostedClusterFlags adds the flags that point to the HostedCluster of a
+// hosted control plane cluster and returns a function that parses them.
+func addHostedClusterFlags(flags *flag.FlagSet) func() (*hostedClusterRef, error) {
+	kubeconfigFlag := flags.String("management-kubeconfig", "", "Kubeconfig of the HyperShift management cluster, for hosted control plane clusters")
+	hostedClusterFlag := flags.String("hosted-cluster", "", "HostedCluster of the cluster on the management cluster in namespace/name form")
+
+	return func() (*hostedClusterRef, error) {
+		return parseHostedClusterRef(*kubeconfigFlag, *hostedClusterFlag)
+	}
+}
+
+func parseHostedClusterRef(kconfig, hostedCluster string) (*hostedClusterRef, error) {
+	if kconfig == "" && hostedCluster == "" {
+		return nil, nil
+	}
+
+	if kconfig == "" || hostedCluster == "" {
+		return nil, fmt.Errorf("management kubeconfig and hosted cluster must be given together")
+	}
+
+	namespace, name, ok := strings.Cut(hostedCluster, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("hosted cluster %q is not in namespace/name form", hostedCluster)
+	}
+
+	return &hostedClusterRef{kubeconfig: kconfig, namespace: namespace, name: name}, nil
+}
+
+// imageContentSource is a mirror entry of a HostedCluster, the equivalent of a
+// repositoryDigestMirrors entry of an ImageContentSourcePolicy.
+type imageContentSource struct {
+	Source  string   `json:"source"`
+	Mirrors []string `json:"mirrors,omitempty"`
+}
+
+type infrastructure struct {
+	Status struct {
+		ControlPlaneTopology string `json:"controlPlaneTopology"`
+	} `json:"status"`
+}
+
+type hostedCluster struct {
+	Spec struct {
+		PullSecret struct {
+			Name string `json:"name"`
+		} `json:"pullSecret"`
+		ImageContentSources []imageContentSource `json:"imageContentSources"`
+	} `json:"spec"`
+}
+
+// isHostedControlPlane returns true if the control plane of the cluster runs
+// outside of it, on a HyperShift management cluster.
+func isHostedControlPlane(kconfig string) (bool, error) {
+	var infra infrastructure
+	if _, err := getJSON(kconfig, &infra, "infrastructure/cluster"); err != nil {
+		if errors.Is(err, errForbidden) {
+			slog.Warn("cannot read the control plane topology, assuming a standalone cluster", "error", err)
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return infra.Status.ControlPlaneTopology == "External", nil
+}
+
+func getHostedCluster(ref *hostedClusterRef) (*hostedCluster, error) {
+	var hc hostedCluster
+	found, err := getJSON(ref.kubeconfig, &hc, "hostedclusters.hypershift.openshift.io/"+ref.name, "-n", ref.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("HostedCluster %s/%s not found on the management cluster", ref.namespace, ref.name)
+	}
+
+	return &hc, nil
+}
+
+// addHostedRHCEPHAuth adds the RHCEPH registry auth to the pull secret of the
+// HostedCluster, which HyperShift propagates to the nodes of the hosted
+// cluster.
+func addHostedRHCEPHAuth(clusterName string, ref *hostedClusterRef, rhcephPassword, conflict, match string, force bool) error {
+	hc, err := getHostedCluster(ref)
+	if err != nil {
+		return err
+	}
+
+	if hc.Spec.PullSecret.Name == "" {
+		return fmt.Errorf("HostedCluster %s/%s has no pull secret", ref.namespace, ref.name)
+	}
+
+	pullSecret := secretRef{Namespace: ref.namespace, Name: hc.Spec.PullSecret.Name}
+	slog.Info("adding RHCEPH auth to the HostedCluster pull secret", "cluster", clusterName,
+		"namespace", pullSecret.Namespace, "secret", pullSecret.Name)
+
+	return addRHCEPHAuth(clusterName, ref.kubeconfig, pullSecret, rhcephPassword, conflict, match, force)
+}
+
+// digestMirrorKeys are the lists of digest mirrors of an
+// ImageContentSourcePolicy and of an ImageDigestMirrorSet.
+var digestMirrorKeys = []string{"repositoryDigestMirrors", "imageDigestMirrors"}
+
+// parseDigestMirrors returns the repositoryDigestMirrors of an
+// ImageContentSourcePolicy document or the imageDigestMirrors of an
+// ImageDigestMirrorSet document. Only JSON and the YAML block style used by
+// the embedded mirror sets and by oc are understood. A document without
+// digest mirrors, like an ImageTagMirrorSet, is an error, the
+// imageContentSources of a HostedCluster only hold digest mirrors.
+func parseDigestMirrors(icspYAML string) ([]imageContentSource, error) {
+	var sources []imageContentSource
+	if strings.HasPrefix(strings.TrimSpace(icspYAML), "{") {
+		var doc struct {
+			Spec struct {
+				RepositoryDigestMirrors []imageContentSource `json:"repositoryDigestMirrors"`
+				ImageDigestMirrors      []imageContentSource `json:"imageDigestMirrors"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal([]byte(icspYAML), &doc); err != nil {
+			return nil, fmt.Errorf("error parsing mirror set: %v", err)
+		}
+		sources = append(doc.Spec.RepositoryDigestMirrors, doc.Spec.ImageDigestMirrors...)
+	} else {
+		var err error
+		if sources, err = parseYAMLDigestMirrors(icspYAML); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no %s found", strings.Join(digestMirrorKeys, " or "))
+	}
+
+	for _, source := range sources {
+		if source.Source == "" || len(source.Mirrors) == 0 {
+			return nil, fmt.Errorf("digest mirrors entry without source or mirrors")
+		}
+	}
+
+	return sources, nil
+}
+
+// parseYAMLDigestMirrors returns the entries of the digest mirror lists of a
+// YAML document.
+func parseYAMLDigestMirrors(doc string) ([]imageContentSource, error) {
+	sources := []imageContentSource{}
+	// keyIndent is the indent of the key of the list the lines are in, -1
+	// outside of a list.
+	keyIndent := -1
+	itemIndent := -1
+	var current *imageContentSource
+
+	for _, line := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if key, ok := strings.CutSuffix(trimmed, ":"); ok && slices.Contains(digestMirrorKeys, key) {
+			keyIndent, itemIndent, current = indent, -1, nil
+			continue
+		}
+		if keyIndent == -1 {
+			continue
+		}
+
+		item := strings.HasPrefix(trimmed, "- ")
+		switch {
+		case indent < keyIndent || (indent == keyIndent && !item), itemIndent != -1 && indent < itemIndent:
+			// The list ended.
+			keyIndent = -1
+			continue
+		case item && (itemIndent == -1 || indent == itemIndent):
+			itemIndent = indent
+			sources = append(sources, imageContentSource{})
+			current = &sources[len(sources)-1]
+			trimmed = strings.TrimPrefix(trimmed, "- ")
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("unexpected line in digest mirrors: %q", line)
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "source:"):
+			current.Source = strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "source:")), `"'`)
+		case trimmed == "mirrors:":
+		case strings.HasPrefix(trimmed, "mirrorSourcePolicy:"):
+			// HostedClusters have no mirror source policy, the mirrors
+			// are tried before the source.
+		case strings.HasPrefix(trimmed, "- "):
+			current.Mirrors = append(current.Mirrors, strings.Trim(strings.TrimPrefix(trimmed, "- "), `"'`))
+		default:
+			return nil, fmt.Errorf("unexpected line in digest mirrors: %q", line)
+		}
+	}
+
+	return sources, nil
+}
+
+// mergeImageContentSources adds the mirrors of added to existing and returns
+// the result and whether anything was added.
+func mergeImageContentSources(existing, added []imageContentSource) ([]imageContentSource, bool) {
+	merged := slices.Clone(existing)
+	changed := false
+
+	for _, source := range added {
+		i := slices.IndexFunc(merged, func(s imageContentSource) bool { return s.Source == source.Source })
+		if i == -1 {
+			merged = append(merged, source)
+			changed = true
+			continue
+		}
+
+		for _, mirror := range source.Mirrors {
+			if !slices.Contains(merged[i].Mirrors, mirror) {
+				merged[i].Mirrors = append(slices.Clone(merged[i].Mirrors), mirror)
+				changed = true
+			}
+		}
+	}
+
+	return merged, changed
+}
+
+// addHostedMirrorSets adds the mirrors of the mirror sets to the
+// imageContentSources of the HostedCluster. Hosted clusters have no
+// MachineConfig rollout, so ImageContentSourcePolicies applied to them never
+// reach the nodes.
+func addHostedMirrorSets(clusterName string, ref *hostedClusterRef, sets []mirrorSet) error {
+	added := []imageContentSource{}
+	for _, set := range sets {
+		sources, err := parseDigestMirrors(set.YAML)
+		if err != nil {
+			return fmt.Errorf("error parsing mirror set %s: %v", set.Name, err)
+		}
+		added = append(added, sources...)
+	}
+
+	hc, err := getHostedCluster(ref)
+	if err != nil {
+		return err
+	}
+
+	merged, changed := mergeImageContentSources(hc.Spec.ImageContentSources, added)
+	if !changed {
+		slog.Info("HostedCluster already has the mirror sets", "cluster", clusterName)
+		return nil
+	}
+
+	patch := map[string]any{"spec": map[string]any{"imageContentSources": merged}}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("error encoding HostedCluster patch: %v", err)
+	}
+
+	patchCmd := ocCommand(ref.kubeconfig, "patch", "hostedclusters.hypershift.openshift.io/"+ref.name, "-n", ref.namespace,
+		"--type=merge", "-p", string(patchData))
+	err = patchCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error patching HostedCluster: %v", err)
+	}
+
+	slog.Info("added mirror sets to HostedCluster", "cluster", clusterName, "hostedCluster", ref.namespace+"/"+ref.name,
+		"sources", len(added))
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDigestMirrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    []imageContentSource
+		wantErr string
+	}{
+		{
+			name: "ImageContentSourcePolicy",
+			doc: `apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: ceph
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - quay.io/rhceph-dev/rhceph-8-rhel9
+    source: registry.redhat.io/rhceph/rhceph-8-rhel9
+`,
+			want: []imageContentSource{{Source: "registry.redhat.io/rhceph/rhceph-8-rhel9", Mirrors: []string{"quay.io/rhceph-dev/rhceph-8-rhel9"}}},
+		},
+		{
+			name: "ImageDigestMirrorSet",
+			doc: `apiVersion: config.openshift.io/v1
+kind: ImageDigestMirrorSet
+metadata:
+  name: odf
+spec:
+  imageDigestMirrors:
+    - source: registry.redhat.io/odf4
+      mirrors:
+        - quay.io/rhceph-dev/odf4
+        - "mirror.example.com/odf4"
+      mirrorSourcePolicy: NeverContactSource
+    - source: registry.redhat.io/rhceph
+      mirrors:
+        - quay.io/rhceph-dev/rhceph
+status: {}
+`,
+			want: []imageContentSource{
+				{Source: "registry.redhat.io/odf4", Mirrors: []string{"quay.io/rhceph-dev/odf4", "mirror.example.com/odf4"}},
+				{Source: "registry.redhat.io/rhceph", Mirrors: []string{"quay.io/rhceph-dev/rhceph"}},
+			},
+		},
+		{
+			name: "sibling key after the list",
+			doc: `spec:
+  imageDigestMirrors:
+  - source: registry.redhat.io/odf4
+    mirrors:
+    - quay.io/rhceph-dev/odf4
+  other: value
+`,
+			want: []imageContentSource{{Source: "registry.redhat.io/odf4", Mirrors: []string{"quay.io/rhceph-dev/odf4"}}},
+		},
+		{
+			name: "JSON ImageDigestMirrorSet",
+			doc:  `{"kind":"ImageDigestMirrorSet","spec":{"imageDigestMirrors":[{"source":"registry.redhat.io/odf4","mirrors":["quay.io/rhceph-dev/odf4"]}]}}`,
+			want: []imageContentSource{{Source: "registry.redhat.io/odf4", Mirrors: []string{"quay.io/rhceph-dev/odf4"}}},
+		},
+		{
+			name: "ImageTagMirrorSet",
+			doc: `kind: ImageTagMirrorSet
+spec:
+  imageTagMirrors:
+  - source: registry.redhat.io/odf4
+    mirrors:
+    - quay.io/rhceph-dev/odf4
+`,
+			wantErr: "no repositoryDigestMirrors or imageDigestMirrors",
+		},
+		{
+			name:    "JSON without mirrors",
+			doc:     `{"kind":"ImageDigestMirrorSet","spec":{}}`,
+			wantErr: "no repositoryDigestMirrors or imageDigestMirrors",
+		},
+		{
+			name: "entry without mirrors",
+			doc: `spec:
+  imageDigestMirrors:
+  - source: registry.redhat.io/odf4
+`,
+			wantErr: "without source or mirrors",
+		},
+		{
+			name: "flow style",
+			doc: `spec:
+  imageDigestMirrors:
+  - source: registry.redhat.io/odf4
+    mirrors: [quay.io/rhceph-dev/odf4]
+`,
+			wantErr: "unexpected line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDigestMirrors(tt.doc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIdentityProvider = "odfdr-htpasswd"
+	// htpasswdSecretKey is the key of the htpasswd file in the Secret of an
+	// htpasswd identity provider.
+	htpasswdSecretKey    = "htpasswd"
+	oauthConfigNamespace = "openshift-config"
+)
+
+// identityConfig is a dedicated cluster-admin user created by the identity
+// step, for clusters whose kubeadmin is to be removed.
+type identityConfig struct {
+	Username string `json:"username"`
+	// Password is generated and kept in <cluster>-<username>-password if it
+	// is not set.
+	Password string `json:"password,omitempty"`
+	// Provider is the name of the htpasswd identity provider,
+	// odfdr-htpasswd by default.
+	Provider string `json:"provider,omitempty"`
+}
+
+func (c *identityConfig) validate() error {
+	if c.Username == "" || strings.ContainsAny(c.Username, ": ") {
+		return fmt.Errorf("identity needs a username without colons and spaces")
+	}
+
+	if c.Provider == "" {
+		c.Provider = defaultIdentityProvider
+	}
+
+	return nil
+}
+
+func (c *identityConfig) secretName() string {
+	return c.Provider + "-secret"
+}
+
+// htpasswdEntry returns the htpasswd line of the user. The SHA-1 form is used
+// as bcrypt is not in the standard library, the OpenShift OAuth server
+// accepts both.
+func htpasswdEntry(username, password string) string {
+	sum := sha1.Sum([]byte(password))
+	return username + ":{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// identityPassword returns the configured password, or the one generated by
+// an earlier run, or generates one.
+func identityPassword(clusterName string, identity *identityConfig) (string, error) {
+	if identity.Password != "" {
+		return identity.Password, nil
+	}
+
+	fileName := clusterName + "-" + identity.Username + "-password"
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("error reading password file: %v", err)
+	}
+
+	random := make([]byte, 18)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("error generating password: %v", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(random)
+
+	if err := writePrivateFile(fileName, []byte(password+"\n")); err != nil {
+		return "", fmt.Errorf("error writing password file: %v", err)
+	}
+	if err := recordArtifact(clusterName, fileName); err != nil {
+		return "", err
+	}
+	slog.Info("generated password", "cluster", clusterName, "username", identity.Username, "file", fileName)
+
+	return password, nil
+}
+
+type identityProvider struct {
+	Name          string `json:"name"`
+	MappingMethod string `json:"mappingMethod"`
+	Type          string `json:"type"`
+	HTPasswd      struct {
+		FileData struct {
+			Name string `json:"name"`
+		} `json:"fileData"`
+	} `json:"htpasswd"`
+}
+
+// addIdentityProvider adds the htpasswd identity provider to the OAuth
+// configuration, unless it is configured already.
+func addIdentityProvider(kconfig string, identity *identityConfig) error {
+	var oauth struct {
+		Spec struct {
+			IdentityProviders []json.RawMessage `json:"identityProviders"`
+		} `json:"spec"`
+	}
+	if _, err := getJSON(kconfig, &oauth, "oauth.config.openshift.io", "cluster"); err != nil {
+		return fmt.Errorf("error getting OAuth configuration: %v", err)
+	}
+
+	for _, raw := range oauth.Spec.IdentityProviders {
+		var provider struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &provider); err == nil && provider.Name == identity.Provider {
+			return nil
+		}
+	}
+
+	provider := identityProvider{Name: identity.Provider, MappingMethod: "claim", Type: "HTPasswd"}
+	provider.HTPasswd.FileData.Name = identity.secretName()
+	data, err := json.Marshal(provider)
+	if err != nil {
+		return fmt.Errorf("error encoding identity provider: %v", err)
+	}
+
+	// The list is replaced as a whole by a merge patch.
+	oauth.Spec.IdentityProviders = append(oauth.Spec.IdentityProviders, data)
+	patchData, err := json.Marshal(oauth)
+	if err != nil {
+		return fmt.Errorf("error encoding OAuth patch: %v", err)
+	}
+
+	patchCmd := ocCommand(kconfig, "patch", "oauth.config.openshift.io", "cluster", "--type", "merge", "-p", string(patchData))
+	if err := patchCmd.Run(); err != nil {
+		return fmt.Errorf("error adding identity provider %s: %v", identity.Provider, err)
+	}
+
+	return nil
+}
+
+// addIdentity creates the htpasswd identity provider and the cluster-admin
+// user, and logs in as the user for the following steps. Clusters given by a
+// kubeconfig keep it, the installer does not overwrite it.
+func addIdentity(clusterName, kconfig string, identity *identityConfig) error {
+	if identity == nil {
+		return nil
+	}
+
+	password, err := identityPassword(clusterName, identity)
+	if err != nil {
+		return err
+	}
+
+	htpasswdSecret := secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: identity.secretName(), Namespace: oauthConfigNamespace},
+		Type:       "Opaque",
+		Data:       map[string][]byte{htpasswdSecretKey: []byte(htpasswdEntry(identity.Username, password) + "\n")},
+	}
+	binding := clusterRoleBinding{
+		APIVersion: "rbac.authorization.k8s.io/v1",
+		Kind:       "ClusterRoleBinding",
+		Metadata:   objectMeta{Name: "odfdr-installer-" + identity.Username + "-cluster-admin"},
+		RoleRef:    roleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects:   []subject{{Kind: "User", Name: identity.Username}},
+	}
+
+	data, err := json.MarshalIndent(list{APIVersion: "v1", Kind: "List", Items: []any{htpasswdSecret, binding}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding identity manifests: %v", err)
+	}
+
+	fileName := clusterName + "-identity.json"
+	if err := writeArtifact(clusterName, fileName, data); err != nil {
+		return fmt.Errorf("error writing identity manifests to file: %v", err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying identity manifests: %v", err)
+	}
+
+	if err := addIdentityProvider(kconfig, identity); err != nil {
+		return err
+	}
+
+	if !hasSession(kconfig) {
+		slog.Warn("not switching to the new user, the kubeconfig was not created by the installer", "cluster", clusterName, "username", identity.Username)
+		return nil
+	}
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		return err
+	}
+
+	// The OAuth server is rolled out with the new identity provider, which
+	// takes a few minutes.
+	return waitFor(kconfig, "login as "+identity.Username, 10*time.Minute, 15*time.Second, func() (bool, error) {
+		if err := login(url, identity.Username, password, kconfig); err != nil {
+			slog.Debug("login not possible yet", "cluster", clusterName, "username", identity.Username, "error", err)
+			return false, nil
+		}
+
+		return true, nil
+	})
+}
+
+func describeIdentity(identity *identityConfig) string {
+	if identity == nil {
+		return "No identity is configured."
+	}
+
+	return fmt.Sprintf("Add the htpasswd identity provider %s with the user %s, bind it to cluster-admin and log in as %s.",
+		identity.Provider, identity.Username, identity.Username)
+}
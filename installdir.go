@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readInstallDir returns the API URL and the kubeadmin password from the auth
+// directory that openshift-install creates for a new cluster.
+func readInstallDir(dir string) (string, string, error) {
+	kubeconfig, err := os.ReadFile(filepath.Join(dir, "auth", "kubeconfig"))
+	if err != nil {
+		return "", "", fmt.Errorf("error reading installer kubeconfig: %v", err)
+	}
+
+	url := ""
+	for _, line := range strings.Split(string(kubeconfig), "\n") {
+		if server, ok := strings.CutPrefix(strings.TrimSpace(line), "server:"); ok {
+			url = strings.TrimSpace(server)
+			break
+		}
+	}
+
+	if url == "" {
+		return "", "", fmt.Errorf("no API server found in installer kubeconfig")
+	}
+
+	password, err := os.ReadFile(filepath.Join(dir, "auth", "kubeadmin-password"))
+	if err != nil {
+		return "", "", fmt.Errorf("error reading kubeadmin password: %v", err)
+	}
+
+	return url, strings.TrimSpace(string(password)), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// drInventoryPrefix names the inventory ConfigMaps of the DR pairs on the
+// hub, which downstream tools read instead of the installer logs.
+const drInventoryPrefix = "odfdr-dr-inventory-"
+
+func drInventoryName(clusters []string) string {
+	return drInventoryPrefix + strings.Join(clusters, "-")
+}
+
+// drInventory returns the inventory of a DR pair as ConfigMap data: the
+// clusters, their API URLs, the storage IDs of the DR storage classes and the
+// S3 stores of Ramen, keyed by <cluster>.<field>, and the DRPolicies that
+// cover the pair.
+func drInventory(kconfig string, opts drOptions) (map[string]string, error) {
+	data := map[string]string{
+		"clusters":         strings.Join(opts.clusters, ","),
+		"storageBackend":   opts.storageBackend,
+		"runId":            artifactRunID,
+		"installerVersion": version,
+		"updated":          time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, cluster := range opts.clusters {
+		mc, err := getManagedCluster(kconfig, cluster)
+		if err != nil {
+			return nil, err
+		}
+		urls := []string{}
+		for _, config := range mc.Spec.ManagedClusterClientConfigs {
+			urls = append(urls, config.URL)
+		}
+		data[cluster+".apiURL"] = strings.Join(urls, ",")
+
+		storageIDs := []string{}
+		for _, sc := range opts.storageClasses {
+			storageIDs = append(storageIDs, drStorageID(cluster, sc.Name))
+		}
+		data[cluster+".storageIDs"] = strings.Join(storageIDs, ",")
+	}
+
+	// LVM Storage clusters are not peered and have no S3 stores.
+	if opts.storageBackend != storageBackendLVMS {
+		data["mirrorPeer"] = mirrorPeerName(opts.clusters)
+
+		var cm configMap
+		if _, err := getJSON(kconfig, &cm, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace); err != nil {
+			return nil, err
+		}
+		profiles := parseS3StoreProfiles(cm.Data[ramenConfigKey])
+		for _, cluster := range opts.clusters {
+			name := s3ProfileName(cluster, opts.storageClusterRef.Name)
+			i := slices.IndexFunc(profiles, func(p s3StoreProfile) bool { return p.Name == name })
+			if i == -1 {
+				continue
+			}
+			data[cluster+".s3Profile"] = profiles[i].Name
+			data[cluster+".s3Endpoint"] = profiles[i].Endpoint
+			data[cluster+".s3Bucket"] = profiles[i].Bucket
+			data[cluster+".s3Region"] = profiles[i].Region
+		}
+	}
+
+	var policies drPolicyList
+	if _, err := getJSON(kconfig, &policies, "drpolicies.ramendr.openshift.io"); err != nil {
+		return nil, err
+	}
+	covering := []string{}
+	for _, policy := range policies.Items {
+		if coversClusters(policy.Spec.DRClusters, opts.clusters) {
+			covering = append(covering, policy.Metadata.Name)
+		}
+	}
+	data["drPolicies"] = strings.Join(covering, ",")
+
+	return data, nil
+}
+
+// recordDRInventory writes the inventory of the DR pair into a ConfigMap in
+// openshift-operators on the hub, replacing the inventory of an earlier run.
+func recordDRInventory(hubName, kconfig string, opts drOptions) error {
+	data, err := drInventory(kconfig, opts)
+	if err != nil {
+		return err
+	}
+
+	name := drInventoryName(opts.clusters)
+	cm := configMap{APIVersion: "v1", Kind: "ConfigMap", Metadata: objectMeta{Name: name, Namespace: globalOperatorsNamespace}, Data: data}
+	manifest, err := json.MarshalIndent(cm, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", name, err)
+	}
+
+	fileName := hubName + "-" + name + ".json"
+	if err := writeArtifact(hubName, fileName, manifest); err != nil {
+		return fmt.Errorf("error writing %s to file: %v", name, err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying %s: %v", name, err)
+	}
+
+	slog.Info("recorded DR inventory", "configMap", globalOperatorsNamespace+"/"+name, "clusters", opts.clusters, "drPolicies", data["drPolicies"])
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// jsonLogsFlag is the -json-logs flag. It is a boolean flag that also takes
+// auto, which logs JSON unless stderr is a terminal. auto is the default, so
+// that CI jobs and pods get logs their pipelines can index without passing
+// the flag, and people at a terminal get the text logs.
+type jsonLogsFlag string
+
+var (
+	jsonLogs jsonLogsFlag = "auto"
+	// textLogger is the default logger of slog, which writes the text logs
+	// through the standard logger.
+	textLogger = slog.Default()
+)
+
+func (f *jsonLogsFlag) String() string {
+	return string(*f)
+}
+
+func (f *jsonLogsFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *jsonLogsFlag) Set(value string) error {
+	var enabled bool
+	switch value {
+	case "true":
+		enabled = true
+	case "false":
+	case "auto":
+		enabled = !isTerminal(os.Stderr)
+	default:
+		return fmt.Errorf("unknown value %q, expected true, false or auto", value)
+	}
+
+	*f = jsonLogsFlag(value)
+	if enabled {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(jsonLogWriter{}, &slog.HandlerOptions{ReplaceAttr: jsonLogAttr})))
+		return nil
+	}
+
+	// The JSON logger took over the standard logger, which the text logger
+	// writes through.
+	log.SetOutput(logOutput)
+	log.SetFlags(log.LstdFlags)
+	slog.SetDefault(textLogger)
+
+	return nil
+}
+
+// setupLogs applies the default of -json-logs, before the flags of the
+// command are parsed.
+func setupLogs() {
+	_ = jsonLogs.Set(string(jsonLogs))
+}
+
+func addJSONLogsFlag(flags *flag.FlagSet) {
+	flags.Var(&jsonLogs, "json-logs", "Log one JSON object per line, for log aggregation (true, false or auto, which logs JSON unless stderr is a terminal)")
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// jsonLogWriter writes the JSON logs where the text logs go, with the matches
+// of the redact patterns replaced.
+type jsonLogWriter struct{}
+
+func (jsonLogWriter) Write(p []byte) (int, error) {
+	return redactingWriter{w: logOutput}.Write(p)
+}
+
+// jsonLogAttr logs durations in seconds, which log pipelines can aggregate
+// without parsing Go durations.
+func jsonLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Round(time.Millisecond).Seconds())
+	}
+
+	return a
+}
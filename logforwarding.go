@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRunLogNamespace = "odfdr-installer-logs"
+	defaultRunLogImage     = "registry.access.redhat.com/ubi9/ubi-minimal"
+	defaultRunLogTTL       = time.Hour
+
+	// maxRunLogSize bounds the run log kept in memory.
+	maxRunLogSize = 16 * mebibyte
+	// maxRunLogConfigSize is the part of the run log forwarded to a
+	// cluster, as a ConfigMap holds up to 1 MiB.
+	maxRunLogConfigSize = 900 * 1024
+)
+
+// logForwardingConfig forwards the log of every run, once it finished, so
+// that it is kept with the logs of the clusters instead of on the host.
+type logForwardingConfig struct {
+	// Loki pushes the run log to a Loki push API.
+	Loki *lokiConfig `json:"loki,omitempty"`
+	// Cluster prints the run log in a Job on a cluster, whose log is
+	// collected by the logging stack of the cluster.
+	Cluster *clusterLogConfig `json:"cluster,omitempty"`
+}
+
+type lokiConfig struct {
+	// URL is the base URL of Loki, the push API is below it. A bearer
+	// token is taken from $LOKI_TOKEN.
+	URL string `json:"url"`
+	// Labels are added to the labels of the stream of the run.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type clusterLogConfig struct {
+	Kubeconfig string `json:"kubeconfig"`
+	Namespace  string `json:"namespace,omitempty"`
+	Image      string `json:"image,omitempty"`
+	// TTL is how long the finished Job and its ConfigMap are kept.
+	TTL string `json:"ttl,omitempty"`
+
+	ttl time.Duration
+}
+
+func (c *logForwardingConfig) validate() error {
+	if c.Loki != nil && c.Loki.URL == "" {
+		return fmt.Errorf("logForwarding.loki has no url")
+	}
+
+	if c.Cluster != nil {
+		if c.Cluster.Kubeconfig == "" {
+			return fmt.Errorf("logForwarding.cluster has no kubeconfig")
+		}
+		if c.Cluster.Namespace == "" {
+			c.Cluster.Namespace = defaultRunLogNamespace
+		}
+		if c.Cluster.Image == "" {
+			c.Cluster.Image = defaultRunLogImage
+		}
+		c.Cluster.ttl = defaultRunLogTTL
+		if c.Cluster.TTL != "" {
+			ttl, err := time.ParseDuration(c.Cluster.TTL)
+			if err != nil || ttl <= 0 {
+				return fmt.Errorf("invalid logForwarding.cluster.ttl %q, expected e.g. 1h", c.Cluster.TTL)
+			}
+			c.Cluster.ttl = ttl
+		}
+	}
+
+	return nil
+}
+
+// logForwarding is set by the configuration file.
+var logForwarding *logForwardingConfig
+
+// runLogLine is a line of the run log with the time it was logged.
+type runLogLine struct {
+	time time.Time
+	text string
+}
+
+// runLogBuffer keeps the lines logged during the run, up to maxRunLogSize.
+type runLogBuffer struct {
+	mu        sync.Mutex
+	lines     []runLogLine
+	size      int
+	truncated bool
+}
+
+func (b *runLogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size+len(p) > maxRunLogSize {
+		b.truncated = true
+		return len(p), nil
+	}
+
+	// Loggers write every line at once.
+	b.lines = append(b.lines, runLogLine{time: time.Now(), text: strings.TrimRight(string(p), "\n")})
+	b.size += len(p)
+
+	return len(p), nil
+}
+
+// reset drops the lines of an earlier run, for commands running several.
+func (b *runLogBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines, b.size, b.truncated = nil, 0, false
+}
+
+func (b *runLogBuffer) snapshot() ([]runLogLine, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]runLogLine{}, b.lines...), b.truncated
+}
+
+var (
+	runLog = &runLogBuffer{}
+	// logOutput is where the standard logger, and so slog, writes to.
+	logOutput io.Writer = io.MultiWriter(os.Stderr, runLog)
+)
+
+// forwardRunLog forwards the log of the finished run. Errors are only logged,
+// the run has finished already.
+func forwardRunLog(report *runReport) {
+	if logForwarding == nil {
+		return
+	}
+
+	lines, truncated := runLog.snapshot()
+	if truncated {
+		slog.Warn("run log exceeds the size limit, forwarding its beginning", "limitMB", maxRunLogSize/mebibyte)
+	}
+
+	if logForwarding.Loki != nil {
+		if err := pushToLoki(logForwarding.Loki, report, lines); err != nil {
+			slog.Warn("error forwarding run log to Loki", "error", err)
+		}
+	}
+
+	if logForwarding.Cluster != nil {
+		if err := forwardToCluster(logForwarding.Cluster, report, lines); err != nil {
+			slog.Warn("error forwarding run log to cluster", "error", err)
+		}
+	}
+}
+
+func runLogLabels(report *runReport) map[string]string {
+	return map[string]string{
+		"app":     managedByValue,
+		"command": report.Command,
+		"run_id":  report.ID,
+		"outcome": report.Outcome,
+	}
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// pushToLoki pushes the run log as one stream labeled with the run.
+func pushToLoki(cfg *lokiConfig, report *runReport, lines []runLogLine) error {
+	stream := lokiStream{Stream: runLogLabels(report)}
+	for key, value := range cfg.Labels {
+		stream.Stream[key] = value
+	}
+	for _, line := range lines {
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(line.time.UnixNano(), 10), line.text})
+	}
+
+	data, err := json.Marshal(lokiPush{Streams: []lokiStream{stream}})
+	if err != nil {
+		return fmt.Errorf("error encoding run log: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(cfg.URL, "/")+"/loki/api/v1/push", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("LOKI_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing run log: %s", resp.Status)
+	}
+
+	slog.Info("forwarded run log to Loki", "url", cfg.URL, "lines", len(lines))
+
+	return nil
+}
+
+type runLogJob struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		BackoffLimit            int `json:"backoffLimit"`
+		TTLSecondsAfterFinished int `json:"ttlSecondsAfterFinished"`
+		Template                struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Spec podSpec `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// runLogConfigMap is the ConfigMap of the run log, owned by its Job.
+type runLogConfigMap struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string           `json:"name"`
+		Namespace       string           `json:"namespace"`
+		OwnerReferences []ownerReference `json:"ownerReferences,omitempty"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+type ownerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// runLogText returns the end of the run log that fits into a ConfigMap.
+func runLogText(lines []runLogLine) string {
+	start, size := len(lines), 0
+	for start > 0 && size+len(lines[start-1].text)+1 <= maxRunLogConfigSize {
+		start--
+		size += len(lines[start].text) + 1
+	}
+
+	var text strings.Builder
+	if start > 0 {
+		text.WriteString("... earlier lines of the run log are not forwarded\n")
+	}
+	for _, line := range lines[start:] {
+		text.WriteString(line.text + "\n")
+	}
+
+	return text.String()
+}
+
+// runLogManifests returns the Job that prints the run log, and the ConfigMap
+// with the log it mounts.
+func runLogManifests(cfg *clusterLogConfig, report *runReport, lines []runLogLine) (runLogJob, runLogConfigMap) {
+	name := "odfdr-run-log-" + report.ID
+	labels := runLogLabels(report)
+
+	cm := runLogConfigMap{APIVersion: "v1", Kind: "ConfigMap", Data: map[string]string{"run.log": runLogText(lines)}}
+	cm.Metadata.Name = name
+	cm.Metadata.Namespace = cfg.Namespace
+
+	job := runLogJob{APIVersion: "batch/v1", Kind: "Job"}
+	job.Metadata.Name = name
+	job.Metadata.Namespace = cfg.Namespace
+	job.Metadata.Labels = labels
+	job.Spec.TTLSecondsAfterFinished = int(cfg.ttl.Seconds())
+	job.Spec.Template.Metadata.Labels = labels
+	job.Spec.Template.Spec = newPod(name, cfg.Image, "cat", "/run-log/run.log").Spec
+	job.Spec.Template.Spec.Containers[0].VolumeMounts = []volumeMount{{Name: "run-log", MountPath: "/run-log"}}
+	job.Spec.Template.Spec.Volumes = []volume{{Name: "run-log", ConfigMap: &configMapVolumeSource{Name: name}}}
+
+	return job, cm
+}
+
+// forwardToCluster creates a Job on the cluster that prints the run log, so
+// that the log collector of the cluster forwards it like the logs of any
+// other pod. The ConfigMap with the log is owned by the Job, both are deleted
+// once the TTL of the finished Job expires.
+func forwardToCluster(cfg *clusterLogConfig, report *runReport, lines []runLogLine) error {
+	job, cm := runLogManifests(cfg, report, lines)
+
+	manifests := list{APIVersion: "v1", Kind: "List", Items: []any{
+		namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: cfg.Namespace}},
+		job,
+	}}
+	if err := applyRunLogManifest(cfg.Kubeconfig, job.Metadata.Name+"-job.json", manifests); err != nil {
+		return err
+	}
+
+	var created struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if _, err := getJSON(cfg.Kubeconfig, &created, "job/"+job.Metadata.Name, "-n", cfg.Namespace); err != nil {
+		return err
+	}
+
+	cm.Metadata.OwnerReferences = []ownerReference{{APIVersion: "batch/v1", Kind: "Job", Name: job.Metadata.Name, UID: created.Metadata.UID}}
+	if err := applyRunLogManifest(cfg.Kubeconfig, cm.Metadata.Name+"-configmap.json", cm); err != nil {
+		return err
+	}
+
+	slog.Info("forwarded run log to cluster", "job", cfg.Namespace+"/"+job.Metadata.Name, "lines", len(lines))
+
+	return nil
+}
+
+func applyRunLogManifest(kconfig, fileName string, manifest any) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", fileName, err)
+	}
+
+	if err := writeArtifact("", fileName, data); err != nil {
+		return fmt.Errorf("error writing %s: %v", fileName, err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying %s: %v", fileName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	storageBackendODF  = "odf"
+	storageBackendLVMS = "lvms"
+
+	lvmsPackage            = "lvms-operator"
+	defaultLVMSDeviceClass = "vg1"
+)
+
+// lvmsConfig configures LVM Storage, which replaces ODF on single node and
+// edge clusters.
+type lvmsConfig struct {
+	// Channel of the lvms-operator in the redhat-operators catalog, the
+	// default channel of the package if empty.
+	Channel string `json:"channel,omitempty"`
+	// DeviceClass is the volume group, vg1 by default. LVMS names the
+	// storage class and the snapshot class lvms-<device class>.
+	DeviceClass string `json:"deviceClass,omitempty"`
+	// DevicePaths are the disks of the volume group, all unused disks if
+	// empty.
+	DevicePaths []string `json:"devicePaths,omitempty"`
+	// ThinPoolSizePercent is the share of the volume group used by the thin
+	// pool, 90 by default.
+	ThinPoolSizePercent int `json:"thinPoolSizePercent,omitempty"`
+}
+
+func (c *lvmsConfig) validate() error {
+	if c.DeviceClass == "" {
+		c.DeviceClass = defaultLVMSDeviceClass
+	}
+
+	if c.ThinPoolSizePercent == 0 {
+		c.ThinPoolSizePercent = 90
+	}
+	if c.ThinPoolSizePercent < 10 || c.ThinPoolSizePercent > 90 {
+		return fmt.Errorf("LVMS thin pool size %d%% is out of range, expected 10 to 90", c.ThinPoolSizePercent)
+	}
+
+	return nil
+}
+
+// storageClass returns the storage class LVMS creates for the device class.
+func (c *lvmsConfig) storageClass() string {
+	return "lvms-" + c.DeviceClass
+}
+
+// addLVMCluster installs the LVMS operator and creates the LVMCluster, unless
+// the namespace already has one, and waits for it to be Ready.
+func addLVMCluster(clusterName, kconfig string, cfg *storageConfig) error {
+	operator := operatorConfig{
+		Package:   lvmsPackage,
+		Namespace: cfg.Namespace,
+		Channel:   cfg.LVMS.Channel,
+		Source:    redHatOperatorsCatalog,
+	}
+	if err := installOperators(clusterName, kconfig, "", []operatorConfig{operator}, nil, nil, nil); err != nil {
+		return fmt.Errorf("error installing LVMS: %v", err)
+	}
+
+	var existing objectList
+	if _, err := getJSON(kconfig, &existing, "lvmclusters.lvm.topolvm.io", "-n", cfg.Namespace); err != nil {
+		return err
+	}
+
+	name := "lvmcluster"
+	if len(existing.Items) > 0 {
+		name = existing.Items[0].Metadata.Name
+		slog.Info("LVMCluster already exists, not creating it", "lvmCluster", name)
+	} else {
+		rendered, err := renderStorageCluster(cfg)
+		if err != nil {
+			return err
+		}
+		data := []byte(rendered.docs[0])
+
+		fileName := clusterName + "-lvmcluster.json"
+		err = writeArtifact(clusterName, fileName, data)
+		if err != nil {
+			return fmt.Errorf("error writing LVMCluster to file: %v", err)
+		}
+
+		_, err = applyManifest(kconfig, fileName)
+		if err != nil {
+			return fmt.Errorf("error applying LVMCluster: %v", err)
+		}
+
+		slog.Info("created LVMCluster", "deviceClass", cfg.LVMS.DeviceClass)
+	}
+
+	watch := []string{"lvmclusters.lvm.topolvm.io/" + name, "-n", cfg.Namespace}
+	return watchFor(kconfig, "LVMCluster "+name+" to be Ready", 15*time.Minute, time.Minute, watch, func() (bool, error) {
+		var cluster struct {
+			Status struct {
+				State string `json:"state"`
+			} `json:"status"`
+		}
+		found, err := getJSON(kconfig, &cluster, "lvmclusters.lvm.topolvm.io/"+name, "-n", cfg.Namespace)
+		if err != nil || !found {
+			return false, err
+		}
+
+		return cluster.Status.State == "Ready", nil
+	})
+}
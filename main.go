@@ -1,21 +1,28 @@
 package main
 
 import (
-	_ "embed"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
-//go:embed icsp.yaml
-var icspYAML string
+// stringList is a flag.Value that collects repeated occurrences of a flag.
+type stringList []string
 
-//go:embed odf-catalogsource.yaml
-var odfCatalogSourceYAML string
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
 
 // checkCommandExists verifies that a required command is available in the system path
 func checkCommandExists(command string) error {
@@ -28,7 +35,11 @@ func checkCommandExists(command string) error {
 
 // checkRequiredCommands verifies that all required commands are available
 func checkRequiredCommands() error {
-	requiredCommands := []string{"jq", "oc"}
+	if fixtureMode == fixtureModeReplay {
+		return nil
+	}
+
+	requiredCommands := []string{"oc"}
 
 	for _, cmd := range requiredCommands {
 		if err := checkCommandExists(cmd); err != nil {
@@ -39,17 +50,19 @@ func checkRequiredCommands() error {
 	return nil
 }
 
+// getClusterName returns the name of the cluster of an API URL, the label
+// after api, made unique among the clusters of the run by uniqueClusterName.
 func getClusterName(url string) (string, error) {
 	parts := strings.Split(url, ".")
 	if len(parts) < 2 {
 		return "", fmt.Errorf("could not parse cluster name from URL")
 	}
 
-	return parts[1], nil
+	return uniqueClusterName(parts[1], url), nil
 }
 
 func getKubeconfig(cluster string) (*os.File, error) {
-	kconfig, err := os.CreateTemp("", cluster+"-kubeconfig"+"-*")
+	kconfig, err := createTempFile(cluster + "-kubeconfig" + "-*")
 	if err != nil {
 		return nil, err
 	}
@@ -58,25 +71,79 @@ func getKubeconfig(cluster string) (*os.File, error) {
 	return kconfig, nil
 }
 
+// ocCommand returns an oc command that runs against the cluster of kconfig.
+func ocCommand(kconfig string, args ...string) *exec.Cmd {
+	if printCommands {
+		printOCCommand(kconfig, args)
+	}
+
+	ocArgs := connectionFor(kconfig).ocArgs(args)
+	cmd := exec.Command("oc", ocArgs...)
+	if fixtureMode != "" {
+		cmd = fixtureCommand(fixtureCluster(kconfig), args, ocArgs)
+	} else if path := captureFile(kconfig); path != "" {
+		cmd = fixtureShim(fixtureModeRecord, path, fixtureCluster(kconfig), args, ocArgs)
+	}
+	cmd.Env = append(cmd.Environ(), "KUBECONFIG="+kconfig)
+	return traceCommand(kconfig, cmd, args)
+}
+
 func login(url, username, password, kconfig string) error {
-	loginCmd := exec.Command("oc", "login", url, "-u", username, "-p", password)
+	slog.Info("logging in using kubeconfig", "kubeconfig", kconfig, "cluster", url)
+
+	// Recorded and replayed runs log in with oc, so that the login is part
+	// of the fixture.
+	loginArgs := append([]string{"login", url, "-u", username, "-p", password}, connectionFor(kconfig).loginArgs()...)
+	if fixtureMode == "" {
+		if printCommands {
+			printOCCommand(kconfig, loginArgs)
+		}
+
+		if err := oauthLogin(url, username, password, kconfig); err != nil {
+			return fmt.Errorf("error logging into OpenShift: %v", err)
+		}
+		rememberSession(kconfig, url, username, password)
+
+		return nil
+	}
+
+	loginCmd := ocCommand(kconfig, loginArgs...)
 	loginCmd.Stdout = os.Stdout
 	loginCmd.Stderr = os.Stderr
-	loginCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-
-	slog.Info("logging in using kubeconfig", "kubeconfig", kconfig, "cluster", url)
 
 	err := loginCmd.Run()
 	if err != nil {
 		return fmt.Errorf("error logging into OpenShift: %v", err)
 	}
+	rememberSession(kconfig, url, username, password)
 
 	return nil
 }
 
 func showUsage() {
-	fmt.Println("Usage: ./odfdr-installer -url <URL> -username <username> -password <password> -rhceph-password <password>")
-	fmt.Println("Example: ./odfdr-installer -url ./odfdr-installer -url api.cluster.example.com:6443 -password abc -rhceph-password=xyz")
+	fmt.Println("Usage: ./odfdr-installer [prepare] -api-url <URL> -username <username> -password <password> (-rhceph-password <password> | -skip-registry-auth)")
+	fmt.Println("       ./odfdr-installer reconcile -kubeconfig <kubeconfig> [-rhceph-password <password>]")
+	fmt.Println("       ./odfdr-installer fleet -file <fleet file> (-rhceph-password <password> | -skip-registry-auth) [-min-success <count|percentage>]")
+	fmt.Println("       ./odfdr-installer operator -kubeconfig <hub kubeconfig> [-namespace <namespace>] [-install-crd] [-once]")
+	fmt.Println("       ./odfdr-installer cleanup -kubeconfig <kubeconfig> [-cascade] [-namespace-cleanup-policy retain|delete [-wipe-disks]]")
+	fmt.Println("       ./odfdr-installer configure-dr -kubeconfig <hub kubeconfig> -cluster <cluster1> -cluster <cluster2>")
+	fmt.Println("       ./odfdr-installer switch-hub -kubeconfig <standby hub kubeconfig> -cluster <cluster>=<kubeconfig>... [-previous-hub-kubeconfig <kubeconfig>]")
+	fmt.Println("       ./odfdr-installer diagnose-peering -kubeconfig <hub kubeconfig> -cluster <cluster1> -cluster <cluster2>")
+	fmt.Println("       ./odfdr-installer doctor -kubeconfig <kubeconfig> [-kubeconfig <kubeconfig>...] [-check <check>...]")
+	fmt.Println("       ./odfdr-installer ceph-cmd -kubeconfig <kubeconfig> [-enable-toolbox] <ceph command>")
+	fmt.Println("       ./odfdr-installer verify -kubeconfig <kubeconfig> [-kubeconfig <kubeconfig>...]")
+	fmt.Println("       ./odfdr-installer gather -kubeconfig <kubeconfig> [-dir <directory>]")
+	fmt.Println("       ./odfdr-installer clean-artifacts [-cluster <cluster>] [-dry-run]")
+	fmt.Println("       ./odfdr-installer compare <kubeconfig A> <kubeconfig B>")
+	fmt.Println("       ./odfdr-installer measure-network [-interval <interval>] [-enforce] <kubeconfig A> <kubeconfig B>")
+	fmt.Println("       ./odfdr-installer history [-cluster <cluster>] [-limit <count>]")
+	fmt.Println("       ./odfdr-installer show-run [-json] <run ID>")
+	fmt.Println("       ./odfdr-installer print-rbac [-profile <profile>...] [-service-account <namespace/name>] [-describe]")
+	fmt.Println("       ./odfdr-installer generate-pipeline -image <image> [-type tekton|argo] [-output <file>]")
+	fmt.Println("       ./odfdr-installer migrate-config [-in-place] <config file>")
+	fmt.Println("       ./odfdr-installer render [-manifest icsp|catalogsource|storagecluster...] [-output-dir <directory>]")
+	fmt.Println("       ./odfdr-installer list-builds [-repository <repository>] [-filter <text>] [-limit <count>]")
+	fmt.Println("Example: ./odfdr-installer -api-url api.cluster.example.com:6443 -password abc -rhceph-password=xyz")
 }
 
 func showUsageAndExit() {
@@ -84,151 +151,568 @@ func showUsageAndExit() {
 	os.Exit(1)
 }
 
-func addCatalogSource(clusterName, kconfig, catalogSourceYAML string) error {
+func writeCatalogSource(clusterName, catalogSourceYAML string) (string, error) {
 	catalogSourceFileName := clusterName + "-catalogsource.yaml"
-	err := os.WriteFile(catalogSourceFileName, []byte(catalogSourceYAML), 0o644)
+	err := writeArtifact(clusterName, catalogSourceFileName, []byte(catalogSourceYAML))
+	if err != nil {
+		return "", fmt.Errorf("error writing CatalogSource to file: %v", err)
+	}
+
+	return catalogSourceFileName, nil
+}
+
+// addCatalogSource applies the CatalogSource and waits for its registry to be
+// served.
+func addCatalogSource(clusterName, kconfig, catalogSourceYAML string) error {
+	catalogSourceFileName, err := writeCatalogSource(clusterName, catalogSourceYAML)
 	if err != nil {
-		return fmt.Errorf("error writing CatalogSource to file: %v", err)
+		return err
 	}
 
-	applyCmd := exec.Command("oc", "apply", "-f", catalogSourceFileName)
-	applyCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	err = applyCmd.Run()
+	resources, err := applyManifest(kconfig, catalogSourceFileName)
 	if err != nil {
 		return fmt.Errorf("error applying CatalogSource: %v", err)
 	}
 
-	return nil
+	// The label tells the CatalogSources of the installer apart when they
+	// are pruned.
+	if len(resources) > 0 {
+		labelArgs := append([]string{"label", "--overwrite", "-n", "openshift-marketplace"}, resources...)
+		labelCmd := ocCommand(kconfig, append(labelArgs, managedByLabel+"="+managedByValue)...)
+		if err := labelCmd.Run(); err != nil {
+			return fmt.Errorf("error labeling CatalogSource: %v", err)
+		}
+	}
+
+	return waitForCatalogSourceReady(kconfig, catalogSourceFileName, 10*time.Minute)
 }
 
-func addICSP(clusterName, kconfig string) error {
-	icspFileName := clusterName + "-icsp.yaml"
-	err := os.WriteFile(icspFileName, []byte(icspYAML), 0o644)
-	if err != nil {
-		return fmt.Errorf("error writing ICSP to file: %v", err)
+func main() {
+	log.SetOutput(logOutput)
+
+	if len(os.Args) > 1 && os.Args[1] == fixtureShimCommand {
+		runFixtureShim(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == traceShimCommand {
+		runTraceShim(os.Args[2:])
+	}
+
+	setupLogs()
+
+	command := "prepare"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	if current, found := deprecatedCommands[command]; found {
+		slog.Warn("command is deprecated", "command", command, "use", current)
+		command = current
+	}
+
+	switch command {
+	case "prepare":
+		runPrepare(args)
+	case "reconcile":
+		runReconcile(args)
+	case "fleet":
+		runFleet(args)
+	case "operator":
+		runOperator(args)
+	case "cleanup":
+		runCleanup(args)
+	case "configure-dr":
+		runConfigureDR(args)
+	case "switch-hub":
+		runSwitchHub(args)
+	case "diagnose-peering":
+		runDiagnosePeering(args)
+	case "doctor":
+		runDoctor(args)
+	case "ceph-cmd":
+		runCephCmd(args)
+	case "verify":
+		runVerify(args)
+	case "compare":
+		runCompare(args)
+	case "measure-network":
+		runMeasureNetwork(args)
+	case "history":
+		runHistory(args)
+	case "show-run":
+		runShowRun(args)
+	case "clean-artifacts":
+		runCleanArtifacts(args)
+	case "gather":
+		runGather(args)
+	case "list-builds":
+		runListBuilds(args)
+	case "print-rbac":
+		runPrintRBAC(args)
+	case "generate-pipeline":
+		runGeneratePipeline(args)
+	case "migrate-config":
+		runMigrateConfig(args)
+	case "render":
+		runRender(args)
+	default:
+		slog.Error("error: unknown command", "command", command)
+		showUsageAndExit()
 	}
+}
 
-	applyCmd := exec.Command("oc", "apply", "-f", icspFileName)
-	applyCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	err = applyCmd.Run()
-	if err != nil {
-		return fmt.Errorf("error applying ICSP: %v", err)
+// prepareOptions are the settings used to prepare a cluster.
+type prepareOptions struct {
+	rhcephPassword    string
+	catalogSourceYAML string
+	mirrorSets        []mirrorSet
+	operators         []operatorConfig
+	scheduling        *scheduling
+	// storage is the StorageCluster and the block pools and filesystems
+	// added once it is Ready.
+	storage *storageConfig
+	// pullSecretMode is where the RHCEPH registry auth is added, see
+	// resolvePullSecretMode.
+	pullSecretMode string
+	// skipRegistryAuth skips the pull-secret step, for public catalogs
+	// whose images need no RHCEPH registry auth.
+	skipRegistryAuth bool
+	// pullSecretConflict is the conflict policy for a different RHCEPH
+	// registry auth in the pull secret, see mergeDockerConfigs.
+	pullSecretConflict string
+	// registryAuthMatch tells whether a broader auth in the pull secret
+	// satisfies the RHCEPH registry auth, see findRegistryAuth.
+	registryAuthMatch string
+	// backupBeforeChanges backs up etcd, or runs backupCommand, before the
+	// pull secret and the mirror sets are changed.
+	backupBeforeChanges bool
+	backupCommand       string
+	// hostedCluster is the HostedCluster of a hosted control plane cluster,
+	// which gets the pull secret and mirror sets instead of the cluster.
+	hostedCluster *hostedClusterRef
+	// force lists the steps that recreate their resources even when they
+	// already exist.
+	force []string
+	// only selects the steps to run, with the steps they require, see
+	// selectSteps. onlyStrict fails on required steps not selected instead.
+	only       []string
+	onlyStrict bool
+	// waits are the wait conditions evaluated after the steps.
+	waits []waitCondition
+	// identity is the cluster-admin user the steps after the identity step
+	// are run as.
+	identity *identityConfig
+	// previousRun is what to do about an earlier run that did not finish,
+	// see handlePreviousRun.
+	previousRun string
+	// snapshot adds the changes of the run on the cluster to the report.
+	snapshot bool
+	// prune deletes the mirror sets and CatalogSources of earlier runs that
+	// are no longer configured.
+	prune bool
+	// terminatingNamespaces is what to do about namespaces of the run stuck
+	// Terminating, see checkTerminatingNamespaces.
+	terminatingNamespaces string
+}
+
+// prepareSteps returns the steps that add the RHCEPH registry auth, the mirror
+// sets and the CatalogSource to a logged in cluster and install the configured
+// operators from it. The remediations of the steps are recorded in report.
+func prepareSteps(clusterName, kconfig string, opts prepareOptions, report *clusterReport) []step {
+	// The pull secret mode is resolved by the pull-secret step, the catalog
+	// step needs it to reference the namespace pull secret.
+	pullSecretMode := opts.pullSecretMode
+	addPullSecret := func(force bool) error {
+		if opts.skipRegistryAuth {
+			slog.Info("not adding the RHCEPH registry auth, the catalog is public", "cluster", clusterName)
+			return nil
+		}
+
+		if opts.hostedCluster != nil {
+			return addHostedRHCEPHAuth(clusterName, opts.hostedCluster, opts.rhcephPassword, opts.pullSecretConflict, opts.registryAuthMatch, force)
+		}
+
+		mode, err := resolvePullSecretMode(clusterName, kconfig, pullSecretMode)
+		if err != nil {
+			return err
+		}
+		pullSecretMode = mode
+
+		if mode == pullSecretModeNamespace {
+			return addNamespacePullSecrets(clusterName, kconfig, opts.rhcephPassword, pullSecretNamespaces(opts.operators), report)
+		}
+
+		return addRHCEPHAuth(clusterName, kconfig, globalPullSecret, opts.rhcephPassword, opts.pullSecretConflict, opts.registryAuthMatch, force)
 	}
+	// The CSVs create the service accounts of the operators, which are
+	// linked to the namespace pull secrets while the operators install.
+	linkPullSecrets := func() error {
+		if pullSecretMode != pullSecretModeNamespace || opts.skipRegistryAuth || opts.hostedCluster != nil {
+			return nil
+		}
 
-	return nil
+		return linkNamespacePullSecrets(clusterName, kconfig, pullSecretNamespaces(opts.operators), report)
+	}
+	catalogSourceYAML := func() string {
+		if pullSecretMode == pullSecretModeNamespace && !opts.skipRegistryAuth {
+			return setCatalogSpecField(opts.catalogSourceYAML, "secrets", []string{namespacePullSecretName})
+		}
+
+		return opts.catalogSourceYAML
+	}
+
+	steps := []step{
+		{
+			name: "identity",
+			run: func() error {
+				if opts.identity != nil && opts.hostedCluster != nil {
+					return fmt.Errorf("the identity providers of hosted control plane clusters are configured in the HostedCluster")
+				}
+
+				return addIdentity(clusterName, kconfig, opts.identity)
+			},
+			describe: func() string {
+				return describeIdentity(opts.identity)
+			},
+		},
+		{
+			name: "pull-secret",
+			run: func() error {
+				return addPullSecret(false)
+			},
+			force: func() error {
+				return addPullSecret(true)
+			},
+			describe: func() string {
+				if opts.skipRegistryAuth {
+					return "Nothing to do, the catalog is public and needs no RHCEPH registry auth."
+				}
+
+				if opts.hostedCluster != nil {
+					return fmt.Sprintf("Add the %s auth to the pull secret of HostedCluster %s/%s.",
+						rhcephRegistry, opts.hostedCluster.namespace, opts.hostedCluster.name)
+				}
+
+				return fmt.Sprintf("Add the %s auth to the pull secret (mode %s).", rhcephRegistry, pullSecretMode)
+			},
+		},
+		{
+			name: "mirror-sets",
+			run: func() error {
+				return addMirrorSetsFor(clusterName, kconfig, opts, addMirrorSets)
+			},
+			force: func() error {
+				return addMirrorSetsFor(clusterName, kconfig, opts, recreateMirrorSets)
+			},
+			describe: func() string {
+				var description strings.Builder
+				for _, set := range opts.mirrorSets {
+					fmt.Fprintf(&description, "--- mirror set %s\n%s\n", set.Name, strings.TrimRight(set.YAML, "\n"))
+				}
+
+				return description.String()
+			},
+		},
+		{
+			name: "catalog",
+			// The catalog pulls with the RHCEPH registry auth, in the pull
+			// secret mode resolved by the pull-secret step.
+			after: []string{"pull-secret"},
+			run: func() error {
+				return addCatalogSource(clusterName, kconfig, catalogSourceYAML())
+			},
+			force: func() error {
+				return recreateCatalogSource(clusterName, kconfig, catalogSourceYAML())
+			},
+			describe: catalogSourceYAML,
+		},
+		{
+			name:  "operators",
+			after: []string{"mirror-sets", "catalog"},
+			run: func() error {
+				return installOperators(clusterName, kconfig, opts.catalogSourceYAML, opts.operators, opts.scheduling, linkPullSecrets, report)
+			},
+			describe: func() string {
+				if len(opts.operators) == 0 {
+					return "No operators are configured."
+				}
+
+				var description strings.Builder
+				for _, operator := range opts.operators {
+					ns := operator.Namespace
+					if ns == "" {
+						ns = globalOperatorsNamespace
+					}
+					fmt.Fprintf(&description, "Subscribe to %s in namespace %s", operator.Package, ns)
+					if operator.Channel != "" {
+						fmt.Fprintf(&description, " on channel %s", operator.Channel)
+					}
+					description.WriteString(".\n")
+				}
+
+				return description.String()
+			},
+		},
+		{
+			name:  "storage-cluster",
+			after: []string{"operators"},
+			run: func() error {
+				return addStorageCluster(clusterName, kconfig, opts.storage)
+			},
+			describe: func() string {
+				return describeStorageCluster(opts.storage)
+			},
+		},
+		{
+			name:  "storage-pools",
+			after: []string{"storage-cluster"},
+			run: func() error {
+				return addStoragePools(clusterName, kconfig, opts.storage)
+			},
+			describe: func() string {
+				return describeStoragePools(opts.storage)
+			},
+		},
+		{
+			name:  "ceph-config",
+			after: []string{"storage-cluster"},
+			run: func() error {
+				return addCephConfigOverrides(clusterName, kconfig, opts.storage)
+			},
+			describe: func() string {
+				return describeCephConfig(opts.storage)
+			},
+		},
+		{
+			name: "prune",
+			// Stale resources are only deleted once the configured ones
+			// replace them.
+			after: []string{"mirror-sets", "catalog"},
+			run: func() error {
+				_, err := pruneStaleResources(clusterName, kconfig, opts.mirrorSets, catalogSourceYAML(), opts.prune)
+				return err
+			},
+			describe: func() string {
+				if !opts.prune {
+					return "List the mirror sets and CatalogSources of earlier runs that are no longer configured."
+				}
+
+				return "Delete the mirror sets and CatalogSources of earlier runs that are no longer configured."
+			},
+		},
+	}
+
+	steps = withEtcdBackup(clusterName, kconfig, steps, opts, report)
+	steps = withRegistryLimits(clusterName, kconfig, imageRegistryHost(catalogSourceImage(opts.catalogSourceYAML)), steps)
+
+	return withWaitConditions(kconfig, steps, opts.waits)
 }
 
-func addRHCEPHAuth(clusterName, kconfig, rhcephPassword string) error {
-	getPullSecretCmd := exec.Command("oc", "get", "secret/pull-secret", "-n", "openshift-config", "--template={{index .data \".dockerconfigjson\" | base64decode}}")
-	getPullSecretCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	pullSecretOutput, err := getPullSecretCmd.Output()
-	if err != nil {
-		return fmt.Errorf("error getting pull secret: %v", err)
+// addMirrorSetsFor adds the mirror sets with add, or to the HostedCluster of
+// a hosted control plane cluster, where ImageContentSourcePolicies have no
+// effect.
+func addMirrorSetsFor(clusterName, kconfig string, opts prepareOptions, add func(string, string, []mirrorSet) error) error {
+	if opts.hostedCluster != nil {
+		return addHostedMirrorSets(clusterName, opts.hostedCluster, opts.mirrorSets)
 	}
 
-	pullSecretFileName := clusterName + "-pull-secret.json"
-	err = os.WriteFile(pullSecretFileName, pullSecretOutput, 0o644)
+	hosted, err := isHostedControlPlane(kconfig)
 	if err != nil {
-		return fmt.Errorf("error writing pull secret to file: %v", err)
+		return fmt.Errorf("error detecting control plane topology: %v", err)
 	}
 
-	var pullSecret map[string]any
-	err = json.Unmarshal(pullSecretOutput, &pullSecret)
-	if err != nil {
-		return fmt.Errorf("error parsing pull secret JSON: %v", err)
+	if hosted {
+		return fmt.Errorf("mirror sets are not rolled out on hosted control plane clusters, use -management-kubeconfig and -hosted-cluster to add them to the HostedCluster")
 	}
 
-	auths, ok := pullSecret["auths"].(map[string]any)
-	if !ok {
-		return fmt.Errorf("invalid pull secret format")
-	}
-	elementsCount := len(auths)
+	return add(clusterName, kconfig, opts.mirrorSets)
+}
+
+// prepareCluster runs the prepare steps against a logged in cluster.
+func prepareCluster(clusterName, kconfig string, opts prepareOptions, report *clusterReport) (err error) {
+	span := startSpan(kconfig, "prepare "+clusterName, map[string]string{"cluster": clusterName})
+	defer func() { span.end(err) }()
 
-	if pullSecret["auths"] == nil {
-		return fmt.Errorf("pull secret does not contain auths")
+	activeWaits.nameCluster(kconfig, clusterName)
+
+	kinds, err := preparedKinds(opts)
+	if err != nil {
+		return err
+	}
+	unverified, err := checkServedKinds(clusterName, kconfig, kinds)
+	if err != nil {
+		return err
+	}
+	if report != nil {
+		report.UnverifiedKinds = unverified
+	}
+	if err := checkTerminatingNamespaces(clusterName, kconfig, preparedNamespaces(opts), opts.terminatingNamespaces); err != nil {
+		return err
 	}
 
-	if pullSecret["auths"].(map[string]any)["quay.io/rhceph-dev"] != nil {
-		slog.Info("RHCEPH auth already exists in pull secret")
-		return nil
+	if opts.snapshot {
+		before, err := takeSnapshot(kconfig)
+		if err != nil {
+			return fmt.Errorf("error capturing the cluster before the run: %v", err)
+		}
+		defer recordChanges(clusterName, kconfig, before, report)
 	}
 
-	appendFileName := clusterName + "-append-pull-secret.json"
-	registryLoginCmd := exec.Command("oc", "registry", "login", "--registry=quay.io/rhceph-dev",
-		"--auth-basic="+rhcephPassword, "--to="+appendFileName)
-	registryLoginCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	err = registryLoginCmd.Run()
-	if err != nil {
-		return fmt.Errorf("error logging into registry: %v", err)
+	if err := handlePreviousRun(clusterName, kconfig, opts, report); err != nil {
+		return err
 	}
 
-	newPullSecretFileName := clusterName + "-new-pull-secret.json"
-	mergeCmd := exec.Command("jq", "-s", ".[0] * .[1]", pullSecretFileName, appendFileName)
-	mergedOutput, err := mergeCmd.Output()
+	progress := newInstallProgress(clusterName, kconfig)
+	progress.write()
+	steps, _, err := selectSteps(prepareSteps(clusterName, kconfig, opts, report), opts.only, opts.onlyStrict)
 	if err != nil {
-		return fmt.Errorf("error merging pull secrets: %v", err)
+		progress.finish(err)
+		return err
 	}
+	steps = trackProgress(steps, progress)
+	if err := runSteps(clusterName, kconfig, steps, opts.force, report); err != nil {
+		progress.finish(err)
+		return err
+	}
+	progress.finish(nil)
 
-	err = os.WriteFile(newPullSecretFileName, mergedOutput, 0o644)
+	versions, err := getOperatorVersions(kconfig)
 	if err != nil {
-		return fmt.Errorf("error writing merged pull secret to file: %v", err)
+		slog.Warn("error getting operator versions", "cluster", clusterName, "error", err)
 	}
+	report.OperatorVersions = versions
 
-	updateCmd := exec.Command("oc", "set", "data", "secret/pull-secret", "-n", "openshift-config",
-		"--from-file=.dockerconfigjson="+newPullSecretFileName)
-	updateCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	err = updateCmd.Run()
-	if err != nil {
-		return fmt.Errorf("error updating pull secret: %v", err)
+	return nil
+}
+
+func runPrepare(args []string) {
+	report := newRunReport("prepare")
+
+	flags := flag.NewFlagSet("prepare", flag.ExitOnError)
+	urlFlag := flags.String("api-url", "", "OpenShift API URL")
+	addDeprecatedFlag(flags, "url", "api-url")
+	usernameFlag := flags.String("username", "kubeadmin", "OpenShift username")
+	passwordFlag := flags.String("password", "", "OpenShift password")
+	rhcephPasswordFlag := flags.String("rhceph-password", "", "RHCEPH repository password")
+	installDirFlag := flags.String("install-dir", "", "openshift-install directory to read the API URL and kubeadmin password from")
+	manifests := addManifestFlags(flags)
+	configFlag := addConfigFlag(flags)
+	var force stringList
+	flags.Var(&force, "force", "Recreate the resources of a step even if they exist: "+strings.Join(prepareStepNames(), ", ")+" (can be repeated)")
+	selection := addStepSelectionFlags(flags)
+	pullSecretModeFlag := addPullSecretModeFlag(flags)
+	pullSecretConflictFlag := addPullSecretConflictFlag(flags)
+	registryAuthMatchFlag := addRegistryAuthMatchFlag(flags)
+	skipRegistryAuthFlag := addSkipRegistryAuthFlag(flags)
+	maxCatalogAgeFlag := addMaxCatalogAgeFlag(flags)
+	backupFlag, backupCommandFlag := addBackupFlags(flags)
+	previousRunFlag := addPreviousRunFlag(flags)
+	snapshotFlag := addSnapshotFlag(flags)
+	pruneFlag := addPruneFlag(flags)
+	terminatingNamespacesFlag := addTerminatingNamespacesFlag(flags)
+	channelOverrides := addChannelOverrideFlag(flags)
+	addStepFlag(flags)
+	addDebugCaptureFlag(flags)
+	addDeadlineFlag(flags)
+	addMessageFlags(flags)
+	hostedClusterFlags := addHostedClusterFlags(flags)
+	clusterPoolFlags := addClusterPoolFlags(flags)
+	gatherOnFailureFlag := flags.Bool("gather-on-failure", true, "Gather diagnostics into <cluster>-diagnostics when preparing the cluster fails")
+	gatherOpts := addGatherFlags(flags, "gather-")
+	bastionFlag := addBastionFlag(flags)
+	addTLSFlags(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addTracingFlag(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if err := startTracing(); err != nil {
+		slog.Error("error starting trace", "error", err)
+		os.Exit(1)
 	}
 
-	getPullSecretCmd = exec.Command("oc", "get", "secret/pull-secret", "-n", "openshift-config", "--template={{index .data \".dockerconfigjson\" | base64decode}}")
-	getPullSecretCmd.Env = append(os.Environ(), "KUBECONFIG="+kconfig)
-	pullSecretOutput, err = getPullSecretCmd.Output()
-	if err != nil {
-		return fmt.Errorf("error getting pull secret: %v", err)
+	if err := validateStepNames(prepareStepNames(), force); err != nil {
+		slog.Error("error: invalid -force", "error", err)
+		showUsageAndExit()
 	}
 
-	var newPullSecret map[string]any
-	err = json.Unmarshal(mergedOutput, &newPullSecret)
-	if err != nil {
-		return fmt.Errorf("error parsing new pull secret JSON: %v", err)
+	if err := validatePullSecretMode(*pullSecretModeFlag); err != nil {
+		slog.Error("error: invalid -pull-secret-mode", "error", err)
+		showUsageAndExit()
 	}
 
-	newAuths, ok := newPullSecret["auths"].(map[string]any)
-	if !ok {
-		return fmt.Errorf("invalid new pull secret format")
+	if err := validatePullSecretConflict(*pullSecretConflictFlag); err != nil {
+		slog.Error("error: invalid -pull-secret-conflict", "error", err)
+		showUsageAndExit()
 	}
 
-	newElementsCount := len(newAuths)
+	if err := validateRegistryAuthMatch(*registryAuthMatchFlag); err != nil {
+		slog.Error("error: invalid -registry-auth-match", "error", err)
+		showUsageAndExit()
+	}
 
-	if newElementsCount != elementsCount+1 {
-		return fmt.Errorf("pull secret does not contain the expected number of elements")
+	if err := validatePreviousRunAction(*previousRunFlag); err != nil {
+		slog.Error("error: invalid -previous-run", "error", err)
+		showUsageAndExit()
 	}
 
-	return nil
-}
+	if err := validateTerminatingNamespaceAction(*terminatingNamespacesFlag); err != nil {
+		slog.Error("error: invalid -terminating-namespaces", "error", err)
+		showUsageAndExit()
+	}
 
-func main() {
-	urlFlag := flag.String("url", "", "OpenShift API URL")
-	usernameFlag := flag.String("username", "kubeadmin", "OpenShift username")
-	passwordFlag := flag.String("password", "", "OpenShift password")
-	rhcephPasswordFlag := flag.String("rhceph-password", "", "RHCEPH repository password")
+	if selection.printPlan {
+		selection.writePlan(*configFlag, *backupFlag || *backupCommandFlag != "")
+		return
+	}
 
-	flag.Parse()
+	hostedCluster, err := hostedClusterFlags()
+	if err != nil {
+		slog.Error("error: invalid hosted cluster", "error", err)
+		showUsageAndExit()
+	}
 
-	if *urlFlag == "" {
-		slog.Error("error: URL is required")
+	pool, err := clusterPoolFlags()
+	if err != nil {
+		slog.Error("error: invalid cluster pool", "error", err)
 		showUsageAndExit()
 	}
 
-	if *passwordFlag == "" {
-		slog.Error("error: password is required")
+	if *installDirFlag != "" {
+		url, password, err := readInstallDir(*installDirFlag)
+		if err != nil {
+			slog.Error("error reading install directory", "error", err)
+			os.Exit(1)
+		}
+
+		if *urlFlag == "" {
+			*urlFlag = url
+		}
+		if *passwordFlag == "" {
+			*passwordFlag = password
+		}
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// The URL and the password of a claimed cluster are known once it is
+	// claimed.
+	if pool == nil && *urlFlag == "" {
+		slog.Error("error: URL is required")
 		showUsageAndExit()
 	}
 
-	if *rhcephPasswordFlag == "" {
-		slog.Error("error: RHCEPH password is required")
+	if pool == nil && *passwordFlag == "" {
+		slog.Error("error: password is required")
 		showUsageAndExit()
 	}
 
@@ -237,40 +721,144 @@ func main() {
 	password := *passwordFlag
 	rhcephPassword := *rhcephPasswordFlag
 
-	if err := checkRequiredCommands(); err != nil {
-		slog.Error("error checking required commands", "error", err)
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
 		os.Exit(1)
 	}
 
-	clusterName, err := getClusterName(url)
+	cfg.Operators, err = applyChannelOverrides(cfg.Operators, *channelOverrides)
 	if err != nil {
-		slog.Error("error getting cluster name", "error", err)
+		slog.Error("error: invalid -channel-override", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := selection.check(cfg, *backupFlag || *backupCommandFlag != ""); err != nil {
+		slog.Error("error: invalid -only", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := manifests.applyRelease(cfg); err != nil {
+		slog.Error("error selecting release", "error", err)
 		os.Exit(1)
 	}
 
-	kconfig, err := getKubeconfig(clusterName)
+	// A public release stream implies -skip-registry-auth.
+	skipRegistryAuth := *skipRegistryAuthFlag || manifests.publicCatalog
+	if rhcephPassword == "" && !skipRegistryAuth {
+		slog.Error("error: RHCEPH password is required unless -skip-registry-auth is set")
+		showUsageAndExit()
+	}
+
+	catalogSourceYAML := manifests.catalogSourceYAML(cfg)
+
+	mirrorSets, err := manifests.loadMirrorSets()
 	if err != nil {
-		slog.Error("error creating kubeconfig file", "error", err)
+		slog.Error("error loading mirror sets", "error", err)
 		os.Exit(1)
 	}
 
-	if err := login(url, username, password, kconfig.Name()); err != nil {
-		slog.Error("error logging into OpenShift", "error", err)
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
 		os.Exit(1)
 	}
 
-	if err := addRHCEPHAuth(clusterName, kconfig.Name(), rhcephPassword); err != nil {
-		slog.Error("error adding RHCEPH auth to pull secret", "error", err)
+	checkCatalogFreshness(catalogSourceYAML, *maxCatalogAgeFlag)
+
+	// failed releases the claimed cluster before the run exits, when asked
+	// to.
+	failed := func() {}
+	// claimedKubeconfig is the admin kubeconfig of a claimed cluster, used
+	// instead of logging in.
+	var claimedKubeconfig []byte
+	if pool != nil {
+		claimed, err := claimCluster(pool)
+		if err != nil {
+			slog.Error("error claiming cluster from pool", "error", err)
+			os.Exit(1)
+		}
+		url, claimedKubeconfig = claimed.url, claimed.kubeconfig
+		if pool.releaseOnFailure {
+			failed = func() { releaseClaim(pool) }
+		}
+	}
+
+	url, err = resolveAPIURL(url)
+	if err != nil {
+		failed()
+		slog.Error("error: invalid -api-url", "error", err)
 		os.Exit(1)
 	}
 
-	if err := addICSP(clusterName, kconfig.Name()); err != nil {
-		slog.Error("error adding ICSP", "error", err)
+	clusterName, err := getClusterName(url)
+	if err != nil {
+		failed()
+		slog.Error("error getting cluster name", "error", err)
 		os.Exit(1)
 	}
 
-	if err := addCatalogSource(clusterName, kconfig.Name(), odfCatalogSourceYAML); err != nil {
-		slog.Error("error adding CatalogSource", "error", err)
+	reportFileName := clusterName + "-report.json"
+	report.cluster(clusterName).URL = url
+	if pool != nil {
+		report.cluster(clusterName).ClusterClaim = pool.namespace + "/" + pool.claim
+	}
+
+	kconfig, err := getKubeconfig(clusterName)
+	if err != nil {
+		failed()
+		exitWithFailedRun(report, reportFileName, "error creating kubeconfig file", err)
+	}
+
+	useClusterConnection(clusterName, kconfig.Name())
+	nameFixtureCluster(kconfig.Name(), clusterName)
+	if claimedKubeconfig != nil {
+		if err := writePrivateFile(kconfig.Name(), claimedKubeconfig); err != nil {
+			failed()
+			exitWithFailedRun(report, reportFileName, "error writing the kubeconfig of the claimed cluster", err)
+		}
+	} else if err := login(url, username, password, kconfig.Name()); err != nil {
+		failed()
+		exitWithFailedRun(report, reportFileName, "error logging into OpenShift", err)
+	}
+
+	opts := prepareOptions{
+		rhcephPassword:        rhcephPassword,
+		catalogSourceYAML:     catalogSourceYAML,
+		mirrorSets:            mirrorSets,
+		operators:             cfg.Operators,
+		scheduling:            cfg.Scheduling,
+		storage:               cfg.Storage,
+		waits:                 cfg.Waits,
+		identity:              cfg.Identity,
+		previousRun:           *previousRunFlag,
+		snapshot:              *snapshotFlag,
+		prune:                 *pruneFlag,
+		terminatingNamespaces: *terminatingNamespacesFlag,
+		pullSecretMode:        *pullSecretModeFlag,
+		pullSecretConflict:    *pullSecretConflictFlag,
+		registryAuthMatch:     *registryAuthMatchFlag,
+		backupBeforeChanges:   *backupFlag || *backupCommandFlag != "",
+		backupCommand:         *backupCommandFlag,
+		skipRegistryAuth:      skipRegistryAuth,
+		hostedCluster:         hostedCluster,
+		force:                 force,
+		only:                  selection.only,
+		onlyStrict:            selection.strict,
+	}
+
+	err = prepareCluster(clusterName, kconfig.Name(), opts, report.cluster(clusterName))
+	if err != nil {
+		if *gatherOnFailureFlag && !errors.Is(err, errStepAborted) && !errors.Is(err, errDeadline) {
+			gatherOnFailure(clusterName, kconfig.Name(), opts.operators, gatherOpts)
+		}
+		failed()
+		exitWithFailedRun(report, reportFileName, "error preparing cluster", err)
+	}
+
+	if err := report.finish(reportFileName, nil); err != nil {
+		slog.Error("error writing report", "error", err)
 		os.Exit(1)
 	}
+
+	notify(messageInfo, "cluster-prepared", "cluster", clusterName)
 }
@@ -0,0 +1,60 @@
+package manifest
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed odf-catalogsource.yaml
+var odfCatalogSourceYAML string
+
+// CatalogSourceOptions tune the CatalogSource of the ODF catalog. The zero
+// value renders the CatalogSource shipped with the installer.
+type CatalogSourceOptions struct {
+	// Image replaces the catalog image.
+	Image string
+	// Scheduling places the registry pod of the catalog.
+	Scheduling *Scheduling
+	// PollInterval makes OLM poll the catalog image for a new digest, like
+	// 15m.
+	PollInterval string
+	// Priority orders the CatalogSource against the other catalogs
+	// offering the same packages, higher first.
+	Priority *int
+}
+
+// RenderCatalogSource returns the CatalogSource of the ODF catalog.
+func RenderCatalogSource(opts CatalogSourceOptions) string {
+	catalogSourceYAML := odfCatalogSourceYAML
+	if opts.Image != "" {
+		catalogSourceYAML = setCatalogImage(catalogSourceYAML, opts.Image)
+	}
+
+	if opts.Scheduling != nil {
+		catalogSourceYAML = SetYAMLField(catalogSourceYAML, "spec", "grpcPodConfig", opts.Scheduling)
+	}
+
+	if opts.PollInterval != "" {
+		catalogSourceYAML = SetYAMLField(catalogSourceYAML, "spec", "updateStrategy",
+			map[string]any{"registryPoll": map[string]string{"interval": opts.PollInterval}})
+	}
+
+	if opts.Priority != nil {
+		catalogSourceYAML = SetYAMLField(catalogSourceYAML, "spec", "priority", *opts.Priority)
+	}
+
+	return catalogSourceYAML
+}
+
+// setCatalogImage replaces the image of the CatalogSource manifest.
+func setCatalogImage(catalogSourceYAML, image string) string {
+	lines := strings.Split(catalogSourceYAML, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "image:") {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+			lines[i] = indent + "image: " + image
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,67 @@
+// Package manifest renders the manifests the odfdr-installer applies: the
+// ImageContentSourcePolicies of the mirror sets, the CatalogSource of the ODF
+// catalog and the StorageCluster, or the LVMCluster of LVM Storage. Other
+// tools use it to create exactly the same resources as the installer. The
+// functions only render, they neither read nor change a cluster, and the
+// overlays of the configuration file of the installer are not applied.
+package manifest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ObjectMeta is the metadata of the rendered objects.
+type ObjectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Scheduling places pods on nodes.
+type Scheduling struct {
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations  []Toleration      `json:"tolerations,omitempty"`
+}
+
+// Toleration lets pods run on nodes with matching taints.
+type Toleration struct {
+	Key               string `json:"key,omitempty"`
+	Operator          string `json:"operator,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Effect            string `json:"effect,omitempty"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+// SetYAMLField sets a field of a top level block of a YAML document indented
+// by two spaces, replacing the field if it is already present and adding the
+// block if it is missing. The value is written in JSON flow style.
+func SetYAMLField(doc, block, key string, value any) string {
+	data, _ := json.Marshal(value)
+	field := "  " + key + ": " + string(data)
+
+	lines := strings.Split(strings.TrimRight(doc, "\n"), "\n")
+	end := -1
+	for i, line := range lines {
+		if line != block+":" {
+			continue
+		}
+
+		end = i + 1
+		for end < len(lines) && strings.HasPrefix(lines[end], " ") {
+			if strings.HasPrefix(lines[end], "  "+key+":") {
+				lines[end] = field
+				return strings.Join(lines, "\n") + "\n"
+			}
+			end++
+		}
+	}
+
+	if end == -1 {
+		lines = append(lines, block+":")
+		end = len(lines)
+	}
+
+	lines = append(lines[:end], append([]string{field}, lines[end:]...)...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
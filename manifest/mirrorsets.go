@@ -0,0 +1,247 @@
+package manifest
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed mirrorsets/*.yaml
+var embeddedMirrorSets embed.FS
+
+// MirrorSet is a single ImageContentSourcePolicy document. A file with
+// several documents is loaded as one mirror set per document, named after the
+// file and the number of the document.
+type MirrorSet struct {
+	Name string
+	YAML string
+}
+
+// ICSPOptions select the mirror sets of RenderICSP.
+type ICSPOptions struct {
+	// MirrorSets are the names of the mirror sets shipped with the
+	// installer, see MirrorSetNames.
+	MirrorSets []string
+	// Files are mirror set files, rendered after the shipped mirror sets.
+	Files []string
+	// PullThroughCaches map a registry host to a pull-through cache of it,
+	// like quay.io to cache.example.com/quay. The cache is added to the
+	// mirror sets before every mirror on the registry.
+	PullThroughCaches map[string]string
+}
+
+// MirrorSetNames returns the names of the mirror sets shipped with the
+// installer.
+func MirrorSetNames() []string {
+	entries, err := embeddedMirrorSets.ReadDir("mirrorsets")
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+
+	return names
+}
+
+// RenderICSP returns the requested shipped mirror sets followed by the mirror
+// set files, with the pull-through caches added.
+func RenderICSP(opts ICSPOptions) ([]MirrorSet, error) {
+	sets := []MirrorSet{}
+
+	for _, name := range opts.MirrorSets {
+		data, err := embeddedMirrorSets.ReadFile("mirrorsets/" + name + ".yaml")
+		if err != nil {
+			return nil, fmt.Errorf("unknown mirror set %q, available mirror sets: %s", name,
+				strings.Join(MirrorSetNames(), ", "))
+		}
+
+		documents, err := mirrorSetDocuments(name, string(data))
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, documents...)
+	}
+
+	for _, file := range opts.Files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading mirror set file: %v", err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		documents, err := mirrorSetDocuments(name, string(data))
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, documents...)
+	}
+
+	for i, set := range sets {
+		sets[i] = withPullThroughCaches(set, opts.PullThroughCaches)
+	}
+
+	return sets, nil
+}
+
+// mirrorSetDocuments returns a mirror set for every document of a mirror set
+// file.
+func mirrorSetDocuments(name, data string) ([]MirrorSet, error) {
+	docs, err := SplitDocuments(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mirror set %s: %v", name, err)
+	}
+
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("mirror set %s has no documents", name)
+	}
+
+	if len(docs) == 1 {
+		return []MirrorSet{{Name: name, YAML: docs[0]}}, nil
+	}
+
+	sets := []MirrorSet{}
+	for i, doc := range docs {
+		sets = append(sets, MirrorSet{Name: fmt.Sprintf("%s-%d", name, i+1), YAML: doc})
+	}
+
+	return sets, nil
+}
+
+// cachedMirror returns the mirror on the pull-through cache of the registry of
+// a mirror, or "" when the registry has no cache.
+func cachedMirror(mirror string, caches map[string]string) string {
+	host, path, _ := strings.Cut(mirror, "/")
+	cache, ok := caches[host]
+	if !ok {
+		return ""
+	}
+
+	if path == "" {
+		return cache
+	}
+
+	return cache + "/" + path
+}
+
+// withPullThroughCaches adds the pull-through caches to the mirrors of a
+// mirror set, each before the mirror it caches, so that nodes pull from the
+// cache and fall back to the registry. Only the YAML block style used by the
+// shipped mirror sets and by oc is understood, JSON documents are left as
+// they are.
+func withPullThroughCaches(set MirrorSet, caches map[string]string) MirrorSet {
+	if len(caches) == 0 || strings.HasPrefix(strings.TrimSpace(set.YAML), "{") {
+		return set
+	}
+
+	lines := []string{}
+	// mirrorsColumn is the column of the mirrors key whose list the lines
+	// are in, -1 outside of a mirrors list.
+	mirrorsColumn := -1
+	previous := ""
+	added := 0
+	for _, line := range strings.Split(set.YAML, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if mirrorsColumn != -1 && strings.HasPrefix(trimmed, "- ") && indent >= mirrorsColumn {
+			mirror := strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "- ")), `"'`)
+			if cache := cachedMirror(mirror, caches); cache != "" && cache != previous {
+				lines = append(lines, line[:indent]+"- "+cache)
+				added++
+			}
+			previous = mirror
+			lines = append(lines, line)
+			continue
+		}
+
+		key := strings.TrimPrefix(trimmed, "- ")
+		if key == "mirrors:" {
+			mirrorsColumn = indent + len(trimmed) - len(key)
+		} else if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			mirrorsColumn = -1
+		}
+		previous = ""
+		lines = append(lines, line)
+	}
+
+	set.YAML = strings.Join(lines, "\n")
+	if added > 0 {
+		slog.Info("added pull-through caches to mirror set", "mirrorSet", set.Name, "mirrors", added)
+	}
+
+	return set
+}
+
+// SplitDocuments splits a manifest file into its documents. YAML documents
+// are separated by --- lines, a JSON manifest is a single object, or a List
+// or an array whose items are the documents. Empty documents are dropped.
+func SplitDocuments(data string) ([]string, error) {
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return splitJSONDocuments(trimmed)
+	}
+
+	docs := []string{}
+	var doc strings.Builder
+	addDoc := func() {
+		for _, line := range strings.Split(doc.String(), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				docs = append(docs, doc.String())
+				break
+			}
+		}
+		doc.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if marker := strings.TrimRight(line, " \t\r\n"); marker == "---" || strings.HasPrefix(marker, "--- ") {
+			addDoc()
+			continue
+		}
+		doc.WriteString(line)
+	}
+	addDoc()
+
+	return docs, nil
+}
+
+func splitJSONDocuments(data string) ([]string, error) {
+	var items []json.RawMessage
+	if strings.HasPrefix(data, "[") {
+		if err := json.Unmarshal([]byte(data), &items); err != nil {
+			return nil, fmt.Errorf("error parsing JSON manifest: %v", err)
+		}
+	} else {
+		var obj struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal([]byte(data), &obj); err != nil {
+			return nil, fmt.Errorf("error parsing JSON manifest: %v", err)
+		}
+
+		items = []json.RawMessage{json.RawMessage(data)}
+		if obj.Kind == "List" {
+			items = obj.Items
+		}
+	}
+
+	docs := []string{}
+	for _, item := range items {
+		var doc bytes.Buffer
+		if err := json.Indent(&doc, item, "", "  "); err != nil {
+			return nil, fmt.Errorf("error parsing JSON manifest: %v", err)
+		}
+		docs = append(docs, doc.String()+"\n")
+	}
+
+	return docs, nil
+}
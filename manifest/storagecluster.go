@@ -0,0 +1,216 @@
+package manifest
+
+import "sort"
+
+// StorageClusterOptions configure the StorageCluster, with one device set of
+// PVCs from StorageClassName.
+type StorageClusterOptions struct {
+	// Namespace is openshift-storage by default.
+	Namespace string
+	// Name is ocs-storagecluster by default.
+	Name             string
+	StorageClassName string
+	// DeviceSize is the size of every OSD, like 512Gi.
+	DeviceSize string
+	// DeviceCount is the number of OSDs per replica, 1 by default.
+	DeviceCount int
+	// Placement places the Ceph daemons on dedicated storage nodes, keyed by
+	// all, mon, mgr, osd, mds or rgw.
+	Placement map[string]Scheduling
+}
+
+type NodeSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+type NodeSelectorTerm struct {
+	MatchExpressions []NodeSelectorRequirement `json:"matchExpressions"`
+}
+
+type NodeAffinity struct {
+	RequiredDuringSchedulingIgnoredDuringExecution struct {
+		NodeSelectorTerms []NodeSelectorTerm `json:"nodeSelectorTerms"`
+	} `json:"requiredDuringSchedulingIgnoredDuringExecution"`
+}
+
+type PlacementSpec struct {
+	NodeAffinity *NodeAffinity `json:"nodeAffinity,omitempty"`
+	Tolerations  []Toleration  `json:"tolerations,omitempty"`
+}
+
+// StorageCluster is the StorageCluster of ODF, encoded as JSON.
+type StorageCluster struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       struct {
+		MonDataDirHostPath string                   `json:"monDataDirHostPath"`
+		Placement          map[string]PlacementSpec `json:"placement,omitempty"`
+		StorageDeviceSets  []StorageDeviceSet       `json:"storageDeviceSets"`
+	} `json:"spec"`
+}
+
+type StorageDeviceSet struct {
+	Name            string `json:"name"`
+	Count           int    `json:"count"`
+	Replica         int    `json:"replica"`
+	Portable        bool   `json:"portable"`
+	DataPVCTemplate struct {
+		Spec struct {
+			AccessModes []string `json:"accessModes"`
+			Resources   struct {
+				Requests map[string]string `json:"requests"`
+			} `json:"resources"`
+			StorageClassName string `json:"storageClassName"`
+			VolumeMode       string `json:"volumeMode"`
+		} `json:"spec"`
+	} `json:"dataPVCTemplate"`
+	Placement *PlacementSpec `json:"placement,omitempty"`
+}
+
+// placement turns the node selector into a required node affinity, as the
+// StorageCluster has no node selectors.
+func placement(sched Scheduling) PlacementSpec {
+	spec := PlacementSpec{Tolerations: sched.Tolerations}
+	if len(sched.NodeSelector) == 0 {
+		return spec
+	}
+
+	keys := []string{}
+	for key := range sched.NodeSelector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	expressions := []NodeSelectorRequirement{}
+	for _, key := range keys {
+		requirement := NodeSelectorRequirement{Key: key, Operator: "Exists"}
+		if value := sched.NodeSelector[key]; value != "" {
+			requirement = NodeSelectorRequirement{Key: key, Operator: "In", Values: []string{value}}
+		}
+		expressions = append(expressions, requirement)
+	}
+
+	spec.NodeAffinity = &NodeAffinity{}
+	spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []NodeSelectorTerm{{MatchExpressions: expressions}}
+
+	return spec
+}
+
+// RenderStorageCluster returns the StorageCluster of the options.
+func RenderStorageCluster(opts StorageClusterOptions) StorageCluster {
+	if opts.Namespace == "" {
+		opts.Namespace = "openshift-storage"
+	}
+	if opts.Name == "" {
+		opts.Name = "ocs-storagecluster"
+	}
+	if opts.DeviceCount == 0 {
+		opts.DeviceCount = 1
+	}
+
+	sc := StorageCluster{
+		APIVersion: "ocs.openshift.io/v1",
+		Kind:       "StorageCluster",
+		Metadata:   ObjectMeta{Name: opts.Name, Namespace: opts.Namespace},
+	}
+	sc.Spec.MonDataDirHostPath = "/var/lib/rook"
+
+	deviceSet := StorageDeviceSet{Name: "ocs-deviceset", Count: opts.DeviceCount, Replica: 3, Portable: true}
+	deviceSet.DataPVCTemplate.Spec.AccessModes = []string{"ReadWriteOnce"}
+	deviceSet.DataPVCTemplate.Spec.Resources.Requests = map[string]string{"storage": opts.DeviceSize}
+	deviceSet.DataPVCTemplate.Spec.StorageClassName = opts.StorageClassName
+	deviceSet.DataPVCTemplate.Spec.VolumeMode = "Block"
+
+	for component, sched := range opts.Placement {
+		if sc.Spec.Placement == nil {
+			sc.Spec.Placement = map[string]PlacementSpec{}
+		}
+		sc.Spec.Placement[component] = placement(sched)
+	}
+
+	// The OSDs are placed by their device set, the placement of the
+	// StorageCluster does not apply to them.
+	if sched, ok := opts.Placement["osd"]; ok {
+		osdPlacement := placement(sched)
+		deviceSet.Placement = &osdPlacement
+	}
+
+	sc.Spec.StorageDeviceSets = []StorageDeviceSet{deviceSet}
+
+	return sc
+}
+
+// LVMClusterOptions configure the LVMCluster of LVM Storage, which replaces
+// ODF on single node and edge clusters.
+type LVMClusterOptions struct {
+	// Namespace is openshift-storage by default.
+	Namespace string
+	// DeviceClass is the volume group, vg1 by default.
+	DeviceClass string
+	// DevicePaths are the disks of the volume group, all unused disks if
+	// empty.
+	DevicePaths []string
+	// ThinPoolSizePercent is the share of the volume group used by the thin
+	// pool, 90 by default.
+	ThinPoolSizePercent int
+}
+
+// LVMCluster is the LVMCluster of LVM Storage, encoded as JSON.
+type LVMCluster struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       struct {
+		Storage struct {
+			DeviceClasses []LVMDeviceClass `json:"deviceClasses"`
+		} `json:"storage"`
+	} `json:"spec"`
+}
+
+type LVMDeviceClass struct {
+	Name           string             `json:"name"`
+	Default        bool               `json:"default"`
+	DeviceSelector *LVMDeviceSelector `json:"deviceSelector,omitempty"`
+	ThinPoolConfig struct {
+		Name               string `json:"name"`
+		SizePercent        int    `json:"sizePercent"`
+		OverprovisionRatio int    `json:"overprovisionRatio"`
+	} `json:"thinPoolConfig"`
+}
+
+type LVMDeviceSelector struct {
+	Paths []string `json:"paths"`
+}
+
+// RenderLVMCluster returns the LVMCluster of the options.
+func RenderLVMCluster(opts LVMClusterOptions) LVMCluster {
+	if opts.Namespace == "" {
+		opts.Namespace = "openshift-storage"
+	}
+	if opts.DeviceClass == "" {
+		opts.DeviceClass = "vg1"
+	}
+	if opts.ThinPoolSizePercent == 0 {
+		opts.ThinPoolSizePercent = 90
+	}
+
+	cluster := LVMCluster{
+		APIVersion: "lvm.topolvm.io/v1alpha1",
+		Kind:       "LVMCluster",
+		Metadata:   ObjectMeta{Name: "lvmcluster", Namespace: opts.Namespace},
+	}
+
+	deviceClass := LVMDeviceClass{Name: opts.DeviceClass, Default: true}
+	if len(opts.DevicePaths) > 0 {
+		deviceClass.DeviceSelector = &LVMDeviceSelector{Paths: opts.DevicePaths}
+	}
+	deviceClass.ThinPoolConfig.Name = "thin-pool-1"
+	deviceClass.ThinPoolConfig.SizePercent = opts.ThinPoolSizePercent
+	deviceClass.ThinPoolConfig.OverprovisionRatio = 10
+	cluster.Spec.Storage.DeviceClasses = []LVMDeviceClass{deviceClass}
+
+	return cluster
+}
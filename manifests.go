@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+// fieldManager owns the fields of the resources applied by the installer.
+const fieldManager = "odfdr-installer"
+
+// manifestOptions are the flags that select the manifests applied to a
+// cluster, shared by the commands that apply them.
+type manifestOptions struct {
+	catalogImage   *string
+	mirrorSets     *string
+	mirrorSetFiles stringList
+	release        *string
+	// publicCatalog is set by a release stream with public images, which
+	// implies -skip-registry-auth.
+	publicCatalog bool
+
+	// flags tells which of the flags were given, which take precedence over
+	// the release.
+	flags *flag.FlagSet
+}
+
+func addManifestFlags(flags *flag.FlagSet) *manifestOptions {
+	opts := &manifestOptions{flags: flags}
+	opts.catalogImage = flags.String("catalog-image", "", "ODF catalog image to use instead of the embedded one (see list-builds)")
+	opts.mirrorSets = flags.String("mirror-sets", "odf,ceph", "Comma separated list of embedded mirror sets to apply (available: "+
+		strings.Join(manifest.MirrorSetNames(), ", ")+")")
+	flags.Var(&opts.mirrorSetFiles, "mirror-set-file", "Path to an additional ICSP mirror set file to apply (can be repeated)")
+	opts.release = flags.String("release", "", "Release stream, like 4.18, to use the catalog image and mirror sets of (default streams: "+
+		strings.Join(releaseStreamNames(defaultReleaseStreams), ", ")+")")
+
+	return opts
+}
+
+// catalogSourceYAML returns the CatalogSource manifest to apply.
+func (o *manifestOptions) catalogSourceYAML(cfg *config) string {
+	opts := manifest.CatalogSourceOptions{Image: *o.catalogImage, Scheduling: cfg.Scheduling}
+	if cfg.Catalog != nil {
+		opts.PollInterval = cfg.Catalog.PollInterval
+		opts.Priority = cfg.Catalog.Priority
+	}
+
+	return manifest.RenderCatalogSource(opts)
+}
+
+// applyManifest applies a manifest file with server-side apply and returns
+// the applied resources. Fields owned by other field managers, like the
+// controllers of the resources, are not taken over, the conflicts are
+// returned as an error instead.
+func applyManifest(kconfig, fileName string) ([]string, error) {
+	if len(manifestOverlays) > 0 {
+		if err := overlayManifest(kconfig, fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	applyCmd := ocCommand(kconfig, "apply", "--server-side", "--field-manager="+fieldManager, "-f", fileName, "-o", "name")
+	output, err := applyCmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "conflict") {
+			return nil, fmt.Errorf("fields of %s are owned by another field manager: %s", fileName, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return nil, err
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// loadMirrorSets returns the mirror sets to apply.
+func (o *manifestOptions) loadMirrorSets() ([]mirrorSet, error) {
+	names := []string{}
+	for _, name := range strings.Split(*o.mirrorSets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return manifest.RenderICSP(manifest.ICSPOptions{
+		MirrorSets:        names,
+		Files:             o.mirrorSetFiles,
+		PullThroughCaches: pullThroughCaches,
+	})
+}
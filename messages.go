@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	messageInfo    = "info"
+	messageWarning = "warning"
+	messageError   = "error"
+	// messageHint tells the user what to do about the message before it.
+	messageHint = "hint"
+)
+
+// userMessage is a message for the user, like the progress of a run or the
+// reason it failed, as opposed to the logs, which are for debugging the
+// installer. Messages are identified by ID, their text comes from the
+// message catalog, so they read the same on the console, in the JSON output
+// and in the status of an ODFDRInstallation, and can be translated.
+type userMessage struct {
+	Time  time.Time         `json:"time"`
+	Level string            `json:"level"`
+	ID    string            `json:"id"`
+	Text  string            `json:"text"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// defaultMessages is the message catalog. A {name} in a text is replaced by
+// the argument of that name.
+var defaultMessages = map[string]string{
+	"step-started":        "{cluster}: running step {step}",
+	"step-forced":         "{cluster}: forcing step {step}, its resources are recreated",
+	"step-skipped":        "{cluster}: skipped step {step}",
+	"step-retried":        "{cluster}: logged in again, running step {step} again",
+	"cluster-prepared":    "{cluster}: prepared",
+	"pair-configured":     "hub {hub}: configured the DR pair {clusters}",
+	"cluster-failed":      "{cluster}: failed: {error}",
+	"fleet-partial":       "{succeeded} of {total} managed clusters were set up",
+	"fleet-required":      "-min-success requires {required} managed clusters",
+	"run-failed":          "{action}: {error}",
+	"see-report":          "the steps of the run are recorded in {report}, show them with show-run {run}",
+	"rhceph-unavailable":  "the RHCEPH repository {repository} is not available: {error}",
+	"catalog-tag-missing": "the catalog image {image} does not exist",
+	"catalog-stale":       "the catalog image {image} was built {age} ago, more than {maxAge}",
+	"catalog-stale-hint":  "old dev builds often fail to set up DR, pick a recent build with list-builds and -catalog-image or -release",
+}
+
+var (
+	messagesMu sync.Mutex
+	// messages is the message catalog in use, the default one with the
+	// texts of -messages-file replaced.
+	messages                = defaultMessages
+	messageFormat           = "text"
+	messageOutput io.Writer = os.Stderr
+)
+
+// addMessageFlags adds the flags selecting how user messages are shown.
+func addMessageFlags(flags *flag.FlagSet) {
+	flags.Func("message-format", "Format of the messages for the user: text or json (one JSON object per line on stdout)", func(format string) error {
+		switch format {
+		case "text":
+			messageOutput = os.Stderr
+		case "json":
+			messageOutput = os.Stdout
+		default:
+			return fmt.Errorf("unknown format %q, expected text or json", format)
+		}
+		messageFormat = format
+
+		return nil
+	})
+	flags.Func("messages-file", "JSON file of message IDs and texts replacing those of the installer, e.g. translations", loadMessages)
+}
+
+// loadMessages replaces the texts of the message catalog with those of a
+// JSON file. Messages missing from the file keep their default text.
+func loadMessages(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("error reading messages file: %v", err)
+	}
+
+	texts := map[string]string{}
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return fmt.Errorf("error parsing messages file: %v", err)
+	}
+
+	catalog := map[string]string{}
+	for id, text := range defaultMessages {
+		catalog[id] = text
+	}
+	for id, text := range texts {
+		if _, found := defaultMessages[id]; !found {
+			return fmt.Errorf("unknown message %q in messages file", id)
+		}
+		catalog[id] = text
+	}
+
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+	messages = catalog
+
+	return nil
+}
+
+// newMessage returns the message of the catalog with its arguments, given as
+// name and value pairs, filled in.
+func newMessage(level, id string, args ...string) userMessage {
+	msg := userMessage{Time: time.Now(), Level: level, ID: id, Args: map[string]string{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg.Args[args[i]] = redactText(args[i+1])
+	}
+
+	messagesMu.Lock()
+	text, found := messages[id]
+	messagesMu.Unlock()
+	if !found {
+		text = id
+	}
+
+	for name, value := range msg.Args {
+		text = strings.ReplaceAll(text, "{"+name+"}", value)
+	}
+	msg.Text = text
+
+	return msg
+}
+
+// messageText returns the text of a message, for places that keep the text
+// rather than show it, like the status of an ODFDRInstallation.
+func messageText(id string, args ...string) string {
+	return newMessage(messageInfo, id, args...).Text
+}
+
+// notify shows a message to the user.
+func notify(level, id string, args ...string) {
+	msg := newMessage(level, id, args...)
+
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+
+	if messageFormat == "json" {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(messageOutput, string(data))
+		return
+	}
+
+	prefix := ""
+	switch level {
+	case messageWarning:
+		prefix = "Warning: "
+	case messageError:
+		prefix = "Error: "
+	case messageHint:
+		prefix = "Hint: "
+	}
+	fmt.Fprintf(messageOutput, "%s %s%s\n", msg.Time.Format(time.TimeOnly), prefix, msg.Text)
+}
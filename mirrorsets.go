@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "odfdr-installer"
+	mirrorSetLabel = "odfdr-installer/mirror-set"
+)
+
+// mirrorSet is a single ImageContentSourcePolicy document. All mirror sets
+// applied by the installer are labeled so they can be found again as a group.
+type mirrorSet = manifest.MirrorSet
+
+func addMirrorSets(clusterName, kconfig string, sets []mirrorSet) error {
+	for _, set := range sets {
+		if err := addMirrorSet(clusterName, kconfig, set); err != nil {
+			return fmt.Errorf("error adding mirror set %s: %v", set.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func writeMirrorSet(clusterName string, set mirrorSet) (string, error) {
+	icspFileName := clusterName + "-" + set.Name + "-icsp.yaml"
+	err := writeArtifact(clusterName, icspFileName, []byte(set.YAML))
+	if err != nil {
+		return "", fmt.Errorf("error writing ICSP to file: %v", err)
+	}
+
+	return icspFileName, nil
+}
+
+func addMirrorSet(clusterName, kconfig string, set mirrorSet) error {
+	icspFileName, err := writeMirrorSet(clusterName, set)
+	if err != nil {
+		return err
+	}
+
+	resources, err := applyManifest(kconfig, icspFileName)
+	if err != nil {
+		return fmt.Errorf("error applying ICSP: %v", err)
+	}
+
+	if len(resources) == 0 {
+		return fmt.Errorf("no resources were applied from %s", icspFileName)
+	}
+
+	labelArgs := append([]string{"label", "--overwrite"}, resources...)
+	labelArgs = append(labelArgs, managedByLabel+"="+managedByValue, mirrorSetLabel+"="+set.Name)
+	labelCmd := ocCommand(kconfig, labelArgs...)
+	err = labelCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error labeling ICSP: %v", err)
+	}
+
+	slog.Info("applied mirror set", "mirrorSet", set.Name, "resources", resources)
+
+	return nil
+}
+
+// recreateMirrorSets deletes the mirror sets and applies them again. Every
+// change to the mirror sets rolls out to all nodes, so this causes two
+// rollouts.
+func recreateMirrorSets(clusterName, kconfig string, sets []mirrorSet) error {
+	slog.Warn("recreating mirror sets, nodes will be updated twice")
+
+	for _, set := range sets {
+		icspFileName, err := writeMirrorSet(clusterName, set)
+		if err != nil {
+			return err
+		}
+
+		deleteCmd := ocCommand(kconfig, "delete", "-f", icspFileName, "--ignore-not-found", "--wait=true")
+		err = deleteCmd.Run()
+		if err != nil {
+			return fmt.Errorf("error deleting mirror set %s: %v", set.Name, err)
+		}
+
+		if err := addMirrorSet(clusterName, kconfig, set); err != nil {
+			return fmt.Errorf("error adding mirror set %s: %v", set.Name, err)
+		}
+	}
+
+	return nil
+}
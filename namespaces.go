@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	terminatingNamespaceFail  = "fail"
+	terminatingNamespaceWait  = "wait"
+	terminatingNamespaceClear = "clear"
+
+	// terminatingNamespaceTimeout is how long a Terminating namespace is
+	// waited for to be deleted.
+	terminatingNamespaceTimeout = 10 * time.Minute
+)
+
+var terminatingNamespaceActions = []string{terminatingNamespaceFail, terminatingNamespaceWait, terminatingNamespaceClear}
+
+func addTerminatingNamespacesFlag(flags *flag.FlagSet) *string {
+	return flags.String("terminating-namespaces", terminatingNamespaceFail, "What to do when a namespace the run creates resources in is stuck Terminating: "+
+		strings.Join(terminatingNamespaceActions, ", ")+"; clear removes the finalizers of the resources left in it")
+}
+
+func validateTerminatingNamespaceAction(action string) error {
+	if !slices.Contains(terminatingNamespaceActions, action) {
+		return fmt.Errorf("unknown action %q, expected one of %s", action, strings.Join(terminatingNamespaceActions, ", "))
+	}
+
+	return nil
+}
+
+// finalizedNamespaceResources are the resources of the namespaces of the run
+// that are left waiting for their finalizers once the operators are removed,
+// in the form of oc api-resources: the storage resources and the claims of
+// their volumes and buckets. Only these are reported and cleared.
+var finalizedNamespaceResources = append(slices.Clone(storageResources),
+	"persistentvolumeclaims",
+	"objectbucketclaims.objectbucket.io",
+	"lvmclusters.lvm.topolvm.io",
+	"lvmvolumegroups.lvm.topolvm.io",
+)
+
+type namespaceStatus struct {
+	Metadata struct {
+		Name              string `json:"name"`
+		DeletionTimestamp string `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string      `json:"phase"`
+		Conditions []condition `json:"conditions"`
+	} `json:"status"`
+}
+
+// finalizedResource is a resource being deleted that waits for its
+// finalizers.
+type finalizedResource struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	Finalizers []string
+}
+
+func (r finalizedResource) String() string {
+	return fmt.Sprintf("%s/%s (%s)", strings.ToLower(r.Kind), r.Name, strings.Join(r.Finalizers, ", "))
+}
+
+// resource returns the kind of the resource qualified by its version and
+// group, so that oc does not pick a kind of the same name of another group.
+func (r finalizedResource) resource() string {
+	group, version, found := strings.Cut(r.APIVersion, "/")
+	if !found {
+		// The core API, like apiVersion v1, has no group.
+		return strings.ToLower(r.Kind)
+	}
+
+	return strings.ToLower(r.Kind) + "." + version + "." + group
+}
+
+// preparedNamespaces returns the namespaces prepare creates resources in: the
+// namespace of the CatalogSource, the namespaces of the operators and the
+// storage namespace.
+func preparedNamespaces(opts prepareOptions) []string {
+	namespaces := []string{"openshift-marketplace", globalOperatorsNamespace}
+	for _, operator := range opts.operators {
+		if operator.Namespace != "" && !slices.Contains(namespaces, operator.Namespace) {
+			namespaces = append(namespaces, operator.Namespace)
+		}
+	}
+	if opts.storage != nil && !slices.Contains(namespaces, opts.storage.Namespace) {
+		namespaces = append(namespaces, opts.storage.Namespace)
+	}
+
+	return namespaces
+}
+
+// terminatingNamespaceBlockers returns why a Terminating namespace is not
+// deleted yet: the messages of its deletion conditions and the resources left
+// in it that wait for their finalizers, of the finalizedNamespaceResources
+// served by the cluster. Listing them needs more than the prepare permissions,
+// without them only the conditions, which name the finalizers, are returned.
+func terminatingNamespaceBlockers(kconfig string, ns namespaceStatus) ([]string, []finalizedResource) {
+	messages := []string{}
+	for _, cond := range ns.Status.Conditions {
+		if cond.Status == "True" && strings.HasPrefix(cond.Type, "Namespace") && cond.Message != "" {
+			messages = append(messages, cond.Message)
+		}
+	}
+
+	output, err := ocCommand(kconfig, "api-resources", "--verbs=list", "--namespaced", "-o", "name").Output()
+	if err != nil {
+		slog.Warn("error listing namespaced resources", "error", err)
+		return messages, nil
+	}
+	resources := []string{}
+	for _, resource := range strings.Fields(string(output)) {
+		if slices.Contains(finalizedNamespaceResources, resource) {
+			resources = append(resources, resource)
+		}
+	}
+	if len(resources) == 0 {
+		return messages, nil
+	}
+
+	var items struct {
+		Items []struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Metadata   struct {
+				Name              string   `json:"name"`
+				DeletionTimestamp string   `json:"deletionTimestamp"`
+				Finalizers        []string `json:"finalizers"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &items, strings.Join(resources, ","), "-n", ns.Metadata.Name, "--ignore-not-found"); err != nil {
+		slog.Warn("error listing the resources left in namespace", "namespace", ns.Metadata.Name, "error", err)
+		return messages, nil
+	}
+
+	finalized := []finalizedResource{}
+	for _, item := range items.Items {
+		if item.Metadata.DeletionTimestamp != "" && len(item.Metadata.Finalizers) > 0 {
+			finalized = append(finalized, finalizedResource{APIVersion: item.APIVersion, Kind: item.Kind, Name: item.Metadata.Name, Finalizers: item.Metadata.Finalizers})
+		}
+	}
+
+	return messages, finalized
+}
+
+// checkTerminatingNamespaces looks for namespaces of the run stuck
+// Terminating from an earlier teardown, where creating resources fails with
+// confusing errors. Depending on action, it fails with the finalizers that
+// block the deletion, waits for the namespaces to be deleted, or removes the
+// finalizers and then waits. The namespaces are recreated by the steps.
+func checkTerminatingNamespaces(clusterName, kconfig string, namespaces []string, action string) error {
+	for _, name := range namespaces {
+		var ns namespaceStatus
+		found, err := getJSON(kconfig, &ns, "namespace", name)
+		if err != nil {
+			return err
+		}
+		if !found || ns.Status.Phase != "Terminating" {
+			continue
+		}
+
+		messages, finalized := terminatingNamespaceBlockers(kconfig, ns)
+
+		blockers := messages
+		for _, resource := range finalized {
+			blockers = append(blockers, resource.String())
+		}
+		slog.Warn("namespace is terminating", "cluster", clusterName, "namespace", name,
+			"since", ns.Metadata.DeletionTimestamp, "blockers", blockers)
+
+		switch action {
+		case terminatingNamespaceFail:
+			return fmt.Errorf("namespace %s is terminating since %s, blocked by: %s; wait for it or use -terminating-namespaces %s or %s",
+				name, ns.Metadata.DeletionTimestamp, strings.Join(blockers, "; "), terminatingNamespaceWait, terminatingNamespaceClear)
+		case terminatingNamespaceClear:
+			for _, resource := range finalized {
+				patchCmd := ocCommand(kconfig, "patch", resource.resource(), resource.Name, "-n", name,
+					"--type", "merge", "-p", `{"metadata":{"finalizers":null}}`)
+				if err := patchCmd.Run(); err != nil {
+					return fmt.Errorf("error removing finalizers of %s in namespace %s: %v", resource, name, err)
+				}
+
+				slog.Warn("removed finalizers", "resource", resource.String(), "namespace", name)
+			}
+		}
+
+		err = waitFor(kconfig, "terminating namespace "+name+" to be deleted", terminatingNamespaceTimeout, 10*time.Second, func() (bool, error) {
+			found, err := getJSON(kconfig, &ns, "namespace", name)
+			return !found, err
+		})
+		if err != nil {
+			return err
+		}
+
+		slog.Info("terminating namespace deleted", "cluster", clusterName, "namespace", name)
+	}
+
+	return nil
+}
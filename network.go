@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	networkCheckNamespace = "odfdr-installer-netcheck"
+	networkCheckServer    = "iperf-server"
+	networkCheckClient    = "iperf-client"
+	iperfPort             = 5201
+)
+
+// networkOptions are the thresholds the network between two DR peers is
+// checked against.
+type networkOptions struct {
+	image string
+	// interval is the scheduling interval the peers replicate at.
+	interval time.Duration
+	// changeRateGiB is the data changed between two replications, which has
+	// to be transferred within the interval.
+	changeRateGiB float64
+	minMTU        int
+	// enforce fails the measurement when a threshold is not met.
+	enforce bool
+}
+
+func addNetworkFlags(flags *flag.FlagSet, prefix string) *networkOptions {
+	opts := &networkOptions{}
+	flags.StringVar(&opts.image, prefix+"image", "quay.io/networkstatic/iperf3:latest", "iperf3 image used to measure the network")
+	flags.DurationVar(&opts.interval, prefix+"interval", 5*time.Minute, "Scheduling interval the network has to keep up with")
+	flags.Float64Var(&opts.changeRateGiB, prefix+"change-rate-gib", 1, "GiB changed per scheduling interval")
+	flags.IntVar(&opts.minMTU, prefix+"min-mtu", 1400, "Minimum MTU of the cluster networks")
+	flags.BoolVar(&opts.enforce, prefix+"enforce", false, "Fail when the network is below the thresholds")
+
+	return opts
+}
+
+// requiredMbps is the throughput needed to transfer the changes of one
+// interval within the interval.
+func (o *networkOptions) requiredMbps() float64 {
+	return o.changeRateGiB * 8 * 1024 * 1024 * 1024 / 1e6 / o.interval.Seconds()
+}
+
+// networkMeasurement is the network between a cluster and its DR peer, as
+// seen from the cluster.
+type networkMeasurement struct {
+	Peer           string    `json:"peer"`
+	Time           time.Time `json:"time"`
+	ThroughputMbps float64   `json:"throughputMbps"`
+	RTTMillis      float64   `json:"rttMillis"`
+	MTU            int       `json:"mtu"`
+	PeerMTU        int       `json:"peerMTU"`
+	Warnings       []string  `json:"warnings,omitempty"`
+}
+
+type podSpec struct {
+	RestartPolicy string         `json:"restartPolicy"`
+	Containers    []podContainer `json:"containers"`
+	Volumes       []volume       `json:"volumes,omitempty"`
+}
+
+type podContainer struct {
+	Name            string        `json:"name"`
+	Image           string        `json:"image"`
+	Command         []string      `json:"command"`
+	VolumeMounts    []volumeMount `json:"volumeMounts,omitempty"`
+	SecurityContext struct {
+		AllowPrivilegeEscalation bool `json:"allowPrivilegeEscalation"`
+		RunAsNonRoot             bool `json:"runAsNonRoot"`
+		Capabilities             struct {
+			Drop []string `json:"drop"`
+		} `json:"capabilities"`
+		SeccompProfile struct {
+			Type string `json:"type"`
+		} `json:"seccompProfile"`
+	} `json:"securityContext"`
+}
+
+type pod struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec podSpec `json:"spec"`
+}
+
+func newPod(name, image string, command ...string) pod {
+	p := pod{APIVersion: "v1", Kind: "Pod"}
+	p.Metadata.Name = name
+	p.Metadata.Namespace = networkCheckNamespace
+	p.Metadata.Labels = map[string]string{"app": name}
+	p.Spec.RestartPolicy = "Never"
+
+	container := podContainer{Name: name, Image: image, Command: command}
+	container.SecurityContext.RunAsNonRoot = true
+	container.SecurityContext.Capabilities.Drop = []string{"ALL"}
+	container.SecurityContext.SeccompProfile.Type = "RuntimeDefault"
+	p.Spec.Containers = []podContainer{container}
+
+	return p
+}
+
+type service struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Selector map[string]string `json:"selector"`
+		Ports    []servicePort     `json:"ports"`
+	} `json:"spec"`
+}
+
+type servicePort struct {
+	Port int `json:"port"`
+}
+
+type serviceExport struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+}
+
+// iperfServerManifests returns the iperf3 server and its Service, exported to
+// the peer through Submariner.
+func iperfServerManifests(image string) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	manifests.Items = append(manifests.Items,
+		namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: networkCheckNamespace}},
+		newPod(networkCheckServer, image, "iperf3", "-s", "-p", fmt.Sprint(iperfPort)))
+
+	svc := service{APIVersion: "v1", Kind: "Service", Metadata: objectMeta{Name: networkCheckServer, Namespace: networkCheckNamespace}}
+	svc.Spec.Selector = map[string]string{"app": networkCheckServer}
+	svc.Spec.Ports = []servicePort{{Port: iperfPort}}
+	manifests.Items = append(manifests.Items, svc,
+		serviceExport{APIVersion: "multicluster.x-k8s.io/v1alpha1", Kind: "ServiceExport",
+			Metadata: objectMeta{Name: networkCheckServer, Namespace: networkCheckNamespace}})
+
+	return manifests
+}
+
+// iperfClientManifests returns the iperf3 client, which retries until the
+// exported Service of the peer resolves.
+func iperfClientManifests(image string) list {
+	host := networkCheckServer + "." + networkCheckNamespace + ".svc.clusterset.local"
+	script := fmt.Sprintf("for i in $(seq 30); do iperf3 -c %s -p %d -t 10 -J && exit 0; sleep 10; done; exit 1", host, iperfPort)
+
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	manifests.Items = append(manifests.Items,
+		namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: networkCheckNamespace}},
+		newPod(networkCheckClient, image, "sh", "-c", script))
+
+	return manifests
+}
+
+func applyNetworkCheck(clusterName, kconfig, fileName string, manifests list) error {
+	data, err := json.MarshalIndent(manifests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding network check: %v", err)
+	}
+
+	err = writeArtifact(clusterName, fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing network check to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying network check: %v", err)
+	}
+
+	return nil
+}
+
+func waitForPodPhase(kconfig, name string, phases ...string) (string, error) {
+	var phase string
+	err := waitFor(kconfig, "pod "+networkCheckNamespace+"/"+name, 10*time.Minute, 10*time.Second, func() (bool, error) {
+		var p struct {
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		}
+		found, err := getJSON(kconfig, &p, "pod", name, "-n", networkCheckNamespace)
+		if err != nil || !found {
+			return false, err
+		}
+		phase = p.Status.Phase
+
+		return slices.Contains(phases, phase), nil
+	})
+
+	return phase, err
+}
+
+// getClusterNetworkMTU returns the MTU of the pod network of a cluster.
+func getClusterNetworkMTU(kconfig string) (int, error) {
+	var network struct {
+		Status struct {
+			ClusterNetworkMTU int `json:"clusterNetworkMTU"`
+		} `json:"status"`
+	}
+	if _, err := getJSON(kconfig, &network, "networks.config.openshift.io", "cluster"); err != nil {
+		return 0, err
+	}
+
+	return network.Status.ClusterNetworkMTU, nil
+}
+
+type iperfResult struct {
+	End struct {
+		Streams []struct {
+			Sender struct {
+				MeanRTT float64 `json:"mean_rtt"`
+			} `json:"sender"`
+		} `json:"streams"`
+		SumReceived struct {
+			BitsPerSecond float64 `json:"bits_per_second"`
+		} `json:"sum_received"`
+	} `json:"end"`
+}
+
+// measureNetwork runs an iperf3 server on the server cluster and a client on
+// the client cluster, which connects over Submariner, and returns the
+// throughput and round trip time seen by the client.
+func measureNetwork(serverName, serverKconfig, clientName, clientKconfig string, opts *networkOptions) (*networkMeasurement, error) {
+	defer func() {
+		for _, kconfig := range []string{serverKconfig, clientKconfig} {
+			deleteCmd := ocCommand(kconfig, "delete", "namespace", networkCheckNamespace, "--ignore-not-found", "--wait=false")
+			if err := deleteCmd.Run(); err != nil {
+				slog.Warn("error deleting network check namespace", "error", err)
+			}
+		}
+	}()
+
+	m := &networkMeasurement{Peer: serverName, Time: time.Now()}
+
+	var err error
+	if m.MTU, err = getClusterNetworkMTU(clientKconfig); err != nil {
+		return nil, err
+	}
+	if m.PeerMTU, err = getClusterNetworkMTU(serverKconfig); err != nil {
+		return nil, err
+	}
+
+	err = applyNetworkCheck(serverName, serverKconfig, serverName+"-netcheck-server.json", iperfServerManifests(opts.image))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := waitForPodPhase(serverKconfig, networkCheckServer, "Running"); err != nil {
+		return nil, err
+	}
+
+	err = applyNetworkCheck(clientName, clientKconfig, clientName+"-netcheck-client.json", iperfClientManifests(opts.image))
+	if err != nil {
+		return nil, err
+	}
+	phase, err := waitForPodPhase(clientKconfig, networkCheckClient, "Succeeded", "Failed")
+	if err != nil {
+		return nil, err
+	}
+	if phase == "Failed" {
+		return nil, fmt.Errorf("iperf3 client could not reach %s over Submariner, is the ServiceExport imported?", serverName)
+	}
+
+	logsCmd := ocCommand(clientKconfig, "logs", "-n", networkCheckNamespace, networkCheckClient)
+	output, err := logsCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting iperf3 result: %v", err)
+	}
+
+	// Failed attempts print their own JSON before the successful one.
+	result := output[strings.LastIndex(string(output), "\n{")+1:]
+	var r iperfResult
+	if err := json.Unmarshal(result, &r); err != nil {
+		return nil, fmt.Errorf("error parsing iperf3 result: %v", err)
+	}
+
+	m.ThroughputMbps = r.End.SumReceived.BitsPerSecond / 1e6
+	if len(r.End.Streams) > 0 {
+		m.RTTMillis = r.End.Streams[0].Sender.MeanRTT / 1000
+	}
+
+	m.Warnings = checkNetwork(m, opts)
+
+	return m, nil
+}
+
+// checkNetwork returns a warning for every threshold the measurement does not
+// meet.
+func checkNetwork(m *networkMeasurement, opts *networkOptions) []string {
+	warnings := []string{}
+
+	if mtu := min(m.MTU, m.PeerMTU); mtu < opts.minMTU {
+		warnings = append(warnings, fmt.Sprintf("cluster network MTU %d is below %d, Submariner tunnels fragment packets", mtu, opts.minMTU))
+	}
+
+	if required := opts.requiredMbps(); m.ThroughputMbps < required {
+		warnings = append(warnings, fmt.Sprintf("throughput %.0f Mbps is below the %.0f Mbps needed to replicate %v GiB every %v",
+			m.ThroughputMbps, required, opts.changeRateGiB, opts.interval))
+	}
+
+	// A replication needs several round trips per object, on very slow links
+	// they alone take a large part of short intervals.
+	if opts.interval <= 5*time.Minute && m.RTTMillis > 100 {
+		warnings = append(warnings, fmt.Sprintf("round trip time %.0f ms is too high for a %v interval", m.RTTMillis, opts.interval))
+	}
+
+	return warnings
+}
+
+// checkPeerNetwork measures the network between two DR peers, records it in
+// the report of the client cluster and, when enforced, fails if a threshold
+// is not met.
+func checkPeerNetwork(report *runReport, serverName, serverKconfig, clientName, clientKconfig string, opts *networkOptions) error {
+	m, err := measureNetwork(serverName, serverKconfig, clientName, clientKconfig, opts)
+	if err != nil {
+		return fmt.Errorf("error measuring network between %s and %s: %v", clientName, serverName, err)
+	}
+	report.cluster(clientName).Network = m
+
+	slog.Info("measured network", "from", clientName, "to", serverName, "throughputMbps", int(m.ThroughputMbps),
+		"rttMillis", m.RTTMillis, "mtu", min(m.MTU, m.PeerMTU))
+
+	for _, warning := range m.Warnings {
+		slog.Warn("network below threshold", "from", clientName, "to", serverName, "warning", warning)
+	}
+
+	if opts.enforce && len(m.Warnings) > 0 {
+		return fmt.Errorf("network between %s and %s is below the thresholds: %s", clientName, serverName, strings.Join(m.Warnings, "; "))
+	}
+
+	return nil
+}
+
+func runMeasureNetwork(args []string) {
+	flags := flag.NewFlagSet("measure-network", flag.ExitOnError)
+	reportFlag := flags.String("report", "network-report.json", "File to write the run report to")
+	opts := addNetworkFlags(flags, "")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		slog.Error("error: kubeconfigs of exactly two clusters are required")
+		showUsageAndExit()
+	}
+
+	if opts.interval <= 0 {
+		slog.Error("error: -interval must be positive")
+		showUsageAndExit()
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	report := newRunReport("measure-network")
+
+	names := []string{}
+	for _, kconfig := range flags.Args() {
+		url, err := getServerURL(kconfig)
+		if err != nil {
+			exitWithFailedRun(report, *reportFlag, "error connecting to cluster", err)
+		}
+
+		name, err := getClusterName(url)
+		if err != nil {
+			exitWithFailedRun(report, *reportFlag, "error getting cluster name", err)
+		}
+		report.cluster(name).URL = url
+		names = append(names, name)
+	}
+
+	err := checkPeerNetwork(report, names[0], flags.Arg(0), names[1], flags.Arg(1), opts)
+	if err != nil {
+		exitWithFailedRun(report, *reportFlag, "error checking network", err)
+	}
+
+	if err := report.finish(*reportFlag, nil); err != nil {
+		slog.Error("error writing report", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const drNetworkPolicyName = "odfdr-dr-peer-traffic"
+
+// defaultDRNetworkNamespaces are the namespaces of the DR traffic, the
+// rbd-mirror daemons and the object store in openshift-storage and the Ramen
+// cluster operator, which reads and writes the S3 store of the peer, in
+// openshift-dr-system.
+var defaultDRNetworkNamespaces = []string{"openshift-storage", "openshift-dr-system"}
+
+// drNetworkConfig adds exceptions to the NetworkPolicies and EgressFirewalls
+// of the managed clusters for the DR traffic between the clusters of a pair,
+// which restrictive policies otherwise drop silently.
+type drNetworkConfig struct {
+	// CIDRs are the networks of each cluster, by cluster name, that its
+	// peer exchanges DR traffic with. The clusters of a fleet without CIDRs
+	// use their pod and service networks.
+	CIDRs map[string][]string `json:"cidrs,omitempty"`
+	// Namespaces get the exceptions, openshift-storage and
+	// openshift-dr-system by default. A NetworkPolicy isolates the pods it
+	// selects, so the exceptions are only added for the pods and directions
+	// that the NetworkPolicies of the namespaces isolate already.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Egress also allows the egress to the peer, for namespaces whose
+	// NetworkPolicies isolate the egress too. Only the ingress is allowed
+	// by default.
+	Egress bool `json:"egress,omitempty"`
+	// EgressFirewall also allows the egress to the peer in the
+	// EgressFirewall of the namespaces. There is a single EgressFirewall per
+	// namespace, the rules of an existing one are kept after the added
+	// ones.
+	EgressFirewall bool `json:"egressFirewall,omitempty"`
+	// Hosts are the DNS names of each cluster, by cluster name, that its
+	// peer reaches through the ingress of the cluster, like the S3 route of
+	// the object store. The clusters without hosts use the S3 route
+	// s3-openshift-storage in the ingress domain of the cluster.
+	Hosts map[string][]string `json:"hosts,omitempty"`
+
+	// policies are the NetworkPolicies of the namespaces, by cluster and
+	// namespace, read by withPolicies.
+	policies map[string]map[string][]existingNetworkPolicy
+}
+
+func (c *drNetworkConfig) validate() error {
+	for cluster, cidrs := range c.CIDRs {
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid CIDR %q of cluster %s: %v", cidr, cluster, err)
+			}
+		}
+	}
+
+	for cluster, hosts := range c.Hosts {
+		for _, host := range hosts {
+			if host == "" || strings.ContainsAny(host, ":/") {
+				return fmt.Errorf("invalid host %q of cluster %s, expected a DNS name without scheme and port", host, cluster)
+			}
+		}
+	}
+
+	if len(c.Namespaces) == 0 {
+		c.Namespaces = defaultDRNetworkNamespaces
+	}
+
+	return nil
+}
+
+// withDiscoveredCIDRs returns the configuration with the pod and service
+// networks of the clusters without CIDRs added.
+func (c *drNetworkConfig) withDiscoveredCIDRs(names, kconfigs []string) (*drNetworkConfig, error) {
+	network := *c
+	network.CIDRs = map[string][]string{}
+	for cluster, cidrs := range c.CIDRs {
+		network.CIDRs[cluster] = cidrs
+	}
+
+	for i, name := range names {
+		if len(network.CIDRs[name]) > 0 {
+			continue
+		}
+
+		cidrs, err := clusterCIDRs(kconfigs[i])
+		if err != nil {
+			return nil, fmt.Errorf("error getting the networks of cluster %s: %v", name, err)
+		}
+		network.CIDRs[name] = cidrs
+	}
+
+	return &network, nil
+}
+
+// withPolicies returns the configuration with the NetworkPolicies of the
+// namespaces of the clusters, which the exceptions are added for.
+func (c *drNetworkConfig) withPolicies(names, kconfigs []string) (*drNetworkConfig, error) {
+	network := *c
+	network.policies = map[string]map[string][]existingNetworkPolicy{}
+	for i, name := range names {
+		network.policies[name] = map[string][]existingNetworkPolicy{}
+		for _, ns := range c.Namespaces {
+			var policies struct {
+				Items []existingNetworkPolicy `json:"items"`
+			}
+			if _, err := getJSON(kconfigs[i], &policies, "networkpolicies.networking.k8s.io", "-n", ns); err != nil {
+				return nil, fmt.Errorf("error getting the NetworkPolicies of namespace %s of cluster %s: %v", ns, name, err)
+			}
+			network.policies[name][ns] = policies.Items
+		}
+	}
+
+	return &network, nil
+}
+
+// clusterCIDRs returns the pod and service networks of a cluster.
+func clusterCIDRs(kconfig string) ([]string, error) {
+	var network struct {
+		Status struct {
+			ClusterNetwork []struct {
+				CIDR string `json:"cidr"`
+			} `json:"clusterNetwork"`
+			ServiceNetwork []string `json:"serviceNetwork"`
+		} `json:"status"`
+	}
+	found, err := getJSON(kconfig, &network, "network.config.openshift.io", "cluster")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("network configuration not found")
+	}
+
+	cidrs := []string{}
+	for _, clusterNetwork := range network.Status.ClusterNetwork {
+		cidrs = append(cidrs, clusterNetwork.CIDR)
+	}
+	cidrs = append(cidrs, network.Status.ServiceNetwork...)
+
+	return cidrs, nil
+}
+
+type ipBlock struct {
+	CIDR string `json:"cidr"`
+}
+
+type networkPolicyPeer struct {
+	IPBlock ipBlock `json:"ipBlock"`
+}
+
+type networkPolicyIngressRule struct {
+	From []networkPolicyPeer `json:"from"`
+}
+
+type networkPolicyEgressRule struct {
+	To []networkPolicyPeer `json:"to"`
+}
+
+type networkPolicy struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		PodSelector json.RawMessage            `json:"podSelector"`
+		PolicyTypes []string                   `json:"policyTypes"`
+		Ingress     []networkPolicyIngressRule `json:"ingress,omitempty"`
+		Egress      []networkPolicyEgressRule  `json:"egress,omitempty"`
+	} `json:"spec"`
+}
+
+// existingNetworkPolicy is a NetworkPolicy of a cluster, of which only the
+// pods it selects and the directions it isolates matter.
+type existingNetworkPolicy struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		PodSelector json.RawMessage   `json:"podSelector"`
+		PolicyTypes []string          `json:"policyTypes"`
+		Egress      []json.RawMessage `json:"egress"`
+	} `json:"spec"`
+}
+
+// isolates reports whether the policy isolates the pods it selects in the
+// direction, Ingress or Egress. Without policyTypes, a policy isolates the
+// ingress, and the egress if it has egress rules.
+func (p existingNetworkPolicy) isolates(direction string) bool {
+	if len(p.Spec.PolicyTypes) > 0 {
+		return slices.Contains(p.Spec.PolicyTypes, direction)
+	}
+
+	return direction == "Ingress" || len(p.Spec.Egress) > 0
+}
+
+type egressFirewallRule struct {
+	Type string `json:"type"`
+	To   struct {
+		CIDRSelector string `json:"cidrSelector,omitempty"`
+		DNSName      string `json:"dnsName,omitempty"`
+	} `json:"to"`
+}
+
+type egressFirewall struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		// Egress holds the rules as they are, the rules of an existing
+		// EgressFirewall may use fields the installer does not know.
+		Egress []json.RawMessage `json:"egress"`
+	} `json:"spec"`
+}
+
+// drPeer is the peer of a managed cluster the DR traffic is allowed with.
+type drPeer struct {
+	cidrs []string
+	// hosts are reached through the ingress of the peer, addresses are
+	// the addresses they resolved to.
+	hosts     []string
+	addresses []string
+}
+
+// s3RouteHost returns the host of the S3 route of the object store of a
+// managed cluster, in the ingress domain next to the host of its API URL.
+func s3RouteHost(kconfig, cluster string) (string, error) {
+	mc, err := getManagedCluster(kconfig, cluster)
+	if err != nil {
+		return "", err
+	}
+	if len(mc.Spec.ManagedClusterClientConfigs) == 0 {
+		return "", fmt.Errorf("ManagedCluster %s has no API URL", cluster)
+	}
+
+	host := mc.Spec.ManagedClusterClientConfigs[0].URL
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	host, _, _ = strings.Cut(host, "/")
+	host, _, _ = strings.Cut(host, ":")
+
+	domain, ok := strings.CutPrefix(host, "api.")
+	if !ok {
+		return "", fmt.Errorf("API host %s of ManagedCluster %s does not start with api.", host, cluster)
+	}
+
+	return "s3-" + defaultStorageNamespace + ".apps." + domain, nil
+}
+
+// resolvePeer returns the peer of a cluster of the pair with the hosts of the
+// peer and the addresses they resolve to, the ingress VIP of the peer.
+func (c *drNetworkConfig) resolvePeer(kconfig, peer string) (drPeer, error) {
+	p := drPeer{cidrs: c.CIDRs[peer], hosts: c.Hosts[peer]}
+	if len(p.cidrs) == 0 {
+		return p, fmt.Errorf("no CIDRs of cluster %s, add them to dr.network.cidrs", peer)
+	}
+
+	if len(p.hosts) == 0 {
+		host, err := s3RouteHost(kconfig, peer)
+		if err != nil {
+			return p, fmt.Errorf("error getting the S3 route of cluster %s, add its hosts to dr.network.hosts: %v", peer, err)
+		}
+		p.hosts = []string{host}
+	}
+
+	for _, host := range p.hosts {
+		addresses, err := net.LookupHost(host)
+		if err != nil {
+			slog.Warn("error resolving host of the peer, the NetworkPolicies do not allow the egress to it", "cluster", peer, "host", host, "error", err)
+			continue
+		}
+
+		for _, address := range addresses {
+			cidr := address + "/32"
+			if strings.Contains(address, ":") {
+				cidr = address + "/128"
+			}
+			if !slices.Contains(p.addresses, cidr) {
+				p.addresses = append(p.addresses, cidr)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func (r egressFirewallRule) raw() json.RawMessage {
+	// Strings can always be encoded.
+	data, _ := json.Marshal(r)
+
+	return data
+}
+
+// sameRule reports whether two EgressFirewall rules are the same, regardless
+// of the order of their fields.
+func sameRule(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// drEgressFirewall returns the EgressFirewall of a namespace allowing the
+// egress to the peer before the rules of the existing EgressFirewall, if any.
+// The Deny rules of the existing one are kept, rules are applied in order.
+func drEgressFirewall(ns string, peer drPeer, existing *egressFirewall) egressFirewall {
+	firewall := egressFirewall{
+		APIVersion: "k8s.ovn.org/v1",
+		Kind:       "EgressFirewall",
+		// OVN-Kubernetes only honors the EgressFirewall named default.
+		Metadata: objectMeta{Name: "default", Namespace: ns},
+	}
+
+	for _, cidr := range peer.cidrs {
+		rule := egressFirewallRule{Type: "Allow"}
+		rule.To.CIDRSelector = cidr
+		firewall.Spec.Egress = append(firewall.Spec.Egress, rule.raw())
+	}
+	for _, host := range peer.hosts {
+		rule := egressFirewallRule{Type: "Allow"}
+		rule.To.DNSName = host
+		firewall.Spec.Egress = append(firewall.Spec.Egress, rule.raw())
+	}
+
+	if existing == nil {
+		return firewall
+	}
+
+	// The rules added by an earlier run are in the existing one already.
+	added := slices.Clone(firewall.Spec.Egress)
+	for _, rule := range existing.Spec.Egress {
+		if !slices.ContainsFunc(added, func(a json.RawMessage) bool { return sameRule(a, rule) }) {
+			firewall.Spec.Egress = append(firewall.Spec.Egress, rule)
+		}
+	}
+
+	return firewall
+}
+
+// drNetworkManifestWork returns the ManifestWork that allows the traffic of
+// the DR namespaces of a managed cluster from the CIDRs of its peer, and with
+// egress, to the CIDRs and the ingress of the peer. The NetworkPolicies of a
+// pod allow what any of them allows, so every existing policy isolating pods
+// gets an exception for the same pods and directions, which opens the peer
+// traffic without isolating pods or directions that were not isolated. It
+// returns false when no namespace needs an exception.
+func drNetworkManifestWork(cluster string, peer drPeer, cfg *drNetworkConfig) (manifestWork, bool) {
+	work := manifestWork{
+		APIVersion: "work.open-cluster-management.io/v1",
+		Kind:       "ManifestWork",
+		Metadata:   objectMeta{Name: "odfdr-installer-dr-network", Namespace: cluster},
+	}
+	work.Spec.DeleteOption.PropagationPolicy = "Foreground"
+
+	from := []networkPolicyPeer{}
+	for _, cidr := range peer.cidrs {
+		from = append(from, networkPolicyPeer{IPBlock: ipBlock{CIDR: cidr}})
+	}
+	to := slices.Clone(from)
+	for _, cidr := range peer.addresses {
+		to = append(to, networkPolicyPeer{IPBlock: ipBlock{CIDR: cidr}})
+	}
+
+	for _, ns := range cfg.Namespaces {
+		added := 0
+		for _, existing := range cfg.policies[cluster][ns] {
+			if strings.HasPrefix(existing.Metadata.Name, drNetworkPolicyName) {
+				continue
+			}
+
+			policy := networkPolicy{
+				APIVersion: "networking.k8s.io/v1",
+				Kind:       "NetworkPolicy",
+				Metadata:   objectMeta{Name: drNetworkPolicyName + "-" + existing.Metadata.Name, Namespace: ns},
+			}
+			policy.Spec.PodSelector = existing.Spec.PodSelector
+			if len(policy.Spec.PodSelector) == 0 {
+				policy.Spec.PodSelector = json.RawMessage("{}")
+			}
+			policy.Spec.PolicyTypes = []string{}
+			if existing.isolates("Ingress") {
+				policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, "Ingress")
+				policy.Spec.Ingress = []networkPolicyIngressRule{{From: from}}
+			}
+			if cfg.Egress && existing.isolates("Egress") {
+				policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, "Egress")
+				policy.Spec.Egress = []networkPolicyEgressRule{{To: to}}
+			}
+			if len(policy.Spec.PolicyTypes) == 0 {
+				continue
+			}
+
+			work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests, policy)
+			added++
+		}
+
+		if added == 0 {
+			slog.Info("no NetworkPolicy isolates the DR traffic, not adding an exception", "cluster", cluster, "namespace", ns)
+		}
+	}
+
+	return work, len(work.Spec.Workload.Manifests) > 0
+}
+
+// drEgressFirewallManifestWork returns the ManifestWork that applies the
+// EgressFirewalls of the DR namespaces of a managed cluster. The
+// EgressFirewalls hold the rules of the user too, so they are left in place
+// when the ManifestWork is deleted.
+func drEgressFirewallManifestWork(cluster string, firewalls []egressFirewall) manifestWork {
+	work := manifestWork{
+		APIVersion: "work.open-cluster-management.io/v1",
+		Kind:       "ManifestWork",
+		Metadata:   objectMeta{Name: "odfdr-installer-dr-egress-firewall", Namespace: cluster},
+	}
+	work.Spec.DeleteOption.PropagationPolicy = "Orphan"
+	for _, firewall := range firewalls {
+		work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests, firewall)
+	}
+
+	return work
+}
+
+// drEgressFirewalls returns the EgressFirewalls of the DR namespaces of a
+// managed cluster, with the rules of the existing EgressFirewalls, read
+// through the hub, kept after the rules allowing the egress to the peer.
+func drEgressFirewalls(hubName, kconfig, cluster string, peer drPeer, cfg *drNetworkConfig) ([]egressFirewall, error) {
+	firewalls := []egressFirewall{}
+	for _, ns := range cfg.Namespaces {
+		var existing egressFirewall
+		found, err := viewOnCluster(hubName, kconfig, cluster, "odfdr-egressfirewall-"+ns, "k8s.ovn.org", "v1", "EgressFirewall", ns, "default", &existing)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the EgressFirewall of namespace %s of cluster %s: %v", ns, cluster, err)
+		}
+
+		if !found {
+			firewalls = append(firewalls, drEgressFirewall(ns, peer, nil))
+			continue
+		}
+
+		slog.Info("keeping the rules of the existing EgressFirewall", "cluster", cluster, "namespace", ns, "rules", len(existing.Spec.Egress))
+		firewalls = append(firewalls, drEgressFirewall(ns, peer, &existing))
+	}
+
+	return firewalls, nil
+}
+
+// applyDRNetworkPolicies allows the DR traffic between the clusters of a pair
+// through ManifestWorks on the hub.
+func applyDRNetworkPolicies(hubName, kconfig string, clusters []string, cfg *drNetworkConfig) error {
+	if cfg.policies == nil {
+		return fmt.Errorf("the NetworkPolicies of the clusters were not read, which the exceptions are added for")
+	}
+
+	works := list{APIVersion: "v1", Kind: "List"}
+	for i, cluster := range clusters {
+		peer, err := cfg.resolvePeer(kconfig, clusters[1-i])
+		if err != nil {
+			return err
+		}
+
+		work, ok := drNetworkManifestWork(cluster, peer, cfg)
+		if ok {
+			works.Items = append(works.Items, work)
+		} else if err := ocCommand(kconfig, "delete", "manifestwork", work.Metadata.Name, "-n", cluster, "--ignore-not-found").Run(); err != nil {
+			// The exceptions of an earlier run are removed.
+			return fmt.Errorf("error deleting ManifestWork %s of cluster %s: %v", work.Metadata.Name, cluster, err)
+		}
+
+		if cfg.EgressFirewall {
+			firewalls, err := drEgressFirewalls(hubName, kconfig, cluster, peer, cfg)
+			if err != nil {
+				return err
+			}
+			works.Items = append(works.Items, drEgressFirewallManifestWork(cluster, firewalls))
+		}
+	}
+
+	if len(works.Items) == 0 {
+		slog.Info("no DR network policy exceptions needed", "clusters", clusters, "namespaces", cfg.Namespaces)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(works, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ManifestWorks: %v", err)
+	}
+
+	fileName := hubName + "-" + strings.Join(clusters, "-") + "-dr-network-manifestworks.json"
+	if err := writeArtifact(hubName, fileName, data); err != nil {
+		return fmt.Errorf("error writing ManifestWorks to file: %v", err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying ManifestWorks: %v", err)
+	}
+
+	slog.Info("applied DR network policy exceptions", "clusters", clusters, "namespaces", cfg.Namespaces, "egress", cfg.Egress, "egressFirewall", cfg.EgressFirewall)
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// challengingClient is the OAuth client of the OpenShift OAuth server that
+// answers basic auth challenges with a token, as used by oc login.
+const challengingClient = "openshift-challenging-client"
+
+type kubeconfigFile struct {
+	APIVersion     string              `json:"apiVersion"`
+	Kind           string              `json:"kind"`
+	Clusters       []kubeconfigCluster `json:"clusters"`
+	Users          []kubeconfigUser    `json:"users"`
+	Contexts       []kubeconfigContext `json:"contexts"`
+	CurrentContext string              `json:"current-context"`
+}
+
+type kubeconfigCluster struct {
+	Name    string `json:"name"`
+	Cluster struct {
+		Server                   string `json:"server"`
+		CertificateAuthorityData []byte `json:"certificate-authority-data,omitempty"`
+		InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
+	} `json:"cluster"`
+}
+
+type kubeconfigUser struct {
+	Name string `json:"name"`
+	User struct {
+		Token string `json:"token"`
+	} `json:"user"`
+}
+
+type kubeconfigContext struct {
+	Name    string `json:"name"`
+	Context struct {
+		Cluster   string `json:"cluster"`
+		User      string `json:"user"`
+		Namespace string `json:"namespace"`
+	} `json:"context"`
+}
+
+// apiServerURL returns the API URL with a scheme, which the -api-url flag can
+// omit like for oc login.
+func apiServerURL(server string) string {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+
+	return strings.TrimSuffix(server, "/")
+}
+
+// consoleAPIURL returns the API URL of the cluster of a URL of one of its
+// routes, like the web console URL
+// https://console-openshift-console.apps.cluster.example.com, which users
+// often paste instead of the API URL. It returns false for other URLs.
+func consoleAPIURL(server string) (string, bool) {
+	parsed, err := url.Parse(apiServerURL(server))
+	if err != nil {
+		return "", false
+	}
+
+	// Routes are exposed under the apps subdomain of the cluster domain,
+	// the API server under the api subdomain.
+	labels := strings.Split(parsed.Hostname(), ".")
+	if len(labels) < 4 || labels[1] != "apps" || parsed.Port() == "6443" {
+		return "", false
+	}
+
+	return "https://api." + strings.Join(labels[2:], ".") + ":6443", true
+}
+
+// resolveAPIURL returns the API URL to log in to for a URL given by the user.
+// Console URLs are translated to the API URL of the cluster, which is checked
+// to answer, other URLs are returned as they are.
+func resolveAPIURL(server string) (string, error) {
+	apiURL, isConsole := consoleAPIURL(server)
+	if !isConsole {
+		return server, nil
+	}
+
+	// Recorded and replayed runs do not talk to the cluster directly.
+	if fixtureMode == "" {
+		resp, err := connectionFor("").httpClient().Get(apiURL + "/version")
+		if err != nil {
+			return "", fmt.Errorf("%s looks like a console URL, but the API URL %s derived from it does not answer: %v", server, apiURL, err)
+		}
+		resp.Body.Close()
+	}
+
+	slog.Info("using the API URL of the console URL", "url", server, "apiURL", apiURL)
+
+	return apiURL, nil
+}
+
+// requestOAuthToken requests a token from the OAuth server of the cluster
+// with the basic auth challenge flow of oc login, without oc.
+func requestOAuthToken(server, username, password string, connection connectionSettings) (string, error) {
+	client := connection.httpClient()
+	// The token is returned in the fragment of the redirect.
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(server + "/.well-known/oauth-authorization-server")
+	if err != nil {
+		return "", fmt.Errorf("error discovering OAuth server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error discovering OAuth server: %s", resp.Status)
+	}
+
+	var metadata struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("error parsing OAuth server metadata: %v", err)
+	}
+
+	authorizeURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil || authorizeURL.Host == "" {
+		return "", fmt.Errorf("invalid OAuth authorization endpoint %q", metadata.AuthorizationEndpoint)
+	}
+	query := authorizeURL.Query()
+	query.Set("response_type", "token")
+	query.Set("client_id", challengingClient)
+	authorizeURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, authorizeURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating OAuth request: %v", err)
+	}
+	req.SetBasicAuth(username, password)
+	// The OAuth server only answers challenges of requests that cannot come
+	// from a browser form.
+	req.Header.Set("X-CSRF-Token", "1")
+
+	authResp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting OAuth token: %v", err)
+	}
+	defer authResp.Body.Close()
+
+	if authResp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("login failed, verify the username and password")
+	}
+
+	location, err := authResp.Location()
+	if err != nil {
+		return "", fmt.Errorf("error requesting OAuth token: unexpected response %s", authResp.Status)
+	}
+
+	if oauthErr := location.Query().Get("error"); oauthErr != "" {
+		return "", fmt.Errorf("error requesting OAuth token: %s: %s", oauthErr, location.Query().Get("error_description"))
+	}
+
+	values, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		return "", fmt.Errorf("error parsing OAuth token response: %v", err)
+	}
+
+	token := values.Get("access_token")
+	if token == "" {
+		return "", fmt.Errorf("error requesting OAuth token: no token in response")
+	}
+
+	return token, nil
+}
+
+// writeKubeconfig writes a kubeconfig for the token to fileName, like the one
+// written by oc login, with the TLS settings of the connection.
+func writeKubeconfig(fileName, server, username, token string, connection connectionSettings) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid API URL %q: %v", server, err)
+	}
+	clusterName := strings.NewReplacer(".", "-", ":", "-").Replace(u.Host)
+	userName := username + "/" + clusterName
+	contextName := "default/" + clusterName + "/" + username
+
+	cluster := kubeconfigCluster{Name: clusterName}
+	cluster.Cluster.Server = server
+	cluster.Cluster.CertificateAuthorityData = connection.caData
+	cluster.Cluster.InsecureSkipTLSVerify = connection.InsecureSkipTLSVerify
+	user := kubeconfigUser{Name: userName}
+	user.User.Token = token
+	context := kubeconfigContext{Name: contextName}
+	context.Context.Cluster = clusterName
+	context.Context.User = userName
+	context.Context.Namespace = "default"
+
+	kc := kubeconfigFile{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeconfigCluster{cluster},
+		Users:          []kubeconfigUser{user},
+		Contexts:       []kubeconfigContext{context},
+		CurrentContext: contextName,
+	}
+
+	data, err := json.MarshalIndent(kc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding kubeconfig: %v", err)
+	}
+
+	// The kubeconfig holds the token.
+	if err := writePrivateFile(fileName, data); err != nil {
+		return fmt.Errorf("error writing kubeconfig: %v", err)
+	}
+
+	return nil
+}
+
+// oauthLogin logs into the cluster like oc login and writes the kubeconfig.
+func oauthLogin(server, username, password, kconfig string) error {
+	server = apiServerURL(server)
+
+	connection := connectionFor(kconfig)
+	token, err := requestOAuthToken(server, username, password, connection)
+	if err != nil {
+		return err
+	}
+
+	return writeKubeconfig(kconfig, server, username, token, connection)
+}
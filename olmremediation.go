@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// resolutionFailedGrace is how long a Subscription may report
+	// ResolutionFailed before it is remediated. OLM retries the resolution
+	// itself, which usually succeeds once a catalog update settled.
+	resolutionFailedGrace = 5 * time.Minute
+	// olmNamespace runs the OLM catalog operator, which resolves the
+	// Subscriptions.
+	olmNamespace = "openshift-operator-lifecycle-manager"
+	// bundleUnpackRefLabel labels the bundle unpack jobs of OLM with the
+	// ConfigMap the bundle is unpacked to.
+	bundleUnpackRefLabel = "operatorframework.io/bundle-unpack-ref"
+)
+
+// resolutionRemediation remediates a Subscription stuck in ResolutionFailed,
+// a known OLM failure mode after catalog updates. The first remediation
+// deletes the failed unpack jobs of its bundles and restarts the catalog
+// operator, the second one recreates the Subscription. The wait then goes on
+// without further remediations.
+type resolutionRemediation struct {
+	kconfig   string
+	namespace string
+	operator  operatorConfig
+	// manifestFile holds the Subscription, which is applied again to
+	// recreate it.
+	manifestFile string
+	report       *clusterReport
+
+	// failedSince is when the Subscription was first seen in
+	// ResolutionFailed, zero while it is not.
+	failedSince time.Time
+	attempts    int
+	// message is the last ResolutionFailed message, for the error of a
+	// wait that times out.
+	message string
+}
+
+// check remediates the Subscription when it reported ResolutionFailed for
+// longer than resolutionFailedGrace.
+func (r *resolutionRemediation) check(sub *subscription) error {
+	var failed condition
+	if sub.Status != nil {
+		failed, _ = conditionStatus(sub.Status.Conditions, "ResolutionFailed")
+	}
+	if failed.Status != "True" {
+		r.failedSince = time.Time{}
+		return nil
+	}
+
+	r.message = failed.Message
+	if r.failedSince.IsZero() {
+		r.failedSince = time.Now()
+		slog.Warn("Subscription failed to resolve", "package", r.operator.Package, "reason", failed.Reason, "message", failed.Message)
+	}
+	if time.Since(r.failedSince) < resolutionFailedGrace || r.attempts == 2 {
+		return nil
+	}
+
+	r.attempts++
+	r.failedSince = time.Time{}
+	if r.attempts == 1 {
+		return r.restartResolution(sub)
+	}
+
+	return r.recreateSubscription()
+}
+
+// bundleLookup is a bundle the InstallPlan of a Subscription unpacks.
+type bundleLookup struct {
+	// Path is the bundle image.
+	Path             string     `json:"path"`
+	CatalogSourceRef objectMeta `json:"catalogSourceRef"`
+}
+
+// unpackJob is a bundle unpack job of OLM, which pulls the bundle image in
+// one of its containers.
+type unpackJob struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Template struct {
+			Spec struct {
+				InitContainers []struct {
+					Image string `json:"image"`
+				} `json:"initContainers"`
+				Containers []struct {
+					Image string `json:"image"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []condition `json:"conditions"`
+	} `json:"status"`
+}
+
+// unpacks tells whether the job unpacks the bundle image.
+func (j *unpackJob) unpacks(bundle string) bool {
+	for _, c := range j.Spec.Template.Spec.InitContainers {
+		if c.Image == bundle {
+			return true
+		}
+	}
+	for _, c := range j.Spec.Template.Spec.Containers {
+		if c.Image == bundle {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bundleLookups returns the bundles the InstallPlan of the Subscription
+// unpacks, none when it has no InstallPlan yet.
+func (r *resolutionRemediation) bundleLookups(sub *subscription) ([]bundleLookup, error) {
+	if sub.Status == nil || sub.Status.InstallPlanRef == nil {
+		return nil, nil
+	}
+	ref := sub.Status.InstallPlanRef
+
+	var plan struct {
+		Status struct {
+			BundleLookups []bundleLookup `json:"bundleLookups"`
+		} `json:"status"`
+	}
+	found, err := getJSON(r.kconfig, &plan, "installplans.operators.coreos.com/"+ref.Name, "-n", ref.Namespace)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return plan.Status.BundleLookups, nil
+}
+
+// ownedByJob tells whether the ConfigMap exists and is owned by the job.
+func (r *resolutionRemediation) ownedByJob(namespace, name, job string) (bool, error) {
+	var cm struct {
+		Metadata struct {
+			OwnerReferences []ownerReference `json:"ownerReferences"`
+		} `json:"metadata"`
+	}
+	found, err := getJSON(r.kconfig, &cm, "configmap/"+name, "-n", namespace)
+	if err != nil || !found {
+		return false, err
+	}
+
+	for _, owner := range cm.Metadata.OwnerReferences {
+		if owner.Kind == "Job" && owner.Name == job {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// restartResolution deletes the failed unpack jobs of the bundles of the
+// Subscription, with their pods and the ConfigMaps they own, and the catalog
+// operator pods, so that OLM resolves the Subscription from scratch. The
+// unpack jobs of other Subscriptions are left alone.
+func (r *resolutionRemediation) restartResolution(sub *subscription) error {
+	lookups, err := r.bundleLookups(sub)
+	if err != nil {
+		return err
+	}
+
+	for _, lookup := range lookups {
+		namespace := lookup.CatalogSourceRef.Namespace
+		if namespace == "" {
+			namespace = "openshift-marketplace"
+		}
+
+		var jobs struct {
+			Items []unpackJob `json:"items"`
+		}
+		if _, err := getJSON(r.kconfig, &jobs, "jobs", "-n", namespace, "-l", bundleUnpackRefLabel); err != nil {
+			return err
+		}
+
+		for _, job := range jobs.Items {
+			if failed, ok := conditionStatus(job.Status.Conditions, "Failed"); !ok || failed.Status != "True" || !job.unpacks(lookup.Path) {
+				continue
+			}
+
+			resources := []string{"job/" + job.Metadata.Name}
+			// The ConfigMap of the bundle is named by the label of the
+			// job, it is only deleted with the job that owns it.
+			if cmName := job.Metadata.Labels[bundleUnpackRefLabel]; cmName != "" {
+				owned, err := r.ownedByJob(namespace, cmName, job.Metadata.Name)
+				if err != nil {
+					return err
+				}
+				if owned {
+					resources = append(resources, "configmap/"+cmName)
+				}
+			}
+
+			deleteArgs := append([]string{"delete"}, resources...)
+			deleteCmd := ocCommand(r.kconfig, append(deleteArgs, "-n", namespace, "--ignore-not-found")...)
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting bundle unpack job %s: %v", job.Metadata.Name, err)
+			}
+			r.report.recordRemediation("deleted failed bundle unpack job %s/%s of bundle %s for %s", namespace, job.Metadata.Name,
+				lookup.Path, r.operator.Package)
+		}
+	}
+
+	deleteCmd := ocCommand(r.kconfig, "delete", "pods", "-n", olmNamespace, "-l", "app=catalog-operator", "--wait=false")
+	if err := deleteCmd.Run(); err != nil {
+		return fmt.Errorf("error restarting the OLM catalog operator: %v", err)
+	}
+	r.report.recordRemediation("restarted the OLM catalog operator, Subscription %s/%s failed to resolve: %s",
+		r.namespace, r.operator.Package, r.message)
+
+	return nil
+}
+
+// recreateSubscription deletes the Subscription, with its CSV unless it
+// succeeded, and applies it again.
+func (r *resolutionRemediation) recreateSubscription() error {
+	var sub subscription
+	found, err := getJSON(r.kconfig, &sub, "subscriptions.operators.coreos.com", r.operator.Package, "-n", r.namespace)
+	if err != nil {
+		return err
+	}
+
+	if found && sub.Status != nil && sub.Status.CurrentCSV != "" {
+		var csv clusterServiceVersion
+		found, err := getJSON(r.kconfig, &csv, "clusterserviceversions", sub.Status.CurrentCSV, "-n", r.namespace)
+		if err != nil {
+			return err
+		}
+		if found && csv.Status.Phase != "Succeeded" {
+			deleteCmd := ocCommand(r.kconfig, "delete", "clusterserviceversions", sub.Status.CurrentCSV, "-n", r.namespace, "--ignore-not-found")
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting ClusterServiceVersion %s: %v", sub.Status.CurrentCSV, err)
+			}
+		}
+	}
+
+	deleteCmd := ocCommand(r.kconfig, "delete", "subscriptions.operators.coreos.com", r.operator.Package, "-n", r.namespace, "--ignore-not-found")
+	if err := deleteCmd.Run(); err != nil {
+		return fmt.Errorf("error deleting Subscription %s: %v", r.operator.Package, err)
+	}
+
+	if _, err := applyManifest(r.kconfig, r.manifestFile); err != nil {
+		return fmt.Errorf("error applying Subscription for %s: %v", r.operator.Package, err)
+	}
+	r.report.recordRemediation("recreated Subscription %s/%s, it still failed to resolve: %s", r.namespace, r.operator.Package, r.message)
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	installationGroup    = "installer.odfdr.io"
+	installationResource = "odfdrinstallations." + installationGroup
+
+	// kubeconfigSecretKey is the key of the kubeconfig in the Secrets of
+	// the managed clusters, and rhcephPasswordSecretKey the key of the
+	// RHCEPH password.
+	kubeconfigSecretKey     = "kubeconfig"
+	rhcephPasswordSecretKey = "password"
+)
+
+// odfdrInstallation is the desired DR setup of a hub, reconciled by the
+// operator mode. It describes the hub of a fleet file, with the kubeconfigs of
+// the managed clusters in Secrets.
+type odfdrInstallation struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterLabels map[string]string  `json:"clusterLabels,omitempty"`
+		Pairs         []installationPair `json:"pairs"`
+		// RHCEPHPasswordSecret is a Secret in the namespace of the
+		// installation with the RHCEPH password under the password key.
+		// Without it, the RHCEPH registry auth is not added, for public
+		// catalogs.
+		RHCEPHPasswordSecret string   `json:"rhcephPasswordSecret,omitempty"`
+		CatalogImage         string   `json:"catalogImage,omitempty"`
+		MirrorSets           []string `json:"mirrorSets,omitempty"`
+		PullSecretMode       string   `json:"pullSecretMode,omitempty"`
+		// Config is the installer configuration, like the -config file.
+		Config json.RawMessage `json:"config,omitempty"`
+	} `json:"spec"`
+	Status installationStatus `json:"status"`
+}
+
+type installationPair struct {
+	Clusters []installationCluster `json:"clusters"`
+}
+
+type installationCluster struct {
+	// Name is the name of the ManagedCluster.
+	Name string `json:"name"`
+	// KubeconfigSecret is a Secret in the namespace of the installation
+	// with the kubeconfig of the cluster under the kubeconfig key.
+	KubeconfigSecret string `json:"kubeconfigSecret"`
+}
+
+type installationStatus struct {
+	Phase              string `json:"phase,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	RunID              string `json:"runId,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastRunTime        string `json:"lastRunTime,omitempty"`
+}
+
+type jsonSchema struct {
+	Type                  string                `json:"type"`
+	Properties            map[string]jsonSchema `json:"properties,omitempty"`
+	PreserveUnknownFields bool                  `json:"x-kubernetes-preserve-unknown-fields,omitempty"`
+}
+
+type printerColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	JSONPath string `json:"jsonPath"`
+}
+
+type crdVersion struct {
+	Name    string `json:"name"`
+	Served  bool   `json:"served"`
+	Storage bool   `json:"storage"`
+	Schema  struct {
+		OpenAPIV3Schema jsonSchema `json:"openAPIV3Schema"`
+	} `json:"schema"`
+	Subresources struct {
+		Status struct{} `json:"status"`
+	} `json:"subresources"`
+	AdditionalPrinterColumns []printerColumn `json:"additionalPrinterColumns"`
+}
+
+type customResourceDefinition struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind     string `json:"kind"`
+			ListKind string `json:"listKind"`
+			Plural   string `json:"plural"`
+			Singular string `json:"singular"`
+		} `json:"names"`
+		Scope    string       `json:"scope"`
+		Versions []crdVersion `json:"versions"`
+	} `json:"spec"`
+}
+
+// installationCRD returns the CustomResourceDefinition of ODFDRInstallation.
+// The spec is validated by the operator, like a fleet file.
+func installationCRD() customResourceDefinition {
+	crd := customResourceDefinition{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition",
+		Metadata: objectMeta{Name: installationResource}}
+	crd.Spec.Group = installationGroup
+	crd.Spec.Names.Kind = "ODFDRInstallation"
+	crd.Spec.Names.ListKind = "ODFDRInstallationList"
+	crd.Spec.Names.Plural = "odfdrinstallations"
+	crd.Spec.Names.Singular = "odfdrinstallation"
+	crd.Spec.Scope = "Namespaced"
+
+	version := crdVersion{Name: "v1alpha1", Served: true, Storage: true}
+	version.Schema.OpenAPIV3Schema = jsonSchema{Type: "object", Properties: map[string]jsonSchema{
+		"spec":   {Type: "object", PreserveUnknownFields: true},
+		"status": {Type: "object", PreserveUnknownFields: true},
+	}}
+	version.AdditionalPrinterColumns = []printerColumn{
+		{Name: "Phase", Type: "string", JSONPath: ".status.phase"},
+		{Name: "Run", Type: "string", JSONPath: ".status.runId"},
+		{Name: "Message", Type: "string", JSONPath: ".status.message"},
+	}
+	crd.Spec.Versions = []crdVersion{version}
+
+	return crd
+}
+
+func installCRD(kconfig string) error {
+	data, err := json.MarshalIndent(installationCRD(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding CustomResourceDefinition: %v", err)
+	}
+
+	fileName := "odfdrinstallation-crd.json"
+	if err := writeArtifact("", fileName, data); err != nil {
+		return fmt.Errorf("error writing CustomResourceDefinition to file: %v", err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error applying CustomResourceDefinition: %v", err)
+	}
+
+	return nil
+}
+
+// needsRun reports whether the installation changed since it was last run,
+// or failed and is due for a retry.
+func (inst *odfdrInstallation) needsRun(retryInterval time.Duration) bool {
+	if inst.Status.ObservedGeneration != inst.Metadata.Generation {
+		return true
+	}
+
+	if inst.Status.Phase != phaseFailed {
+		return false
+	}
+
+	lastRun, err := time.Parse(time.RFC3339, inst.Status.LastRunTime)
+	return err != nil || time.Since(lastRun) >= retryInterval
+}
+
+func updateInstallationStatus(kconfig string, inst *odfdrInstallation) error {
+	patch := struct {
+		Status installationStatus `json:"status"`
+	}{inst.Status}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("error encoding status: %v", err)
+	}
+
+	patchCmd := ocCommand(kconfig, "patch", installationResource, inst.Metadata.Name, "-n", inst.Metadata.Namespace,
+		"--subresource=status", "--type=merge", "-p", string(data))
+	if err := patchCmd.Run(); err != nil {
+		return fmt.Errorf("error updating status of %s/%s: %v", inst.Metadata.Namespace, inst.Metadata.Name, err)
+	}
+
+	return nil
+}
+
+// secretValue returns a value of a Secret in the namespace of the
+// installation.
+func secretValue(kconfig, namespace, name, key string) ([]byte, error) {
+	var s secret
+	found, err := getJSON(kconfig, &s, "secret", name, "-n", namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found || len(s.Data[key]) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no %s", namespace, name, key)
+	}
+
+	return s.Data[key], nil
+}
+
+// runInstallation prepares the hub and the managed clusters of the
+// installation and peers the pairs, like fleet does for a hub of a fleet file.
+func runInstallation(hubName, kconfig string, inst *odfdrInstallation) (*runReport, error) {
+	ns := inst.Metadata.Namespace
+	report := newRunReport("operator")
+
+	cfg := &config{}
+	if len(inst.Spec.Config) > 0 {
+		var err error
+		if cfg, err = parseConfig(inst.Spec.Config); err != nil {
+			return report, err
+		}
+	}
+
+	// The Placements of the namespaces select the clusters of a single pair.
+	if cfg.DR != nil && cfg.DR.ClusterSet != nil && len(cfg.DR.ClusterSet.Namespaces) > 0 && len(inst.Spec.Pairs) > 1 {
+		return report, fmt.Errorf("dr.clusterSet.namespaces can only be used with one pair")
+	}
+
+	if err := validatePullSecretMode(inst.Spec.PullSecretMode); err != nil {
+		return report, err
+	}
+
+	var password []byte
+	if inst.Spec.RHCEPHPasswordSecret != "" {
+		var err error
+		password, err = secretValue(kconfig, ns, inst.Spec.RHCEPHPasswordSecret, rhcephPasswordSecretKey)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	catalogImage := inst.Spec.CatalogImage
+	mirrorSetNames := strings.Join(inst.Spec.MirrorSets, ",")
+	if len(inst.Spec.MirrorSets) == 0 {
+		mirrorSetNames = "odf,ceph"
+	}
+	manifests := &manifestOptions{catalogImage: &catalogImage, mirrorSets: &mirrorSetNames}
+	mirrorSets, err := manifests.loadMirrorSets()
+	if err != nil {
+		return report, err
+	}
+
+	hub := fleetHub{fleetCluster: fleetCluster{Name: hubName, Kubeconfig: kconfig}, ClusterLabels: inst.Spec.ClusterLabels}
+	for i, pair := range inst.Spec.Pairs {
+		if len(pair.Clusters) != 2 {
+			return report, fmt.Errorf("pair %d must have exactly two clusters", i)
+		}
+
+		fp := fleetPair{}
+		for _, cluster := range pair.Clusters {
+			data, err := secretValue(kconfig, ns, cluster.KubeconfigSecret, kubeconfigSecretKey)
+			if err != nil {
+				return report, err
+			}
+
+			file, err := getKubeconfig(cluster.Name)
+			if err != nil {
+				return report, fmt.Errorf("error creating kubeconfig file: %v", err)
+			}
+			defer os.Remove(file.Name())
+
+			if err := writePrivateFile(file.Name(), data); err != nil {
+				return report, fmt.Errorf("error writing kubeconfig file: %v", err)
+			}
+			fp.Clusters = append(fp.Clusters, fleetCluster{Name: cluster.Name, Kubeconfig: file.Name()})
+		}
+		hub.Pairs = append(hub.Pairs, fp)
+	}
+
+	r := &fleetRun{
+		opts: prepareOptions{
+			rhcephPassword:     string(password),
+			catalogSourceYAML:  manifests.catalogSourceYAML(cfg),
+			mirrorSets:         mirrorSets,
+			operators:          cfg.Operators,
+			scheduling:         cfg.Scheduling,
+			storage:            cfg.Storage,
+			waits:              cfg.Waits,
+			identity:           cfg.Identity,
+			pullSecretMode:     inst.Spec.PullSecretMode,
+			pullSecretConflict: pullSecretConflictOurs,
+			registryAuthMatch:  registryAuthMatchExact,
+			skipRegistryAuth:   inst.Spec.RHCEPHPasswordSecret == "",
+			// A stuck namespace needs a human, the installation is
+			// retried once it is gone.
+			terminatingNamespaces: terminatingNamespaceFail,
+		},
+		report: report,
+		config: cfg,
+	}
+
+	return report, r.runHub(hub)
+}
+
+// reconcileInstallation runs an installation that needs to run and records
+// the outcome in its status.
+func reconcileInstallation(hubName, kconfig string, inst *odfdrInstallation) error {
+	key := inst.Metadata.Namespace + "/" + inst.Metadata.Name
+	slog.Info("reconciling installation", "installation", key, "generation", inst.Metadata.Generation)
+
+	inst.Status.Phase = phaseInstalling
+	inst.Status.Message = ""
+	inst.Status.LastRunTime = time.Now().UTC().Format(time.RFC3339)
+	if err := updateInstallationStatus(kconfig, inst); err != nil {
+		return err
+	}
+
+	report, runErr := runInstallation(hubName, kconfig, inst)
+
+	reportFileName := inst.Metadata.Namespace + "-" + inst.Metadata.Name + "-report.json"
+	if err := report.finish(reportFileName, runErr); err != nil {
+		slog.Warn("error writing report", "installation", key, "error", err)
+	}
+
+	inst.Status.Phase = phaseSucceeded
+	inst.Status.ObservedGeneration = inst.Metadata.Generation
+	inst.Status.RunID = report.ID
+	if runErr != nil {
+		slog.Error("installation failed", "installation", key, "error", runErr)
+		inst.Status.Phase = phaseFailed
+		inst.Status.Message = messageText("run-failed", "action", "error running installation", "error", runErr.Error())
+	} else {
+		slog.Info("installation succeeded", "installation", key)
+	}
+
+	return updateInstallationStatus(kconfig, inst)
+}
+
+func runOperator(args []string) {
+	flags := flag.NewFlagSet("operator", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the hub cluster to reconcile the ODFDRInstallations of")
+	namespaceFlag := flags.String("namespace", "", "Namespace to reconcile the ODFDRInstallations of (default: all namespaces)")
+	intervalFlag := flags.Duration("interval", time.Minute, "How often to look for ODFDRInstallations to reconcile")
+	retryIntervalFlag := flags.Duration("retry-interval", 10*time.Minute, "How long to wait before retrying a failed ODFDRInstallation")
+	installCRDFlag := flags.Bool("install-crd", false, "Create or update the ODFDRInstallation CustomResourceDefinition before starting")
+	onceFlag := flags.Bool("once", false, "Reconcile once and exit instead of running until stopped")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addMessageFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
+		os.Exit(1)
+	}
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	kconfig := *kubeconfigFlag
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to hub", "error", err)
+		os.Exit(1)
+	}
+
+	hubName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting hub name", "error", err)
+		os.Exit(1)
+	}
+
+	if *installCRDFlag {
+		if err := installCRD(kconfig); err != nil {
+			slog.Error("error installing CustomResourceDefinition", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	scope := []string{"--all-namespaces"}
+	if *namespaceFlag != "" {
+		scope = []string{"-n", *namespaceFlag}
+	}
+
+	slog.Info("starting operator", "hub", hubName, "interval", *intervalFlag)
+
+	for {
+		var installations struct {
+			Items []odfdrInstallation `json:"items"`
+		}
+		if _, err := getJSON(kconfig, &installations, append([]string{installationResource}, scope...)...); err != nil {
+			slog.Error("error listing installations", "error", err)
+		}
+
+		for i := range installations.Items {
+			inst := &installations.Items[i]
+			if !inst.needsRun(*retryIntervalFlag) {
+				continue
+			}
+
+			if err := reconcileInstallation(hubName, kconfig, inst); err != nil {
+				slog.Error("error reconciling installation", "installation", inst.Metadata.Namespace+"/"+inst.Metadata.Name, "error", err)
+			}
+		}
+
+		if *onceFlag {
+			return
+		}
+		time.Sleep(*intervalFlag)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// drOperatorPackages are the operators whose versions have to line up across
+// the clusters of a DR setup.
+var drOperatorPackages = []string{
+	"odf-operator",
+	"odf-multicluster-orchestrator",
+	"odr-hub-operator",
+	"odr-cluster-operator",
+}
+
+type clusterServiceVersion struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Version string `json:"version"`
+	} `json:"spec"`
+	Status struct {
+		Phase  string `json:"phase"`
+		Reason string `json:"reason"`
+	} `json:"status"`
+}
+
+type clusterServiceVersionList struct {
+	Items []clusterServiceVersion `json:"items"`
+}
+
+// versionMismatch is an operator that is installed with different versions on
+// different clusters.
+type versionMismatch struct {
+	Package  string            `json:"package"`
+	Versions map[string]string `json:"versions"`
+}
+
+// getOperatorVersions returns the installed versions of the DR operators keyed
+// by package name. Operators that are not installed are omitted.
+func getOperatorVersions(kconfig string) (map[string]string, error) {
+	getArgs := append([]string{"get"}, withReadSelector([]string{"clusterserviceversions", "--all-namespaces", "-o", "json"})...)
+	getCSVCmd := ocCommand(kconfig, getArgs...)
+	csvOutput, err := getCSVCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting ClusterServiceVersions: %v", err)
+	}
+
+	var csvList clusterServiceVersionList
+	err = json.Unmarshal(csvOutput, &csvList)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ClusterServiceVersions: %v", err)
+	}
+
+	versions := map[string]string{}
+	for _, csv := range csvList.Items {
+		// Operators installed for all namespaces are copied into every
+		// namespace, only the original CSV is interesting.
+		if csv.Status.Reason == "Copied" {
+			continue
+		}
+
+		pkg, _, _ := strings.Cut(csv.Metadata.Name, ".v")
+		if slices.Contains(drOperatorPackages, pkg) {
+			versions[pkg] = csv.Spec.Version
+		}
+	}
+
+	return versions, nil
+}
+
+// compareOperatorVersions returns the operators that are installed with
+// different versions on the given clusters. Operators installed on only some of
+// the clusters (e.g. hub only operators) are compared among those clusters.
+func compareOperatorVersions(clusterVersions map[string]map[string]string) []versionMismatch {
+	mismatches := []versionMismatch{}
+
+	for _, pkg := range drOperatorPackages {
+		versions := map[string]string{}
+		for cluster, installed := range clusterVersions {
+			if version, ok := installed[pkg]; ok {
+				versions[cluster] = version
+			}
+		}
+
+		distinct := []string{}
+		for _, version := range versions {
+			if !slices.Contains(distinct, version) {
+				distinct = append(distinct, version)
+			}
+		}
+
+		if len(distinct) > 1 {
+			mismatches = append(mismatches, versionMismatch{Package: pkg, Versions: versions})
+		}
+	}
+
+	return mismatches
+}
+
+// sortedClusterNames returns the keys of a per cluster map in a stable order.
+func sortedClusterNames[T any](clusters map[string]T) []string {
+	names := []string{}
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+const (
+	overlayStrategic = "strategic"
+	overlayMerge     = "merge"
+	overlayJSON      = "json"
+)
+
+var overlayTypes = []string{overlayStrategic, overlayMerge, overlayJSON}
+
+// manifestOverlay is a patch of the configuration file applied to the
+// manifests generated by the installer before they are applied, for
+// customizations the installer has no setting for.
+type manifestOverlay struct {
+	Target overlayTarget `json:"target"`
+	// Type is strategic, merge (default) or json, like the --type of oc
+	// patch. Strategic merge patches only work for the built-in kinds.
+	Type string `json:"type,omitempty"`
+	// Patch is a merge patch object, or a JSON patch (RFC 6902) array.
+	Patch json.RawMessage `json:"patch"`
+}
+
+// overlayTarget selects the manifests an overlay applies to. Kind is
+// required, the other fields match any manifest when empty.
+type overlayTarget struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+func (t overlayTarget) String() string {
+	name := t.Name
+	if name == "" {
+		name = "*"
+	}
+	if t.Namespace != "" {
+		name = t.Namespace + "/" + name
+	}
+
+	return t.Kind + " " + name
+}
+
+func (t overlayTarget) matches(kind groupVersionKind, meta objectMeta) bool {
+	return t.Kind == kind.Kind &&
+		(t.APIVersion == "" || t.APIVersion == kind.APIVersion) &&
+		(t.Name == "" || t.Name == meta.Name) &&
+		(t.Namespace == "" || t.Namespace == meta.Namespace)
+}
+
+// manifestOverlays are the overlays of the configuration file, applied by
+// applyManifest.
+var manifestOverlays []manifestOverlay
+
+// setManifestOverlays validates the overlays and applies them to every
+// manifest applied from now on.
+func setManifestOverlays(overlays []manifestOverlay) error {
+	for i := range overlays {
+		overlay := &overlays[i]
+		if overlay.Target.Kind == "" {
+			return fmt.Errorf("overlay %d has no target kind", i)
+		}
+
+		if overlay.Type == "" {
+			overlay.Type = overlayMerge
+		}
+		if !slices.Contains(overlayTypes, overlay.Type) {
+			return fmt.Errorf("overlay %d has unknown type %q, expected one of %s", i, overlay.Type, strings.Join(overlayTypes, ", "))
+		}
+
+		var patch any
+		if err := json.Unmarshal(overlay.Patch, &patch); err != nil {
+			return fmt.Errorf("overlay %d has an invalid patch: %v", i, err)
+		}
+		_, isArray := patch.([]any)
+		_, isObject := patch.(map[string]any)
+		if overlay.Type == overlayJSON && !isArray {
+			return fmt.Errorf("overlay %d of type %s needs a JSON patch array", i, overlay.Type)
+		}
+		if overlay.Type != overlayJSON && !isObject {
+			return fmt.Errorf("overlay %d of type %s needs a patch object", i, overlay.Type)
+		}
+
+		// The patch is passed to oc on one line.
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, overlay.Patch); err != nil {
+			return fmt.Errorf("overlay %d has an invalid patch: %v", i, err)
+		}
+		overlay.Patch = compact.Bytes()
+	}
+
+	manifestOverlays = overlays
+
+	return nil
+}
+
+// manifestIdentity returns the apiVersion, kind, name and namespace of a
+// manifest document. Only JSON and YAML with a block style metadata are
+// understood.
+func manifestIdentity(doc string) (groupVersionKind, objectMeta, error) {
+	kind, err := manifestKind(doc)
+	if err != nil {
+		return kind, objectMeta{}, err
+	}
+
+	var meta objectMeta
+	if strings.HasPrefix(strings.TrimSpace(doc), "{") {
+		var obj struct {
+			Metadata objectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal([]byte(doc), &obj); err != nil {
+			return kind, meta, fmt.Errorf("error parsing manifest: %v", err)
+		}
+
+		return kind, obj.Metadata, nil
+	}
+
+	inMetadata := false
+	for _, line := range strings.Split(doc, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			inMetadata = strings.TrimSpace(line) == "metadata:"
+			continue
+		}
+		// Only the direct fields of metadata, not the labels.
+		if !inMetadata || strings.HasPrefix(line, "   ") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch key {
+		case "name":
+			meta.Name = value
+		case "namespace":
+			meta.Namespace = value
+		}
+	}
+
+	return kind, meta, nil
+}
+
+// overlayManifest applies the overlays matching the documents of a manifest
+// file and rewrites the file with the patched documents, which are JSON. The
+// comment header of the file is kept, with the overlays applied added to it,
+// except for .json files, which have no header. Files without matching
+// documents are left as they are.
+func overlayManifest(kconfig, fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", fileName, err)
+	}
+
+	var header strings.Builder
+	body := string(data)
+	for strings.HasPrefix(body, "#") {
+		line, rest, _ := strings.Cut(body, "\n")
+		header.WriteString(line + "\n")
+		body = rest
+	}
+
+	docs, err := manifest.SplitDocuments(body)
+	if err != nil {
+		return err
+	}
+
+	docs, applied, err := overlayDocuments(kconfig, fileName, docs)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		return nil
+	}
+
+	if !isJSONFile(fileName) {
+		fmt.Fprintf(&header, "# overlays: %s\n", strings.Join(applied, ", "))
+	}
+	if err := writePrivateFile(fileName, []byte(header.String()+strings.Join(docs, "---\n"))); err != nil {
+		return fmt.Errorf("error writing %s: %v", fileName, err)
+	}
+
+	return nil
+}
+
+// overlayDocuments applies the overlays matching the documents of a manifest
+// and returns the documents, the patched ones as JSON, and the targets of the
+// overlays applied. The patches are applied locally, the cluster of kconfig
+// is not contacted.
+func overlayDocuments(kconfig, fileName string, docs []string) ([]string, []string, error) {
+	docs = slices.Clone(docs)
+	applied := []string{}
+	for i, doc := range docs {
+		kind, meta, err := manifestIdentity(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %v", fileName, err)
+		}
+
+		for _, overlay := range manifestOverlays {
+			if !overlay.Target.matches(kind, meta) {
+				continue
+			}
+
+			patchCmd := ocCommand(kconfig, "patch", "--local", "-f", "-", "--type", overlay.Type, "-p", string(overlay.Patch), "-o", "json")
+			patchCmd.Stdin = strings.NewReader(doc)
+			output, err := patchCmd.Output()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error applying overlay for %s to %s %s: %v", overlay.Target, kind.Kind, meta.Name, err)
+			}
+
+			var patched bytes.Buffer
+			if err := json.Indent(&patched, bytes.TrimSpace(output), "", "  "); err != nil {
+				return nil, nil, fmt.Errorf("error parsing %s %s patched by overlay for %s: %v", kind.Kind, meta.Name, overlay.Target, err)
+			}
+			doc = patched.String() + "\n"
+			docs[i] = doc
+
+			applied = append(applied, overlay.Target.String())
+			slog.Info("applied overlay", "target", overlay.Target.String(), "kind", kind.Kind, "name", meta.Name, "file", fileName)
+		}
+	}
+
+	return docs, applied, nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	pipelineTypeTekton = "tekton"
+	pipelineTypeArgo   = "argo"
+
+	// pipelineWorkDir is where the generated files, reports and the run
+	// history are written in the Argo containers, like in the image.
+	pipelineWorkDir        = "/work"
+	pipelineKubeconfigsDir = "/kubeconfigs"
+)
+
+// pipelineParam is a parameter of a generated Task or template. {name} in
+// the arguments of its command is replaced by the parameter.
+type pipelineParam struct {
+	name         string
+	description  string
+	defaultValue *string
+}
+
+// pipelineCommand is an installer command wrapped as a Task or template.
+// {kubeconfigs} in its arguments is replaced by the directory of the
+// mounted kubeconfigs.
+type pipelineCommand struct {
+	name        string
+	description string
+	params      []pipelineParam
+	args        []string
+	// credentials adds the PASSWORD and RHCEPH_PASSWORD environment
+	// variables from the keys password and rhceph-password of the secret
+	// named by the credentials-secret parameter, which Kubernetes expands in
+	// the arguments.
+	credentials bool
+}
+
+func defaultValue(value string) *string {
+	return &value
+}
+
+var configParam = pipelineParam{
+	name:         "config",
+	description:  "Path of the installer configuration file, empty for none",
+	defaultValue: defaultValue(""),
+}
+
+var pipelineCommands = []pipelineCommand{
+	{
+		name:        "prepare",
+		description: "Prepares a cluster for ODF DR with the odfdr-installer.",
+		params: []pipelineParam{
+			{name: "url", description: "OpenShift API URL of the cluster"},
+			{name: "username", description: "OpenShift username", defaultValue: defaultValue("kubeadmin")},
+			{name: "credentials-secret", description: "Secret with the OpenShift password in the password key and the RHCEPH repository password in the rhceph-password key"},
+			configParam,
+		},
+		args: []string{"prepare", "-api-url", "{url}", "-username", "{username}",
+			"-password", "$(PASSWORD)", "-rhceph-password", "$(RHCEPH_PASSWORD)", "-config", "{config}"},
+		credentials: true,
+	},
+	{
+		name:        "configure-dr",
+		description: "Peers two prepared managed clusters for DR on their hub with the odfdr-installer.",
+		params: []pipelineParam{
+			{name: "hub-kubeconfig", description: "Kubeconfig of the hub, relative to the kubeconfigs"},
+			{name: "cluster1", description: "Name of the first ManagedCluster"},
+			{name: "cluster2", description: "Name of the second ManagedCluster"},
+			{name: "kubeconfig1", description: "Kubeconfig of the first cluster, relative to the kubeconfigs"},
+			{name: "kubeconfig2", description: "Kubeconfig of the second cluster, relative to the kubeconfigs"},
+			configParam,
+		},
+		args: []string{"configure-dr", "-kubeconfig", "{kubeconfigs}/{hub-kubeconfig}",
+			"-cluster", "{cluster1}", "-cluster", "{cluster2}",
+			"-cluster-kubeconfig", "{cluster1}={kubeconfigs}/{kubeconfig1}", "-cluster-kubeconfig", "{cluster2}={kubeconfigs}/{kubeconfig2}",
+			"-config", "{config}"},
+	},
+	{
+		name:        "verify",
+		description: "Verifies that the operators of a DR pair match with the odfdr-installer.",
+		params: []pipelineParam{
+			{name: "kubeconfig1", description: "Kubeconfig of the first cluster, relative to the kubeconfigs"},
+			{name: "kubeconfig2", description: "Kubeconfig of the second cluster, relative to the kubeconfigs"},
+		},
+		args: []string{"verify", "-kubeconfig", "{kubeconfigs}/{kubeconfig1}", "-kubeconfig", "{kubeconfigs}/{kubeconfig2}"},
+	},
+	{
+		name:        "cleanup",
+		description: "Removes what prepare added to a cluster with the odfdr-installer.",
+		params: []pipelineParam{
+			{name: "kubeconfig", description: "Kubeconfig of the cluster, relative to the kubeconfigs"},
+			configParam,
+		},
+		args: []string{"cleanup", "-kubeconfig", "{kubeconfigs}/{kubeconfig}", "-config", "{config}"},
+	},
+}
+
+// expandArgs replaces the parameters and the kubeconfigs directory in the
+// arguments of the command.
+func (c pipelineCommand) expandArgs(param func(name string) string, kubeconfigs string) []string {
+	replacements := []string{"{kubeconfigs}", kubeconfigs}
+	for _, p := range c.params {
+		replacements = append(replacements, "{"+p.name+"}", param(p.name))
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	args := []string{}
+	for _, arg := range c.args {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	return args
+}
+
+type envVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *envVarSource `json:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	SecretKeyRef struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	} `json:"secretKeyRef"`
+}
+
+func secretEnv(name, secretName, key string) envVar {
+	source := &envVarSource{}
+	source.SecretKeyRef.Name = secretName
+	source.SecretKeyRef.Key = key
+
+	return envVar{Name: name, ValueFrom: source}
+}
+
+func credentialsEnv(secretName string) []envVar {
+	return []envVar{
+		secretEnv("PASSWORD", secretName, "password"),
+		secretEnv("RHCEPH_PASSWORD", secretName, "rhceph-password"),
+	}
+}
+
+type tektonParam struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Type        string  `json:"type"`
+	Default     *string `json:"default,omitempty"`
+}
+
+type tektonWorkspace struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+type tektonStep struct {
+	Name       string   `json:"name"`
+	Image      string   `json:"image"`
+	Command    []string `json:"command"`
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"workingDir"`
+	Env        []envVar `json:"env,omitempty"`
+}
+
+type tektonTask struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Description string            `json:"description"`
+		Params      []tektonParam     `json:"params"`
+		Workspaces  []tektonWorkspace `json:"workspaces"`
+		Steps       []tektonStep      `json:"steps"`
+	} `json:"spec"`
+}
+
+// tektonTasks returns a Task for every command, with a work workspace for
+// the generated files and a kubeconfigs workspace.
+func tektonTasks(image string) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	for _, c := range pipelineCommands {
+		task := tektonTask{
+			APIVersion: "tekton.dev/v1",
+			Kind:       "Task",
+			Metadata:   objectMeta{Name: "odfdr-" + c.name},
+		}
+		task.Spec.Description = c.description
+		for _, p := range c.params {
+			task.Spec.Params = append(task.Spec.Params, tektonParam{Name: p.name, Description: p.description, Type: "string", Default: p.defaultValue})
+		}
+		task.Spec.Workspaces = []tektonWorkspace{
+			{Name: "work", Description: "Directory the generated files, reports and the run history are written to"},
+			{Name: "kubeconfigs", Description: "Kubeconfigs of the clusters", ReadOnly: true, Optional: true},
+		}
+
+		step := tektonStep{
+			Name:       c.name,
+			Image:      image,
+			Command:    []string{"/usr/bin/odfdr-installer"},
+			WorkingDir: "$(workspaces.work.path)",
+		}
+		step.Args = c.expandArgs(func(name string) string {
+			return "$(params." + name + ")"
+		}, "$(workspaces.kubeconfigs.path)")
+		// The run history goes to the workspace like in the working
+		// directory of the image.
+		step.Env = []envVar{{Name: "XDG_DATA_HOME", Value: "$(workspaces.work.path)/.local/share"}}
+		if c.credentials {
+			step.Env = append(step.Env, credentialsEnv("$(params.credentials-secret)")...)
+		}
+		task.Spec.Steps = []tektonStep{step}
+
+		manifests.Items = append(manifests.Items, task)
+	}
+
+	return manifests
+}
+
+type argoParam struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Default     *string `json:"default,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+type argoTemplate struct {
+	Name   string `json:"name"`
+	Inputs struct {
+		Parameters []argoParam `json:"parameters"`
+	} `json:"inputs"`
+	Container struct {
+		Image        string        `json:"image"`
+		Command      []string      `json:"command"`
+		Args         []string      `json:"args"`
+		WorkingDir   string        `json:"workingDir"`
+		Env          []envVar      `json:"env,omitempty"`
+		VolumeMounts []volumeMount `json:"volumeMounts"`
+	} `json:"container"`
+}
+
+type volume struct {
+	Name                  string                 `json:"name"`
+	PersistentVolumeClaim *pvcVolumeSource       `json:"persistentVolumeClaim,omitempty"`
+	Secret                *secretVolumeSource    `json:"secret,omitempty"`
+	ConfigMap             *configMapVolumeSource `json:"configMap,omitempty"`
+}
+
+type configMapVolumeSource struct {
+	Name string `json:"name"`
+}
+
+type pvcVolumeSource struct {
+	ClaimName string `json:"claimName"`
+}
+
+type secretVolumeSource struct {
+	SecretName string `json:"secretName"`
+	Optional   bool   `json:"optional,omitempty"`
+}
+
+type argoWorkflowTemplate struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Arguments struct {
+			Parameters []argoParam `json:"parameters"`
+		} `json:"arguments"`
+		Volumes   []volume       `json:"volumes"`
+		Templates []argoTemplate `json:"templates"`
+	} `json:"spec"`
+}
+
+// argoTemplates returns a WorkflowTemplate with a template for every command.
+// The generated files are written to the PVC of the work-pvc parameter and
+// the kubeconfigs are mounted from the Secret of the kubeconfigs-secret
+// parameter.
+func argoTemplates(image string) argoWorkflowTemplate {
+	wt := argoWorkflowTemplate{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "WorkflowTemplate",
+		Metadata:   objectMeta{Name: "odfdr-installer"},
+	}
+	wt.Spec.Arguments.Parameters = []argoParam{
+		{Name: "work-pvc", Description: "PVC the generated files, reports and the run history are written to"},
+		{Name: "kubeconfigs-secret", Description: "Secret with the kubeconfigs of the clusters, one per key"},
+	}
+	wt.Spec.Volumes = []volume{
+		{Name: "work", PersistentVolumeClaim: &pvcVolumeSource{ClaimName: "{{workflow.parameters.work-pvc}}"}},
+		{Name: "kubeconfigs", Secret: &secretVolumeSource{SecretName: "{{workflow.parameters.kubeconfigs-secret}}", Optional: true}},
+	}
+
+	for _, c := range pipelineCommands {
+		template := argoTemplate{Name: c.name}
+		for _, p := range c.params {
+			template.Inputs.Parameters = append(template.Inputs.Parameters, argoParam{Name: p.name, Description: p.description, Default: p.defaultValue})
+		}
+
+		template.Container.Image = image
+		template.Container.Command = []string{"/usr/bin/odfdr-installer"}
+		template.Container.Args = c.expandArgs(func(name string) string {
+			return "{{inputs.parameters." + name + "}}"
+		}, pipelineKubeconfigsDir)
+		template.Container.WorkingDir = pipelineWorkDir
+		if c.credentials {
+			template.Container.Env = credentialsEnv("{{inputs.parameters.credentials-secret}}")
+		}
+		template.Container.VolumeMounts = []volumeMount{
+			{Name: "work", MountPath: pipelineWorkDir},
+			{Name: "kubeconfigs", MountPath: pipelineKubeconfigsDir, ReadOnly: true},
+		}
+
+		wt.Spec.Templates = append(wt.Spec.Templates, template)
+	}
+
+	return wt
+}
+
+func runGeneratePipeline(args []string) {
+	flags := flag.NewFlagSet("generate-pipeline", flag.ExitOnError)
+	typeFlag := flags.String("type", pipelineTypeTekton, "Type of the definitions: tekton or argo")
+	imageFlag := flags.String("image", "", "Installer image used by the definitions, see the Containerfile")
+	outputFlag := flags.String("output", "", "File to write the definitions to (default: stdout)")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *imageFlag == "" {
+		slog.Error("error: image is required")
+		showUsageAndExit()
+	}
+
+	var manifests any
+	switch *typeFlag {
+	case pipelineTypeTekton:
+		manifests = tektonTasks(*imageFlag)
+	case pipelineTypeArgo:
+		manifests = argoTemplates(*imageFlag)
+	default:
+		slog.Error("error: invalid -type, expected tekton or argo", "type", *typeFlag)
+		showUsageAndExit()
+	}
+
+	data, err := json.MarshalIndent(manifests, "", "  ")
+	if err != nil {
+		slog.Error("error encoding pipeline definitions", "error", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *outputFlag == "" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err := writePrivateFile(*outputFlag, data); err != nil {
+		slog.Error("error writing pipeline definitions", "error", fmt.Errorf("%s: %v", *outputFlag, err))
+		os.Exit(1)
+	}
+
+	slog.Info("wrote pipeline definitions", "type", *typeFlag, "file", *outputFlag)
+}
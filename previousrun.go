@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	previousRunContinue = "continue"
+	previousRunRollback = "rollback"
+	previousRunAbort    = "abort"
+)
+
+var previousRunActions = []string{previousRunContinue, previousRunRollback, previousRunAbort}
+
+// previousRun is an earlier run on the cluster that did not finish, as
+// recorded in the progress ConfigMap, with the pieces it left on the cluster.
+type previousRun struct {
+	RunID          string   `json:"runId"`
+	Phase          string   `json:"phase"`
+	CompletedSteps []string `json:"completedSteps,omitempty"`
+	CurrentStep    string   `json:"currentStep,omitempty"`
+	Updated        string   `json:"updated,omitempty"`
+	// Present are the pieces of the installation found on the cluster.
+	Present []string `json:"present,omitempty"`
+	// Action is what was done about the run: continue, rollback or abort.
+	Action string `json:"action,omitempty"`
+}
+
+func addPreviousRunFlag(flags *flag.FlagSet) *string {
+	return flags.String("previous-run", "", "What to do when an earlier run did not finish: "+strings.Join(previousRunActions, ", ")+
+		" (default: ask when run interactively, otherwise continue)")
+}
+
+func validatePreviousRunAction(action string) error {
+	if action != "" && !slices.Contains(previousRunActions, action) {
+		return fmt.Errorf("unknown action %q, expected one of %s", action, strings.Join(previousRunActions, ", "))
+	}
+
+	return nil
+}
+
+// findPreviousRun returns the earlier run recorded in the progress ConfigMap
+// if it did not succeed, or nil.
+func findPreviousRun(kconfig string) (*previousRun, error) {
+	var cm configMap
+	found, err := getJSON(kconfig, &cm, "configmap", progressConfigMap, "-n", globalOperatorsNamespace)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if phase := cm.Data["phase"]; phase == "" || phase == phaseSucceeded {
+		return nil, nil
+	}
+
+	run := &previousRun{
+		RunID:       cm.Data["runId"],
+		Phase:       cm.Data["phase"],
+		CurrentStep: cm.Data["currentStep"],
+		Updated:     cm.Data["updated"],
+	}
+	if completed := cm.Data["completedSteps"]; completed != "" {
+		run.CompletedSteps = strings.Split(completed, ",")
+	}
+
+	return run, nil
+}
+
+// findInstalledPieces returns the pieces of the installation present on the
+// cluster, whichever run applied them.
+func findInstalledPieces(clusterName, kconfig string, opts prepareOptions) ([]string, error) {
+	pieces := []string{}
+
+	pullSecret, err := readPullSecret(clusterName, kconfig, globalPullSecret)
+	if err != nil {
+		return nil, err
+	}
+	if _, exact, _ := pullSecret.findRegistryAuth(rhcephRegistry); exact {
+		pieces = append(pieces, "RHCEPH auth in the global pull secret")
+	}
+
+	for _, ns := range pullSecretNamespaces(opts.operators) {
+		var s secret
+		found, err := getJSON(kconfig, &s, "secret", namespacePullSecretName, "-n", ns)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			pieces = append(pieces, fmt.Sprintf("pull secret %s/%s", ns, namespacePullSecretName))
+		}
+	}
+
+	output, err := ocCommand(kconfig, "get", "imagecontentsourcepolicies", "-l", mirrorSetLabel, "-o", "name").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing mirror sets: %v", err)
+	}
+	for _, name := range strings.Fields(string(output)) {
+		pieces = append(pieces, "mirror set "+strings.TrimPrefix(name, "imagecontentsourcepolicy.operator.openshift.io/"))
+	}
+
+	catalogSourceFileName, err := writeCatalogSource(clusterName, opts.catalogSourceYAML)
+	if err != nil {
+		return nil, err
+	}
+	catalog, found, err := getCatalogSource(kconfig, catalogSourceFileName)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		pieces = append(pieces, "CatalogSource "+catalog.Metadata.Name)
+
+		var subscriptions subscriptionList
+		if _, err := getJSON(kconfig, &subscriptions, "subscriptions.operators.coreos.com", "--all-namespaces"); err != nil {
+			return nil, err
+		}
+		for _, sub := range subscriptions.Items {
+			if sub.Spec.Source == catalog.Metadata.Name {
+				pieces = append(pieces, fmt.Sprintf("Subscription %s/%s", sub.Metadata.Namespace, sub.Metadata.Name))
+			}
+		}
+	}
+
+	return pieces, nil
+}
+
+// askPreviousRunAction shows the previous run and asks what to do about it.
+func askPreviousRunAction(clusterName string, run *previousRun) (string, error) {
+	stepPromptMu.Lock()
+	defer stepPromptMu.Unlock()
+
+	fmt.Printf("\n=== cluster %s: run %s did not finish (%s)\n", clusterName, run.RunID, run.Phase)
+	fmt.Printf("Completed steps: %s\n", strings.Join(run.CompletedSteps, ", "))
+	if run.CurrentStep != "" {
+		fmt.Printf("Interrupted in step: %s\n", run.CurrentStep)
+	}
+	fmt.Println("Present on the cluster:")
+	for _, piece := range run.Present {
+		fmt.Printf("  %s\n", piece)
+	}
+
+	for {
+		fmt.Print("[c] continue, [r] roll back and start over, [a] abort: ")
+		answer, err := stdinReader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading answer: %v", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "c", previousRunContinue:
+			return previousRunContinue, nil
+		case "r", previousRunRollback:
+			return previousRunRollback, nil
+		case "a", previousRunAbort:
+			return previousRunAbort, nil
+		}
+	}
+}
+
+func isInteractive() bool {
+	return isTerminal(os.Stdin)
+}
+
+// rollBackPreviousRun removes the pieces of the installation, like cleanup
+// does, so that the run starts over.
+func rollBackPreviousRun(clusterName, kconfig string, opts prepareOptions) error {
+	if err := removeCatalogSource(clusterName, kconfig, opts.catalogSourceYAML); err != nil {
+		return err
+	}
+
+	if err := removeMirrorSets(kconfig); err != nil {
+		return err
+	}
+
+	if err := removeNamespacePullSecrets(kconfig, pullSecretNamespaces(opts.operators)); err != nil {
+		return err
+	}
+
+	return removeRHCEPHAuth(clusterName, kconfig, globalPullSecret)
+}
+
+// handlePreviousRun detects an earlier run that did not finish, records it in
+// the report and continues, rolls back or aborts as chosen.
+func handlePreviousRun(clusterName, kconfig string, opts prepareOptions, report *clusterReport) error {
+	run, err := findPreviousRun(kconfig)
+	if err != nil {
+		slog.Warn("error reading the progress of earlier runs", "cluster", clusterName, "error", err)
+		return nil
+	}
+	if run == nil {
+		return nil
+	}
+
+	// The pieces of a hosted control plane cluster are in the HostedCluster.
+	if opts.hostedCluster == nil {
+		if run.Present, err = findInstalledPieces(clusterName, kconfig, opts); err != nil {
+			return fmt.Errorf("error finding what the earlier run %s applied: %v", run.RunID, err)
+		}
+	}
+
+	slog.Warn("earlier run did not finish", "cluster", clusterName, "run", run.RunID, "phase", run.Phase,
+		"completedSteps", run.CompletedSteps, "currentStep", run.CurrentStep, "present", run.Present)
+
+	run.Action = opts.previousRun
+	if run.Action == "" {
+		run.Action = previousRunContinue
+		if isInteractive() {
+			if run.Action, err = askPreviousRunAction(clusterName, run); err != nil {
+				return err
+			}
+		}
+	}
+	report.PreviousRun = run
+
+	switch run.Action {
+	case previousRunAbort:
+		return fmt.Errorf("earlier run %s did not finish: %w", run.RunID, errStepAborted)
+	case previousRunRollback:
+		if opts.hostedCluster != nil {
+			return fmt.Errorf("the earlier run of a hosted control plane cluster can not be rolled back")
+		}
+
+		slog.Info("rolling back earlier run", "cluster", clusterName, "run", run.RunID)
+		return rollBackPreviousRun(clusterName, kconfig, opts)
+	}
+
+	slog.Info("continuing earlier run", "cluster", clusterName, "run", run.RunID)
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// printCommands makes ocCommand print every oc command before it is run.
+var printCommands bool
+
+func addPrintCommandsFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&printCommands, "print-kubeadmin-commands", false, "Print the equivalent oc commands to stderr as they are run, with secrets redacted")
+}
+
+const redacted = "<redacted>"
+
+// secretFlags are oc flags whose value is a secret, either as the next
+// argument or after an equal sign.
+var secretFlags = []string{"-p", "--password", "--token", "--auth-basic"}
+
+// redactArgs returns a copy of the oc arguments with the secret values
+// replaced.
+func redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+
+	for i := 0; i < len(result); i++ {
+		for _, secretFlag := range secretFlags {
+			if result[i] == secretFlag && i+1 < len(result) {
+				result[i+1] = redacted
+				i++
+				break
+			}
+
+			if strings.HasPrefix(result[i], secretFlag+"=") {
+				result[i] = secretFlag + "=" + redacted
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// shellQuote quotes an argument so that it can be pasted into a shell.
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'`$\\|&;<>(){}[]*?!#~") {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// printOCCommand prints the oc command with its kubeconfig the way it would
+// be typed in a shell.
+func printOCCommand(kconfig string, args []string) {
+	words := []string{"KUBECONFIG=" + shellQuote(kconfig), "oc"}
+	for _, arg := range redactArgs(args) {
+		words = append(words, shellQuote(arg))
+	}
+
+	fmt.Fprintln(os.Stderr, redactText(strings.Join(words, " ")))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The files of the installer hold passwords, tokens and kubeconfigs, and on
+// shared jump hosts must not be readable by other users. The modes are set
+// explicitly after creating a file or directory, as the umask can only take
+// permissions away and an existing file keeps its mode.
+const (
+	privateFileMode os.FileMode = 0o600
+	privateDirMode  os.FileMode = 0o700
+)
+
+// writePrivateFile is os.WriteFile for a file only the user may read.
+func writePrivateFile(fileName string, data []byte) error {
+	if err := os.WriteFile(fileName, data, privateFileMode); err != nil {
+		return err
+	}
+
+	return os.Chmod(fileName, privateFileMode)
+}
+
+// openPrivateFile is os.OpenFile for a file only the user may read.
+func openPrivateFile(fileName string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(fileName, flag, privateFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(privateFileMode); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// mkdirPrivate is os.MkdirAll for a directory only the user may enter. Only
+// the directories it creates are restricted, existing ones, like the current
+// directory, keep their mode.
+func mkdirPrivate(dir string) error {
+	missing := []string{}
+	for path := filepath.Clean(dir); ; path = filepath.Dir(path) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, path)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, privateDirMode); err != nil {
+		return err
+	}
+
+	for _, path := range missing {
+		if err := os.Chmod(path, privateDirMode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makePrivate restricts a file, or a directory, written by oc on behalf of the
+// installer to the user.
+func makePrivate(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	mode := privateFileMode
+	if info.IsDir() {
+		mode = privateDirMode
+	}
+
+	return os.Chmod(path, mode)
+}
+
+var (
+	tempDirFlag string
+	privateTmp  bool
+	// tempDirFlags are the flags of the command with -temp-dir, which must
+	// be parsed before the temporary directory is used.
+	tempDirFlags *flag.FlagSet
+
+	tempDirOnce     sync.Once
+	resolvedTempDir string
+	tempDirErr      error
+)
+
+func addTempDirFlags(flags *flag.FlagSet) {
+	tempDirFlags = flags
+	flags.StringVar(&tempDirFlag, "temp-dir", "", "Directory for the temporary files, like the kubeconfigs (default: $TMPDIR or /tmp)")
+	flags.BoolVar(&privateTmp, "private-tmp", false, "Put the temporary files into a new directory only the user may enter")
+}
+
+// tempDir returns the directory of the temporary files of the run. It is
+// created on first use, only accessible by the user when it is given with
+// -temp-dir or created for -private-tmp. An existing -temp-dir must not be
+// accessible by other users. oc, which is run with TMPDIR set to it, puts its
+// temporary files there too. It fails while the flags of the command are
+// still being parsed, which would ignore the flags.
+func tempDir() (string, error) {
+	if tempDirFlags != nil && !tempDirFlags.Parsed() {
+		return "", fmt.Errorf("temporary directory used before -temp-dir and -private-tmp are parsed")
+	}
+
+	tempDirOnce.Do(func() {
+		dir := os.TempDir()
+		if tempDirFlag != "" {
+			dir = tempDirFlag
+			if err := checkPrivateDir(dir); err != nil {
+				tempDirErr = err
+				return
+			}
+			if err := mkdirPrivate(dir); err != nil {
+				tempDirErr = fmt.Errorf("error creating temporary directory: %v", err)
+				return
+			}
+		}
+
+		if privateTmp {
+			private, err := os.MkdirTemp(dir, "odfdr-installer-*")
+			if err != nil {
+				tempDirErr = fmt.Errorf("error creating private temporary directory: %v", err)
+				return
+			}
+			if err := os.Chmod(private, privateDirMode); err != nil {
+				tempDirErr = fmt.Errorf("error creating private temporary directory: %v", err)
+				return
+			}
+			dir = private
+		}
+
+		resolvedTempDir = dir
+		if tempDirFlag != "" || privateTmp {
+			tempDirErr = os.Setenv("TMPDIR", dir)
+		}
+	})
+
+	return resolvedTempDir, tempDirErr
+}
+
+// checkPrivateDir returns an error if dir exists and other users may access
+// it. The directories of other programs are not changed to fit the installer.
+func checkPrivateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking temporary directory: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("temporary directory %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("temporary directory %s has mode %v and is accessible by other users, restrict it with chmod 700 or use another directory",
+			dir, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// createTempFile is os.CreateTemp in the temporary directory of the run.
+func createTempFile(pattern string) (*os.File, error) {
+	dir, err := tempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.CreateTemp(dir, pattern)
+}
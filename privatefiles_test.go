@@ -0,0 +1,235 @@
+//go:build unix
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// permissiveUmask sets a umask that lets every file be read by everyone, so
+// that the tests show the modes do not depend on it.
+func permissiveUmask(t *testing.T) {
+	old := syscall.Umask(0)
+	t.Cleanup(func() { syscall.Umask(old) })
+}
+
+func assertMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat %s: %v", path, err)
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("mode of %s is %v, want %v", path, got, want)
+	}
+}
+
+func TestPrivateFileHelpers(t *testing.T) {
+	permissiveUmask(t)
+	dir := t.TempDir()
+
+	written := filepath.Join(dir, "written")
+	if err := writePrivateFile(written, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, written, privateFileMode)
+
+	// An existing file keeps its mode with os.WriteFile and os.OpenFile.
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePrivateFile(existing, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, existing, privateFileMode)
+
+	opened := filepath.Join(dir, "opened")
+	if err := os.WriteFile(opened, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := openPrivateFile(opened, os.O_APPEND|os.O_WRONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	assertMode(t, opened, privateFileMode)
+
+	nested := filepath.Join(dir, "a", "b")
+	if err := mkdirPrivate(nested); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, filepath.Join(dir, "a"), privateDirMode)
+	assertMode(t, nested, privateDirMode)
+
+	// Existing directories keep their mode.
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkdirPrivate(filepath.Join(shared, "c")); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, shared, 0o755)
+	assertMode(t, filepath.Join(shared, "c"), privateDirMode)
+
+	// Files written by oc get the mode oc chose.
+	byOC := filepath.Join(dir, "by-oc")
+	if err := os.WriteFile(byOC, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := makePrivate(byOC); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, byOC, privateFileMode)
+}
+
+func TestArtifactModes(t *testing.T) {
+	permissiveUmask(t)
+	t.Chdir(t.TempDir())
+
+	if err := writeArtifact("c1", "c1-manifest.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRawArtifact("c1", "c1-pull-secret.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("c1-append-pull-secret.json", []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordArtifact("c1", "c1-append-pull-secret.json"); err != nil {
+		t.Fatal(err)
+	}
+	password, err := identityPassword("c1", &identityConfig{Username: "admin"})
+	if err != nil || password == "" {
+		t.Fatalf("identityPassword: %q, %v", password, err)
+	}
+
+	for _, fileName := range []string{"c1-manifest.json", "c1-pull-secret.json", "c1-append-pull-secret.json",
+		"c1-admin-password", artifactIndexFileName} {
+		assertMode(t, fileName, privateFileMode)
+	}
+}
+
+func TestReportModes(t *testing.T) {
+	permissiveUmask(t)
+	t.Chdir(t.TempDir())
+	t.Setenv("XDG_DATA_HOME", filepath.Join(t.TempDir(), "data"))
+
+	report := newRunReport("verify")
+	if err := report.finish("verify-report.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, "verify-report.json", privateFileMode)
+
+	dir, err := historyDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, dir, privateDirMode)
+	assertMode(t, filepath.Join(dir, report.ID+".json"), privateFileMode)
+
+	if err := writeReadyFile("ready.json", report); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, "ready.json", privateFileMode)
+}
+
+func TestBundleModes(t *testing.T) {
+	permissiveUmask(t)
+	dir := filepath.Join(t.TempDir(), "c1-diagnostics")
+
+	if _, err := openBundle(dir, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, dir, privateDirMode)
+}
+
+func TestPrivateTmp(t *testing.T) {
+	permissiveUmask(t)
+	base := filepath.Join(t.TempDir(), "tmp")
+	t.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+
+	tempDirFlag, privateTmp = base, true
+	tempDirOnce = sync.Once{}
+	t.Cleanup(func() {
+		tempDirFlag, privateTmp = "", false
+		tempDirOnce = sync.Once{}
+	})
+
+	kconfig, err := getKubeconfig("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := filepath.Dir(kconfig.Name())
+	if filepath.Dir(private) != base {
+		t.Errorf("kubeconfig %s is not in a private directory of %s", kconfig.Name(), base)
+	}
+	if got := os.Getenv("TMPDIR"); got != private {
+		t.Errorf("TMPDIR is %s, want %s", got, private)
+	}
+	assertMode(t, base, privateDirMode)
+	assertMode(t, private, privateDirMode)
+	assertMode(t, kconfig.Name(), privateFileMode)
+}
+
+func TestSharedTempDir(t *testing.T) {
+	permissiveUmask(t)
+	shared := filepath.Join(t.TempDir(), "tmp")
+	if err := os.Mkdir(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+
+	tempDirFlag = shared
+	tempDirOnce = sync.Once{}
+	t.Cleanup(func() {
+		tempDirFlag = ""
+		tempDirOnce = sync.Once{}
+	})
+
+	if _, err := tempDir(); err == nil {
+		t.Errorf("temporary directory with mode 0755 was accepted")
+	}
+	assertMode(t, shared, 0o755)
+}
+
+func TestTraceFileInTempDir(t *testing.T) {
+	permissiveUmask(t)
+	dir := filepath.Join(t.TempDir(), "tmp")
+	t.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+
+	tempDirOnce = sync.Once{}
+	t.Cleanup(func() {
+		tempDirFlag, tempDirFlags = "", nil
+		tempDirOnce = sync.Once{}
+		activeTracer, traceEndpoint = nil, ""
+	})
+
+	flags := flag.NewFlagSet("prepare", flag.ContinueOnError)
+	addTracingFlag(flags)
+	addTempDirFlags(flags)
+	if _, err := tempDir(); err == nil {
+		t.Errorf("temporary directory was resolved before the flags were parsed")
+	}
+
+	// -temp-dir after -otel-endpoint still applies to the trace file.
+	if err := flags.Parse([]string{"-otel-endpoint", "http://localhost:4318", "-temp-dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := startTracing(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := filepath.Dir(activeTracer.fileName); got != dir {
+		t.Errorf("trace file is in %s, want %s", got, dir)
+	}
+	assertMode(t, dir, 0o700)
+}
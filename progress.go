@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// progressConfigMap records the installation progress on the cluster, so
+// that other tools and humans can follow it without the installer logs.
+const progressConfigMap = "odfdr-installer-progress"
+
+const (
+	phaseInstalling = "Installing"
+	phaseSucceeded  = "Succeeded"
+	phaseFailed     = "Failed"
+)
+
+// installProgress is the state written to the progress ConfigMap.
+type installProgress struct {
+	clusterName string
+	kconfig     string
+
+	phase     string
+	current   string
+	completed []string
+	err       string
+}
+
+func newInstallProgress(clusterName, kconfig string) *installProgress {
+	return &installProgress{clusterName: clusterName, kconfig: kconfig, phase: phaseInstalling}
+}
+
+// write applies the progress ConfigMap. The progress is informational, so
+// failures to write it are only logged.
+func (p *installProgress) write() {
+	cm := configMap{APIVersion: "v1", Kind: "ConfigMap", Metadata: objectMeta{Name: progressConfigMap, Namespace: globalOperatorsNamespace}}
+	cm.Data = map[string]string{
+		"phase":            p.phase,
+		"runId":            artifactRunID,
+		"installerVersion": version,
+		"completedSteps":   strings.Join(p.completed, ","),
+		"currentStep":      p.current,
+		"updated":          time.Now().UTC().Format(time.RFC3339),
+	}
+	if p.err != "" {
+		cm.Data["error"] = p.err
+	}
+
+	data, err := json.MarshalIndent(cm, "", "  ")
+	if err != nil {
+		slog.Warn("error encoding progress ConfigMap", "cluster", p.clusterName, "error", err)
+		return
+	}
+
+	fileName := p.clusterName + "-progress.json"
+	if err := writeArtifact(p.clusterName, fileName, data); err != nil {
+		slog.Warn("error writing progress ConfigMap to file", "cluster", p.clusterName, "error", err)
+		return
+	}
+
+	if _, err := applyManifest(p.kconfig, fileName); err != nil {
+		slog.Warn("error updating progress ConfigMap", "cluster", p.clusterName, "error", err)
+	}
+}
+
+func (p *installProgress) startStep(name string) {
+	p.current = name
+	p.write()
+}
+
+func (p *installProgress) completeStep(name string) {
+	p.current = ""
+	p.completed = append(p.completed, name)
+	p.write()
+}
+
+// finish records the outcome of the installation.
+func (p *installProgress) finish(err error) {
+	p.phase = phaseSucceeded
+	if err != nil {
+		p.phase = phaseFailed
+		p.err = err.Error()
+	}
+	p.write()
+}
+
+// trackProgress records the start and completion of every step in the
+// progress ConfigMap.
+func trackProgress(steps []step, progress *installProgress) []step {
+	tracked := []step{}
+	for _, s := range steps {
+		run := s.run
+		s.run = func() error {
+			progress.startStep(s.name)
+			if err := run(); err != nil {
+				return err
+			}
+			progress.completeStep(s.name)
+			return nil
+		}
+
+		if force := s.force; force != nil {
+			s.force = func() error {
+				progress.startStep(s.name)
+				if err := force(); err != nil {
+					return err
+				}
+				progress.completeStep(s.name)
+				return nil
+			}
+		}
+
+		tracked = append(tracked, s)
+	}
+
+	return tracked
+}
+
+// removeProgress deletes the progress ConfigMap.
+func removeProgress(kconfig string) error {
+	deleteCmd := ocCommand(kconfig, "delete", "configmap", progressConfigMap, "-n", globalOperatorsNamespace, "--ignore-not-found")
+	if err := deleteCmd.Run(); err != nil {
+		return fmt.Errorf("error deleting progress ConfigMap: %v", err)
+	}
+
+	return nil
+}
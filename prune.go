@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+func addPruneFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("prune", false, "Delete the mirror sets and CatalogSources of earlier runs that are no longer configured")
+}
+
+// managedResourceList is a list of resources labeled by the installer.
+type managedResourceList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+// findStaleResources returns the mirror sets and CatalogSources applied by an
+// earlier run that are no longer configured. CatalogSources still used by a
+// Subscription are kept, deleting them would break the operators installed
+// from them.
+func findStaleResources(clusterName, kconfig string, sets []mirrorSet, catalogSourceYAML string) ([]string, error) {
+	stale := []string{}
+
+	var mirrorSets managedResourceList
+	if _, err := getJSON(kconfig, &mirrorSets, "imagecontentsourcepolicies", "-l", mirrorSetLabel); err != nil {
+		return nil, err
+	}
+	for _, item := range mirrorSets.Items {
+		name := item.Metadata.Labels[mirrorSetLabel]
+		if !slices.ContainsFunc(sets, func(set mirrorSet) bool { return set.Name == name }) {
+			stale = append(stale, "imagecontentsourcepolicy/"+item.Metadata.Name)
+		}
+	}
+
+	var catalogs managedResourceList
+	if _, err := getJSON(kconfig, &catalogs, "catalogsources.operators.coreos.com", "-n", "openshift-marketplace",
+		"-l", managedByLabel+"="+managedByValue); err != nil {
+		return nil, err
+	}
+	var subscriptions subscriptionList
+	if _, err := getJSON(kconfig, &subscriptions, "subscriptions.operators.coreos.com", "--all-namespaces"); err != nil {
+		return nil, err
+	}
+	for _, item := range catalogs.Items {
+		if item.Metadata.Name == catalogSourceName(catalogSourceYAML) {
+			continue
+		}
+
+		used := false
+		for _, sub := range subscriptions.Items {
+			if sub.Spec.Source == item.Metadata.Name {
+				slog.Warn("not pruning CatalogSource used by a Subscription", "cluster", clusterName, "catalogSource", item.Metadata.Name,
+					"subscription", sub.Metadata.Namespace+"/"+sub.Metadata.Name)
+				used = true
+				break
+			}
+		}
+		if !used {
+			stale = append(stale, "catalogsource.operators.coreos.com/"+item.Metadata.Name)
+		}
+	}
+
+	return stale, nil
+}
+
+// pruneStaleResources deletes the stale resources of the cluster when prune
+// is set, otherwise it only logs them.
+func pruneStaleResources(clusterName, kconfig string, sets []mirrorSet, catalogSourceYAML string, prune bool) ([]string, error) {
+	stale, err := findStaleResources(clusterName, kconfig, sets, catalogSourceYAML)
+	if err != nil {
+		return nil, fmt.Errorf("error finding stale resources: %v", err)
+	}
+
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	if !prune {
+		slog.Warn("found resources of earlier runs that are no longer configured, run with -prune to delete them",
+			"cluster", clusterName, "resources", stale)
+		return nil, nil
+	}
+
+	for _, resource := range stale {
+		// The catalogs live in openshift-marketplace, the mirror sets are
+		// cluster scoped and ignore the namespace.
+		deleteCmd := ocCommand(kconfig, "delete", resource, "-n", "openshift-marketplace", "--ignore-not-found")
+		if err := deleteCmd.Run(); err != nil {
+			return nil, fmt.Errorf("error pruning %s: %v", resource, err)
+		}
+		slog.Info("pruned resource", "cluster", clusterName, "resource", resource)
+	}
+
+	return stale, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const rhcephRegistry = "quay.io/rhceph-dev"
+
+// The conflict policies decide which credentials are kept when the pull
+// secret of the cluster (ours) and the registry login added to it (theirs)
+// both have an auth for the same registry with different credentials.
+const (
+	pullSecretConflictOurs   = "ours"
+	pullSecretConflictTheirs = "theirs"
+)
+
+// The registry auth match modes decide whether an auth of the pull secret for
+// a broader scope than the RHCEPH registry, like quay.io for
+// quay.io/rhceph-dev, satisfies it.
+const (
+	registryAuthMatchExact   = "exact"
+	registryAuthMatchBroader = "broader"
+)
+
+// secretRef identifies a pull secret.
+type secretRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// globalPullSecret is the pull secret used by all nodes of a cluster.
+var globalPullSecret = secretRef{Namespace: "openshift-config", Name: "pull-secret"}
+
+// dockerConfig is the content of a .dockerconfigjson pull secret.
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Email         string `json:"email,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// parseDockerConfig strictly decodes and validates a .dockerconfigjson.
+func parseDockerConfig(data []byte) (*dockerConfig, error) {
+	// The JSON decoder silently replaces invalid UTF-8, which would corrupt
+	// the credentials instead of failing.
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("error parsing pull secret JSON: not valid UTF-8")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var config dockerConfig
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("error parsing pull secret JSON: %v", err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func (c *dockerConfig) validate() error {
+	if c.Auths == nil {
+		return fmt.Errorf("pull secret does not contain auths")
+	}
+
+	for registry, auth := range c.Auths {
+		if auth.Auth == "" && auth.IdentityToken == "" && (auth.Username == "" || auth.Password == "") {
+			return fmt.Errorf("auth for %s has no credentials", registry)
+		}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("auth for %s is not valid base64: %v", registry, err)
+			}
+
+			if !strings.Contains(string(decoded), ":") {
+				return fmt.Errorf("auth for %s is not in user:password form", registry)
+			}
+		}
+	}
+
+	return nil
+}
+
+// encode returns the JSON of the config after making sure that it decodes
+// back to the same config.
+func (c *dockerConfig) encode() ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding pull secret: %v", err)
+	}
+
+	decoded, err := parseDockerConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(decoded, c) {
+		return nil, fmt.Errorf("pull secret does not round-trip through JSON")
+	}
+
+	return data, nil
+}
+
+func addSkipRegistryAuthFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("skip-registry-auth", false, "Do not add the RHCEPH registry auth, for catalogs and images that are public; -rhceph-password is not needed then")
+}
+
+func addPullSecretConflictFlag(flags *flag.FlagSet) *string {
+	return flags.String("pull-secret-conflict", pullSecretConflictOurs, "Credentials kept when the pull secret already has a different RHCEPH registry auth: ours (the pull secret) or theirs (-rhceph-password)")
+}
+
+func validatePullSecretConflict(policy string) error {
+	switch policy {
+	case pullSecretConflictOurs, pullSecretConflictTheirs:
+		return nil
+	default:
+		return fmt.Errorf("unknown pull secret conflict policy %q", policy)
+	}
+}
+
+func addRegistryAuthMatchFlag(flags *flag.FlagSet) *string {
+	return flags.String("registry-auth-match", registryAuthMatchExact, "Whether an auth of the pull secret for a broader scope, like quay.io, satisfies the RHCEPH registry auth: exact (the RHCEPH auth is added next to it) or broader (nothing is added)")
+}
+
+func validateRegistryAuthMatch(match string) error {
+	switch match {
+	case registryAuthMatchExact, registryAuthMatchBroader:
+		return nil
+	default:
+		return fmt.Errorf("unknown registry auth match %q", match)
+	}
+}
+
+// normalizeRegistryKey returns the registry and path of an auths key, which
+// can have a scheme and a trailing slash, like https://quay.io/.
+func normalizeRegistryKey(key string) string {
+	for _, scheme := range []string{"https://", "http://"} {
+		key = strings.TrimPrefix(key, scheme)
+	}
+
+	return strings.TrimSuffix(key, "/")
+}
+
+// findRegistryAuth returns the key of the auth used for a registry and path,
+// the way container runtimes pick it: the key matching the most path
+// components, down to the host alone. exact tells whether the key is for the
+// registry itself, possibly in another form, rather than a broader scope.
+func (c *dockerConfig) findRegistryAuth(registry string) (key string, exact bool, found bool) {
+	registry = normalizeRegistryKey(registry)
+	best := ""
+	for candidate := range c.Auths {
+		scope := normalizeRegistryKey(candidate)
+		if scope != registry && !strings.HasPrefix(registry, scope+"/") {
+			continue
+		}
+		// Prefer the canonical form among keys of the same scope.
+		if !found || len(scope) > len(best) || (scope == best && candidate == registry) {
+			key, best, found = candidate, scope, true
+		}
+	}
+
+	return key, found && best == registry, found
+}
+
+// mergeDockerConfigs returns the auths of ours and theirs, and the registries
+// that both have different credentials for, which are resolved by the
+// conflict policy. The configs are left unchanged.
+func mergeDockerConfigs(ours, theirs *dockerConfig, policy string) (*dockerConfig, []string, error) {
+	if err := validatePullSecretConflict(policy); err != nil {
+		return nil, nil, err
+	}
+
+	merged := &dockerConfig{Auths: map[string]dockerAuth{}}
+	for registry, auth := range ours.Auths {
+		merged.Auths[registry] = auth
+	}
+
+	conflicts := []string{}
+	for registry, auth := range theirs.Auths {
+		existing, exists := merged.Auths[registry]
+		if exists && existing != auth {
+			conflicts = append(conflicts, registry)
+			if policy == pullSecretConflictOurs {
+				continue
+			}
+		}
+		merged.Auths[registry] = auth
+	}
+	slices.Sort(conflicts)
+
+	return merged, conflicts, nil
+}
+
+// withKey returns a copy of the config with the auth of key moved to newKey.
+func (c *dockerConfig) withKey(key, newKey string) *dockerConfig {
+	moved := &dockerConfig{Auths: map[string]dockerAuth{}}
+	for registry, auth := range c.Auths {
+		if registry == key {
+			registry = newKey
+		}
+		moved.Auths[registry] = auth
+	}
+
+	return moved
+}
+
+func getPullSecret(kconfig string, ref secretRef) ([]byte, error) {
+	getPullSecretCmd := ocCommand(kconfig, "get", "secret/"+ref.Name, "-n", ref.Namespace, "--template={{index .data \".dockerconfigjson\" | base64decode}}")
+	pullSecretOutput, err := getPullSecretCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting pull secret: %v", err)
+	}
+
+	return pullSecretOutput, nil
+}
+
+// setPullSecret validates the new pull secret, updates the cluster with it and
+// checks that the cluster now has exactly that pull secret.
+func setPullSecret(clusterName, kconfig string, ref secretRef, pullSecret *dockerConfig) error {
+	data, err := pullSecret.encode()
+	if err != nil {
+		return fmt.Errorf("invalid new pull secret: %v", err)
+	}
+
+	newPullSecretFileName := clusterName + "-new-pull-secret.json"
+	err = writeRawArtifact(clusterName, newPullSecretFileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing new pull secret to file: %v", err)
+	}
+
+	updateCmd := ocCommand(kconfig, "set", "data", "secret/"+ref.Name, "-n", ref.Namespace,
+		"--from-file=.dockerconfigjson="+newPullSecretFileName)
+	err = updateCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error updating pull secret: %v", err)
+	}
+
+	updatedOutput, err := getPullSecret(kconfig, ref)
+	if err != nil {
+		return err
+	}
+
+	updated, err := parseDockerConfig(updatedOutput)
+	if err != nil {
+		return fmt.Errorf("invalid updated pull secret: %v", err)
+	}
+
+	if !reflect.DeepEqual(updated, pullSecret) {
+		return fmt.Errorf("updated pull secret does not match the expected pull secret")
+	}
+
+	return nil
+}
+
+// readPullSecret returns the pull secret and keeps a copy of it in a file.
+func readPullSecret(clusterName, kconfig string, ref secretRef) (*dockerConfig, error) {
+	pullSecretOutput, err := getPullSecret(kconfig, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pullSecretFileName := clusterName + "-pull-secret.json"
+	err = writeRawArtifact(clusterName, pullSecretFileName, pullSecretOutput)
+	if err != nil {
+		return nil, fmt.Errorf("error writing pull secret to file: %v", err)
+	}
+
+	return parseDockerConfig(pullSecretOutput)
+}
+
+// addRHCEPHAuth adds the RHCEPH registry auth to the pull secret. A different
+// existing auth is replaced with the conflict policy theirs, or when force is
+// set. An auth for a broader scope, like quay.io, satisfies the RHCEPH
+// registry only with the match mode broader.
+func addRHCEPHAuth(clusterName, kconfig string, ref secretRef, rhcephPassword, conflict, match string, force bool) error {
+	original, err := readPullSecret(clusterName, kconfig, ref)
+	if err != nil {
+		return err
+	}
+
+	pullSecret := original
+	key, exact, found := pullSecret.findRegistryAuth(rhcephRegistry)
+	switch {
+	case exact && !force && conflict == pullSecretConflictOurs:
+		slog.Info("RHCEPH auth already exists in pull secret", "cluster", clusterName, "key", key)
+		return nil
+	case exact && key != rhcephRegistry:
+		// The auth is replaced under the key of the registry login,
+		// instead of keeping both forms.
+		slog.Info("replacing RHCEPH auth with a key of another form in pull secret", "cluster", clusterName, "key", key)
+		pullSecret = pullSecret.withKey(key, rhcephRegistry)
+	case found && !exact && !force && match == registryAuthMatchBroader:
+		slog.Info("RHCEPH registry is covered by a broader auth in pull secret, not adding its auth", "cluster", clusterName, "key", key)
+		return nil
+	case found && !exact:
+		slog.Info("pull secret has a broader auth covering the RHCEPH registry, adding the RHCEPH auth, which takes precedence for it",
+			"cluster", clusterName, "key", key)
+	}
+
+	if force {
+		conflict = pullSecretConflictTheirs
+	}
+
+	appendFileName := clusterName + "-append-pull-secret.json"
+	registryLoginCmd := ocCommand(kconfig, "registry", "login", "--registry="+rhcephRegistry,
+		"--auth-basic="+rhcephPassword, "--to="+appendFileName)
+	err = registryLoginCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error logging into registry: %v", err)
+	}
+
+	if err := recordArtifact(clusterName, appendFileName); err != nil {
+		return err
+	}
+
+	appendOutput, err := os.ReadFile(appendFileName)
+	if err != nil {
+		return fmt.Errorf("error reading registry login: %v", err)
+	}
+
+	appendPullSecret, err := parseDockerConfig(appendOutput)
+	if err != nil {
+		return fmt.Errorf("invalid registry login: %v", err)
+	}
+
+	merged, conflicts, err := mergeDockerConfigs(pullSecret, appendPullSecret, conflict)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(merged, original) {
+		slog.Info("RHCEPH auth already exists in pull secret")
+		return nil
+	}
+
+	if len(conflicts) > 0 {
+		slog.Info("replacing existing auths in pull secret", "registries", conflicts)
+	}
+
+	return setPullSecret(clusterName, kconfig, ref, merged)
+}
+
+// removeRHCEPHAuth removes the RHCEPH registry auth from the pull secret, in
+// any key form. Broader auths are kept, they were not added by the installer.
+func removeRHCEPHAuth(clusterName, kconfig string, ref secretRef) error {
+	pullSecret, err := readPullSecret(clusterName, kconfig, ref)
+	if err != nil {
+		return err
+	}
+
+	if _, exact, _ := pullSecret.findRegistryAuth(rhcephRegistry); !exact {
+		slog.Info("RHCEPH auth does not exist in pull secret")
+		return nil
+	}
+
+	for key := range pullSecret.Auths {
+		if normalizeRegistryKey(key) == rhcephRegistry {
+			delete(pullSecret.Auths, key)
+		}
+	}
+
+	return setPullSecret(clusterName, kconfig, ref, pullSecret)
+}
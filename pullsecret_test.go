@@ -0,0 +1,293 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *dockerConfig
+		wantErr string
+	}{
+		{
+			name: "empty auths",
+			data: `{"auths":{}}`,
+			want: &dockerConfig{Auths: map[string]dockerAuth{}},
+		},
+		{
+			name:    "missing auths",
+			data:    `{}`,
+			wantErr: "does not contain auths",
+		},
+		{
+			name: "identity token only",
+			data: `{"auths":{"registry.example.com":{"identitytoken":"token"}}}`,
+			want: &dockerConfig{Auths: map[string]dockerAuth{
+				"registry.example.com": {IdentityToken: "token"},
+			}},
+		},
+		{
+			name: "identity token next to auth",
+			data: `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz","identitytoken":"token"}}}`,
+			want: &dockerConfig{Auths: map[string]dockerAuth{
+				"registry.example.com": {Auth: "dXNlcjpwYXNz", IdentityToken: "token"},
+			}},
+		},
+		{
+			name:    "identity token nested in an object",
+			data:    `{"auths":{"registry.example.com":{"identitytoken":{"token":"token"}}}}`,
+			wantErr: "error parsing pull secret JSON",
+		},
+		{
+			name:    "unknown field",
+			data:    `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz","registrytoken":"token"}}}`,
+			wantErr: "unknown field",
+		},
+		{
+			name:    "no credentials",
+			data:    `{"auths":{"registry.example.com":{"email":"a@b.c"}}}`,
+			wantErr: "has no credentials",
+		},
+		{
+			name:    "auth not in user:password form",
+			data:    `{"auths":{"registry.example.com":{"auth":"dXNlcg=="}}}`,
+			wantErr: "not in user:password form",
+		},
+		{
+			name:    "non-UTF-8 junk",
+			data:    "{\"auths\":{\"registry.example.com\":{\"auth\":\"dXNlcjpwYXNz\",\"email\":\"\xff\xfe\"}}}",
+			wantErr: "not valid UTF-8",
+		},
+		{
+			name:    "leading NUL byte",
+			data:    "\x00{\"auths\":{}}",
+			wantErr: "error parsing pull secret JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDockerConfig([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDockerConfigs(t *testing.T) {
+	cloud := dockerAuth{Auth: "Y2xvdWQ6cGFzcw==", Email: "a@b.c"}
+	oldRHCEPH := dockerAuth{Auth: "dXNlcjpvbGQ="}
+	newRHCEPH := dockerAuth{Auth: "dXNlcjpuZXc="}
+	tokenRHCEPH := dockerAuth{Auth: "dXNlcjpvbGQ=", IdentityToken: "token"}
+
+	tests := []struct {
+		name          string
+		ours          map[string]dockerAuth
+		theirs        map[string]dockerAuth
+		policy        string
+		want          map[string]dockerAuth
+		wantConflicts []string
+		wantErr       bool
+	}{
+		{
+			name:          "empty auths",
+			ours:          map[string]dockerAuth{},
+			theirs:        map[string]dockerAuth{},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{},
+			wantConflicts: []string{},
+		},
+		{
+			name:          "add to empty auths",
+			ours:          map[string]dockerAuth{},
+			theirs:        map[string]dockerAuth{rhcephRegistry: newRHCEPH},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{rhcephRegistry: newRHCEPH},
+			wantConflicts: []string{},
+		},
+		{
+			name:          "add new registry",
+			ours:          map[string]dockerAuth{"cloud.openshift.com": cloud},
+			theirs:        map[string]dockerAuth{rhcephRegistry: newRHCEPH},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{"cloud.openshift.com": cloud, rhcephRegistry: newRHCEPH},
+			wantConflicts: []string{},
+		},
+		{
+			name:          "same credentials are no conflict",
+			ours:          map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			theirs:        map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			wantConflicts: []string{},
+		},
+		{
+			name:          "different credentials, ours",
+			ours:          map[string]dockerAuth{"cloud.openshift.com": cloud, rhcephRegistry: oldRHCEPH},
+			theirs:        map[string]dockerAuth{rhcephRegistry: newRHCEPH},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{"cloud.openshift.com": cloud, rhcephRegistry: oldRHCEPH},
+			wantConflicts: []string{rhcephRegistry},
+		},
+		{
+			name:          "different credentials, theirs",
+			ours:          map[string]dockerAuth{"cloud.openshift.com": cloud, rhcephRegistry: oldRHCEPH},
+			theirs:        map[string]dockerAuth{rhcephRegistry: newRHCEPH},
+			policy:        pullSecretConflictTheirs,
+			want:          map[string]dockerAuth{"cloud.openshift.com": cloud, rhcephRegistry: newRHCEPH},
+			wantConflicts: []string{rhcephRegistry},
+		},
+		{
+			name:          "identity token makes credentials different, ours",
+			ours:          map[string]dockerAuth{rhcephRegistry: tokenRHCEPH},
+			theirs:        map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			policy:        pullSecretConflictOurs,
+			want:          map[string]dockerAuth{rhcephRegistry: tokenRHCEPH},
+			wantConflicts: []string{rhcephRegistry},
+		},
+		{
+			name:          "identity token makes credentials different, theirs",
+			ours:          map[string]dockerAuth{rhcephRegistry: tokenRHCEPH},
+			theirs:        map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			policy:        pullSecretConflictTheirs,
+			want:          map[string]dockerAuth{rhcephRegistry: oldRHCEPH},
+			wantConflicts: []string{rhcephRegistry},
+		},
+		{
+			name:          "conflicts are sorted",
+			ours:          map[string]dockerAuth{"b.example.com": oldRHCEPH, "a.example.com": oldRHCEPH},
+			theirs:        map[string]dockerAuth{"b.example.com": newRHCEPH, "a.example.com": newRHCEPH},
+			policy:        pullSecretConflictTheirs,
+			want:          map[string]dockerAuth{"a.example.com": newRHCEPH, "b.example.com": newRHCEPH},
+			wantConflicts: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:    "unknown policy",
+			ours:    map[string]dockerAuth{},
+			theirs:  map[string]dockerAuth{},
+			policy:  "union",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ours := &dockerConfig{Auths: tt.ours}
+			theirs := &dockerConfig{Auths: tt.theirs}
+			oursBefore := map[string]dockerAuth{}
+			for registry, auth := range tt.ours {
+				oursBefore[registry] = auth
+			}
+
+			got, conflicts, err := mergeDockerConfigs(ours, theirs, tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Auths, tt.want) {
+				t.Errorf("got auths %+v, want %+v", got.Auths, tt.want)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("got conflicts %v, want %v", conflicts, tt.wantConflicts)
+			}
+			if !reflect.DeepEqual(ours.Auths, oursBefore) {
+				t.Errorf("ours was modified: %+v", ours.Auths)
+			}
+		})
+	}
+}
+
+func TestFindRegistryAuth(t *testing.T) {
+	auth := dockerAuth{Auth: "dXNlcjpwYXNz"}
+
+	tests := []struct {
+		name      string
+		keys      []string
+		wantKey   string
+		wantExact bool
+		wantFound bool
+	}{
+		{
+			name: "no auths",
+			keys: []string{},
+		},
+		{
+			name: "other registries",
+			keys: []string{"cloud.openshift.com", "quay.io.example.com", "quay.io/rhceph"},
+		},
+		{
+			name:      "exact key",
+			keys:      []string{"quay.io", rhcephRegistry},
+			wantKey:   rhcephRegistry,
+			wantExact: true,
+			wantFound: true,
+		},
+		{
+			name:      "exact key with scheme and trailing slash",
+			keys:      []string{"https://quay.io/rhceph-dev/"},
+			wantKey:   "https://quay.io/rhceph-dev/",
+			wantExact: true,
+			wantFound: true,
+		},
+		{
+			name:      "canonical form preferred",
+			keys:      []string{"https://quay.io/rhceph-dev", rhcephRegistry},
+			wantKey:   rhcephRegistry,
+			wantExact: true,
+			wantFound: true,
+		},
+		{
+			name:      "host only",
+			keys:      []string{"cloud.openshift.com", "quay.io"},
+			wantKey:   "quay.io",
+			wantFound: true,
+		},
+		{
+			name:      "host with scheme",
+			keys:      []string{"https://quay.io/"},
+			wantKey:   "https://quay.io/",
+			wantFound: true,
+		},
+		{
+			name:      "narrower scope does not cover",
+			keys:      []string{"quay.io", "quay.io/rhceph-dev/odf4"},
+			wantKey:   "quay.io",
+			wantFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &dockerConfig{Auths: map[string]dockerAuth{}}
+			for _, key := range tt.keys {
+				config.Auths[key] = auth
+			}
+
+			key, exact, found := config.findRegistryAuth(rhcephRegistry)
+			if key != tt.wantKey || exact != tt.wantExact || found != tt.wantFound {
+				t.Errorf("got %q, exact %v, found %v, want %q, exact %v, found %v",
+					key, exact, found, tt.wantKey, tt.wantExact, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+)
+
+const (
+	pullSecretModeAuto      = "auto"
+	pullSecretModeGlobal    = "global"
+	pullSecretModeNamespace = "namespace"
+)
+
+// namespacePullSecretName is the name of the RHCEPH pull secret created in
+// every namespace that pulls RHCEPH images when the global pull secret cannot
+// be edited.
+const namespacePullSecretName = "rhceph-pull-secret"
+
+func addPullSecretModeFlag(flags *flag.FlagSet) *string {
+	return flags.String("pull-secret-mode", pullSecretModeAuto, "Where to add the RHCEPH registry auth: global (openshift-config/pull-secret), namespace (pull secrets linked to service accounts) or auto")
+}
+
+func validatePullSecretMode(mode string) error {
+	switch mode {
+	case pullSecretModeAuto, pullSecretModeGlobal, pullSecretModeNamespace:
+		return nil
+	default:
+		return fmt.Errorf("unknown pull secret mode %q", mode)
+	}
+}
+
+// getPullSecretManager returns the platform whose controller reconciles the
+// global pull secret of the cluster, or an empty string if the global pull
+// secret can be edited.
+func getPullSecretManager(kconfig string) (string, error) {
+	hosted, err := isHostedControlPlane(kconfig)
+	if err != nil {
+		return "", err
+	}
+
+	// Hosted control planes take the pull secret from the HostedCluster on
+	// the management cluster and overwrite local edits.
+	if hosted {
+		return "HyperShift hosted control plane", nil
+	}
+
+	// OpenShift Dedicated and ROSA sync the pull secret from OCM.
+	var ns namespace
+	found, err := getJSON(kconfig, &ns, "namespace/openshift-backplane")
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return "OpenShift Dedicated or ROSA", nil
+	}
+
+	return "", nil
+}
+
+// resolvePullSecretMode turns the auto mode into the mode that works on the
+// cluster.
+func resolvePullSecretMode(clusterName, kconfig, mode string) (string, error) {
+	if mode != pullSecretModeAuto {
+		return mode, nil
+	}
+
+	manager, err := getPullSecretManager(kconfig)
+	if err != nil {
+		return "", fmt.Errorf("error detecting pull secret management: %v", err)
+	}
+
+	if manager == "" {
+		return pullSecretModeGlobal, nil
+	}
+
+	slog.Warn("global pull secret is managed by the platform, edits would be reverted; using namespace pull secrets instead",
+		"cluster", clusterName, "platform", manager)
+
+	return pullSecretModeNamespace, nil
+}
+
+// pullSecretNamespaces returns the namespaces whose pods pull RHCEPH images:
+// the CatalogSource namespace, the ODF namespace and the namespaces of the
+// configured operators.
+func pullSecretNamespaces(operators []operatorConfig) []string {
+	namespaces := []string{"openshift-marketplace", "openshift-storage"}
+	for _, operator := range operators {
+		ns := operator.Namespace
+		if ns == "" {
+			ns = globalOperatorsNamespace
+		}
+
+		if !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	return namespaces
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type localObjectReference struct {
+	Name string `json:"name"`
+}
+
+type serviceAccountList struct {
+	Items []struct {
+		Metadata         objectMeta             `json:"metadata"`
+		ImagePullSecrets []localObjectReference `json:"imagePullSecrets"`
+	} `json:"items"`
+}
+
+// pullFailingPodList are the pods of a namespace with what tells whether they
+// failed to pull an image without the namespace pull secret.
+type pullFailingPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name            string `json:"name"`
+			OwnerReferences []any  `json:"ownerReferences"`
+		} `json:"metadata"`
+		Spec struct {
+			ServiceAccountName string                 `json:"serviceAccountName"`
+			ImagePullSecrets   []localObjectReference `json:"imagePullSecrets"`
+		} `json:"spec"`
+		Status struct {
+			InitContainerStatuses []containerWaitingStatus `json:"initContainerStatuses"`
+			ContainerStatuses     []containerWaitingStatus `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type containerWaitingStatus struct {
+	State struct {
+		Waiting *struct {
+			Reason string `json:"reason"`
+		} `json:"waiting"`
+	} `json:"state"`
+}
+
+func hasPullSecret(secrets []localObjectReference) bool {
+	return slices.ContainsFunc(secrets, func(ref localObjectReference) bool { return ref.Name == namespacePullSecretName })
+}
+
+// addNamespacePullSecrets creates a pull secret with the RHCEPH registry auth
+// in every namespace and links it to the service accounts of the namespace for
+// image pulls. The service accounts of the operators do not exist yet, they
+// are linked by linkNamespacePullSecrets while the operators are installed.
+func addNamespacePullSecrets(clusterName, kconfig, rhcephPassword string, namespaces []string, report *clusterReport) error {
+	loginFileName := clusterName + "-rhceph-pull-secret.json"
+	registryLoginCmd := ocCommand(kconfig, "registry", "login", "--registry="+rhcephRegistry,
+		"--auth-basic="+rhcephPassword, "--to="+loginFileName)
+	err := registryLoginCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error logging into registry: %v", err)
+	}
+
+	if err := recordArtifact(clusterName, loginFileName); err != nil {
+		return err
+	}
+
+	loginOutput, err := os.ReadFile(loginFileName)
+	if err != nil {
+		return fmt.Errorf("error reading registry login: %v", err)
+	}
+
+	pullSecret, err := parseDockerConfig(loginOutput)
+	if err != nil {
+		return fmt.Errorf("invalid registry login: %v", err)
+	}
+
+	data, err := pullSecret.encode()
+	if err != nil {
+		return err
+	}
+
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	for _, ns := range namespaces {
+		manifests.Items = append(manifests.Items,
+			namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: ns}},
+			secret{
+				APIVersion: "v1",
+				Kind:       "Secret",
+				Metadata:   objectMeta{Name: namespacePullSecretName, Namespace: ns},
+				Type:       "kubernetes.io/dockerconfigjson",
+				Data:       map[string][]byte{".dockerconfigjson": data},
+			})
+	}
+
+	manifestData, err := json.MarshalIndent(manifests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding namespace pull secrets: %v", err)
+	}
+
+	fileName := clusterName + "-namespace-pull-secrets.json"
+	err = writeArtifact(clusterName, fileName, manifestData)
+	if err != nil {
+		return fmt.Errorf("error writing namespace pull secrets to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying namespace pull secrets: %v", err)
+	}
+
+	for _, ns := range namespaces {
+		slog.Info("added namespace pull secret", "cluster", clusterName, "namespace", ns)
+	}
+
+	return linkNamespacePullSecrets(clusterName, kconfig, namespaces, report)
+}
+
+// linkNamespacePullSecrets links the namespace pull secret to the service
+// accounts of the namespaces that do not have it yet, like the ones created
+// by the CSVs of the operators. The pods of controllers that failed to pull
+// an image without the secret are deleted, so that they are created again
+// with it. Pods only get the pull secrets of their service account when they
+// are created.
+func linkNamespacePullSecrets(clusterName, kconfig string, namespaces []string, report *clusterReport) error {
+	for _, ns := range namespaces {
+		var accounts serviceAccountList
+		if _, err := getJSON(kconfig, &accounts, "serviceaccounts", "-n", ns); err != nil {
+			return err
+		}
+
+		linked := []string{}
+		for _, account := range accounts.Items {
+			if !hasPullSecret(account.ImagePullSecrets) {
+				linkCmd := ocCommand(kconfig, "secrets", "link", account.Metadata.Name, namespacePullSecretName, "--for=pull", "-n", ns)
+				err := linkCmd.Run()
+				if err != nil {
+					return fmt.Errorf("error linking pull secret to service account %s/%s: %v", ns, account.Metadata.Name, err)
+				}
+				slog.Info("linked namespace pull secret", "cluster", clusterName, "namespace", ns, "serviceAccount", account.Metadata.Name)
+			}
+			linked = append(linked, account.Metadata.Name)
+		}
+
+		var pods pullFailingPodList
+		if _, err := getJSON(kconfig, &pods, "pods", "-n", ns); err != nil {
+			return err
+		}
+
+		for _, pod := range pods.Items {
+			failed := slices.ContainsFunc(append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...), func(status containerWaitingStatus) bool {
+				waiting := status.State.Waiting
+				return waiting != nil && (waiting.Reason == "ErrImagePull" || waiting.Reason == "ImagePullBackOff")
+			})
+			if !failed || hasPullSecret(pod.Spec.ImagePullSecrets) || len(pod.Metadata.OwnerReferences) == 0 ||
+				!slices.Contains(linked, pod.Spec.ServiceAccountName) {
+				continue
+			}
+
+			deleteCmd := ocCommand(kconfig, "delete", "pod", pod.Metadata.Name, "-n", ns, "--ignore-not-found", "--wait=false")
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting pod %s/%s: %v", ns, pod.Metadata.Name, err)
+			}
+			report.recordRemediation("deleted pod %s/%s, which failed to pull an image before its service account was linked to the namespace pull secret",
+				ns, pod.Metadata.Name)
+		}
+	}
+
+	return nil
+}
+
+// removeNamespacePullSecrets deletes the namespace pull secrets. Service
+// accounts keep a reference to the deleted secret, which is ignored for pulls.
+func removeNamespacePullSecrets(kconfig string, namespaces []string) error {
+	for _, ns := range namespaces {
+		deleteCmd := ocCommand(kconfig, "delete", "secret", namespacePullSecretName, "-n", ns, "--ignore-not-found")
+		err := deleteCmd.Run()
+		if err != nil {
+			return fmt.Errorf("error deleting pull secret in namespace %s: %v", ns, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	readVerbs   = []string{"get", "list", "watch"}
+	writeVerbs  = []string{"get", "list", "watch", "create", "patch", "update"}
+	deleteVerbs = []string{"get", "list", "watch", "create", "patch", "update", "delete"}
+)
+
+type policyRule struct {
+	APIGroups     []string `json:"apiGroups"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	Verbs         []string `json:"verbs"`
+}
+
+type clusterRole struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Rules []policyRule `json:"rules"`
+}
+
+type roleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+type subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type clusterRoleBinding struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	RoleRef    roleRef    `json:"roleRef"`
+	Subjects   []subject  `json:"subjects"`
+}
+
+// rbacPermission is a rule needed by a profile. Optional rules are only used
+// for extra checks, which are skipped with a warning without them.
+type rbacPermission struct {
+	rule     policyRule
+	optional bool
+}
+
+// rbacProfile are the permissions needed by a set of commands run with a
+// scoped service account instead of cluster-admin.
+type rbacProfile struct {
+	name        string
+	commands    string
+	permissions []rbacPermission
+}
+
+func requiredRule(groups, resources, verbs []string) rbacPermission {
+	return rbacPermission{rule: policyRule{APIGroups: groups, Resources: resources, Verbs: verbs}}
+}
+
+func optionalRule(groups, resources, verbs []string) rbacPermission {
+	p := requiredRule(groups, resources, verbs)
+	p.optional = true
+	return p
+}
+
+// preparePermissions are the permissions of prepare, which cleanup needs
+// with delete on top.
+func preparePermissions(verbs []string) []rbacPermission {
+	permissions := []rbacPermission{
+		requiredRule([]string{""}, []string{"namespaces", "secrets", "serviceaccounts"}, verbs),
+		requiredRule([]string{""}, []string{"pods"}, readVerbs),
+		requiredRule([]string{"operator.openshift.io"}, []string{"imagecontentsourcepolicies"}, verbs),
+		requiredRule([]string{"operators.coreos.com"}, []string{"catalogsources", "subscriptions", "operatorgroups", "clusterserviceversions"}, verbs),
+		requiredRule([]string{"operators.coreos.com"}, []string{"installplans"}, readVerbs),
+		requiredRule([]string{"packages.operators.coreos.com"}, []string{"packagemanifests"}, readVerbs),
+		requiredRule([]string{"ocs.openshift.io"}, []string{"storageclusters", "ocsinitializations"}, verbs),
+		requiredRule([]string{"ceph.rook.io"}, []string{"cephclusters", "cephblockpools", "cephfilesystems"}, verbs),
+		requiredRule([]string{"lvm.topolvm.io"}, []string{"lvmclusters"}, verbs),
+		requiredRule([]string{"storage.k8s.io"}, []string{"storageclasses"}, readVerbs),
+		requiredRule([]string{"apps"}, []string{"deployments"}, readVerbs),
+		optionalRule([]string{"config.openshift.io"}, []string{"infrastructures", "clusterversions"}, readVerbs),
+		optionalRule([]string{""}, []string{"nodes"}, readVerbs),
+		optionalRule([]string{"machineconfiguration.openshift.io"}, []string{"machineconfigpools"}, readVerbs),
+		optionalRule([]string{""}, []string{"configmaps"}, verbs),
+		// Remediating Subscriptions that fail to resolve.
+		optionalRule([]string{"batch"}, []string{"jobs"}, deleteVerbs),
+		optionalRule([]string{""}, []string{"pods", "configmaps"}, []string{"delete"}),
+		optionalRule([]string{"operators.coreos.com"}, []string{"subscriptions", "clusterserviceversions"}, []string{"delete"}),
+	}
+
+	// The resources left in a Terminating namespace, whose finalizers
+	// -terminating-namespaces clear removes.
+	for _, rule := range resourceRules(finalizedNamespaceResources) {
+		permissions = append(permissions, optionalRule(rule.APIGroups, rule.Resources, []string{"list", "patch"}))
+	}
+
+	return permissions
+}
+
+// resourceRules returns a rule per API group for resources in the form of oc
+// api-resources, like cephclusters.ceph.rook.io, in the order of resources.
+func resourceRules(resources []string) []policyRule {
+	rules := []policyRule{}
+	for _, resource := range resources {
+		name, group, _ := strings.Cut(resource, ".")
+		i := slices.IndexFunc(rules, func(rule policyRule) bool { return rule.APIGroups[0] == group })
+		if i == -1 {
+			rules = append(rules, policyRule{APIGroups: []string{group}})
+			i = len(rules) - 1
+		}
+		rules[i].Resources = append(rules[i].Resources, name)
+	}
+
+	return rules
+}
+
+// rbacProfiles are the profiles of print-rbac.
+var rbacProfiles = []rbacProfile{
+	{
+		name:        "prepare",
+		commands:    "prepare, fleet (managed clusters) and reconcile",
+		permissions: preparePermissions(writeVerbs),
+	},
+	{
+		name:     "cleanup",
+		commands: "cleanup",
+		permissions: append(preparePermissions(deleteVerbs),
+			requiredRule([]string{"ramendr.openshift.io"}, []string{"drplacementcontrols", "volumereplicationgroups"}, readVerbs),
+			// The storage teardown of -namespace-cleanup-policy.
+			requiredRule([]string{"odf.openshift.io"}, []string{"storagesystems"}, deleteVerbs),
+			requiredRule([]string{""}, []string{"persistentvolumes", "persistentvolumeclaims"}, deleteVerbs),
+			requiredRule([]string{"ceph.rook.io", "noobaa.io"}, []string{"*"}, deleteVerbs),
+			requiredRule([]string{"local.storage.openshift.io"}, []string{"localvolumesets", "localvolumes"}, deleteVerbs),
+			requiredRule([]string{""}, []string{"nodes"}, []string{"get", "list", "patch"}),
+			// oc debug node for -wipe-disks.
+			requiredRule([]string{""}, []string{"pods", "pods/attach"}, deleteVerbs),
+			rbacPermission{rule: policyRule{APIGroups: []string{"security.openshift.io"}, Resources: []string{"securitycontextconstraints"},
+				ResourceNames: []string{"privileged"}, Verbs: []string{"use"}}},
+		),
+	},
+	{
+		name:     "configure-dr",
+		commands: "configure-dr and fleet (hub)",
+		permissions: []rbacPermission{
+			requiredRule([]string{"cluster.open-cluster-management.io"}, []string{"managedclusters"}, []string{"get", "list", "watch", "patch", "update"}),
+			requiredRule([]string{"cluster.open-cluster-management.io"}, []string{"managedclustersets", "managedclustersetbindings", "placements"}, writeVerbs),
+			requiredRule([]string{"cluster.open-cluster-management.io"}, []string{"managedclustersets/join", "managedclustersets/bind"}, []string{"create"}),
+			requiredRule([]string{"cluster.open-cluster-management.io"}, []string{"placementdecisions"}, readVerbs),
+			requiredRule([]string{"multicluster.odf.openshift.io"}, []string{"mirrorpeers"}, writeVerbs),
+			requiredRule([]string{"ramendr.openshift.io"}, []string{"drpolicies"}, writeVerbs),
+			// The DR resources replicated to a standby hub.
+			optionalRule([]string{"ramendr.openshift.io"}, []string{"drclusters"}, writeVerbs),
+			optionalRule([]string{""}, []string{"secrets"}, writeVerbs),
+			requiredRule([]string{"work.open-cluster-management.io"}, []string{"manifestworks"}, writeVerbs),
+			requiredRule([]string{"view.open-cluster-management.io"}, []string{"managedclusterviews"}, writeVerbs),
+			requiredRule([]string{""}, []string{"secrets"}, readVerbs),
+			requiredRule([]string{"apps.open-cluster-management.io"}, []string{"gitopsclusters"}, writeVerbs),
+			requiredRule([]string{""}, []string{"configmaps", "namespaces"}, writeVerbs),
+			optionalRule([]string{"argoproj.io"}, []string{"argocds"}, readVerbs),
+			optionalRule([]string{"addon.open-cluster-management.io"}, []string{"managedclusteraddons"}, readVerbs),
+		},
+	},
+	{
+		name:     "operator",
+		commands: "operator, on top of prepare and configure-dr on the hub",
+		permissions: []rbacPermission{
+			requiredRule([]string{"installer.odfdr.io"}, []string{"odfdrinstallations"}, readVerbs),
+			requiredRule([]string{"installer.odfdr.io"}, []string{"odfdrinstallations/status"}, []string{"get", "patch", "update"}),
+			requiredRule([]string{""}, []string{"secrets"}, []string{"get"}),
+			optionalRule([]string{"apiextensions.k8s.io"}, []string{"customresourcedefinitions"}, writeVerbs),
+		},
+	},
+	{
+		name:     "switch-hub",
+		commands: "switch-hub (hubs)",
+		permissions: []rbacPermission{
+			requiredRule([]string{"cluster.open-cluster-management.io"}, []string{"managedclusters"}, writeVerbs),
+			requiredRule([]string{"agent.open-cluster-management.io"}, []string{"klusterletaddonconfigs"}, writeVerbs),
+			requiredRule([]string{""}, []string{"namespaces", "secrets"}, writeVerbs),
+		},
+	},
+	{
+		name:     "cluster-pool",
+		commands: "prepare -claim-from-pool (hub)",
+		permissions: []rbacPermission{
+			requiredRule([]string{"hive.openshift.io"}, []string{"clusterclaims"}, deleteVerbs),
+			requiredRule([]string{"hive.openshift.io"}, []string{"clusterdeployments"}, readVerbs),
+			requiredRule([]string{""}, []string{"secrets"}, []string{"get"}),
+		},
+	},
+	{
+		name:     "read-only",
+		commands: "verify, doctor, diagnose-peering, gather and compare",
+		permissions: []rbacPermission{
+			requiredRule([]string{""}, []string{"pods", "events", "nodes", "configmaps"}, readVerbs),
+			requiredRule([]string{""}, []string{"pods/log"}, []string{"get"}),
+			requiredRule([]string{"apps"}, []string{"deployments"}, readVerbs),
+			requiredRule([]string{"coordination.k8s.io"}, []string{"leases"}, readVerbs),
+			requiredRule([]string{"operators.coreos.com"}, []string{"catalogsources", "subscriptions", "installplans", "clusterserviceversions"}, readVerbs),
+			requiredRule([]string{"operator.openshift.io"}, []string{"imagecontentsourcepolicies"}, readVerbs),
+			requiredRule([]string{"machineconfiguration.openshift.io"}, []string{"machineconfigpools"}, readVerbs),
+			requiredRule([]string{"apiextensions.k8s.io"}, []string{"customresourcedefinitions"}, readVerbs),
+			requiredRule([]string{"ocs.openshift.io"}, []string{"storageclusters"}, readVerbs),
+			requiredRule([]string{"multicluster.odf.openshift.io"}, []string{"mirrorpeers"}, readVerbs),
+			requiredRule([]string{"ramendr.openshift.io"}, []string{"drclusters", "drpolicies", "drplacementcontrols"}, readVerbs),
+			requiredRule([]string{"addon.open-cluster-management.io"}, []string{"managedclusteraddons"}, readVerbs),
+			optionalRule([]string{"config.openshift.io"}, []string{"infrastructures", "clusterversions"}, readVerbs),
+			// The peering token secrets diagnose-peering looks for. Listing
+			// Secrets returns their data, grant it to diagnose the peering
+			// only.
+			optionalRule([]string{""}, []string{"secrets"}, []string{"list"}),
+		},
+	},
+}
+
+// rbacManifests returns a ClusterRole for every profile, and a binding to the
+// service account if it is given.
+func rbacManifests(profiles []rbacProfile, serviceAccount *objectMeta) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+
+	for _, profile := range profiles {
+		name := "odfdr-installer-" + profile.name
+
+		rules := []policyRule{}
+		for _, permission := range profile.permissions {
+			rules = append(rules, permission.rule)
+		}
+		role := clusterRole{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Rules: rules}
+		role.Metadata.Name = name
+		role.Metadata.Labels = map[string]string{managedByLabel: managedByValue}
+		manifests.Items = append(manifests.Items, role)
+
+		if serviceAccount != nil {
+			binding := clusterRoleBinding{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRoleBinding",
+				Metadata:   objectMeta{Name: name + "-" + serviceAccount.Namespace + "-" + serviceAccount.Name},
+				RoleRef:    roleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: name},
+				Subjects:   []subject{{Kind: "ServiceAccount", Name: serviceAccount.Name, Namespace: serviceAccount.Namespace}},
+			}
+			manifests.Items = append(manifests.Items, binding)
+		}
+	}
+
+	return manifests
+}
+
+// describeRBACProfile lists the rules of a profile, marking the optional
+// ones.
+func describeRBACProfile(profile rbacProfile) string {
+	var description strings.Builder
+	fmt.Fprintf(&description, "# %s: %s\n", profile.name, profile.commands)
+	for _, permission := range profile.permissions {
+		groups := slices.Clone(permission.rule.APIGroups)
+		for i, group := range groups {
+			if group == "" {
+				groups[i] = "core"
+			}
+		}
+
+		fmt.Fprintf(&description, "#   %s %s: %s", strings.Join(groups, ","), strings.Join(permission.rule.Resources, ","),
+			strings.Join(permission.rule.Verbs, ","))
+		if permission.optional {
+			description.WriteString(" (optional)")
+		}
+		description.WriteString("\n")
+	}
+
+	return description.String()
+}
+
+func runPrintRBAC(args []string) {
+	flags := flag.NewFlagSet("print-rbac", flag.ExitOnError)
+	var profileNames stringList
+	flags.Var(&profileNames, "profile", "Profile to print the ClusterRole of (can be repeated, default: all profiles)")
+	serviceAccountFlag := flags.String("service-account", "", "Service account in namespace/name form to bind the ClusterRoles to")
+	describeFlag := flags.Bool("describe", false, "List the rules of the profiles instead of printing the manifests")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	profiles := rbacProfiles
+	if len(profileNames) > 0 {
+		profiles = []rbacProfile{}
+		for _, name := range profileNames {
+			i := slices.IndexFunc(rbacProfiles, func(p rbacProfile) bool { return p.name == name })
+			if i == -1 {
+				slog.Error("error: unknown profile", "profile", name)
+				showUsageAndExit()
+			}
+			profiles = append(profiles, rbacProfiles[i])
+		}
+	}
+
+	if *describeFlag {
+		for _, profile := range profiles {
+			fmt.Print(describeRBACProfile(profile))
+		}
+		return
+	}
+
+	var serviceAccount *objectMeta
+	if *serviceAccountFlag != "" {
+		ns, name, found := strings.Cut(*serviceAccountFlag, "/")
+		if !found || ns == "" || name == "" {
+			slog.Error("error: invalid -service-account, expected namespace/name", "serviceAccount", *serviceAccountFlag)
+			showUsageAndExit()
+		}
+		serviceAccount = &objectMeta{Name: name, Namespace: ns}
+	}
+
+	data, err := json.MarshalIndent(rbacManifests(profiles, serviceAccount), "", "  ")
+	if err != nil {
+		slog.Error("error encoding RBAC manifests", "error", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
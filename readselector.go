@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// readSelector is the label selector of the read-only commands, which limits
+// the resources they list to the matching ones, e.g. to keep a scoped service
+// account away from resources of other teams that it may list.
+var readSelector string
+
+func addReadSelectorFlag(flags *flag.FlagSet) {
+	flags.StringVar(&readSelector, "selector", "", "Label selector that the resources listed must match, e.g. 'team=dr' or 'environment!=production'; resources read by name are not filtered")
+}
+
+// isListRead reports whether the arguments of oc get list resources instead
+// of reading them by name: a resource type, or comma separated types, followed
+// by flags only.
+func isListRead(args []string) bool {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "/") {
+		return false
+	}
+
+	return len(args) == 1 || strings.HasPrefix(args[1], "-")
+}
+
+// withReadSelector adds the readSelector to the arguments of an oc get that
+// lists resources, combined with the selector of the arguments, if any.
+func withReadSelector(args []string) []string {
+	if readSelector == "" || !isListRead(args) {
+		return args
+	}
+
+	args = append([]string{}, args...)
+	for i, arg := range args {
+		switch {
+		case (arg == "-l" || arg == "--selector") && i+1 < len(args):
+			args[i+1] += "," + readSelector
+			return args
+		case strings.HasPrefix(arg, "-l=") || strings.HasPrefix(arg, "--selector="):
+			args[i] += "," + readSelector
+			return args
+		}
+	}
+
+	return append(args, "-l", readSelector)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// readyFile is written for downstream CI stages once a DR pair has been
+// verified to be operational. Its absence means the pair is not ready.
+type readyFile struct {
+	RunID    string                  `json:"runId"`
+	Time     time.Time               `json:"time"`
+	Clusters map[string]readyCluster `json:"clusters"`
+}
+
+type readyCluster struct {
+	URL              string            `json:"url"`
+	OperatorVersions map[string]string `json:"operatorVersions"`
+}
+
+// checkDRPairReady returns an error unless the verified clusters are a DR
+// pair that is ready for DR, checked like by the fleet scorecard: at least
+// two clusters run the DR cluster operator and their operators succeeded in
+// the same versions, and the hub among the clusters has peered them with a
+// MirrorPeer, validated their DRClusters and a DRPolicy covering them.
+func checkDRPairReady(kconfigs map[string]string, clusterVersions map[string]map[string]string) error {
+	hubs := []string{}
+	managedClusters := []string{}
+	for _, cluster := range sortedClusterNames(clusterVersions) {
+		if _, ok := clusterVersions[cluster]["odr-hub-operator"]; ok {
+			hubs = append(hubs, cluster)
+		}
+		if _, ok := clusterVersions[cluster]["odr-cluster-operator"]; ok {
+			managedClusters = append(managedClusters, cluster)
+		}
+	}
+
+	if len(managedClusters) < 2 {
+		return fmt.Errorf("found %d clusters with odr-cluster-operator installed, a DR pair needs 2", len(managedClusters))
+	}
+	if len(hubs) != 1 {
+		return fmt.Errorf("found %d clusters with odr-hub-operator installed, the kubeconfig of the hub of the DR pair is needed", len(hubs))
+	}
+	hubKconfig := kconfigs[hubs[0]]
+
+	managedKconfigs := []string{}
+	for _, cluster := range managedClusters {
+		managedKconfigs = append(managedKconfigs, kconfigs[cluster])
+	}
+
+	// The operator versions of the report are the ones of verify, the
+	// scorecard only records the ones it checks.
+	scratch := &runReport{Clusters: map[string]*clusterReport{}}
+	policy, _ := checkPairPolicy(hubKconfig, managedClusters)
+	checks := []struct {
+		name  string
+		check scorecardCheck
+	}{
+		{name: "CSVs", check: checkPairCSVs(managedClusters, managedKconfigs, scratch)},
+		{name: "mirroring", check: checkPairMirroring(hubKconfig, managedClusters)},
+		{name: "DRClusters", check: checkPairDRClusters(hubKconfig, managedClusters)},
+		{name: "DRPolicy", check: policy},
+	}
+
+	problems := []string{}
+	for _, c := range checks {
+		if !c.check.OK {
+			problems = append(problems, c.name+": "+c.check.Detail)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("DR pair %s is not ready: %s", strings.Join(managedClusters, ", "), strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// checkPairDRClusters checks that the DRClusters of the pair on the hub are
+// validated.
+func checkPairDRClusters(hubKconfig string, clusters []string) scorecardCheck {
+	problems := []string{}
+	for _, cluster := range clusters {
+		var drCluster conditionedObject
+		found, err := getJSON(hubKconfig, &drCluster, "drclusters.ramendr.openshift.io", cluster)
+		if err != nil {
+			return checkFailed(err.Error())
+		}
+		if !found {
+			problems = append(problems, cluster+" missing")
+			continue
+		}
+
+		if cond, ok := conditionStatus(drCluster.Status.Conditions, "Validated"); !ok || cond.Status != "True" {
+			problems = append(problems, fmt.Sprintf("%s not validated: %s", cluster, cond.Message))
+		}
+	}
+
+	if len(problems) > 0 {
+		return checkFailed(strings.Join(problems, "; "))
+	}
+
+	return checkPassed("Validated")
+}
+
+func writeReadyFile(fileName string, report *runReport) error {
+	ready := readyFile{
+		RunID:    report.ID,
+		Time:     time.Now(),
+		Clusters: map[string]readyCluster{},
+	}
+
+	for name, cluster := range report.Clusters {
+		ready.Clusters[name] = readyCluster{URL: cluster.URL, OperatorVersions: cluster.OperatorVersions}
+	}
+
+	data, err := json.MarshalIndent(ready, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ready file: %v", err)
+	}
+
+	err = writeRawArtifact("", fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing ready file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// hasDrifted reports whether the live resources differ from the manifest file.
+func hasDrifted(kconfig, fileName string) (bool, error) {
+	diffCmd := ocCommand(kconfig, "diff", "--server-side", "--field-manager="+fieldManager, "-f", fileName)
+	diffOutput, err := diffCmd.Output()
+	if err == nil {
+		return false, nil
+	}
+
+	// oc diff exits with 1 when there are differences and with a higher
+	// status when the diff itself failed.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		slog.Info("drift detected", "manifest", fileName, "diff", string(diffOutput))
+		return true, nil
+	}
+
+	return false, fmt.Errorf("error comparing %s with the cluster: %v", fileName, err)
+}
+
+// reconcileMirrorSets reapplies the mirror sets that have drifted and returns
+// their names.
+func reconcileMirrorSets(clusterName, kconfig string, sets []mirrorSet) ([]string, error) {
+	reapplied := []string{}
+
+	for _, set := range sets {
+		icspFileName, err := writeMirrorSet(clusterName, set)
+		if err != nil {
+			return nil, err
+		}
+
+		drifted, err := hasDrifted(kconfig, icspFileName)
+		if err != nil {
+			return nil, err
+		}
+
+		if !drifted {
+			continue
+		}
+
+		if err := addMirrorSet(clusterName, kconfig, set); err != nil {
+			return nil, fmt.Errorf("error adding mirror set %s: %v", set.Name, err)
+		}
+		reapplied = append(reapplied, "mirror set "+set.Name)
+	}
+
+	return reapplied, nil
+}
+
+// reconcileCatalogSource reapplies the CatalogSource if it has drifted.
+func reconcileCatalogSource(clusterName, kconfig, catalogSourceYAML string) ([]string, error) {
+	catalogSourceFileName, err := writeCatalogSource(clusterName, catalogSourceYAML)
+	if err != nil {
+		return nil, err
+	}
+
+	drifted, err := hasDrifted(kconfig, catalogSourceFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !drifted {
+		return nil, nil
+	}
+
+	if err := addCatalogSource(clusterName, kconfig, catalogSourceYAML); err != nil {
+		return nil, err
+	}
+
+	return []string{"CatalogSource"}, nil
+}
+
+func runReconcile(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the cluster to reconcile")
+	rhcephPasswordFlag := flags.String("rhceph-password", "", "RHCEPH repository password, the pull secret is not reconciled without it")
+	manifests := addManifestFlags(flags)
+	configFlag := addConfigFlag(flags)
+	pullSecretConflictFlag := addPullSecretConflictFlag(flags)
+	registryAuthMatchFlag := addRegistryAuthMatchFlag(flags)
+	pruneFlag := addPruneFlag(flags)
+	addMessageFlags(flags)
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if err := validatePullSecretConflict(*pullSecretConflictFlag); err != nil {
+		slog.Error("error: invalid -pull-secret-conflict", "error", err)
+		showUsageAndExit()
+	}
+
+	if err := validateRegistryAuthMatch(*registryAuthMatchFlag); err != nil {
+		slog.Error("error: invalid -registry-auth-match", "error", err)
+		showUsageAndExit()
+	}
+
+	kconfig := *kubeconfigFlag
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	if err := manifests.applyRelease(cfg); err != nil {
+		slog.Error("error selecting release", "error", err)
+		os.Exit(1)
+	}
+
+	mirrorSets, err := manifests.loadMirrorSets()
+	if err != nil {
+		slog.Error("error loading mirror sets", "error", err)
+		os.Exit(1)
+	}
+
+	if err := checkRequiredCommands(); err != nil {
+		slog.Error("error checking required commands", "error", err)
+		os.Exit(1)
+	}
+
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to cluster", "error", err)
+		os.Exit(1)
+	}
+
+	clusterName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting cluster name", "error", err)
+		os.Exit(1)
+	}
+
+	catalogSourceYAML := manifests.catalogSourceYAML(cfg)
+	docs := []string{catalogSourceYAML}
+	for _, set := range mirrorSets {
+		docs = append(docs, set.YAML)
+	}
+	kinds := []groupVersionKind{}
+	for _, doc := range docs {
+		kind, err := manifestKind(doc)
+		if err != nil {
+			slog.Error("error reading manifests", "error", err)
+			os.Exit(1)
+		}
+		kinds = append(kinds, kind)
+	}
+	if _, err := checkServedKinds(clusterName, kconfig, kinds); err != nil {
+		slog.Error("error checking manifests", "error", err)
+		os.Exit(1)
+	}
+
+	if *rhcephPasswordFlag != "" {
+		if err := addRHCEPHAuth(clusterName, kconfig, globalPullSecret, *rhcephPasswordFlag, *pullSecretConflictFlag, *registryAuthMatchFlag, false); err != nil {
+			slog.Error("error adding RHCEPH auth to pull secret", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		slog.Info("no RHCEPH password given, not reconciling the pull secret")
+	}
+
+	reapplied, err := reconcileMirrorSets(clusterName, kconfig, mirrorSets)
+	if err != nil {
+		slog.Error("error reconciling mirror sets", "error", err)
+		os.Exit(1)
+	}
+
+	reappliedCatalog, err := reconcileCatalogSource(clusterName, kconfig, catalogSourceYAML)
+	if err != nil {
+		slog.Error("error reconciling CatalogSource", "error", err)
+		os.Exit(1)
+	}
+	reapplied = append(reapplied, reappliedCatalog...)
+
+	pruned, err := pruneStaleResources(clusterName, kconfig, mirrorSets, catalogSourceYAML, *pruneFlag)
+	if err != nil {
+		slog.Error("error pruning stale resources", "error", err)
+		os.Exit(1)
+	}
+	for _, resource := range pruned {
+		reapplied = append(reapplied, "pruned "+resource)
+	}
+
+	if len(reapplied) == 0 {
+		slog.Info("no drift detected", "cluster", clusterName)
+		return
+	}
+
+	slog.Info("reapplied drifted resources", "cluster", clusterName, "resources", reapplied)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+)
+
+// redactPatterns are the patterns of the configuration file whose matches are
+// redacted from the logs, the printed commands, the reports and the traces,
+// e.g. internal host names, on top of the known secrets.
+var redactPatterns []*regexp.Regexp
+
+// setRedactPatterns compiles the patterns and redacts their matches from
+// everything logged from now on.
+func setRedactPatterns(patterns []string) error {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	redactPatterns = compiled
+	if len(redactPatterns) > 0 {
+		// slog logs through the standard logger until a handler is set.
+		log.SetOutput(redactingWriter{w: logOutput})
+	}
+
+	return nil
+}
+
+// redactText replaces the matches of the redact patterns.
+func redactText(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+
+	return s
+}
+
+// redactingWriter redacts everything written to w. Loggers write every line
+// at once, so a match cannot be split across writes.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write([]byte(redactText(string(p)))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// jsonString matches a string of a JSON document, followed by a colon if it
+// is a key.
+var jsonString = regexp.MustCompile(`"(?:[^"\\]|\\.)*"(\s*:)?`)
+
+// redactJSON redacts the string values of a JSON document, leaving its keys,
+// order and indentation intact.
+func redactJSON(data []byte) ([]byte, error) {
+	if len(redactPatterns) == 0 {
+		return data, nil
+	}
+
+	var redactErr error
+	redactedData := jsonString.ReplaceAllFunc(data, func(match []byte) []byte {
+		if match[len(match)-1] == ':' {
+			return match
+		}
+
+		var value string
+		if err := json.Unmarshal(match, &value); err != nil {
+			redactErr = err
+			return match
+		}
+
+		var encoded bytes.Buffer
+		encoder := json.NewEncoder(&encoded)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(redactText(value)); err != nil {
+			redactErr = err
+			return match
+		}
+
+		return bytes.TrimSuffix(encoded.Bytes(), []byte("\n"))
+	})
+
+	return redactedData, redactErr
+}
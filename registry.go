@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// imageHeavySteps are the prepare steps pulling most of the images: the
+// catalog image, the operator bundles and images, and the Ceph images.
+var imageHeavySteps = []string{"catalog", "operators", "storage-cluster"}
+
+// registryConfig eases the pulls from registries with rate limits shared by a
+// lab.
+type registryConfig struct {
+	// PullThroughCaches map a registry host to a pull-through cache of it,
+	// like quay.io to cache.example.com/quay. The cache is added to the
+	// mirror sets before every mirror on the registry.
+	PullThroughCaches map[string]string `json:"pullThroughCaches,omitempty"`
+	// Concurrency limits how many clusters of the run pull from a registry
+	// host at once in the image-heavy steps.
+	Concurrency map[string]int `json:"concurrency,omitempty"`
+}
+
+var (
+	// pullThroughCaches are the caches of the configuration file by the
+	// registry host they cache.
+	pullThroughCaches = map[string]string{}
+	// registrySlots hold a token for every image-heavy step running against
+	// a registry host with a concurrency limit.
+	registrySlots = map[string]chan struct{}{}
+)
+
+// setRegistryConfig validates the registry settings of the configuration file
+// and makes them the settings of the run.
+func setRegistryConfig(cfg *registryConfig) error {
+	caches := map[string]string{}
+	slots := map[string]chan struct{}{}
+
+	if cfg != nil {
+		for host, cache := range cfg.PullThroughCaches {
+			if host == "" || strings.Contains(host, "/") {
+				return fmt.Errorf("invalid registry.pullThroughCaches host %q, expected a registry host like quay.io", host)
+			}
+			if cache == "" || strings.Contains(cache, "://") {
+				return fmt.Errorf("invalid registry.pullThroughCaches cache %q of %s, expected a registry host and path without scheme", cache, host)
+			}
+			caches[host] = strings.TrimSuffix(cache, "/")
+		}
+
+		for host, limit := range cfg.Concurrency {
+			if host == "" || strings.Contains(host, "/") {
+				return fmt.Errorf("invalid registry.concurrency host %q, expected a registry host like quay.io", host)
+			}
+			if limit < 1 {
+				return fmt.Errorf("invalid registry.concurrency of %s: %d, expected at least 1", host, limit)
+			}
+			slots[host] = make(chan struct{}, limit)
+		}
+	}
+
+	pullThroughCaches = caches
+	registrySlots = slots
+
+	return nil
+}
+
+// imageRegistryHost returns the registry host of an image reference, Docker
+// Hub for references without one.
+func imageRegistryHost(image string) string {
+	host, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+
+	return host
+}
+
+// catalogSourceImage returns the image of a CatalogSource manifest.
+func catalogSourceImage(catalogSourceYAML string) string {
+	for _, line := range strings.Split(catalogSourceYAML, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), "image:"); found {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+
+	return ""
+}
+
+// acquireRegistrySlot waits until the step of the cluster of kconfig may pull
+// from the registry host, and returns the function releasing the slot. Hosts
+// without a concurrency limit are not waited for.
+func acquireRegistrySlot(clusterName, kconfig, host, stepName string) (func(), error) {
+	slots, ok := registrySlots[host]
+	if !ok {
+		return func() {}, nil
+	}
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	slog.Info("waiting for other clusters to finish pulling from the registry", "cluster", clusterName, "step", stepName,
+		"registry", host, "concurrency", cap(slots))
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case slots <- struct{}{}:
+			slog.Info("got a slot of the registry", "cluster", clusterName, "step", stepName, "registry", host,
+				"duration", time.Since(start).Round(time.Second))
+			return release, nil
+		case <-ticker.C:
+			if err := siblingFailed(kconfig); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// withRegistryLimits makes the image-heavy steps wait for a slot of the
+// registry host they pull from, so that the clusters of a fleet take turns
+// instead of hitting the rate limits of the registry at once.
+func withRegistryLimits(clusterName, kconfig, host string, steps []step) []step {
+	if _, ok := registrySlots[host]; !ok {
+		return steps
+	}
+
+	limit := func(stepName string, run func() error) func() error {
+		if run == nil {
+			return nil
+		}
+
+		return func() error {
+			release, err := acquireRegistrySlot(clusterName, kconfig, host, stepName)
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			return run()
+		}
+	}
+
+	result := slices.Clone(steps)
+	for i, s := range result {
+		if slices.Contains(imageHeavySteps, s.name) {
+			result[i].run = limit(s.name, s.run)
+			result[i].force = limit(s.name, s.force)
+		}
+	}
+
+	return result
+}
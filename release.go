@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// releaseStream locates the ODF DR catalog and the mirror sets of a release
+// stream, like 4.18.
+type releaseStream struct {
+	// CatalogImage is the catalog image of the stream. Without it, the
+	// newest build of the stream in CatalogRepository is used.
+	CatalogImage      string `json:"catalogImage,omitempty"`
+	CatalogRepository string `json:"catalogRepository,omitempty"`
+	// MirrorSets are the embedded mirror sets of the stream, and
+	// MirrorSetFiles additional mirror set files.
+	MirrorSets     []string `json:"mirrorSets,omitempty"`
+	MirrorSetFiles []string `json:"mirrorSetFiles,omitempty"`
+	// PublicCatalog marks streams whose catalog and images are public, like
+	// released ODF from the official catalogs, which need no RHCEPH
+	// registry auth.
+	PublicCatalog bool `json:"publicCatalog,omitempty"`
+}
+
+// defaultReleaseStreams are the streams known without a configuration file.
+// Their builds are tagged like 4.18.0-123.konflux.
+var defaultReleaseStreams = map[string]releaseStream{
+	"4.16": {CatalogRepository: defaultCatalogRepository, MirrorSets: []string{"odf", "ceph"}},
+	"4.17": {CatalogRepository: defaultCatalogRepository, MirrorSets: []string{"odf", "ceph"}},
+	"4.18": {CatalogRepository: defaultCatalogRepository, MirrorSets: []string{"odf", "ceph"}},
+	"4.19": {CatalogRepository: defaultCatalogRepository, MirrorSets: []string{"odf", "ceph"}},
+}
+
+// releaseStreams returns the default streams with those of the configuration
+// file added or replaced.
+func (c *config) releaseStreams() map[string]releaseStream {
+	streams := map[string]releaseStream{}
+	for name, stream := range defaultReleaseStreams {
+		streams[name] = stream
+	}
+	for name, stream := range c.Releases {
+		streams[name] = stream
+	}
+
+	return streams
+}
+
+func validateReleaseStreams(streams map[string]releaseStream) error {
+	for name, stream := range streams {
+		if stream.CatalogImage == "" && stream.CatalogRepository == "" {
+			return fmt.Errorf("release %s needs a catalogImage or a catalogRepository", name)
+		}
+	}
+
+	return nil
+}
+
+func releaseStreamNames(streams map[string]releaseStream) []string {
+	names := []string{}
+	for name := range streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// applyRelease selects the catalog image and mirror sets of the -release
+// stream. A -catalog-image or -mirror-sets given on the command line takes
+// precedence, the mirror set files of the stream are added to -mirror-set-file.
+func (o *manifestOptions) applyRelease(cfg *config) error {
+	if *o.release == "" {
+		return nil
+	}
+
+	streams := cfg.releaseStreams()
+	stream, found := streams[*o.release]
+	if !found {
+		return fmt.Errorf("unknown release %q, known releases: %s", *o.release, strings.Join(releaseStreamNames(streams), ", "))
+	}
+
+	explicit := []string{}
+	o.flags.Visit(func(f *flag.Flag) {
+		explicit = append(explicit, f.Name)
+	})
+
+	if !slices.Contains(explicit, "catalog-image") {
+		image := stream.CatalogImage
+		if image == "" {
+			tags, err := listBuilds(stream.CatalogRepository, os.Getenv("QUAY_TOKEN"), *o.release+".", 1)
+			if err != nil {
+				return fmt.Errorf("error finding the newest build of release %s: %v", *o.release, err)
+			}
+			if len(tags) == 0 {
+				return fmt.Errorf("no build of release %s found in %s", *o.release, stream.CatalogRepository)
+			}
+			image = stream.CatalogRepository + ":" + tags[0].Name
+		}
+		*o.catalogImage = image
+	}
+
+	if !slices.Contains(explicit, "mirror-sets") && len(stream.MirrorSets) > 0 {
+		*o.mirrorSets = strings.Join(stream.MirrorSets, ",")
+	}
+	o.mirrorSetFiles = append(o.mirrorSetFiles, stream.MirrorSetFiles...)
+	o.publicCatalog = stream.PublicCatalog
+
+	slog.Info("using release", "release", *o.release, "catalogImage", *o.catalogImage, "mirrorSets", *o.mirrorSets, "publicCatalog", o.publicCatalog)
+
+	return nil
+}
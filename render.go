@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+// renderKinds are the manifests the render command renders, in the order the
+// steps apply them.
+var renderKinds = []string{"icsp", "catalogsource", "storagecluster"}
+
+// renderedManifest is a manifest as a step applies it, without the cluster
+// prefix in its file name.
+type renderedManifest struct {
+	fileName string
+	docs     []string
+}
+
+// renderICSP returns the ImageContentSourcePolicies of the mirror sets, one
+// manifest per mirror set. The mirror set labels are added by the mirror-sets
+// step once they are applied.
+func renderICSP(manifests *manifestOptions) ([]renderedManifest, error) {
+	sets, err := manifests.loadMirrorSets()
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := []renderedManifest{}
+	for _, set := range sets {
+		rendered = append(rendered, renderedManifest{fileName: set.Name + "-icsp.yaml", docs: []string{set.YAML}})
+	}
+
+	return rendered, nil
+}
+
+// renderCatalogSource returns the CatalogSource of the ODF catalog.
+func renderCatalogSource(manifests *manifestOptions, cfg *config) renderedManifest {
+	return renderedManifest{fileName: "catalogsource.yaml", docs: []string{manifests.catalogSourceYAML(cfg)}}
+}
+
+// renderStorageCluster returns the StorageCluster of the configuration, or the
+// LVMCluster with the lvms backend, or nil when no storage is configured.
+func renderStorageCluster(cfg *storageConfig) (*renderedManifest, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var fileName string
+	var obj any
+	switch {
+	case cfg.Backend == storageBackendLVMS:
+		fileName, obj = "lvmcluster.json", manifest.RenderLVMCluster(manifest.LVMClusterOptions{
+			Namespace:           cfg.Namespace,
+			DeviceClass:         cfg.LVMS.DeviceClass,
+			DevicePaths:         cfg.LVMS.DevicePaths,
+			ThinPoolSizePercent: cfg.LVMS.ThinPoolSizePercent,
+		})
+	case cfg.StorageCluster != nil:
+		fileName, obj = "storagecluster.json", manifest.RenderStorageCluster(manifest.StorageClusterOptions{
+			Namespace:        cfg.Namespace,
+			Name:             cfg.StorageCluster.Name,
+			StorageClassName: cfg.StorageCluster.StorageClassName,
+			DeviceSize:       cfg.StorageCluster.DeviceSize,
+			DeviceCount:      cfg.StorageCluster.DeviceCount,
+			Placement:        cfg.StorageCluster.Placement,
+		})
+	default:
+		return nil, nil
+	}
+
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s: %v", fileName, err)
+	}
+
+	return &renderedManifest{fileName: fileName, docs: []string{string(data) + "\n"}}, nil
+}
+
+// renderManifests renders the manifests of the kinds and applies the overlays
+// of the configuration file to them, as applyManifest would.
+func renderManifests(manifests *manifestOptions, cfg *config, kinds []string) ([]renderedManifest, error) {
+	rendered := []renderedManifest{}
+	for _, kind := range kinds {
+		switch kind {
+		case "icsp":
+			icsps, err := renderICSP(manifests)
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(rendered, icsps...)
+		case "catalogsource":
+			rendered = append(rendered, renderCatalogSource(manifests, cfg))
+		case "storagecluster":
+			storage, err := renderStorageCluster(cfg.Storage)
+			if err != nil {
+				return nil, err
+			}
+			if storage == nil {
+				slog.Info("no StorageCluster configured")
+				continue
+			}
+			rendered = append(rendered, *storage)
+		}
+	}
+
+	for i, m := range rendered {
+		docs, _, err := overlayDocuments("", m.fileName, m.docs)
+		if err != nil {
+			return nil, err
+		}
+		rendered[i].docs = docs
+	}
+
+	return rendered, nil
+}
+
+func runRender(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	manifests := addManifestFlags(flags)
+	configFlag := addConfigFlag(flags)
+	var kinds stringList
+	flags.Var(&kinds, "manifest", "Manifest to render: "+strings.Join(renderKinds, ", ")+" (can be repeated, default: all)")
+	outputDirFlag := flags.String("output-dir", "", "Directory to write a file per manifest to (default: stdout)")
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if len(kinds) == 0 {
+		kinds = renderKinds
+	}
+	for _, kind := range kinds {
+		if !slices.Contains(renderKinds, kind) {
+			slog.Error("error: invalid -manifest", "manifest", kind, "expected", strings.Join(renderKinds, ", "))
+			showUsageAndExit()
+		}
+	}
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	if err := manifests.applyRelease(cfg); err != nil {
+		slog.Error("error selecting release", "error", err)
+		os.Exit(1)
+	}
+
+	rendered, err := renderManifests(manifests, cfg, kinds)
+	if err != nil {
+		slog.Error("error rendering manifests", "error", err)
+		os.Exit(1)
+	}
+
+	if *outputDirFlag == "" {
+		docs := []string{}
+		for _, m := range rendered {
+			docs = append(docs, m.docs...)
+		}
+		os.Stdout.WriteString(strings.Join(docs, "---\n"))
+		return
+	}
+
+	if err := mkdirPrivate(*outputDirFlag); err != nil {
+		slog.Error("error creating output directory", "error", err)
+		os.Exit(1)
+	}
+	for _, m := range rendered {
+		fileName := filepath.Join(*outputDirFlag, m.fileName)
+		if err := writePrivateFile(fileName, []byte(strings.Join(m.docs, "---\n"))); err != nil {
+			slog.Error("error writing manifest", "error", fmt.Errorf("%s: %v", fileName, err))
+			os.Exit(1)
+		}
+		slog.Info("rendered manifest", "file", fileName)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	outcomeSucceeded = "succeeded"
+	outcomeFailed    = "failed"
+	// outcomePartial is the outcome of a fleet run where some of the
+	// managed clusters were set up and others failed.
+	outcomePartial = "partial"
+)
+
+// runReport summarizes a single run of the installer. It is written as JSON
+// next to the other generated files and kept in the run history.
+type runReport struct {
+	ID                string                    `json:"id"`
+	Command           string                    `json:"command"`
+	StartTime         time.Time                 `json:"startTime"`
+	EndTime           time.Time                 `json:"endTime"`
+	Outcome           string                    `json:"outcome"`
+	Error             string                    `json:"error,omitempty"`
+	Clusters          map[string]*clusterReport `json:"clusters"`
+	VersionMismatches []versionMismatch         `json:"versionMismatches,omitempty"`
+	// FailedClusters are the managed clusters of a fleet run that were not
+	// set up.
+	FailedClusters []string `json:"failedClusters,omitempty"`
+	// Scorecard is the DR readiness of the pairs of a fleet checked by
+	// verify -fleet.
+	Scorecard *fleetScorecard `json:"scorecard,omitempty"`
+
+	mu sync.Mutex
+}
+
+type clusterReport struct {
+	URL string `json:"url,omitempty"`
+	// ClusterClaim is the Hive ClusterClaim the cluster was claimed with,
+	// in namespace/name form.
+	ClusterClaim     string            `json:"clusterClaim,omitempty"`
+	Steps            []stepRecord      `json:"steps,omitempty"`
+	OperatorVersions map[string]string `json:"operatorVersions,omitempty"`
+	// Network is the network to the DR peer, when it was measured.
+	Network *networkMeasurement `json:"network,omitempty"`
+	// DestructiveActions are the actions of the run that deleted or wiped
+	// data, like the StorageCluster or the local disks.
+	DestructiveActions []string `json:"destructiveActions,omitempty"`
+	// Remediations are the actions of the run that repaired known failure
+	// modes, like a Subscription that failed to resolve.
+	Remediations []string `json:"remediations,omitempty"`
+	// UnverifiedKinds are the kinds of the manifests that the cluster could
+	// not be checked to serve, as their discovery failed.
+	UnverifiedKinds []string `json:"unverifiedKinds,omitempty"`
+	// EtcdBackup is the location of the backup taken before the run changed
+	// the cluster, with -backup-before-changes.
+	EtcdBackup string `json:"etcdBackup,omitempty"`
+	// PreviousRun is an earlier run that did not finish, found before the
+	// steps.
+	PreviousRun *previousRun `json:"previousRun,omitempty"`
+	// Changes are the resources the run added, removed or changed, when it
+	// was run with -snapshot.
+	Changes *clusterChanges `json:"changes,omitempty"`
+	// Outcome and Error are the outcome of a managed cluster of a fleet
+	// run, which is set up once its DR pair is configured.
+	Outcome string `json:"outcome,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// recordDestructiveAction logs an action that deleted or wiped data and adds
+// it to the report.
+func (c *clusterReport) recordDestructiveAction(format string, args ...any) {
+	action := fmt.Sprintf(format, args...)
+	slog.Warn("destructive action", "action", action)
+	c.DestructiveActions = append(c.DestructiveActions, action)
+}
+
+// recordRemediation logs an action that repaired a known failure mode and
+// adds it to the report, if any.
+func (c *clusterReport) recordRemediation(format string, args ...any) {
+	action := fmt.Sprintf(format, args...)
+	slog.Warn("remediation", "action", action)
+	if c != nil {
+		c.Remediations = append(c.Remediations, action)
+	}
+}
+
+func newRunID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func newRunReport(command string) *runReport {
+	id := newRunID()
+	artifactRunID = id
+	runLog.reset()
+
+	return &runReport{
+		ID:        id,
+		Command:   command,
+		StartTime: time.Now(),
+		Clusters:  map[string]*clusterReport{},
+	}
+}
+
+// cluster returns the report section of a cluster, creating it if needed.
+func (r *runReport) cluster(name string) *clusterReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Clusters[name] == nil {
+		r.Clusters[name] = &clusterReport{}
+	}
+
+	return r.Clusters[name]
+}
+
+func (r *runReport) write(fileName string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %v", err)
+	}
+
+	data, err = redactJSON(data)
+	if err != nil {
+		return fmt.Errorf("error redacting report: %v", err)
+	}
+
+	err = writePrivateFile(fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing report to file: %v", err)
+	}
+
+	return nil
+}
+
+// finish records the outcome of the run, writes the report to fileName and
+// adds the run to the run history.
+func (r *runReport) finish(fileName string, runErr error) error {
+	r.EndTime = time.Now()
+	r.Outcome = outcomeSucceeded
+	if runErr != nil {
+		r.Outcome = outcomeFailed
+		r.Error = runErr.Error()
+
+		if succeeded, failed := r.completion(); len(succeeded) > 0 && len(failed) > 0 {
+			r.Outcome = outcomePartial
+			r.FailedClusters = failed
+		}
+	}
+
+	if err := r.write(fileName); err != nil {
+		return err
+	}
+
+	if err := recordArtifact("", fileName); err != nil {
+		return err
+	}
+
+	if err := saveRun(r); err != nil {
+		slog.Warn("error saving run history", "error", err)
+	}
+
+	if activeTracer != nil {
+		if err := activeTracer.export(r); err != nil {
+			slog.Warn("error exporting trace", "error", err)
+		}
+	}
+
+	forwardRunLog(r)
+
+	return nil
+}
+
+// completion returns the managed clusters of a fleet run that were set up and
+// those that failed, sorted by name.
+func (r *runReport) completion() ([]string, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	succeeded, failed := []string{}, []string{}
+	for _, name := range sortedClusterNames(r.Clusters) {
+		switch r.Clusters[name].Outcome {
+		case outcomeSucceeded:
+			succeeded = append(succeeded, name)
+		case outcomeFailed:
+			failed = append(failed, name)
+		}
+	}
+
+	return succeeded, failed
+}
+
+// exitWithFailedRun logs the error, records the failed run and exits.
+func exitWithFailedRun(report *runReport, fileName, msg string, err error) {
+	notify(messageError, "run-failed", "action", msg, "error", err.Error())
+
+	if err := report.finish(fileName, fmt.Errorf("%s: %v", msg, err)); err != nil {
+		slog.Error("error writing report", "error", err)
+	} else {
+		notify(messageHint, "see-report", "report", fileName, "run", report.ID)
+	}
+
+	os.Exit(1)
+}
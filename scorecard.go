@@ -0,0 +1,579 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// scorecardOperators are the operators checked on the managed clusters of a
+// DR pair.
+var scorecardOperators = []string{"odf-operator", "odr-cluster-operator"}
+
+// scorecardCheck is the outcome of a check of a DR pair.
+type scorecardCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+func checkPassed(detail string) scorecardCheck {
+	return scorecardCheck{OK: true, Detail: detail}
+}
+
+func checkFailed(detail string) scorecardCheck {
+	return scorecardCheck{Detail: detail}
+}
+
+func (c scorecardCheck) String() string {
+	if c.OK {
+		return c.Detail
+	}
+
+	return "FAIL: " + c.Detail
+}
+
+// scorecardRow is the DR readiness of a pair of a fleet.
+type scorecardRow struct {
+	Hub       string         `json:"hub"`
+	Clusters  []string       `json:"clusters"`
+	Catalog   scorecardCheck `json:"catalog"`
+	CSVs      scorecardCheck `json:"csvs"`
+	Mirroring scorecardCheck `json:"mirroring"`
+	Policy    scorecardCheck `json:"policy"`
+	LastSync  scorecardCheck `json:"lastSync"`
+	// Error is why the pair could not be checked.
+	Error string `json:"error,omitempty"`
+	// Fingerprints are the resource versions of the resources of the
+	// catalog, csvs and mirroring checks, by check, which tell an
+	// incremental verification whether they changed.
+	Fingerprints map[string]string `json:"fingerprints,omitempty"`
+	// Unchanged are the checks taken from the previous verification, as
+	// their resources did not change.
+	Unchanged []string `json:"unchanged,omitempty"`
+}
+
+func (r scorecardRow) passed() bool {
+	return r.Error == "" && r.Catalog.OK && r.CSVs.OK && r.Mirroring.OK && r.Policy.OK && r.LastSync.OK
+}
+
+// fleetScorecard is the DR readiness of every pair of a fleet.
+type fleetScorecard struct {
+	Rows     []scorecardRow `json:"rows"`
+	Passed   int            `json:"passed"`
+	PassRate float64        `json:"passRate"`
+}
+
+// scoreFleet checks the DR readiness of every pair of the fleet. The pairs are
+// checked in parallel, a pair whose clusters cannot be reached fails. With a
+// previous scorecard, the checks that passed then and whose resources did not
+// change since are not evaluated again.
+func scoreFleet(f *fleet, report *runReport, previous *fleetScorecard) *fleetScorecard {
+	hubRows := make([][]scorecardRow, len(f.Hubs))
+	fns := []func() error{}
+	for i, hub := range f.Hubs {
+		fns = append(fns, func() error {
+			hubRows[i] = scoreHub(hub, report, previous)
+			return nil
+		})
+	}
+	runParallel(fns...)
+
+	card := &fleetScorecard{Rows: slices.Concat(hubRows...)}
+	for _, row := range card.Rows {
+		if row.passed() {
+			card.Passed++
+		}
+	}
+	if len(card.Rows) > 0 {
+		card.PassRate = float64(card.Passed) / float64(len(card.Rows))
+	}
+
+	return card
+}
+
+// scoreHub checks the pairs of a hub.
+func scoreHub(hub fleetHub, report *runReport, previous *fleetScorecard) []scorecardRow {
+	rows := make([]scorecardRow, len(hub.Pairs))
+	for i, pair := range hub.Pairs {
+		rows[i].Hub = hub.displayName()
+		for _, cluster := range pair.Clusters {
+			rows[i].Clusters = append(rows[i].Clusters, cluster.displayName())
+		}
+	}
+
+	hubName, hubKconfig, err := hub.connect()
+	if err != nil {
+		for i := range rows {
+			rows[i].Error = fmt.Sprintf("error connecting to hub %s: %v", rows[i].Hub, err)
+		}
+		return rows
+	}
+	report.cluster(hubName).URL = hub.URL
+
+	fns := []func() error{}
+	for i, pair := range hub.Pairs {
+		fns = append(fns, func() error {
+			rows[i] = scorePair(hubName, hubKconfig, pair, report, previous)
+			return nil
+		})
+	}
+	runParallel(fns...)
+
+	return rows
+}
+
+// scorePair checks the catalog, the operators, the mirroring, the DRPolicy
+// and the last sync of the protected workloads of a pair.
+func scorePair(hubName, hubKconfig string, pair fleetPair, report *runReport, previous *fleetScorecard) scorecardRow {
+	row := scorecardRow{Hub: hubName}
+	kconfigs := []string{}
+	for _, cluster := range pair.Clusters {
+		name, kconfig, err := cluster.connect()
+		if err != nil {
+			row.Clusters = append(row.Clusters, cluster.displayName())
+			row.Error = fmt.Sprintf("error connecting to cluster %s: %v", cluster.displayName(), err)
+			continue
+		}
+		report.cluster(name).URL = cluster.URL
+		row.Clusters = append(row.Clusters, name)
+		kconfigs = append(kconfigs, kconfig)
+	}
+	if row.Error != "" {
+		return row
+	}
+
+	// The fingerprints are recorded by every run, so that any run can be
+	// verified against with -since.
+	row.Fingerprints = pairFingerprints(hubKconfig, kconfigs)
+	var last *scorecardRow
+	if previous != nil {
+		last = previous.row(row.Hub, row.Clusters)
+	}
+
+	row.Catalog = row.checkUnlessUnchanged(last, "catalog", func() scorecardCheck { return checkPairCatalogs(row.Clusters, kconfigs) })
+	row.CSVs = row.checkUnlessUnchanged(last, "csvs", func() scorecardCheck { return checkPairCSVs(row.Clusters, kconfigs, report) })
+	row.Mirroring = row.checkUnlessUnchanged(last, "mirroring", func() scorecardCheck { return checkPairMirroring(hubKconfig, row.Clusters) })
+	// The last sync ages with time, it is always checked, with the
+	// DRPolicies it needs.
+	var policies []scoredPolicy
+	row.Policy, policies = checkPairPolicy(hubKconfig, row.Clusters)
+	row.LastSync = checkPairLastSync(hubKconfig, policies)
+
+	slog.Info("checked DR pair", "hub", hubName, "clusters", row.Clusters, "passed", row.passed(), "unchanged", row.Unchanged)
+
+	return row
+}
+
+// checkPairCatalogs checks that the CatalogSources applied by the installer
+// are READY on both clusters.
+func checkPairCatalogs(clusters, kconfigs []string) scorecardCheck {
+	problems := []string{}
+	for i, kconfig := range kconfigs {
+		var catalogs struct {
+			Items []catalogSource `json:"items"`
+		}
+		_, err := getJSON(kconfig, &catalogs, "catalogsources", "-n", "openshift-marketplace", "-l", managedByLabel+"="+managedByValue)
+		if err != nil {
+			problems = append(problems, clusters[i]+": "+err.Error())
+			continue
+		}
+
+		if len(catalogs.Items) == 0 {
+			problems = append(problems, clusters[i]+": no CatalogSource of the installer")
+		}
+		for _, catalog := range catalogs.Items {
+			if state := catalog.Status.ConnectionState.LastObservedState; state != "READY" {
+				problems = append(problems, fmt.Sprintf("%s: %s is %q", clusters[i], catalog.Metadata.Name, state))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return checkFailed(strings.Join(problems, "; "))
+	}
+
+	return checkPassed("READY")
+}
+
+// checkPairCSVs checks that the operators of a managed cluster succeeded on
+// both clusters, with the same versions.
+func checkPairCSVs(clusters, kconfigs []string, report *runReport) scorecardCheck {
+	problems := []string{}
+	clusterVersions := map[string]map[string]string{}
+	for i, kconfig := range kconfigs {
+		var csvs clusterServiceVersionList
+		if _, err := getJSON(kconfig, &csvs, "clusterserviceversions", "--all-namespaces"); err != nil {
+			problems = append(problems, clusters[i]+": "+err.Error())
+			continue
+		}
+
+		versions := map[string]string{}
+		for _, pkg := range scorecardOperators {
+			j := slices.IndexFunc(csvs.Items, func(csv clusterServiceVersion) bool {
+				name, _, _ := strings.Cut(csv.Metadata.Name, ".v")
+				return name == pkg && csv.Status.Reason != "Copied"
+			})
+			if j == -1 {
+				problems = append(problems, clusters[i]+": "+pkg+" missing")
+				continue
+			}
+			if phase := csvs.Items[j].Status.Phase; phase != "Succeeded" {
+				problems = append(problems, fmt.Sprintf("%s: %s is %s", clusters[i], csvs.Items[j].Metadata.Name, phase))
+			}
+			versions[pkg] = csvs.Items[j].Spec.Version
+		}
+		clusterVersions[clusters[i]] = versions
+		report.cluster(clusters[i]).OperatorVersions = versions
+	}
+
+	for _, mismatch := range compareOperatorVersions(clusterVersions) {
+		problems = append(problems, fmt.Sprintf("%s versions differ", mismatch.Package))
+	}
+
+	if len(problems) > 0 {
+		return checkFailed(strings.Join(problems, "; "))
+	}
+
+	return checkPassed(scorecardOperators[0] + " " + clusterVersions[clusters[0]][scorecardOperators[0]])
+}
+
+// checkPairMirroring checks that the MirrorPeer of the pair on the hub
+// exchanged the peering tokens.
+func checkPairMirroring(hubKconfig string, clusters []string) scorecardCheck {
+	var peers struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Spec     struct {
+				Items []mirrorPeerItem `json:"items"`
+			} `json:"spec"`
+			mirrorPeerStatus
+		} `json:"items"`
+	}
+	if _, err := getJSON(hubKconfig, &peers, "mirrorpeers.multicluster.odf.openshift.io"); err != nil {
+		return checkFailed(err.Error())
+	}
+
+	for _, peer := range peers.Items {
+		peered := []string{}
+		for _, item := range peer.Spec.Items {
+			peered = append(peered, item.ClusterName)
+		}
+		if len(peered) != len(clusters) || !coversClusters(peered, clusters) {
+			continue
+		}
+
+		if phase := peer.Status.Phase; phase != "ExchangedSecret" && phase != "S3ProfileSynced" {
+			return checkFailed(fmt.Sprintf("MirrorPeer %s is in phase %s", peer.Metadata.Name, phase))
+		}
+
+		return checkPassed(peer.Status.Phase)
+	}
+
+	return checkFailed("no MirrorPeer")
+}
+
+// coversClusters reports whether all clusters are in covered.
+func coversClusters(covered, clusters []string) bool {
+	for _, cluster := range clusters {
+		if !slices.Contains(covered, cluster) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type drPolicyList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+		Spec     struct {
+			DRClusters         []string `json:"drClusters"`
+			SchedulingInterval string   `json:"schedulingInterval"`
+		} `json:"spec"`
+		Status struct {
+			Conditions []condition `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// scoredPolicy is a DRPolicy covering a pair.
+type scoredPolicy struct {
+	name     string
+	interval time.Duration
+}
+
+// checkPairPolicy checks that a validated DRPolicy covers the pair, and
+// returns the DRPolicies that cover it.
+func checkPairPolicy(hubKconfig string, clusters []string) (scorecardCheck, []scoredPolicy) {
+	var policies drPolicyList
+	if _, err := getJSON(hubKconfig, &policies, "drpolicies.ramendr.openshift.io"); err != nil {
+		return checkFailed(err.Error()), nil
+	}
+
+	covering := []scoredPolicy{}
+	validated := []string{}
+	problems := []string{}
+	for _, policy := range policies.Items {
+		if !coversClusters(policy.Spec.DRClusters, clusters) {
+			continue
+		}
+
+		// Metro DR policies have no scheduling interval.
+		interval, _ := parseSchedulingInterval(policy.Spec.SchedulingInterval)
+		covering = append(covering, scoredPolicy{name: policy.Metadata.Name, interval: interval})
+
+		if cond, ok := conditionStatus(policy.Status.Conditions, "Validated"); !ok || cond.Status != "True" {
+			problems = append(problems, fmt.Sprintf("%s not validated: %s", policy.Metadata.Name, cond.Message))
+			continue
+		}
+		validated = append(validated, policy.Metadata.Name)
+	}
+
+	if len(covering) == 0 {
+		return checkFailed("no DRPolicy"), nil
+	}
+	if len(problems) > 0 {
+		return checkFailed(strings.Join(problems, "; ")), covering
+	}
+
+	return checkPassed(strings.Join(validated, ", ")), covering
+}
+
+// checkPairLastSync checks that the workloads protected by the DRPolicies of
+// the pair synced within three scheduling intervals, and returns the time
+// since the oldest sync.
+func checkPairLastSync(hubKconfig string, policies []scoredPolicy) scorecardCheck {
+	if len(policies) == 0 {
+		return checkFailed("no DRPolicy")
+	}
+
+	var drpcs struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Spec     struct {
+				DRPolicyRef struct {
+					Name string `json:"name"`
+				} `json:"drPolicyRef"`
+			} `json:"spec"`
+			Status struct {
+				LastGroupSyncTime string `json:"lastGroupSyncTime"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(hubKconfig, &drpcs, "drplacementcontrols.ramendr.openshift.io", "--all-namespaces"); err != nil {
+		return checkFailed(err.Error())
+	}
+
+	protected := 0
+	var oldest time.Duration
+	problems := []string{}
+	for _, drpc := range drpcs.Items {
+		i := slices.IndexFunc(policies, func(p scoredPolicy) bool { return p.name == drpc.Spec.DRPolicyRef.Name })
+		if i == -1 {
+			continue
+		}
+		protected++
+		name := drpc.Metadata.Namespace + "/" + drpc.Metadata.Name
+
+		// Metro DR replicates synchronously, there is no last sync.
+		if policies[i].interval == 0 {
+			continue
+		}
+
+		synced, err := time.Parse(time.RFC3339, drpc.Status.LastGroupSyncTime)
+		if err != nil {
+			problems = append(problems, name+" never synced")
+			continue
+		}
+
+		age := time.Since(synced)
+		oldest = max(oldest, age)
+		if age > 3*policies[i].interval {
+			problems = append(problems, fmt.Sprintf("%s synced %v ago", name, age.Round(time.Second)))
+		}
+	}
+
+	if len(problems) > 0 {
+		return checkFailed(strings.Join(problems, "; "))
+	}
+	if protected == 0 {
+		return checkPassed("no protected workloads")
+	}
+	if oldest == 0 {
+		return checkPassed(fmt.Sprintf("%d synchronous", protected))
+	}
+
+	return checkPassed(fmt.Sprintf("%v ago (%d workloads)", oldest.Round(time.Second), protected))
+}
+
+// fingerprintedChecks are the checks whose resources are fingerprinted, with
+// the resources of the managed clusters and of the hub they read. version is
+// the JSONPath of what the check reads of a resource, its resourceVersion if
+// empty. The resourceVersion of CatalogSources and CSVs changes with every
+// status update of OLM, like the last connection of a catalog, so they are
+// fingerprinted by their generation and the state the check reads.
+var fingerprintedChecks = []struct {
+	name    string
+	cluster []string
+	hub     []string
+	version string
+}{
+	{
+		name:    "catalog",
+		cluster: []string{"catalogsources", "-n", "openshift-marketplace", "-l", managedByLabel + "=" + managedByValue},
+		version: "{.metadata.generation}:{.status.connectionState.lastObservedState}",
+	},
+	{
+		name:    "csvs",
+		cluster: []string{"clusterserviceversions", "--all-namespaces"},
+		version: "{.metadata.generation}:{.status.phase}:{.status.reason}",
+	},
+	{name: "mirroring", hub: []string{"mirrorpeers.multicluster.odf.openshift.io"}},
+}
+
+// pairFingerprints returns the fingerprints of the checks of a pair. A check
+// whose resources cannot be listed has no fingerprint and is evaluated.
+func pairFingerprints(hubKconfig string, kconfigs []string) map[string]string {
+	fingerprints := map[string]string{}
+	for _, check := range fingerprintedChecks {
+		versions := []string{}
+		listed := true
+		if check.hub != nil {
+			hubVersions, err := resourceVersions(hubKconfig, check.version, check.hub...)
+			versions, listed = hubVersions, err == nil
+		}
+		for i, kconfig := range kconfigs {
+			if check.cluster == nil {
+				break
+			}
+			clusterVersions, err := resourceVersions(kconfig, check.version, check.cluster...)
+			if err != nil {
+				listed = false
+				break
+			}
+			for _, version := range clusterVersions {
+				versions = append(versions, fmt.Sprintf("%d:%s", i, version))
+			}
+		}
+		if !listed {
+			continue
+		}
+
+		slices.Sort(versions)
+		sum := sha256.Sum256([]byte(strings.Join(versions, "\n")))
+		fingerprints[check.name] = hex.EncodeToString(sum[:])
+	}
+
+	return fingerprints
+}
+
+// resourceVersions returns the namespace/name@version of the listed
+// resources, with the version read by the JSONPath version, or the
+// resourceVersion if it is empty.
+func resourceVersions(kconfig, version string, args ...string) ([]string, error) {
+	if version == "" {
+		version = "{.metadata.resourceVersion}"
+	}
+
+	getArgs := append([]string{"get"}, withReadSelector(args)...)
+	getArgs = append(getArgs, "-o", `jsonpath={range .items[*]}{.metadata.namespace}/{.metadata.name}@`+version+`{"\n"}{end}`)
+	output, err := ocCommand(kconfig, getArgs...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", args[0], err)
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// row returns the row of a pair, nil if the scorecard has none.
+func (c *fleetScorecard) row(hub string, clusters []string) *scorecardRow {
+	for i, row := range c.Rows {
+		if row.Hub == hub && slices.Equal(row.Clusters, clusters) {
+			return &c.Rows[i]
+		}
+	}
+
+	return nil
+}
+
+// checkUnlessUnchanged returns the check of the last row when it passed and
+// its resources have the same fingerprint, and evaluates the check otherwise.
+func (r *scorecardRow) checkUnlessUnchanged(last *scorecardRow, name string, check func() scorecardCheck) scorecardCheck {
+	if last != nil && r.Fingerprints[name] != "" && r.Fingerprints[name] == last.Fingerprints[name] {
+		var lastCheck scorecardCheck
+		switch name {
+		case "catalog":
+			lastCheck = last.Catalog
+		case "csvs":
+			lastCheck = last.CSVs
+		case "mirroring":
+			lastCheck = last.Mirroring
+		}
+		if lastCheck.OK {
+			r.Unchanged = append(r.Unchanged, name)
+			return lastCheck
+		}
+	}
+
+	return check()
+}
+
+// lastScorecard returns the scorecard of a verify -fleet run of the history,
+// the most recent one when id is last.
+func lastScorecard(id string) (*fleetScorecard, error) {
+	if id != "last" {
+		r, err := loadRun(id)
+		if err != nil {
+			return nil, err
+		}
+		if r.Scorecard == nil {
+			return nil, fmt.Errorf("run %s has no scorecard", id)
+		}
+		return r.Scorecard, nil
+	}
+
+	runs, err := loadRuns()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Command == "verify" && runs[i].Scorecard != nil {
+			slog.Info("verifying incrementally since run", "run", runs[i].ID, "time", runs[i].StartTime)
+			return runs[i].Scorecard, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no verify -fleet run in the history")
+}
+
+// printScorecard prints a row per DR pair and the pass rate.
+func printScorecard(out io.Writer, card *fleetScorecard) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HUB\tPAIR\tCATALOG\tCSVS\tMIRRORING\tPOLICY\tLAST SYNC\tRESULT")
+	for _, row := range card.Rows {
+		result := "pass"
+		if !row.passed() {
+			result = "fail"
+		}
+
+		cells := []string{row.Catalog.String(), row.CSVs.String(), row.Mirroring.String(), row.Policy.String(), row.LastSync.String()}
+		for i, name := range []string{"catalog", "csvs", "mirroring"} {
+			if slices.Contains(row.Unchanged, name) {
+				cells[i] += " (unchanged)"
+			}
+		}
+		if row.Error != "" {
+			cells = []string{"FAIL: " + row.Error, "-", "-", "-", "-"}
+		}
+		fmt.Fprintln(w, row.Hub+"\t"+strings.Join(row.Clusters, ",")+"\t"+strings.Join(cells, "\t")+"\t"+result)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d of %d DR pairs passed (%.0f%%)\n", card.Passed, len(card.Rows), card.PassRate*100)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// sessionCredentials are the credentials a kubeconfig was logged in with, so
+// that the session can be renewed when its token expires during a long run.
+type sessionCredentials struct {
+	url      string
+	username string
+	password string
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]sessionCredentials{}
+
+	// renewMu serializes renewals, so that steps of the same cluster run in
+	// parallel log in again only once.
+	renewMu sync.Mutex
+)
+
+func rememberSession(kconfig, url, username, password string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	sessions[kconfig] = sessionCredentials{url: url, username: username, password: password}
+}
+
+// hasSession reports whether the installer logged into kconfig itself.
+func hasSession(kconfig string) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	_, found := sessions[kconfig]
+	return found
+}
+
+// isUnauthorized reports whether the output of oc is an authentication
+// failure, which is what oc reports when the token expired.
+func isUnauthorized(output string) bool {
+	return strings.Contains(output, "Unauthorized") || strings.Contains(output, "You must be logged in")
+}
+
+// renewSession logs in again with the credentials of kconfig if its token is
+// no longer accepted. It returns whether the session was renewed.
+func renewSession(kconfig string) (bool, error) {
+	renewMu.Lock()
+	defer renewMu.Unlock()
+
+	var stderr bytes.Buffer
+	whoamiCmd := ocCommand(kconfig, "whoami")
+	whoamiCmd.Stderr = &stderr
+	if err := whoamiCmd.Run(); err == nil || !isUnauthorized(stderr.String()) {
+		return false, nil
+	}
+
+	sessionsMu.Lock()
+	credentials, found := sessions[kconfig]
+	sessionsMu.Unlock()
+	if !found {
+		return false, fmt.Errorf("the session of %s expired, log in again", kconfig)
+	}
+
+	slog.Warn("session expired, logging in again", "cluster", credentials.url)
+	if err := login(credentials.url, credentials.username, credentials.password, kconfig); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
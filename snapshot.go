@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// snapshotResources are the resource types captured before and after a run
+// with -snapshot, the ones a run of the installer changes.
+var snapshotResources = []string{
+	"catalogsources.operators.coreos.com",
+	"imagecontentsourcepolicies.operator.openshift.io",
+	"imagedigestmirrorsets.config.openshift.io",
+	"subscriptions.operators.coreos.com",
+}
+
+// snapshotMetadataResources are the resource types captured by their
+// metadata only, so that their data is never read: a change shows in their
+// resourceVersion.
+var snapshotMetadataResources = []string{
+	"secrets",
+}
+
+// snapshotObject is a resource as captured by a snapshot. Secrets are
+// captured without their data, a change shows in their resourceVersion.
+type snapshotObject struct {
+	ResourceVersion string
+	Spec            map[string]json.RawMessage
+}
+
+// clusterSnapshot are the captured resources by type/namespace/name.
+type clusterSnapshot map[string]snapshotObject
+
+// resourceChange is a resource added, removed or changed by a run.
+type resourceChange struct {
+	Resource string `json:"resource"`
+	// Fields are the changed fields of the spec of a changed resource.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// clusterChanges is what a run changed on the cluster, the difference of the
+// snapshots taken before and after it.
+type clusterChanges struct {
+	Added   []resourceChange `json:"added,omitempty"`
+	Removed []resourceChange `json:"removed,omitempty"`
+	Changed []resourceChange `json:"changed,omitempty"`
+}
+
+func addSnapshotFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("snapshot", false, "Capture the CatalogSources, mirror sets, Subscriptions and Secrets before and after the run and add the changes to the report")
+}
+
+// takeSnapshot captures the snapshot resources of the cluster. Resource types
+// the cluster does not serve are skipped.
+func takeSnapshot(kconfig string) (clusterSnapshot, error) {
+	snapshot := clusterSnapshot{}
+
+	for _, resource := range snapshotResources {
+		var objects struct {
+			Items []struct {
+				Metadata struct {
+					Name            string `json:"name"`
+					Namespace       string `json:"namespace"`
+					ResourceVersion string `json:"resourceVersion"`
+				} `json:"metadata"`
+				Spec map[string]json.RawMessage `json:"spec"`
+			} `json:"items"`
+		}
+		if _, err := getJSON(kconfig, &objects, resource, "--all-namespaces"); err != nil {
+			if errors.Is(err, errForbidden) {
+				return nil, err
+			}
+			slog.Debug("not capturing resource type", "resource", resource, "error", err)
+			continue
+		}
+
+		for _, item := range objects.Items {
+			key := resource + "/" + item.Metadata.Name
+			if item.Metadata.Namespace != "" {
+				key = resource + "/" + item.Metadata.Namespace + "/" + item.Metadata.Name
+			}
+			snapshot[key] = snapshotObject{ResourceVersion: item.Metadata.ResourceVersion, Spec: item.Spec}
+		}
+	}
+
+	for _, resource := range snapshotMetadataResources {
+		getCmd := ocCommand(kconfig, "get", resource, "--all-namespaces", "-o",
+			`jsonpath={range .items[*]}{.metadata.namespace}{" "}{.metadata.name}{" "}{.metadata.resourceVersion}{"\n"}{end}`)
+		output, err := getCmd.Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "Forbidden") {
+				return nil, fmt.Errorf("error getting %s: %w", resource, errForbidden)
+			}
+			slog.Debug("not capturing resource type", "resource", resource, "error", err)
+			continue
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			snapshot[resource+"/"+fields[0]+"/"+fields[1]] = snapshotObject{ResourceVersion: fields[2]}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// diffSnapshots returns the changes from before to after.
+func diffSnapshots(before, after clusterSnapshot) *clusterChanges {
+	changes := &clusterChanges{}
+
+	keys := []string{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, found := before[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old, existed := before[key]
+		current, exists := after[key]
+
+		switch {
+		case !existed:
+			changes.Added = append(changes.Added, resourceChange{Resource: key})
+		case !exists:
+			changes.Removed = append(changes.Removed, resourceChange{Resource: key})
+		case old.ResourceVersion != current.ResourceVersion:
+			changes.Changed = append(changes.Changed, resourceChange{Resource: key, Fields: changedFields(old.Spec, current.Spec)})
+		}
+	}
+
+	return changes
+}
+
+// changedFields returns the top-level fields of the spec that differ.
+func changedFields(before, after map[string]json.RawMessage) []string {
+	fields := []string{}
+	for field, value := range after {
+		if !jsonEqual(before[field], value) {
+			fields = append(fields, "spec."+field)
+		}
+	}
+	for field := range before {
+		if _, found := after[field]; !found {
+			fields = append(fields, "spec."+field)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var valueA, valueB any
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return string(a) == string(b)
+	}
+
+	return reflect.DeepEqual(valueA, valueB)
+}
+
+// recordChanges takes the snapshot after the run and adds the changes since
+// before to the report.
+func recordChanges(clusterName, kconfig string, before clusterSnapshot, report *clusterReport) {
+	after, err := takeSnapshot(kconfig)
+	if err != nil {
+		slog.Warn("error capturing the cluster after the run", "cluster", clusterName, "error", err)
+		return
+	}
+
+	report.Changes = diffSnapshots(before, after)
+	slog.Info("changes on the cluster", "cluster", clusterName, "added", len(report.Changes.Added),
+		"removed", len(report.Changes.Removed), "changed", len(report.Changes.Changed))
+}
+
+// describeChanges lists the changes like a diff, one resource per line.
+func describeChanges(changes *clusterChanges) string {
+	var description strings.Builder
+	for _, change := range changes.Added {
+		fmt.Fprintf(&description, "  + %s\n", change.Resource)
+	}
+	for _, change := range changes.Removed {
+		fmt.Fprintf(&description, "  - %s\n", change.Resource)
+	}
+	for _, change := range changes.Changed {
+		fmt.Fprintf(&description, "  ~ %s %s\n", change.Resource, strings.Join(change.Fields, ", "))
+	}
+
+	return description.String()
+}
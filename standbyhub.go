@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// replicatedFromLabel names the hub the DR resources of a standby hub were
+// replicated from.
+const replicatedFromLabel = "odfdr-installer/replicated-from"
+
+// standbyHubConfig is a passive hub that the managed clusters are switched to
+// with switch-hub when the active hub is lost.
+type standbyHubConfig struct {
+	// Kubeconfig is the kubeconfig of the standby hub.
+	Kubeconfig string `json:"kubeconfig"`
+}
+
+func (c *standbyHubConfig) validate() error {
+	if c.Kubeconfig == "" {
+		return fmt.Errorf("dr.standbyHub has no kubeconfig")
+	}
+
+	return nil
+}
+
+// standbyResources returns the DR resources of a pair on the active hub that
+// are replicated to the standby hub, as resource/name with the namespace
+// flags: the MirrorPeer, the DRClusters, the DRPolicies covering the pair, the
+// S3 secrets of the pair and the DR inventory. The S3 store profiles that
+// reference the secrets are merged by replicateS3StoreProfiles.
+func standbyResources(kconfig string, opts drOptions) ([][]string, error) {
+	resources := [][]string{}
+
+	// LVM Storage clusters are not peered and have no S3 stores.
+	if opts.storageBackend != storageBackendLVMS {
+		resources = append(resources, []string{"mirrorpeers.multicluster.odf.openshift.io/" + mirrorPeerName(opts.clusters)})
+
+		var cm configMap
+		if _, err := getJSON(kconfig, &cm, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace); err != nil {
+			return nil, err
+		}
+		profiles := parseS3StoreProfiles(cm.Data[ramenConfigKey])
+		for _, cluster := range opts.clusters {
+			name := s3ProfileName(cluster, opts.storageClusterRef.Name)
+			i := slices.IndexFunc(profiles, func(p s3StoreProfile) bool { return p.Name == name })
+			if i == -1 || profiles[i].SecretName == "" {
+				continue
+			}
+			resources = append(resources, []string{"secret/" + profiles[i].SecretName, "-n", globalOperatorsNamespace})
+		}
+	}
+
+	for _, cluster := range opts.clusters {
+		resources = append(resources, []string{"drclusters.ramendr.openshift.io/" + cluster})
+	}
+
+	var policies drPolicyList
+	if _, err := getJSON(kconfig, &policies, "drpolicies.ramendr.openshift.io"); err != nil {
+		return nil, err
+	}
+	for _, policy := range policies.Items {
+		if coversClusters(policy.Spec.DRClusters, opts.clusters) {
+			resources = append(resources, []string{"drpolicies.ramendr.openshift.io/" + policy.Metadata.Name})
+		}
+	}
+
+	resources = append(resources, []string{"configmap/" + drInventoryName(opts.clusters), "-n", globalOperatorsNamespace})
+
+	return resources, nil
+}
+
+// standbyCopy returns the copy of a resource of the active hub to apply on
+// the standby hub, without the status and the metadata set by the API server
+// of the active hub.
+func standbyCopy(obj map[string]any, hubName string) map[string]any {
+	delete(obj, "status")
+
+	metadata, _ := obj["metadata"].(map[string]any)
+	kept := map[string]any{"name": metadata["name"]}
+	if ns, ok := metadata["namespace"]; ok {
+		kept["namespace"] = ns
+	}
+
+	labels, _ := metadata["labels"].(map[string]any)
+	if labels == nil {
+		labels = map[string]any{}
+	}
+	labels[replicatedFromLabel] = hubName
+	kept["labels"] = labels
+
+	if annotations, ok := metadata["annotations"].(map[string]any); ok {
+		delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+		if len(annotations) > 0 {
+			kept["annotations"] = annotations
+		}
+	}
+	obj["metadata"] = kept
+
+	return obj
+}
+
+// replicateToStandbyHub copies the DR resources of the pair from the active
+// hub to the standby hub, so that the standby hub can take over the pair. The
+// copies are applied again by every run, a resource missing on the active hub
+// is not replicated.
+func replicateToStandbyHub(hubName, kconfig string, opts drOptions) error {
+	standbyKconfig := opts.standbyHub.Kubeconfig
+	url, err := getServerURL(standbyKconfig)
+	if err != nil {
+		return fmt.Errorf("error connecting to standby hub: %v", err)
+	}
+	standbyName, err := getClusterName(url)
+	if err != nil {
+		return err
+	}
+	if standbyName == hubName {
+		return fmt.Errorf("standby hub %s is the active hub", standbyName)
+	}
+
+	resources, err := standbyResources(kconfig, opts)
+	if err != nil {
+		return err
+	}
+
+	copies := list{APIVersion: "v1", Kind: "List"}
+	replicated := []string{}
+	for _, resource := range resources {
+		var obj map[string]any
+		found, err := getJSON(kconfig, &obj, resource...)
+		if err != nil {
+			return err
+		}
+		if !found {
+			slog.Warn("DR resource not found on the active hub, not replicating it", "resource", resource[0])
+			continue
+		}
+
+		copies.Items = append(copies.Items, standbyCopy(obj, hubName))
+		replicated = append(replicated, resource[0])
+	}
+
+	data, err := json.MarshalIndent(copies, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding DR resources: %v", err)
+	}
+
+	fileName := standbyName + "-" + strings.Join(opts.clusters, "-") + "-standby.json"
+	if err := writeArtifact(standbyName, fileName, data); err != nil {
+		return fmt.Errorf("error writing DR resources to file: %v", err)
+	}
+
+	if _, err := applyManifest(standbyKconfig, fileName); err != nil {
+		return fmt.Errorf("error applying DR resources on standby hub %s: %v", standbyName, err)
+	}
+
+	// LVM Storage clusters are not peered and have no S3 stores.
+	if opts.storageBackend != storageBackendLVMS {
+		if err := replicateS3StoreProfiles(kconfig, standbyKconfig, standbyName, opts); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("replicated DR resources to standby hub", "standbyHub", standbyName, "resources", replicated)
+
+	return nil
+}
+
+// replicateS3StoreProfiles merges the S3 store profiles of the pair in the
+// Ramen hub configuration of the active hub into the one of the standby hub,
+// whose DRClusters reference them. Profiles of other pairs on the standby hub
+// are kept.
+func replicateS3StoreProfiles(kconfig, standbyKconfig, standbyName string, opts drOptions) error {
+	var active configMap
+	if _, err := getJSON(kconfig, &active, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, cluster := range opts.clusters {
+		names = append(names, s3ProfileName(cluster, opts.storageClusterRef.Name))
+	}
+	_, activeItems, _, _ := splitS3StoreProfiles(active.Data[ramenConfigKey])
+	activeItems = slices.DeleteFunc(activeItems, func(item []string) bool {
+		return !slices.Contains(names, s3StoreProfileItemName(item))
+	})
+	if len(activeItems) == 0 {
+		slog.Warn("S3 store profiles of the pair not found on the active hub, not replicating them", "profiles", names)
+		return nil
+	}
+
+	var standby configMap
+	found, err := getJSON(standbyKconfig, &standby, "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace)
+	if err != nil {
+		return err
+	}
+	if !found || standby.Data[ramenConfigKey] == "" {
+		return fmt.Errorf("the Ramen hub configuration %s/%s is not found on standby hub %s, install the ODR hub operator with prepare",
+			globalOperatorsNamespace, ramenHubConfigMap, standbyName)
+	}
+
+	ramenConfig := mergeS3StoreProfiles(standby.Data[ramenConfigKey], activeItems)
+	if strings.TrimRight(ramenConfig, "\n") == strings.TrimRight(standby.Data[ramenConfigKey], "\n") {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{"data": map[string]string{ramenConfigKey: ramenConfig}})
+	if err != nil {
+		return fmt.Errorf("error encoding Ramen configuration patch: %v", err)
+	}
+
+	patchCmd := ocCommand(standbyKconfig, "patch", "configmap/"+ramenHubConfigMap, "-n", globalOperatorsNamespace,
+		"--type=merge", "-p", string(patch))
+	if err := patchCmd.Run(); err != nil {
+		return fmt.Errorf("error replicating the S3 store profiles to standby hub %s: %v", standbyName, err)
+	}
+
+	slog.Info("replicated S3 store profiles to standby hub", "standbyHub", standbyName, "profiles", names)
+
+	return nil
+}
+
+// splitS3StoreProfiles splits a Ramen configuration into the lines up to the
+// s3StoreProfiles key, the lines of every profile without the indentation of
+// the list, the lines after the profiles and the indentation of the list.
+// Only the YAML block style written by the operators is understood, like by
+// parseS3StoreProfiles.
+func splitS3StoreProfiles(ramenConfig string) ([]string, [][]string, []string, string) {
+	lines := strings.Split(strings.TrimRight(ramenConfig, "\n"), "\n")
+	start := slices.IndexFunc(lines, func(line string) bool { return strings.HasPrefix(line, "s3StoreProfiles:") })
+	if start == -1 {
+		return append(lines, "s3StoreProfiles:"), nil, nil, ""
+	}
+
+	head := append(slices.Clone(lines[:start]), "s3StoreProfiles:")
+	items := [][]string{}
+	indent := ""
+	end := start + 1
+	for ; end < len(lines); end++ {
+		line := lines[end]
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			break
+		}
+
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if len(items) == 0 {
+				indent = line[:len(line)-len(trimmed)]
+			}
+			items = append(items, []string{})
+		}
+		if len(items) == 0 {
+			continue
+		}
+		items[len(items)-1] = append(items[len(items)-1], strings.TrimPrefix(line, indent))
+	}
+
+	return head, items, lines[end:], indent
+}
+
+// s3StoreProfileItemName returns the name of a profile split by
+// splitS3StoreProfiles.
+func s3StoreProfileItemName(item []string) string {
+	profiles := parseS3StoreProfiles("s3StoreProfiles:\n" + strings.Join(item, "\n"))
+	if len(profiles) == 0 {
+		return ""
+	}
+
+	return profiles[0].Name
+}
+
+// mergeS3StoreProfiles replaces the profiles of the same name in a Ramen
+// configuration with items, or adds them, and keeps the other profiles.
+func mergeS3StoreProfiles(ramenConfig string, items [][]string) string {
+	head, existing, tail, indent := splitS3StoreProfiles(ramenConfig)
+	for _, item := range items {
+		name := s3StoreProfileItemName(item)
+		i := slices.IndexFunc(existing, func(other []string) bool { return s3StoreProfileItemName(other) == name })
+		if i == -1 {
+			existing = append(existing, item)
+		} else {
+			existing[i] = item
+		}
+	}
+
+	lines := head
+	for _, item := range existing {
+		for _, line := range item {
+			lines = append(lines, indent+line)
+		}
+	}
+	lines = append(lines, tail...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
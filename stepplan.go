@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// stepProfiles are names for groups of prepare steps that -only accepts like
+// step names.
+var stepProfiles = map[string][]string{
+	"registry": {"pull-secret", "mirror-sets", "catalog"},
+	"storage":  {"storage-cluster", "storage-pools", "ceph-config"},
+}
+
+func stepProfileNames() []string {
+	names := []string{}
+	for name := range stepProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// stepSelection is the selection of the -only flags.
+type stepSelection struct {
+	only stringList
+	// strict fails the selection when a selected step requires a step that
+	// is not selected, instead of running the required step too.
+	strict    bool
+	printPlan bool
+}
+
+func addStepSelectionFlags(flags *flag.FlagSet) *stepSelection {
+	selection := &stepSelection{}
+	flags.Var(&selection.only, "only", "Run only this step or profile of steps, with the steps it requires: "+strings.Join(prepareStepNames(), ", ")+
+		", or the profiles "+strings.Join(stepProfileNames(), ", ")+" (can be repeated)")
+	flags.BoolVar(&selection.strict, "only-strict", false, "Fail when a step selected with -only requires a step that is not selected, instead of running it too")
+	flags.BoolVar(&selection.printPlan, "print-plan", false, "Print the steps that would run, in order, and exit without connecting to the clusters")
+
+	return selection
+}
+
+// validateStepGraph returns an error if a step requires a step that does not
+// come before it.
+func validateStepGraph(steps []step) error {
+	for i, s := range steps {
+		for _, required := range s.after {
+			j := slices.IndexFunc(steps, func(other step) bool { return other.name == required })
+			if j == -1 {
+				return fmt.Errorf("step %s requires unknown step %s", s.name, required)
+			}
+			if j >= i {
+				return fmt.Errorf("step %s requires step %s, which runs after it", s.name, required)
+			}
+		}
+	}
+
+	return nil
+}
+
+// selectSteps returns the steps selected by name or profile in only, and the
+// steps they require, in the order of steps. requiredBy maps each step that
+// was not selected but is required to the step requiring it. All steps are
+// returned without a selection.
+func selectSteps(steps []step, only []string, strict bool) ([]step, map[string]string, error) {
+	if err := validateStepGraph(steps); err != nil {
+		return nil, nil, err
+	}
+
+	requiredBy := map[string]string{}
+	if len(only) == 0 {
+		return steps, requiredBy, nil
+	}
+
+	known := []string{}
+	for _, s := range steps {
+		known = append(known, s.name)
+	}
+
+	selected := map[string]bool{}
+	for _, name := range only {
+		if profile, ok := stepProfiles[name]; ok {
+			for _, stepName := range profile {
+				selected[stepName] = true
+			}
+			continue
+		}
+
+		if !slices.Contains(known, name) {
+			return nil, nil, fmt.Errorf("unknown step %q, known steps: %s, profiles: %s", name, strings.Join(known, ", "),
+				strings.Join(stepProfileNames(), ", "))
+		}
+		selected[name] = true
+	}
+
+	// Steps only require earlier steps, so going backwards reaches the
+	// requirements of the required steps too.
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		if !selected[s.name] && requiredBy[s.name] == "" {
+			continue
+		}
+
+		for _, required := range s.after {
+			if selected[required] || requiredBy[required] != "" {
+				continue
+			}
+			if strict {
+				return nil, nil, fmt.Errorf("step %s requires step %s, which is not selected: add -only %s or drop -only-strict", s.name, required, required)
+			}
+			requiredBy[required] = s.name
+		}
+	}
+
+	result := []step{}
+	for _, s := range steps {
+		if selected[s.name] || requiredBy[s.name] != "" {
+			result = append(result, s)
+		}
+	}
+
+	return result, requiredBy, nil
+}
+
+// printStepPlan prints the steps in the order they run, with the steps they
+// require and why steps that were not selected run.
+func printStepPlan(out io.Writer, steps []step, requiredBy map[string]string) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tSTEP\tAFTER\tSELECTED")
+	for i, s := range steps {
+		after := "-"
+		if len(s.after) > 0 {
+			after = strings.Join(s.after, ",")
+		}
+
+		selected := "yes"
+		if step, ok := requiredBy[s.name]; ok {
+			selected = "required by " + step
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, s.name, after, selected)
+	}
+	w.Flush()
+}
+
+// plan returns the prepare steps selected for runs with the configuration
+// cfg, as far as they are known before connecting to the clusters.
+func (selection *stepSelection) plan(cfg *config, backupBeforeChanges bool) ([]step, map[string]string, error) {
+	opts := prepareOptions{waits: cfg.Waits, backupBeforeChanges: backupBeforeChanges}
+
+	return selectSteps(prepareSteps("", "", opts, nil), selection.only, selection.strict)
+}
+
+// check validates the selection against the steps of the run before
+// connecting to the clusters and logs the steps run because selected steps
+// require them.
+func (selection *stepSelection) check(cfg *config, backupBeforeChanges bool) error {
+	steps, requiredBy, err := selection.plan(cfg, backupBeforeChanges)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range steps {
+		if step, ok := requiredBy[s.name]; ok {
+			slog.Info("running step required by a selected step", "step", s.name, "requiredBy", step)
+		}
+	}
+
+	return nil
+}
+
+// writePlan prints the steps a run with the configuration file would run.
+func (selection *stepSelection) writePlan(configFile string, backupBeforeChanges bool) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	steps, requiredBy, err := selection.plan(cfg, backupBeforeChanges)
+	if err != nil {
+		slog.Error("error: invalid -only", "error", err)
+		showUsageAndExit()
+	}
+
+	printStepPlan(os.Stdout, steps, requiredBy)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/engine"
+)
+
+// step is a unit of work applied to a cluster. Steps are timed and recorded in
+// the run report individually.
+type step struct {
+	name string
+	run  func() error
+	// force, if set, is used instead of run when the step is forced. It
+	// deletes and recreates the resources of the step instead of treating
+	// existing resources as done.
+	force func() error
+	// describe, if set, returns the manifests or actions of the step, shown
+	// before the step in step-through mode.
+	describe func() string
+	// after lists the steps that must have run before the step, which
+	// come before it in the steps. Selecting the step with -only selects
+	// them too.
+	after []string
+}
+
+type stepRecord struct {
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// stepThrough makes runSteps ask before every step whether to run it.
+var stepThrough bool
+
+func addStepFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&stepThrough, "step", false, "Pause before every step, show what it does and ask whether to run, skip or abort it")
+}
+
+var (
+	// stepPromptMu keeps the prompts of clusters handled in parallel apart.
+	stepPromptMu sync.Mutex
+	stdinReader  = bufio.NewReader(os.Stdin)
+)
+
+var errStepAborted = errors.New("aborted")
+
+// confirmStep shows the step and asks whether to run it. It returns false
+// when the step is skipped and errStepAborted when the run is aborted.
+func confirmStep(clusterName string, s step) (bool, error) {
+	stepPromptMu.Lock()
+	defer stepPromptMu.Unlock()
+
+	fmt.Printf("\n=== cluster %s, step %s\n", clusterName, s.name)
+	if s.describe != nil {
+		fmt.Println(strings.TrimRight(s.describe(), "\n"))
+	}
+
+	for {
+		fmt.Print("[Enter] run, [s] skip, [a] abort: ")
+		answer, err := stdinReader.ReadString('\n')
+		if err != nil {
+			return false, fmt.Errorf("error reading answer: %v", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "":
+			return true, nil
+		case "s", "skip":
+			return false, nil
+		case "a", "abort":
+			return false, errStepAborted
+		}
+	}
+}
+
+// prepareStepNames returns the names of the prepare steps in order.
+func prepareStepNames() []string {
+	names := []string{}
+	for _, s := range prepareSteps("", "", prepareOptions{}, nil) {
+		names = append(names, s.name)
+	}
+
+	return names
+}
+
+// validateStepNames returns an error if any of names is not a known step.
+func validateStepNames(known, names []string) error {
+	for _, name := range names {
+		if !slices.Contains(known, name) {
+			return fmt.Errorf("unknown step %q, known steps: %s", name, strings.Join(known, ", "))
+		}
+	}
+
+	return nil
+}
+
+// runSteps runs the steps in order with the step engine, recording each of
+// them in the cluster report, and stops at the first failing step. Steps
+// listed in force are run with their force function.
+func runSteps(clusterName, kconfig string, steps []step, force []string, report *clusterReport) error {
+	engineSteps := []engine.Step{}
+	for _, s := range steps {
+		run := s.run
+		if slices.Contains(force, s.name) && s.force != nil {
+			run = s.force
+		}
+		engineSteps = append(engineSteps, engine.Step{Name: s.name, Run: run})
+	}
+
+	var (
+		span    *traceSpan
+		capture *stepCapture
+		attempt int
+	)
+	runner := engine.Runner{
+		Cluster: clusterName,
+		Before: func(remaining []engine.Step) error {
+			if err := siblingFailed(kconfig); err != nil {
+				return fmt.Errorf("step %s not run: %v", remaining[0].Name, err)
+			}
+
+			// Stopping between steps leaves no step half done.
+			return checkDeadline(clusterName, steps[len(steps)-len(remaining):])
+		},
+		Retry: func(s engine.Step, next int, err error) bool {
+			if next > 2 {
+				return false
+			}
+
+			// Long steps can outlive the token of the session. The step
+			// is run again once logged in, like a rerun of the installer.
+			renewed, renewErr := renewSession(kconfig)
+			if renewErr != nil {
+				slog.Warn("error renewing session", "cluster", clusterName, "error", renewErr)
+			}
+
+			return renewed
+		},
+		Observers: []engine.Observer{{
+			OnStepStart: func(_, name string) {
+				if slices.Contains(force, name) {
+					notify(messageInfo, "step-forced", "cluster", clusterName, "step", name)
+				} else {
+					notify(messageInfo, "step-started", "cluster", clusterName, "step", name)
+				}
+
+				span = startSpan(kconfig, name, map[string]string{"cluster": clusterName, "step": name})
+				capture = startCapture(clusterName, kconfig, name)
+				attempt = 1
+			},
+			OnRetry: func(_, name string, next int, _ error) {
+				notify(messageInfo, "step-retried", "cluster", clusterName, "step", name)
+				attempt = next
+			},
+			OnStepEnd: func(_, name string, duration time.Duration, err error) {
+				span.end(err)
+				capture.end(err)
+
+				record := stepRecord{Name: name, Start: time.Now().Add(-duration), Duration: duration}
+				if err != nil {
+					record.Error = err.Error()
+					slog.Warn("step failed", "cluster", clusterName, "step", name, "attempt", attempt, "duration", duration.Round(time.Millisecond), "error", err)
+				} else {
+					slog.Info("step finished", "cluster", clusterName, "step", name, "attempt", attempt, "duration", duration.Round(time.Millisecond))
+				}
+				report.Steps = append(report.Steps, record)
+			},
+		}},
+	}
+
+	if stepThrough {
+		runner.Confirm = func(s engine.Step) (bool, error) {
+			i := slices.IndexFunc(steps, func(other step) bool { return other.name == s.Name })
+			confirmed, err := confirmStep(clusterName, steps[i])
+			if err == nil && !confirmed {
+				notify(messageInfo, "step-skipped", "cluster", clusterName, "step", s.Name)
+				report.Steps = append(report.Steps, stepRecord{Name: s.Name, Start: time.Now(), Skipped: true})
+			}
+
+			return confirmed, err
+		}
+	}
+
+	return runner.Run(engineSteps)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+const defaultStorageClusterName = "ocs-storagecluster"
+
+// placementComponents are the Ceph daemons whose placement can be set, all
+// applies to every daemon without a placement of its own.
+var placementComponents = []string{"all", "mon", "mgr", "osd", "mds", "rgw"}
+
+// storageClusterConfig generates the StorageCluster, with one device set of
+// PVCs from StorageClassName.
+type storageClusterConfig struct {
+	// Name is ocs-storagecluster by default.
+	Name             string `json:"name,omitempty"`
+	StorageClassName string `json:"storageClassName"`
+	// DeviceSize is the size of every OSD, like 512Gi.
+	DeviceSize string `json:"deviceSize"`
+	// DeviceCount is the number of OSDs per replica, 1 by default.
+	DeviceCount int `json:"deviceCount,omitempty"`
+	// Placement places the Ceph daemons on dedicated storage nodes, keyed by
+	// all, mon, mgr, osd, mds or rgw.
+	Placement map[string]scheduling `json:"placement,omitempty"`
+	// CephTools enables the rook-ceph toolbox once the StorageCluster is
+	// Ready, which ceph-cmd runs the ceph commands in.
+	CephTools bool `json:"cephTools,omitempty"`
+}
+
+func (c *storageClusterConfig) validate() error {
+	if c.Name == "" {
+		c.Name = defaultStorageClusterName
+	}
+
+	if c.StorageClassName == "" {
+		return fmt.Errorf("StorageCluster %s has no storageClassName", c.Name)
+	}
+
+	if _, err := parseQuantity(c.DeviceSize); err != nil {
+		return fmt.Errorf("StorageCluster %s has invalid device size: %v", c.Name, err)
+	}
+
+	if c.DeviceCount == 0 {
+		c.DeviceCount = 1
+	}
+	if c.DeviceCount < 0 {
+		return fmt.Errorf("StorageCluster %s has invalid device count %d", c.Name, c.DeviceCount)
+	}
+
+	for component := range c.Placement {
+		if !slices.Contains(placementComponents, component) {
+			return fmt.Errorf("StorageCluster %s has placement for unknown component %q, expected one of %s", c.Name, component,
+				strings.Join(placementComponents, ", "))
+		}
+	}
+
+	return nil
+}
+
+type nodeSelectorRequirement = manifest.NodeSelectorRequirement
+
+type nodeSelectorTerm = manifest.NodeSelectorTerm
+
+type storageDeviceSet = manifest.StorageDeviceSet
+
+// addStorageCluster creates the configured StorageCluster, unless the
+// namespace already has one, and waits for it to be Ready. With the lvms
+// backend, it creates the LVMCluster instead.
+func addStorageCluster(clusterName, kconfig string, cfg *storageConfig) error {
+	if cfg != nil && cfg.Backend == storageBackendLVMS {
+		return addLVMCluster(clusterName, kconfig, cfg)
+	}
+
+	if cfg == nil || cfg.StorageCluster == nil {
+		slog.Info("no StorageCluster configured")
+		return nil
+	}
+
+	var existing struct {
+		Items []storageClusterStatus `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &existing, "storageclusters.ocs.openshift.io", "-n", cfg.Namespace); err != nil {
+		return err
+	}
+
+	if len(existing.Items) > 0 {
+		slog.Info("StorageCluster already exists, not creating it", "storageCluster", existing.Items[0].Metadata.Name)
+	} else {
+		rendered, err := renderStorageCluster(cfg)
+		if err != nil {
+			return err
+		}
+		data := []byte(rendered.docs[0])
+
+		fileName := clusterName + "-storagecluster.json"
+		err = writeArtifact(clusterName, fileName, data)
+		if err != nil {
+			return fmt.Errorf("error writing StorageCluster to file: %v", err)
+		}
+
+		_, err = applyManifest(kconfig, fileName)
+		if err != nil {
+			return fmt.Errorf("error applying StorageCluster: %v", err)
+		}
+
+		slog.Info("created StorageCluster", "storageCluster", cfg.StorageCluster.Name)
+	}
+
+	if _, err := waitForStorageCluster(kconfig, cfg.Namespace, 30*time.Minute); err != nil {
+		return err
+	}
+
+	if cfg.StorageCluster.CephTools {
+		return enableCephTools(kconfig, cfg.Namespace)
+	}
+
+	return nil
+}
+
+// describeStorageCluster describes the StorageCluster the storage-cluster
+// step creates.
+func describeStorageCluster(cfg *storageConfig) string {
+	if cfg != nil && cfg.Backend == storageBackendLVMS {
+		return fmt.Sprintf("Install %s from %s and create an LVMCluster with device class %s in %s.\n",
+			lvmsPackage, redHatOperatorsCatalog, cfg.LVMS.DeviceClass, cfg.Namespace)
+	}
+
+	if cfg == nil || cfg.StorageCluster == nil {
+		return "No StorageCluster is configured."
+	}
+
+	sc := cfg.StorageCluster
+	description := fmt.Sprintf("Create StorageCluster %s/%s with %d x 3 OSDs of %s from storage class %s.\n",
+		cfg.Namespace, sc.Name, sc.DeviceCount, sc.DeviceSize, sc.StorageClassName)
+	for _, component := range placementComponents {
+		if sched, ok := sc.Placement[component]; ok {
+			description += fmt.Sprintf("Place %s on nodes %v with %d tolerations.\n", component, sched.NodeSelector, len(sched.Tolerations))
+		}
+	}
+	if sc.CephTools {
+		description += "Enable the rook-ceph toolbox.\n"
+	}
+
+	return description
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStorageNamespace = "openshift-storage"
+	defaultPoolReplicas     = 3
+)
+
+var compressionModes = []string{"none", "passive", "aggressive", "force"}
+
+// storageConfig creates the StorageCluster and adds block pools and
+// filesystems next to the ones created by it, e.g. a dedicated mirrored pool
+// for DR.
+type storageConfig struct {
+	// Namespace is the namespace of the StorageCluster, openshift-storage
+	// by default.
+	Namespace string `json:"namespace,omitempty"`
+	// Backend is odf, by default, or lvms for LVM Storage on single node
+	// and edge clusters.
+	Backend        string                `json:"backend,omitempty"`
+	LVMS           *lvmsConfig           `json:"lvms,omitempty"`
+	StorageCluster *storageClusterConfig `json:"storageCluster,omitempty"`
+	BlockPools     []blockPoolConfig     `json:"blockPools,omitempty"`
+	Filesystems    []filesystemConfig    `json:"filesystems,omitempty"`
+	// CephConfig overrides Ceph settings, applied by the ceph-config step.
+	CephConfig *cephConfig `json:"cephConfig,omitempty"`
+}
+
+// poolConfig are the settings shared by block pools and the data pools of
+// filesystems.
+type poolConfig struct {
+	// Replicas is the number of copies of the data, 3 by default.
+	Replicas int `json:"replicas,omitempty"`
+	// Compression is none, passive, aggressive or force.
+	Compression string `json:"compression,omitempty"`
+	// TargetSizeRatio is the expected share of the cluster capacity used by
+	// the pool, which lets Ceph size the placement groups up front.
+	TargetSizeRatio float64 `json:"targetSizeRatio,omitempty"`
+	// MaxSize is a quota like 100Gi.
+	MaxSize string `json:"maxSize,omitempty"`
+}
+
+type blockPoolConfig struct {
+	Name string `json:"name"`
+	poolConfig
+	// Mirroring enables RBD image mirroring, which async DR needs.
+	Mirroring bool `json:"mirroring,omitempty"`
+}
+
+type filesystemConfig struct {
+	Name string `json:"name"`
+	poolConfig
+	// ActiveMDS is the number of active metadata servers, 1 by default.
+	ActiveMDS int `json:"activeMDS,omitempty"`
+}
+
+func (p *poolConfig) validate(kind, name string) error {
+	if p.Replicas == 0 {
+		p.Replicas = defaultPoolReplicas
+	}
+	if p.Replicas < 2 {
+		return fmt.Errorf("%s %s needs at least 2 replicas", kind, name)
+	}
+
+	if p.Compression != "" && !slices.Contains(compressionModes, p.Compression) {
+		return fmt.Errorf("%s %s has unknown compression %q, expected one of %s", kind, name, p.Compression,
+			strings.Join(compressionModes, ", "))
+	}
+
+	if p.TargetSizeRatio < 0 || p.TargetSizeRatio > 1 {
+		return fmt.Errorf("%s %s has target size ratio %v, expected a value between 0 and 1", kind, name, p.TargetSizeRatio)
+	}
+
+	if p.MaxSize != "" {
+		if _, err := parseQuantity(p.MaxSize); err != nil {
+			return fmt.Errorf("%s %s has invalid max size: %v", kind, name, err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the pools and filesystems and fills in the defaults.
+func (c *storageConfig) validate() error {
+	if c.Namespace == "" {
+		c.Namespace = defaultStorageNamespace
+	}
+
+	switch c.Backend {
+	case "", storageBackendODF:
+		c.Backend = storageBackendODF
+		if c.LVMS != nil {
+			return fmt.Errorf("storage lvms settings need the %s backend", storageBackendLVMS)
+		}
+	case storageBackendLVMS:
+		if c.StorageCluster != nil || len(c.BlockPools)+len(c.Filesystems) > 0 || c.CephConfig != nil {
+			return fmt.Errorf("the StorageCluster, block pools, filesystems and Ceph config need the %s backend", storageBackendODF)
+		}
+		if c.LVMS == nil {
+			c.LVMS = &lvmsConfig{}
+		}
+		return c.LVMS.validate()
+	default:
+		return fmt.Errorf("unknown storage backend %q, expected %s or %s", c.Backend, storageBackendODF, storageBackendLVMS)
+	}
+
+	if c.StorageCluster != nil {
+		if err := c.StorageCluster.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.CephConfig != nil {
+		if err := c.CephConfig.validate(); err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range c.BlockPools {
+		pool := &c.BlockPools[i]
+		if pool.Name == "" {
+			return fmt.Errorf("block pool %d has no name", i)
+		}
+		if names["pool/"+pool.Name] {
+			return fmt.Errorf("block pool %s is configured twice", pool.Name)
+		}
+		names["pool/"+pool.Name] = true
+
+		if err := pool.validate("block pool", pool.Name); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Filesystems {
+		fs := &c.Filesystems[i]
+		if fs.Name == "" {
+			return fmt.Errorf("filesystem %d has no name", i)
+		}
+		if names["fs/"+fs.Name] {
+			return fmt.Errorf("filesystem %s is configured twice", fs.Name)
+		}
+		names["fs/"+fs.Name] = true
+
+		if fs.ActiveMDS == 0 {
+			fs.ActiveMDS = 1
+		}
+		if err := fs.validate("filesystem", fs.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var quantitySuffixes = map[string]int64{
+	"":   1,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+}
+
+// parseQuantity returns the bytes of a binary quantity like 100Gi.
+func parseQuantity(quantity string) (int64, error) {
+	number := strings.TrimRight(quantity, "KMGTPi")
+	multiplier, ok := quantitySuffixes[quantity[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q, expected Ki, Mi, Gi, Ti or Pi", quantity)
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+
+	return n * multiplier, nil
+}
+
+type replicatedSpec struct {
+	Size            int     `json:"size"`
+	TargetSizeRatio float64 `json:"targetSizeRatio,omitempty"`
+}
+
+type quotasSpec struct {
+	MaxSize string `json:"maxSize,omitempty"`
+}
+
+type poolSpec struct {
+	Name            string         `json:"name,omitempty"`
+	FailureDomain   string         `json:"failureDomain"`
+	Replicated      replicatedSpec `json:"replicated"`
+	CompressionMode string         `json:"compressionMode,omitempty"`
+	Quotas          *quotasSpec    `json:"quotas,omitempty"`
+	Mirroring       *mirroringSpec `json:"mirroring,omitempty"`
+}
+
+type mirroringSpec struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode"`
+}
+
+type cephBlockPool struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       poolSpec   `json:"spec"`
+}
+
+type cephFilesystem struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		MetadataPool               poolSpec   `json:"metadataPool"`
+		DataPools                  []poolSpec `json:"dataPools"`
+		PreserveFilesystemOnDelete bool       `json:"preserveFilesystemOnDelete"`
+		MetadataServer             struct {
+			ActiveCount   int  `json:"activeCount"`
+			ActiveStandby bool `json:"activeStandby"`
+		} `json:"metadataServer"`
+	} `json:"spec"`
+}
+
+func (p poolConfig) spec(failureDomain string) poolSpec {
+	spec := poolSpec{
+		FailureDomain:   failureDomain,
+		Replicated:      replicatedSpec{Size: p.Replicas, TargetSizeRatio: p.TargetSizeRatio},
+		CompressionMode: p.Compression,
+	}
+	if p.MaxSize != "" {
+		spec.Quotas = &quotasSpec{MaxSize: p.MaxSize}
+	}
+
+	return spec
+}
+
+// storagePoolManifests returns the CephBlockPools and CephFilesystems of the
+// configuration.
+func storagePoolManifests(cfg *storageConfig, failureDomain string) list {
+	manifests := list{APIVersion: "v1", Kind: "List"}
+
+	for _, pool := range cfg.BlockPools {
+		blockPool := cephBlockPool{
+			APIVersion: "ceph.rook.io/v1",
+			Kind:       "CephBlockPool",
+			Metadata:   objectMeta{Name: pool.Name, Namespace: cfg.Namespace},
+			Spec:       pool.spec(failureDomain),
+		}
+		if pool.Mirroring {
+			blockPool.Spec.Mirroring = &mirroringSpec{Enabled: true, Mode: "image"}
+		}
+		manifests.Items = append(manifests.Items, blockPool)
+	}
+
+	for _, fs := range cfg.Filesystems {
+		filesystem := cephFilesystem{
+			APIVersion: "ceph.rook.io/v1",
+			Kind:       "CephFilesystem",
+			Metadata:   objectMeta{Name: fs.Name, Namespace: cfg.Namespace},
+		}
+		// The metadata is small and latency sensitive, it is never
+		// compressed or limited.
+		filesystem.Spec.MetadataPool = poolSpec{FailureDomain: failureDomain, Replicated: replicatedSpec{Size: fs.Replicas}}
+		dataPool := fs.spec(failureDomain)
+		dataPool.Name = "data0"
+		filesystem.Spec.DataPools = []poolSpec{dataPool}
+		filesystem.Spec.PreserveFilesystemOnDelete = true
+		filesystem.Spec.MetadataServer.ActiveCount = fs.ActiveMDS
+		filesystem.Spec.MetadataServer.ActiveStandby = true
+		manifests.Items = append(manifests.Items, filesystem)
+	}
+
+	return manifests
+}
+
+type storageClusterStatus struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Phase               string   `json:"phase"`
+		FailureDomain       string   `json:"failureDomain"`
+		FailureDomainValues []string `json:"failureDomainValues"`
+	} `json:"status"`
+}
+
+// waitForStorageCluster waits for the StorageCluster of the namespace to be
+// Ready and returns it.
+func waitForStorageCluster(kconfig, namespace string, timeout time.Duration) (storageClusterStatus, error) {
+	var storageCluster storageClusterStatus
+	watch := []string{"storageclusters.ocs.openshift.io", "-n", namespace}
+	err := watchFor(kconfig, "StorageCluster in "+namespace+" to be Ready", timeout, time.Minute, watch, func() (bool, error) {
+		var storageClusters struct {
+			Items []storageClusterStatus `json:"items"`
+		}
+		if _, err := getJSON(kconfig, &storageClusters, "storageclusters.ocs.openshift.io", "-n", namespace); err != nil {
+			return false, err
+		}
+
+		if len(storageClusters.Items) == 0 {
+			return false, fmt.Errorf("no StorageCluster found in namespace %s, block pools and filesystems are added to an existing StorageCluster", namespace)
+		}
+
+		storageCluster = storageClusters.Items[0]
+
+		return storageCluster.Status.Phase == "Ready", nil
+	})
+
+	return storageCluster, err
+}
+
+// checkStorageCapacity checks that the failure domains can hold the replicas
+// of every pool and that the quotas of the pools fit into the available
+// capacity of the Ceph cluster.
+func checkStorageCapacity(kconfig string, cfg *storageConfig, storageCluster storageClusterStatus) error {
+	failureDomains := len(storageCluster.Status.FailureDomainValues)
+	pools := []poolConfig{}
+	names := []string{}
+	for _, pool := range cfg.BlockPools {
+		pools = append(pools, pool.poolConfig)
+		names = append(names, "block pool "+pool.Name)
+	}
+	for _, fs := range cfg.Filesystems {
+		pools = append(pools, fs.poolConfig)
+		names = append(names, "filesystem "+fs.Name)
+	}
+
+	for i, pool := range pools {
+		if pool.Replicas > failureDomains {
+			return fmt.Errorf("%s needs %d replicas, but the StorageCluster has only %d %s failure domains",
+				names[i], pool.Replicas, failureDomains, storageCluster.Status.FailureDomain)
+		}
+	}
+
+	var cephClusters struct {
+		Items []struct {
+			Status struct {
+				Ceph struct {
+					Capacity struct {
+						BytesAvailable int64 `json:"bytesAvailable"`
+					} `json:"capacity"`
+				} `json:"ceph"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &cephClusters, "cephclusters.ceph.rook.io", "-n", cfg.Namespace); err != nil {
+		if errors.Is(err, errForbidden) {
+			slog.Warn("cannot read the CephCluster, not checking the capacity for the quotas", "error", err)
+			return nil
+		}
+
+		return err
+	}
+	if len(cephClusters.Items) == 0 {
+		return fmt.Errorf("no CephCluster found in namespace %s", cfg.Namespace)
+	}
+	available := cephClusters.Items[0].Status.Ceph.Capacity.BytesAvailable
+
+	// Every replica of the data takes raw capacity.
+	var required int64
+	for _, pool := range pools {
+		if pool.MaxSize != "" {
+			maxSize, _ := parseQuantity(pool.MaxSize)
+			required += maxSize * int64(pool.Replicas)
+		}
+	}
+
+	if required > available {
+		return fmt.Errorf("the quotas of the block pools and filesystems need %d GiB of raw capacity, but only %d GiB are available",
+			required>>30, available>>30)
+	}
+
+	return nil
+}
+
+// addStoragePools creates the configured block pools and filesystems once the
+// StorageCluster is Ready and waits for them to be Ready.
+func addStoragePools(clusterName, kconfig string, cfg *storageConfig) error {
+	if cfg == nil || len(cfg.BlockPools)+len(cfg.Filesystems) == 0 {
+		slog.Info("no block pools or filesystems configured")
+		return nil
+	}
+
+	storageCluster, err := waitForStorageCluster(kconfig, cfg.Namespace, 30*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	if err := checkStorageCapacity(kconfig, cfg, storageCluster); err != nil {
+		return err
+	}
+
+	manifests := storagePoolManifests(cfg, storageCluster.Status.FailureDomain)
+	data, err := json.MarshalIndent(manifests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding storage pools: %v", err)
+	}
+
+	fileName := clusterName + "-storage-pools.json"
+	err = writeArtifact(clusterName, fileName, data)
+	if err != nil {
+		return fmt.Errorf("error writing storage pools to file: %v", err)
+	}
+
+	_, err = applyManifest(kconfig, fileName)
+	if err != nil {
+		return fmt.Errorf("error applying storage pools: %v", err)
+	}
+
+	resources := []string{}
+	for _, pool := range cfg.BlockPools {
+		resources = append(resources, "cephblockpools.ceph.rook.io/"+pool.Name)
+	}
+	for _, fs := range cfg.Filesystems {
+		resources = append(resources, "cephfilesystems.ceph.rook.io/"+fs.Name)
+	}
+
+	for _, resource := range resources {
+		err := waitFor(kconfig, resource+" to be Ready", 10*time.Minute, 10*time.Second, func() (bool, error) {
+			var pool struct {
+				Status struct {
+					Phase string `json:"phase"`
+				} `json:"status"`
+			}
+			found, err := getJSON(kconfig, &pool, resource, "-n", cfg.Namespace)
+			if err != nil || !found {
+				return false, err
+			}
+
+			return pool.Status.Phase == "Ready", nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("added storage pools", "cluster", clusterName, "blockPools", len(cfg.BlockPools), "filesystems", len(cfg.Filesystems))
+
+	return nil
+}
+
+// describeStoragePools describes the block pools and filesystems the
+// storage-pools step creates.
+func describeStoragePools(cfg *storageConfig) string {
+	if cfg == nil || len(cfg.BlockPools)+len(cfg.Filesystems) == 0 {
+		return "No block pools or filesystems are configured."
+	}
+
+	var description strings.Builder
+	for _, pool := range cfg.BlockPools {
+		fmt.Fprintf(&description, "Create CephBlockPool %s with %d replicas", pool.Name, pool.Replicas)
+		if pool.Mirroring {
+			description.WriteString(", mirrored")
+		}
+		description.WriteString(".\n")
+	}
+	for _, fs := range cfg.Filesystems {
+		fmt.Fprintf(&description, "Create CephFilesystem %s with %d replicas and %d active MDS.\n", fs.Name, fs.Replicas, fs.ActiveMDS)
+	}
+
+	return description.String()
+}
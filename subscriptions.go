@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/manifest"
+)
+
+const globalOperatorsNamespace = "openshift-operators"
+
+// redHatOperatorsCatalog is the default catalog of the Red Hat operators
+// that are not part of ODF.
+const redHatOperatorsCatalog = "redhat-operators"
+
+type objectMeta = manifest.ObjectMeta
+
+type subscriptionConfig struct {
+	NodeSelector map[string]string     `json:"nodeSelector,omitempty"`
+	Tolerations  []toleration          `json:"tolerations,omitempty"`
+	Resources    *resourceRequirements `json:"resources,omitempty"`
+}
+
+type subscription struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		Channel             string              `json:"channel,omitempty"`
+		Name                string              `json:"name"`
+		Source              string              `json:"source"`
+		SourceNamespace     string              `json:"sourceNamespace"`
+		InstallPlanApproval string              `json:"installPlanApproval"`
+		Config              *subscriptionConfig `json:"config,omitempty"`
+	} `json:"spec"`
+	Status *subscriptionStatus `json:"status,omitempty"`
+}
+
+type subscriptionStatus struct {
+	InstalledCSV   string      `json:"installedCSV"`
+	CurrentCSV     string      `json:"currentCSV"`
+	Conditions     []condition `json:"conditions"`
+	InstallPlanRef *objectMeta `json:"installPlanRef,omitempty"`
+}
+
+type operatorGroup struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		TargetNamespaces []string `json:"targetNamespaces"`
+	} `json:"spec"`
+}
+
+type namespace struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+}
+
+type list struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Items      []any  `json:"items"`
+}
+
+// operatorManifests returns the Namespace, OperatorGroup and Subscription that
+// install an operator from the catalog.
+func operatorManifests(kconfig, catalogName string, operator operatorConfig, sched *scheduling) (list, error) {
+	ns := operator.Namespace
+	if ns == "" {
+		ns = globalOperatorsNamespace
+	}
+
+	manifests := list{APIVersion: "v1", Kind: "List"}
+	manifests.Items = append(manifests.Items, namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: ns}})
+
+	// The global operators namespace has its own OperatorGroup, and a
+	// namespace must not have more than one.
+	if ns != globalOperatorsNamespace {
+		var groups objectList
+		if _, err := getJSON(kconfig, &groups, "operatorgroups.operators.coreos.com", "-n", ns); err != nil {
+			return list{}, err
+		}
+
+		if len(groups.Items) == 0 {
+			group := operatorGroup{APIVersion: "operators.coreos.com/v1", Kind: "OperatorGroup", Metadata: objectMeta{Name: ns, Namespace: ns}}
+			group.Spec.TargetNamespaces = []string{ns}
+			manifests.Items = append(manifests.Items, group)
+		}
+	}
+
+	sub := subscription{APIVersion: "operators.coreos.com/v1alpha1", Kind: "Subscription", Metadata: objectMeta{Name: operator.Package, Namespace: ns}}
+	sub.Spec.Channel = operator.Channel
+	sub.Spec.Name = operator.Package
+	sub.Spec.Source = catalogName
+	if operator.Source != "" {
+		sub.Spec.Source = operator.Source
+	}
+	sub.Spec.SourceNamespace = "openshift-marketplace"
+	sub.Spec.InstallPlanApproval = "Automatic"
+	if sched != nil || operator.Resources != nil {
+		sub.Spec.Config = &subscriptionConfig{Resources: operator.Resources}
+		if sched != nil {
+			sub.Spec.Config.NodeSelector = sched.NodeSelector
+			sub.Spec.Config.Tolerations = sched.Tolerations
+		}
+	}
+	manifests.Items = append(manifests.Items, sub)
+
+	return manifests, nil
+}
+
+// waitForOperator waits for the CSV of the Subscription of an operator to
+// succeed. A Subscription stuck in ResolutionFailed is remediated, recreating
+// it from subscriptionFileName if needed.
+func waitForOperator(kconfig string, operator operatorConfig, subscriptionFileName string, timeout time.Duration, linkPullSecrets func() error, report *clusterReport) error {
+	ns := operator.Namespace
+	if ns == "" {
+		ns = globalOperatorsNamespace
+	}
+	remediation := &resolutionRemediation{kconfig: kconfig, namespace: ns, operator: operator, manifestFile: subscriptionFileName, report: report}
+
+	// oc watches a single resource type, the installed CSV is usually set
+	// long before it succeeds.
+	watch := []string{"clusterserviceversions", "-n", ns}
+	err := watchFor(kconfig, "operator "+operator.Package+" to be installed", timeout, time.Minute, watch, func() (bool, error) {
+		var sub subscription
+		found, err := getJSON(kconfig, &sub, "subscriptions.operators.coreos.com", operator.Package, "-n", ns)
+		if err != nil || !found {
+			return false, err
+		}
+		if err := remediation.check(&sub); err != nil {
+			return false, err
+		}
+		if linkPullSecrets != nil {
+			if err := linkPullSecrets(); err != nil {
+				return false, err
+			}
+		}
+		if sub.Status == nil || sub.Status.InstalledCSV == "" {
+			return false, nil
+		}
+
+		var csv struct {
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		}
+		found, err = getJSON(kconfig, &csv, "clusterserviceversions", sub.Status.InstalledCSV, "-n", ns)
+		if err != nil || !found {
+			return false, err
+		}
+
+		return csv.Status.Phase == "Succeeded", nil
+	})
+	if err != nil && remediation.message != "" {
+		return fmt.Errorf("%v, Subscription failed to resolve: %s", err, remediation.message)
+	}
+
+	return err
+}
+
+// installOperators subscribes to the operators from the catalog and waits for
+// them to be installed. linkPullSecrets, if set, is called while waiting and
+// once the operators are installed, to link the namespace pull secrets to the
+// service accounts the CSVs create. Remediations of Subscriptions that fail to
+// resolve are recorded in report, if any.
+func installOperators(clusterName, kconfig, catalogSourceYAML string, operators []operatorConfig, sched *scheduling, linkPullSecrets func() error, report *clusterReport) error {
+	catalogName := catalogSourceName(catalogSourceYAML)
+
+	for _, operator := range operators {
+		if err := validateChannel(kconfig, catalogName, operator); err != nil {
+			return err
+		}
+
+		manifests, err := operatorManifests(kconfig, catalogName, operator, sched)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(manifests, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding Subscription: %v", err)
+		}
+
+		subscriptionFileName := clusterName + "-" + operator.Package + "-subscription.json"
+		err = writeArtifact(clusterName, subscriptionFileName, data)
+		if err != nil {
+			return fmt.Errorf("error writing Subscription to file: %v", err)
+		}
+
+		_, err = applyManifest(kconfig, subscriptionFileName)
+		if err != nil {
+			return fmt.Errorf("error applying Subscription for %s: %v", operator.Package, err)
+		}
+
+		slog.Info("subscribed to operator", "package", operator.Package, "channel", operator.Channel)
+	}
+
+	for _, operator := range operators {
+		subscriptionFileName := clusterName + "-" + operator.Package + "-subscription.json"
+		if err := waitForOperator(kconfig, operator, subscriptionFileName, 15*time.Minute, linkPullSecrets, report); err != nil {
+			return err
+		}
+	}
+
+	if linkPullSecrets != nil {
+		return linkPullSecrets()
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// autoImportSecret is the Secret in the namespace of a ManagedCluster that
+// the import controller of the hub uses to install the klusterlet on the
+// cluster, which repoints an imported cluster to the hub.
+const autoImportSecret = "auto-import-secret"
+
+type managedClusterManifest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HubAcceptsClient bool `json:"hubAcceptsClient"`
+	} `json:"spec"`
+}
+
+type klusterletAddonConfig struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       struct {
+		ClusterName          string       `json:"clusterName"`
+		ClusterNamespace     string       `json:"clusterNamespace"`
+		ApplicationManager   addonEnabled `json:"applicationManager"`
+		CertPolicyController addonEnabled `json:"certPolicyController"`
+		PolicyController     addonEnabled `json:"policyController"`
+		SearchCollector      addonEnabled `json:"searchCollector"`
+	} `json:"spec"`
+}
+
+type addonEnabled struct {
+	Enabled bool `json:"enabled"`
+}
+
+// switchHubManifests returns the resources that import a managed cluster
+// into a hub: its namespace, the ManagedCluster, the KlusterletAddonConfig
+// enabling the addons DR depends on, and the auto-import Secret with the
+// kubeconfig of the cluster.
+func switchHubManifests(cluster string, labels map[string]string, clusterKubeconfig []byte) list {
+	mc := managedClusterManifest{APIVersion: "cluster.open-cluster-management.io/v1", Kind: "ManagedCluster"}
+	mc.Metadata.Name = cluster
+	mc.Metadata.Labels = labels
+	mc.Spec.HubAcceptsClient = true
+
+	addons := klusterletAddonConfig{
+		APIVersion: "agent.open-cluster-management.io/v1",
+		Kind:       "KlusterletAddonConfig",
+		Metadata:   objectMeta{Name: cluster, Namespace: cluster},
+	}
+	addons.Spec.ClusterName = cluster
+	addons.Spec.ClusterNamespace = cluster
+	addons.Spec.ApplicationManager.Enabled = true
+	addons.Spec.CertPolicyController.Enabled = true
+	addons.Spec.PolicyController.Enabled = true
+	addons.Spec.SearchCollector.Enabled = true
+
+	importSecret := secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: autoImportSecret, Namespace: cluster},
+		Type:       "Opaque",
+		Data: map[string][]byte{
+			"autoImportRetry": []byte("5"),
+			"kubeconfig":      clusterKubeconfig,
+		},
+	}
+
+	return list{APIVersion: "v1", Kind: "List", Items: []any{
+		namespace{APIVersion: "v1", Kind: "Namespace", Metadata: objectMeta{Name: cluster}},
+		mc,
+		addons,
+		importSecret,
+	}}
+}
+
+// switchHub imports a managed cluster into the hub of kconfig and waits for
+// it to join and be available. The klusterlet of the cluster is replaced by
+// the one of the hub, so the cluster stops reporting to its previous hub.
+func switchHub(hubName, kconfig, cluster, clusterKconfig string, labels map[string]string, timeout time.Duration) error {
+	clusterKubeconfig, err := os.ReadFile(clusterKconfig)
+	if err != nil {
+		return fmt.Errorf("error reading kubeconfig of %s: %v", cluster, err)
+	}
+
+	data, err := json.MarshalIndent(switchHubManifests(cluster, labels, clusterKubeconfig), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding import of %s: %v", cluster, err)
+	}
+
+	fileName := hubName + "-" + cluster + "-import.json"
+	if err := writeArtifact(hubName, fileName, data); err != nil {
+		return fmt.Errorf("error writing import of %s to file: %v", cluster, err)
+	}
+
+	if _, err := applyManifest(kconfig, fileName); err != nil {
+		return fmt.Errorf("error importing %s: %v", cluster, err)
+	}
+	slog.Info("importing managed cluster", "hub", hubName, "cluster", cluster)
+
+	return waitFor(kconfig, "ManagedCluster "+cluster+" to be available", timeout, 10*time.Second, func() (bool, error) {
+		var mc conditionedObject
+		found, err := getJSON(kconfig, &mc, "managedcluster/"+cluster)
+		if err != nil || !found {
+			return false, err
+		}
+
+		joined, _ := conditionStatus(mc.Status.Conditions, "ManagedClusterJoined")
+		available, _ := conditionStatus(mc.Status.Conditions, "ManagedClusterConditionAvailable")
+
+		return joined.Status == "True" && available.Status == "True", nil
+	})
+}
+
+// detachFromHub stops the previous hub from accepting a managed cluster, so
+// that it does not import the cluster back when it is recovered.
+func detachFromHub(kconfig, cluster string) error {
+	patchCmd := ocCommand(kconfig, "patch", "managedcluster/"+cluster, "--type", "merge", "-p", `{"spec":{"hubAcceptsClient":false}}`)
+	if output, err := patchCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error detaching %s from previous hub: %v: %s", cluster, err, strings.TrimSpace(string(output)))
+	}
+
+	slog.Info("detached managed cluster from previous hub", "cluster", cluster)
+
+	return nil
+}
+
+func runSwitchHub(args []string) {
+	flags := flag.NewFlagSet("switch-hub", flag.ExitOnError)
+	kubeconfigFlag := flags.String("kubeconfig", "", "Kubeconfig of the hub to switch the managed clusters to")
+	previousHubFlag := flags.String("previous-hub-kubeconfig", "", "Kubeconfig of the hub the managed clusters leave, which stops accepting them, if it is reachable")
+	var clusters stringList
+	flags.Var(&clusters, "cluster", "Managed cluster to switch in name=kubeconfig form (can be repeated)")
+	var clusterLabels stringList
+	flags.Var(&clusterLabels, "cluster-label", "Label in key=value form of the ManagedClusters on the hub (can be repeated)")
+	timeoutFlag := flags.Duration("timeout", 15*time.Minute, "How long to wait for each managed cluster to be available on the hub")
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+
+	flags.Parse(args)
+
+	if *kubeconfigFlag == "" {
+		slog.Error("error: hub kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	if len(clusters) == 0 {
+		slog.Error("error: at least one cluster is required")
+		showUsageAndExit()
+	}
+
+	clusterKconfigs := map[string]string{}
+	names := []string{}
+	for _, cluster := range clusters {
+		name, clusterKconfig, ok := strings.Cut(cluster, "=")
+		if !ok || name == "" || clusterKconfig == "" {
+			slog.Error("error: invalid cluster, expected name=kubeconfig", "cluster", cluster)
+			showUsageAndExit()
+		}
+		clusterKconfigs[name] = clusterKconfig
+		names = append(names, name)
+	}
+
+	labels := map[string]string{}
+	for _, label := range clusterLabels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			slog.Error("error: invalid cluster label, expected key=value", "label", label)
+			showUsageAndExit()
+		}
+		labels[key] = value
+	}
+
+	kconfig := *kubeconfigFlag
+	url, err := getServerURL(kconfig)
+	if err != nil {
+		slog.Error("error connecting to hub", "error", err)
+		os.Exit(1)
+	}
+
+	hubName, err := getClusterName(url)
+	if err != nil {
+		slog.Error("error getting cluster name", "error", err)
+		os.Exit(1)
+	}
+
+	for _, name := range names {
+		// A cluster that fails to join the hub stays with the previous hub.
+		if err := switchHub(hubName, kconfig, name, clusterKconfigs[name], labels, *timeoutFlag); err != nil {
+			slog.Error("error switching hub", "error", err)
+			os.Exit(1)
+		}
+
+		if *previousHubFlag != "" {
+			if err := detachFromHub(*previousHubFlag, name); err != nil {
+				slog.Error("error switching hub", "error", err)
+				os.Exit(1)
+			}
+		}
+		slog.Info("switched managed cluster", "hub", hubName, "cluster", name)
+	}
+}
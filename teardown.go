@@ -0,0 +1,509 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	cleanupPolicyRetain = "retain"
+	cleanupPolicyDelete = "delete"
+
+	// localStorageNamespace is the namespace of the Local Storage Operator,
+	// which provides the disks of the OSDs on bare metal.
+	localStorageNamespace = "openshift-local-storage"
+	// localStorageRoot is where the Local Storage Operator links the disks
+	// of its PVs on the nodes.
+	localStorageRoot = "/mnt/local-storage/"
+	// localStorageOwnerLabel is set by the Local Storage Operator on its PVs.
+	localStorageOwnerLabel = "storage.openshift.com/owner-kind"
+
+	cleanupPolicyAnnotation = "uninstall.ocs.openshift.io/cleanup-policy"
+	uninstallModeAnnotation = "uninstall.ocs.openshift.io/mode"
+)
+
+// localDiskPath matches the links of the Local Storage Operator to the disks.
+var localDiskPath = regexp.MustCompile(`^` + localStorageRoot + `[A-Za-z0-9._-]+/[A-Za-z0-9._-]+$`)
+
+// storageResources are the resources in the storage namespace whose
+// finalizers are left behind once the operators are removed.
+var storageResources = []string{
+	"storageclusters.ocs.openshift.io",
+	"cephclusters.ceph.rook.io",
+	"cephblockpools.ceph.rook.io",
+	"cephfilesystems.ceph.rook.io",
+	"cephfilesystemsubvolumegroups.ceph.rook.io",
+	"cephobjectstores.ceph.rook.io",
+	"cephobjectstoreusers.ceph.rook.io",
+	"cephnfses.ceph.rook.io",
+	"cephrbdmirrors.ceph.rook.io",
+	"cephclients.ceph.rook.io",
+	"noobaas.noobaa.io",
+	"backingstores.noobaa.io",
+	"bucketclasses.noobaa.io",
+}
+
+// localStorageResources are the Local Storage Operator resources that create
+// PVs for the disks of the nodes.
+var localStorageResources = []string{
+	"localvolumesets.local.storage.openshift.io",
+	"localvolumes.local.storage.openshift.io",
+}
+
+// deviceSetVolumes are the storage classes of the device sets of the
+// StorageClusters and the local PVs claimed by the device sets in the storage
+// namespace. The teardown records them before deleting the StorageClusters,
+// which releases the PVs, so that wipeLocalDisks only wipes their disks.
+type deviceSetVolumes struct {
+	classes []string
+	pvs     []string
+}
+
+type persistentVolumeList struct {
+	Items []persistentVolume `json:"items"`
+}
+
+type persistentVolume struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		StorageClassName string `json:"storageClassName"`
+		ClaimRef         *struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"claimRef"`
+		Local *struct {
+			Path string `json:"path"`
+		} `json:"local"`
+		NodeAffinity *struct {
+			Required struct {
+				NodeSelectorTerms []nodeSelectorTerm `json:"nodeSelectorTerms"`
+			} `json:"required"`
+		} `json:"nodeAffinity"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// node returns the node of a local PV.
+func (pv persistentVolume) node() string {
+	if pv.Spec.NodeAffinity == nil {
+		return ""
+	}
+
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == "kubernetes.io/hostname" && len(expr.Values) == 1 {
+				return expr.Values[0]
+			}
+		}
+	}
+
+	return ""
+}
+
+type persistentVolumeClaimList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+		Spec     struct {
+			StorageClassName string `json:"storageClassName"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// validateCleanupPolicy checks the -namespace-cleanup-policy and -wipe-disks
+// flags of cleanup.
+func validateCleanupPolicy(policy string, wipeDisks bool) error {
+	switch policy {
+	case "", cleanupPolicyRetain, cleanupPolicyDelete:
+	default:
+		return fmt.Errorf("unknown namespace cleanup policy %q, expected %s or %s", policy, cleanupPolicyRetain, cleanupPolicyDelete)
+	}
+
+	if wipeDisks && policy != cleanupPolicyDelete {
+		return fmt.Errorf("wiping the local disks needs the %s namespace cleanup policy", cleanupPolicyDelete)
+	}
+
+	return nil
+}
+
+// odfStorageClasses returns the storage classes provisioned by the storage
+// system in namespace, whose provisioners are prefixed by the namespace.
+func odfStorageClasses(kconfig, namespace string) ([]string, error) {
+	var classes struct {
+		Items []struct {
+			Metadata    objectMeta `json:"metadata"`
+			Provisioner string     `json:"provisioner"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &classes, "storageclasses"); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, class := range classes.Items {
+		if strings.HasPrefix(class.Provisioner, namespace+".") {
+			names = append(names, class.Metadata.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// findStorageConsumers returns the PVCs outside of namespace that use the
+// storage classes of the storage system in namespace.
+func findStorageConsumers(kconfig, namespace string) ([]string, error) {
+	classes, err := odfStorageClasses(kconfig, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims persistentVolumeClaimList
+	if _, err := getJSON(kconfig, &claims, "persistentvolumeclaims", "--all-namespaces"); err != nil {
+		return nil, err
+	}
+
+	consumers := []string{}
+	for _, claim := range claims.Items {
+		if claim.Metadata.Namespace != namespace && slices.Contains(classes, claim.Spec.StorageClassName) {
+			consumers = append(consumers, claim.Metadata.Namespace+"/"+claim.Metadata.Name)
+		}
+	}
+
+	return consumers, nil
+}
+
+// recordDeviceSetVolumes records the storage classes of the device sets of
+// the StorageClusters in namespace and the local PVs claimed in namespace
+// from them.
+func recordDeviceSetVolumes(kconfig, namespace string, volumes *deviceSetVolumes) error {
+	var storageClusters struct {
+		Items []struct {
+			Spec struct {
+				StorageDeviceSets []storageDeviceSet `json:"storageDeviceSets"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if _, err := getJSON(kconfig, &storageClusters, "storageclusters.ocs.openshift.io", "-n", namespace); err != nil {
+		return err
+	}
+
+	for _, sc := range storageClusters.Items {
+		for _, set := range sc.Spec.StorageDeviceSets {
+			class := set.DataPVCTemplate.Spec.StorageClassName
+			if class != "" && !slices.Contains(volumes.classes, class) {
+				volumes.classes = append(volumes.classes, class)
+			}
+		}
+	}
+
+	var pvs persistentVolumeList
+	if _, err := getJSON(kconfig, &pvs, "persistentvolumes", "-l", localStorageOwnerLabel); err != nil {
+		return err
+	}
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == namespace && slices.Contains(volumes.classes, pv.Spec.StorageClassName) {
+			volumes.pvs = append(volumes.pvs, pv.Metadata.Name)
+		}
+	}
+
+	return nil
+}
+
+// deleteStorageCluster deletes the StorageSystems and StorageClusters in
+// namespace with the cleanup policy, and waits for them to be gone. With the
+// delete policy, rook wipes its data on the nodes and the OSD disks. It
+// refuses while PVCs use the storage, unless force is set. The volumes of the
+// device sets are recorded in volumes first.
+func deleteStorageCluster(kconfig, namespace, policy string, force bool, volumes *deviceSetVolumes, report *clusterReport) error {
+	storageClusters, err := listResources(kconfig, "storageclusters.ocs.openshift.io", namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(storageClusters) == 0 {
+		slog.Info("no StorageCluster to delete", "namespace", namespace)
+		return nil
+	}
+
+	if err := recordDeviceSetVolumes(kconfig, namespace, volumes); err != nil {
+		return err
+	}
+
+	consumers, err := findStorageConsumers(kconfig, namespace)
+	if err != nil {
+		return err
+	}
+
+	mode := "graceful"
+	if len(consumers) > 0 {
+		if !force {
+			return fmt.Errorf("refusing to delete the StorageCluster, PVCs use its storage classes, use -cascade to delete it anyway: %s",
+				strings.Join(consumers, ", "))
+		}
+
+		mode = "forced"
+		report.recordDestructiveAction("forced the StorageCluster deletion while PVCs use its storage: %s", strings.Join(consumers, ", "))
+	}
+
+	for _, name := range storageClusters {
+		annotateCmd := ocCommand(kconfig, "annotate", name, "-n", namespace, "--overwrite",
+			cleanupPolicyAnnotation+"="+policy, uninstallModeAnnotation+"="+mode)
+		if err := annotateCmd.Run(); err != nil {
+			return fmt.Errorf("error setting the cleanup policy of %s: %v", name, err)
+		}
+	}
+
+	storageSystems, err := listResources(kconfig, "storagesystems.odf.openshift.io", namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(storageSystems, storageClusters...) {
+		deleteCmd := ocCommand(kconfig, "delete", name, "-n", namespace, "--ignore-not-found", "--wait=false")
+		if err := deleteCmd.Run(); err != nil {
+			return fmt.Errorf("error deleting %s: %v", name, err)
+		}
+	}
+
+	for _, name := range storageClusters {
+		if policy == cleanupPolicyDelete {
+			report.recordDestructiveAction("deleted %s with the delete cleanup policy, wiping the Ceph data on the nodes and the OSD disks", name)
+		} else {
+			report.recordDestructiveAction("deleted %s with the retain cleanup policy, keeping the Ceph data on the nodes and the OSD disks", name)
+		}
+	}
+
+	return waitFor(kconfig, "StorageCluster to be deleted", 20*time.Minute, 15*time.Second, func() (bool, error) {
+		remaining, err := listResources(kconfig, "storageclusters.ocs.openshift.io", namespace)
+		return len(remaining) == 0, err
+	})
+}
+
+// removeFinalizers removes the finalizers of the storage resources left in
+// namespace, which nothing finalizes once the operators are removed.
+func removeFinalizers(kconfig, namespace string) error {
+	for _, resource := range storageResources {
+		names, err := listResources(kconfig, resource, namespace)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			patchCmd := ocCommand(kconfig, "patch", name, "-n", namespace, "--type", "merge", "-p", `{"metadata":{"finalizers":null}}`)
+			if err := patchCmd.Run(); err != nil {
+				return fmt.Errorf("error removing finalizers of %s: %v", name, err)
+			}
+
+			slog.Warn("removed finalizers", "resource", name, "namespace", namespace)
+		}
+	}
+
+	return nil
+}
+
+// deleteStorageNamespace deletes the storage namespace with what is left in
+// it, the storage classes of the storage system and its node labels. With
+// the retain policy the PVs of the namespace are kept, with the delete
+// policy they are deleted.
+func deleteStorageNamespace(kconfig, storageNamespace, policy string, report *clusterReport) error {
+	var ns namespace
+	found, err := getJSON(kconfig, &ns, "namespace", storageNamespace)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		slog.Info("storage namespace does not exist", "namespace", storageNamespace)
+		return nil
+	}
+
+	if err := removeFinalizers(kconfig, storageNamespace); err != nil {
+		return err
+	}
+
+	var volumes persistentVolumeList
+	if _, err := getJSON(kconfig, &volumes, "persistentvolumes"); err != nil {
+		return err
+	}
+
+	claimed := []persistentVolume{}
+	for _, pv := range volumes.Items {
+		if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == storageNamespace {
+			claimed = append(claimed, pv)
+		}
+	}
+
+	if policy == cleanupPolicyRetain {
+		for _, pv := range claimed {
+			patchCmd := ocCommand(kconfig, "patch", "persistentvolume", pv.Metadata.Name, "--type", "merge",
+				"-p", `{"spec":{"persistentVolumeReclaimPolicy":"Retain"}}`)
+			if err := patchCmd.Run(); err != nil {
+				return fmt.Errorf("error retaining PV %s: %v", pv.Metadata.Name, err)
+			}
+
+			slog.Info("retaining PV", "pv", pv.Metadata.Name, "claim", pv.Spec.ClaimRef.Name)
+		}
+	}
+
+	classes, err := odfStorageClasses(kconfig, storageNamespace)
+	if err != nil {
+		return err
+	}
+
+	for _, class := range classes {
+		deleteCmd := ocCommand(kconfig, "delete", "storageclass", class, "--ignore-not-found")
+		if err := deleteCmd.Run(); err != nil {
+			return fmt.Errorf("error deleting storage class %s: %v", class, err)
+		}
+	}
+
+	deleteCmd := ocCommand(kconfig, "delete", "namespace", storageNamespace, "--ignore-not-found", "--wait=false")
+	if err := deleteCmd.Run(); err != nil {
+		return fmt.Errorf("error deleting namespace %s: %v", storageNamespace, err)
+	}
+	report.recordDestructiveAction("deleted namespace %s with its PVCs", storageNamespace)
+
+	err = waitFor(kconfig, "namespace "+storageNamespace+" to be deleted", 10*time.Minute, 10*time.Second, func() (bool, error) {
+		found, err := getJSON(kconfig, &ns, "namespace", storageNamespace)
+		return !found, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if policy == cleanupPolicyDelete {
+		for _, pv := range claimed {
+			// The Local Storage Operator releases its PVs itself, and
+			// wipeLocalDisks needs them to find the disks.
+			if _, ok := pv.Metadata.Labels[localStorageOwnerLabel]; ok {
+				continue
+			}
+
+			deleteCmd := ocCommand(kconfig, "delete", "persistentvolume", pv.Metadata.Name, "--ignore-not-found")
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting PV %s: %v", pv.Metadata.Name, err)
+			}
+			report.recordDestructiveAction("deleted PV %s of claim %s/%s", pv.Metadata.Name, storageNamespace, pv.Spec.ClaimRef.Name)
+		}
+	}
+
+	labelCmd := ocCommand(kconfig, "label", "nodes", "--all", "cluster.ocs.openshift.io/"+storageNamespace+"-")
+	if err := labelCmd.Run(); err != nil {
+		return fmt.Errorf("error removing the storage node label: %v", err)
+	}
+
+	return nil
+}
+
+// localStorageClasses returns the storage classes of the PVs a Local Storage
+// Operator resource creates.
+func localStorageClasses(kconfig, name string) ([]string, error) {
+	var resource struct {
+		Spec struct {
+			StorageClassName    string `json:"storageClassName"`
+			StorageClassDevices []struct {
+				StorageClassName string `json:"storageClassName"`
+			} `json:"storageClassDevices"`
+		} `json:"spec"`
+	}
+	if _, err := getJSON(kconfig, &resource, name, "-n", localStorageNamespace); err != nil {
+		return nil, err
+	}
+
+	classes := []string{}
+	if resource.Spec.StorageClassName != "" {
+		classes = append(classes, resource.Spec.StorageClassName)
+	}
+	for _, devices := range resource.Spec.StorageClassDevices {
+		classes = append(classes, devices.StorageClassName)
+	}
+
+	return classes, nil
+}
+
+// wipeLocalDisks deletes the Local Storage Operator resources creating the
+// PVs of the device sets and wipes the disks of the unbound PVs the device
+// sets claimed, so that they can be reused for new OSDs. Resources and PVs of
+// other storage classes, and PVs claimed outside of the storage namespace, are
+// not touched.
+func wipeLocalDisks(kconfig string, volumes *deviceSetVolumes, report *clusterReport) error {
+	if len(volumes.pvs) == 0 {
+		slog.Warn("not wiping disks, the teardown recorded no local PVs of the device sets of a StorageCluster")
+		return nil
+	}
+
+	// The operator recreates the PVs of the disks as long as its resources
+	// exist.
+	for _, resource := range localStorageResources {
+		names, err := listResources(kconfig, resource, localStorageNamespace)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			classes, err := localStorageClasses(kconfig, name)
+			if err != nil {
+				return err
+			}
+
+			if len(classes) == 0 || slices.ContainsFunc(classes, func(class string) bool { return !slices.Contains(volumes.classes, class) }) {
+				slog.Info("not deleting Local Storage Operator resource of other storage classes", "resource", name, "storageClasses", classes)
+				continue
+			}
+
+			deleteCmd := ocCommand(kconfig, "delete", name, "-n", localStorageNamespace, "--ignore-not-found")
+			if err := deleteCmd.Run(); err != nil {
+				return fmt.Errorf("error deleting %s: %v", name, err)
+			}
+			report.recordDestructiveAction("deleted %s of storage classes %s", name, strings.Join(classes, ", "))
+		}
+	}
+
+	var pvs persistentVolumeList
+	if _, err := getJSON(kconfig, &pvs, "persistentvolumes", "-l", localStorageOwnerLabel); err != nil {
+		return err
+	}
+
+	for _, pv := range pvs.Items {
+		if !slices.Contains(volumes.pvs, pv.Metadata.Name) || !slices.Contains(volumes.classes, pv.Spec.StorageClassName) {
+			continue
+		}
+
+		if pv.Status.Phase == "Bound" {
+			slog.Warn("not wiping disk of bound PV", "pv", pv.Metadata.Name, "claim", pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name)
+			continue
+		}
+
+		node := pv.node()
+		// The path is passed to a shell on the node.
+		if pv.Spec.Local == nil || node == "" || !localDiskPath.MatchString(pv.Spec.Local.Path) {
+			slog.Warn("not wiping disk of PV without a local disk", "pv", pv.Metadata.Name)
+			continue
+		}
+
+		path := pv.Spec.Local.Path
+		script := fmt.Sprintf(`dev=$(readlink -f %s) && wipefs -a "$dev" && sgdisk --zap-all "$dev" && rm -f %s`, path, path)
+		debugCmd := ocCommand(kconfig, "debug", "node/"+node, "-q", "--", "chroot", "/host", "sh", "-c", script)
+		if output, err := debugCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("error wiping disk %s on node %s: %v: %s", path, node, err, strings.TrimSpace(string(output)))
+		}
+		report.recordDestructiveAction("wiped disk %s on node %s", path, node)
+
+		deleteCmd := ocCommand(kconfig, "delete", "persistentvolume", pv.Metadata.Name, "--ignore-not-found")
+		if err := deleteCmd.Run(); err != nil {
+			return fmt.Errorf("error deleting PV %s: %v", pv.Metadata.Name, err)
+		}
+		report.recordDestructiveAction("deleted PV %s", pv.Metadata.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -otel-endpoint, the run is exported as an OpenTelemetry trace to an
+// OTLP/HTTP endpoint when it finishes, with a span for every prepared
+// cluster and step and, below them, for every wait and oc command. Every oc
+// command is run through the installer itself as traceShimCommand, which
+// times it and appends its span to the trace file of the run.
+const (
+	traceShimCommand = "__oc-trace"
+
+	traceFileEnv = "ODFDR_TRACE_FILE"
+	traceSpanEnv = "ODFDR_TRACE_SPAN"
+)
+
+type traceSpan struct {
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentSpanId"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	kconfig string
+}
+
+type tracer struct {
+	endpoint string
+	traceID  string
+	rootID   string
+	// fileName is the file the trace shims append the spans of the oc
+	// commands to.
+	fileName string
+
+	mu    sync.Mutex
+	spans []*traceSpan
+	// active are the unfinished spans by kubeconfig, innermost last. Work
+	// done in parallel against the same cluster, like the pairs of a fleet
+	// hub, can end up as children of each other's spans.
+	active map[string][]*traceSpan
+}
+
+var (
+	// activeTracer is set when traces are exported.
+	activeTracer *tracer
+	// traceEndpoint is the traces URL of -otel-endpoint.
+	traceEndpoint string
+)
+
+func addTracingFlag(flags *flag.FlagSet) {
+	flags.Func("otel-endpoint", "OTLP/HTTP endpoint to export a trace of the run to, e.g. http://localhost:4318", func(endpoint string) error {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+		}
+
+		if !strings.HasSuffix(u.Path, "/v1/traces") {
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+		}
+		traceEndpoint = u.String()
+
+		return nil
+	})
+}
+
+// startTracing starts the trace of the run with -otel-endpoint. It is called
+// once the flags are parsed, the trace file goes into the temporary directory
+// of -temp-dir.
+func startTracing() error {
+	if traceEndpoint == "" {
+		return nil
+	}
+
+	file, err := createTempFile("odfdr-trace-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("error creating trace file: %v", err)
+	}
+	file.Close()
+
+	activeTracer = &tracer{
+		endpoint: traceEndpoint,
+		traceID:  randomHex(16),
+		rootID:   randomHex(8),
+		fileName: file.Name(),
+		active:   map[string][]*traceSpan{},
+	}
+
+	return nil
+}
+
+func randomHex(n int) string {
+	id := make([]byte, n)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// startSpan starts a span below the innermost active span of the cluster of
+// kconfig, or below the span of the run. It returns nil when traces are not
+// exported.
+func startSpan(kconfig, name string, attributes map[string]string) *traceSpan {
+	if activeTracer == nil {
+		return nil
+	}
+
+	return activeTracer.start(kconfig, name, attributes)
+}
+
+func (t *tracer) start(kconfig, name string, attributes map[string]string) *traceSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &traceSpan{
+		SpanID:     randomHex(8),
+		ParentID:   t.parentOf(kconfig),
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: attributes,
+		kconfig:    kconfig,
+	}
+	t.active[kconfig] = append(t.active[kconfig], span)
+
+	return span
+}
+
+func (t *tracer) parentOf(kconfig string) string {
+	if spans := t.active[kconfig]; len(spans) > 0 {
+		return spans[len(spans)-1].SpanID
+	}
+
+	return t.rootID
+}
+
+// end finishes the span, recording err as its error.
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+
+	t := activeTracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	t.active[s.kconfig] = slices.DeleteFunc(t.active[s.kconfig], func(active *traceSpan) bool {
+		return active == s
+	})
+	t.spans = append(t.spans, s)
+}
+
+// traceCommand returns the command that runs cmd through the trace shim, or
+// cmd when traces are not exported.
+func traceCommand(kconfig string, cmd *exec.Cmd, args []string) *exec.Cmd {
+	if activeTracer == nil {
+		return cmd
+	}
+
+	activeTracer.mu.Lock()
+	span := traceSpan{
+		SpanID:     randomHex(8),
+		ParentID:   activeTracer.parentOf(kconfig),
+		Name:       "oc " + args[0],
+		Attributes: map[string]string{"oc.args": strings.Join(redactArgs(args), " ")},
+	}
+	activeTracer.mu.Unlock()
+
+	data, err := json.Marshal(span)
+	if err != nil {
+		return cmd
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+
+	traced := exec.Command(executable, append([]string{traceShimCommand, cmd.Path}, cmd.Args[1:]...)...)
+	traced.Env = append(cmd.Environ(), traceFileEnv+"="+activeTracer.fileName, traceSpanEnv+"="+string(data))
+
+	return traced
+}
+
+// runTraceShim runs the command of args and appends its span to the trace
+// file. It exits with the exit code of the command.
+func runTraceShim(args []string) {
+	span := traceSpan{}
+	_ = json.Unmarshal([]byte(os.Getenv(traceSpanEnv)), &span)
+	span.Start = time.Now()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 1
+	}
+
+	span.End = time.Now()
+	if span.Attributes == nil {
+		span.Attributes = map[string]string{}
+	}
+	span.Attributes["oc.exit_code"] = strconv.Itoa(exitCode)
+	if exitCode != 0 {
+		span.Error = "exit status " + strconv.Itoa(exitCode)
+	}
+
+	// Spans of commands run in parallel are written with a single append
+	// each, which keeps their lines apart.
+	if data, err := json.Marshal(span); err == nil {
+		if file, err := os.OpenFile(os.Getenv(traceFileEnv), os.O_WRONLY|os.O_APPEND, 0); err == nil {
+			_, _ = file.Write(append(data, '\n'))
+			file.Close()
+		}
+	}
+
+	os.Exit(exitCode)
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []otlpAttribute{}
+	for _, key := range keys {
+		attribute := otlpAttribute{Key: key}
+		attribute.Value.StringValue = redactText(attributes[key])
+		result = append(result, attribute)
+	}
+
+	return result
+}
+
+func (t *tracer) otlpSpan(span *traceSpan) otlpSpan {
+	s := otlpSpan{
+		TraceID:           t.traceID,
+		SpanID:            span.SpanID,
+		ParentSpanID:      span.ParentID,
+		Name:              redactText(span.Name),
+		Kind:              1, // internal
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes),
+	}
+	s.Status.Code = 1 // ok
+	if span.Error != "" {
+		s.Status.Code = 2 // error
+		s.Status.Message = redactText(span.Error)
+	}
+
+	return s
+}
+
+// runSpans returns the span of the run and every span below it. Spans still
+// active, e.g. when the run fails, end with the run.
+func (t *tracer) runSpans(report *runReport) ([]*traceSpan, error) {
+	root := &traceSpan{
+		SpanID:     t.rootID,
+		Name:       report.Command,
+		Start:      report.StartTime,
+		End:        report.EndTime,
+		Attributes: map[string]string{"run.id": report.ID, "run.outcome": report.Outcome},
+		Error:      report.Error,
+	}
+
+	t.mu.Lock()
+	spans := append([]*traceSpan{root}, t.spans...)
+	for _, active := range t.active {
+		for _, span := range active {
+			span.End = report.EndTime
+			spans = append(spans, span)
+		}
+	}
+	t.mu.Unlock()
+
+	data, err := os.ReadFile(t.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trace file: %v", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var span traceSpan
+		if err := json.Unmarshal([]byte(line), &span); err != nil {
+			continue
+		}
+		spans = append(spans, &span)
+	}
+
+	return spans, nil
+}
+
+// export sends the trace of the run to the OTLP endpoint.
+func (t *tracer) export(report *runReport) error {
+	defer os.Remove(t.fileName)
+
+	spans, err := t.runSpans(report)
+	if err != nil {
+		return err
+	}
+
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "odfdr-installer"
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, t.otlpSpan(span))
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = otlpAttributes(map[string]string{"service.name": "odfdr-installer"})
+
+	data, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{resource}})
+	if err != nil {
+		return fmt.Errorf("error encoding trace: %v", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error exporting trace: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error exporting trace: %s", resp.Status)
+	}
+
+	slog.Info("exported trace", "traceID", t.traceID, "spans", len(spans))
+
+	return nil
+}
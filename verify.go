@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// getServerURL returns the API server URL of the cluster of kconfig.
+func getServerURL(kconfig string) (string, error) {
+	whoamiCmd := ocCommand(kconfig, "whoami", "--show-server")
+	whoamiOutput, err := whoamiCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting API server URL: %v", err)
+	}
+
+	return strings.TrimSpace(string(whoamiOutput)), nil
+}
+
+func printOperatorVersions(clusterVersions map[string]map[string]string) {
+	clusters := sortedClusterNames(clusterVersions)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATOR\t"+strings.Join(clusters, "\t"))
+	for _, pkg := range drOperatorPackages {
+		row := []string{pkg}
+		for _, cluster := range clusters {
+			version, ok := clusterVersions[cluster][pkg]
+			if !ok {
+				version = "-"
+			}
+			row = append(row, version)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var kubeconfigs stringList
+	flags.Var(&kubeconfigs, "kubeconfig", "Kubeconfig of a cluster to verify (can be repeated)")
+	reportFlag := flags.String("report", "verify-report.json", "File to write the verification report to")
+	readyFileFlag := flags.String("ready-file", "", "File to write once the DR pair is verified operational")
+	fleetFlag := flags.String("fleet", "", "Fleet file whose DR pairs to check, printing a scorecard instead of the operator versions")
+	sinceFlag := flags.String("since", "", "With -fleet, only evaluate the checks whose resources changed since a verify run of the history, by ID or last")
+	bastionFlag := addBastionFlag(flags)
+	addPrintCommandsFlag(flags)
+	addTempDirFlags(flags)
+	addFixtureFlags(flags)
+	addJSONLogsFlag(flags)
+	addReadSelectorFlag(flags)
+
+	flags.Parse(args)
+
+	if *bastionFlag != "" {
+		if err := startBastionProxy(*bastionFlag); err != nil {
+			slog.Error("error starting SSH bastion proxy", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *fleetFlag != "" {
+		if len(kubeconfigs) > 0 || *readyFileFlag != "" {
+			slog.Error("error: -fleet cannot be combined with -kubeconfig or -ready-file")
+			showUsageAndExit()
+		}
+
+		verifyFleet(*fleetFlag, *reportFlag, *sinceFlag)
+		return
+	}
+
+	if *sinceFlag != "" {
+		slog.Error("error: -since requires -fleet")
+		showUsageAndExit()
+	}
+
+	if len(kubeconfigs) == 0 {
+		slog.Error("error: at least one kubeconfig is required")
+		showUsageAndExit()
+	}
+
+	// A ready file left over from an earlier run must not signal readiness.
+	if *readyFileFlag != "" {
+		if err := os.Remove(*readyFileFlag); err != nil && !os.IsNotExist(err) {
+			slog.Error("error removing ready file", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	report := newRunReport("verify")
+	clusterVersions := map[string]map[string]string{}
+	clusterKconfigs := map[string]string{}
+
+	for _, kconfig := range kubeconfigs {
+		url, err := getServerURL(kconfig)
+		if err != nil {
+			exitWithFailedRun(report, *reportFlag, "error verifying cluster "+kconfig, err)
+		}
+
+		clusterName, err := getClusterName(url)
+		if err != nil {
+			exitWithFailedRun(report, *reportFlag, "error getting cluster name", err)
+		}
+
+		versions, err := getOperatorVersions(kconfig)
+		if err != nil {
+			exitWithFailedRun(report, *reportFlag, "error getting operator versions of "+clusterName, err)
+		}
+
+		clusterVersions[clusterName] = versions
+		clusterKconfigs[clusterName] = kconfig
+		report.cluster(clusterName).URL = url
+		report.cluster(clusterName).OperatorVersions = versions
+	}
+
+	printOperatorVersions(clusterVersions)
+
+	report.VersionMismatches = compareOperatorVersions(clusterVersions)
+	for _, mismatch := range report.VersionMismatches {
+		slog.Warn("operator version mismatch between clusters", "operator", mismatch.Package, "versions", mismatch.Versions)
+	}
+
+	var verifyErr error
+	if len(report.VersionMismatches) > 0 {
+		verifyErr = fmt.Errorf("found %d operator version mismatches", len(report.VersionMismatches))
+	} else if *readyFileFlag != "" {
+		verifyErr = checkDRPairReady(clusterKconfigs, clusterVersions)
+	}
+
+	if err := report.finish(*reportFlag, verifyErr); err != nil {
+		slog.Error("error writing report", "error", err)
+		os.Exit(1)
+	}
+
+	if verifyErr != nil {
+		slog.Error("verification failed", "error", verifyErr)
+		os.Exit(1)
+	}
+
+	if *readyFileFlag != "" {
+		if err := writeReadyFile(*readyFileFlag, report); err != nil {
+			slog.Error("error writing ready file", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("DR pair is ready", "readyFile", *readyFileFlag)
+	}
+}
+
+// verifyFleet checks the DR readiness of every pair of a fleet and prints a
+// scorecard. The verification fails unless every pair passed. With since, the
+// checks that passed in that run and whose resources did not change are
+// taken from it.
+func verifyFleet(fileName, reportFileName, since string) {
+	f, err := loadFleet(fileName)
+	if err != nil {
+		slog.Error("error loading fleet", "error", err)
+		os.Exit(1)
+	}
+
+	var previous *fleetScorecard
+	if since != "" {
+		previous, err = lastScorecard(since)
+		if err != nil {
+			slog.Error("error loading previous verification", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	report := newRunReport("verify")
+	report.Scorecard = scoreFleet(f, report, previous)
+	printScorecard(os.Stdout, report.Scorecard)
+
+	var verifyErr error
+	if failed := len(report.Scorecard.Rows) - report.Scorecard.Passed; failed > 0 {
+		verifyErr = fmt.Errorf("%d of %d DR pairs failed", failed, len(report.Scorecard.Rows))
+	}
+
+	if err := report.finish(reportFileName, verifyErr); err != nil {
+		slog.Error("error writing report", "error", err)
+		os.Exit(1)
+	}
+
+	if verifyErr != nil {
+		slog.Error("verification failed", "error", verifyErr)
+		os.Exit(1)
+	}
+}
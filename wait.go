@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// waitFor polls check every interval until it reports done, returns an error
+// or timeout expires. kconfig is the cluster waited on, if any, whose trace
+// the wait is part of.
+func waitFor(kconfig, description string, timeout, interval time.Duration, check func() (bool, error)) (err error) {
+	span := startSpan(kconfig, "wait for "+description, nil)
+	defer func() { span.end(err) }()
+
+	deadline := time.Now().Add(timeout)
+	w := activeWaits.start(kconfig, description, deadline)
+	defer activeWaits.done(w)
+
+	for {
+		if err := siblingFailed(kconfig); err != nil {
+			return err
+		}
+
+		done, err := check()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, description)
+		}
+
+		activeWaits.progress(w)
+		time.Sleep(interval)
+	}
+}
+
+// maxRebootExtension limits how much longer waitAcrossReboots waits while
+// nodes reboot.
+const maxRebootExtension = 30 * time.Minute
+
+// waitAcrossReboots is waitFor for resources served by pods that are
+// recreated when the nodes reboot, e.g. after the mirror sets are rolled
+// out. Errors of check, e.g. while the API server restarts, are retried
+// until the timeout, and the time spent while a node reboots does not count
+// towards the timeout.
+func waitAcrossReboots(kconfig, description string, timeout, interval time.Duration, check func() (bool, error)) (err error) {
+	span := startSpan(kconfig, "wait for "+description, nil)
+	defer func() { span.end(err) }()
+
+	deadline := time.Now().Add(timeout)
+	extended := time.Duration(0)
+	w := activeWaits.start(kconfig, description, deadline)
+	defer activeWaits.done(w)
+
+	for {
+		if err := siblingFailed(kconfig); err != nil {
+			return err
+		}
+
+		done, err := check()
+		if err == nil && done {
+			return nil
+		}
+
+		if err != nil {
+			slog.Warn("error while waiting, retrying", "for", description, "error", err)
+		}
+
+		if node, rebooting := nodeRebooting(kconfig); rebooting && extended < maxRebootExtension {
+			slog.Info("node is rebooting, extending wait", "for", description, "node", node)
+			deadline = deadline.Add(interval)
+			extended += interval
+			activeWaits.extend(w, deadline)
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out after %v waiting for %s: %v", timeout+extended, description, err)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s", timeout+extended, description)
+		}
+
+		activeWaits.progress(w)
+		time.Sleep(interval)
+	}
+}
+
+// nodeRebooting returns a node that is being drained, rebooted or updated by
+// the machine config daemon, if any.
+func nodeRebooting(kconfig string) (string, bool) {
+	var nodes struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+			Spec struct {
+				Unschedulable bool `json:"unschedulable"`
+			} `json:"spec"`
+			conditionedObject
+		} `json:"items"`
+	}
+	// The nodes cannot be listed while the API server is down, which is
+	// not a reboot in itself.
+	if _, err := getJSON(kconfig, &nodes, "nodes"); err != nil {
+		return "", false
+	}
+
+	for _, node := range nodes.Items {
+		ready, _ := conditionStatus(node.Status.Conditions, "Ready")
+		if node.Spec.Unschedulable || ready.Status != "True" ||
+			node.Metadata.Annotations["machineconfiguration.openshift.io/state"] == "Working" {
+			return node.Metadata.Name, true
+		}
+	}
+
+	return "", false
+}
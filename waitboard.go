@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raghavendra-talur/odfdr-installer/engine"
+)
+
+// combinedViewInterval is how often the combined view of the waits running in
+// parallel is logged.
+const combinedViewInterval = 30 * time.Second
+
+// activeWait is a wait in progress, shown in the combined view.
+type activeWait struct {
+	kconfig     string
+	description string
+	deadline    time.Time
+}
+
+// waitBoard keeps the waits in progress, so that parallel waits, like those
+// of the two clusters of a DR pair, are logged as one view with their
+// remaining time instead of interleaved lines.
+type waitBoard struct {
+	mu         sync.Mutex
+	waits      map[*activeWait]bool
+	names      map[string]string
+	lastLogged time.Time
+}
+
+var activeWaits = &waitBoard{waits: map[*activeWait]bool{}, names: map[string]string{}}
+
+// nameCluster names the cluster of kconfig in the combined view.
+func (b *waitBoard) nameCluster(kconfig, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.names[kconfig] = name
+}
+
+func (b *waitBoard) start(kconfig, description string, deadline time.Time) *activeWait {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := &activeWait{kconfig: kconfig, description: description, deadline: deadline}
+	b.waits[w] = true
+
+	return w
+}
+
+func (b *waitBoard) done(w *activeWait) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.waits, w)
+}
+
+// extend moves the deadline of a wait, like when nodes reboot.
+func (b *waitBoard) extend(w *activeWait, deadline time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w.deadline = deadline
+}
+
+// progress logs that w is still waiting and tells the observers of the step
+// engine. With other waits in progress, all of them are logged together every
+// combinedViewInterval instead.
+func (b *waitBoard) progress(w *activeWait) {
+	b.mu.Lock()
+	cluster, remaining := b.names[w.kconfig], time.Until(w.deadline)
+	b.mu.Unlock()
+	engine.WaitProgress(cluster, w.description, remaining)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.waits) < 2 {
+		slog.Info("waiting", "for", w.description, "remaining", time.Until(w.deadline).Round(time.Second))
+		return
+	}
+
+	if time.Since(b.lastLogged) < combinedViewInterval {
+		return
+	}
+	b.lastLogged = time.Now()
+
+	lines := []string{}
+	for wait := range b.waits {
+		name := b.names[wait.kconfig]
+		if name == "" {
+			name = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s left)", name, wait.description, time.Until(wait.deadline).Round(time.Second)))
+	}
+	sort.Strings(lines)
+
+	slog.Info("waiting in parallel", "waits", strings.Join(lines, "; "))
+}
+
+// siblingGroup are clusters set up together, like the clusters of a DR pair.
+// Once one of them failed, waiting for the others is pointless, so their
+// waits and steps stop with the error of the group.
+type siblingGroup struct {
+	mu  sync.Mutex
+	err error
+}
+
+var (
+	siblingsMu      sync.Mutex
+	clusterSiblings = map[string]*siblingGroup{}
+)
+
+func (g *siblingGroup) join(kconfig string) {
+	siblingsMu.Lock()
+	defer siblingsMu.Unlock()
+
+	clusterSiblings[kconfig] = g
+}
+
+// cancel stops the waits and steps of the clusters of the group, keeping the
+// first error.
+func (g *siblingGroup) cancel(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// siblingFailed returns the error of a failed sibling of the cluster of
+// kconfig, if any.
+func siblingFailed(kconfig string) error {
+	siblingsMu.Lock()
+	g := clusterSiblings[kconfig]
+	siblingsMu.Unlock()
+
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return fmt.Errorf("cancelled: %v", g.err)
+	}
+
+	return nil
+}
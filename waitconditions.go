@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultWaitConditionTimeout = 10 * time.Minute
+
+// waitCondition is a readiness gate of the environment, evaluated by a step
+// of its own after one of the prepare steps.
+type waitCondition struct {
+	Name string `json:"name"`
+	// After is the prepare step the condition is evaluated after.
+	After    string      `json:"after"`
+	Resource resourceRef `json:"resource"`
+	// JSONPath selects the value of the resource, like
+	// {.status.phase}, and is evaluated by oc.
+	JSONPath string `json:"jsonPath"`
+	// Value is the expected value. Without it, any value that is not empty
+	// is accepted.
+	Value string `json:"value,omitempty"`
+	// Timeout is like 5m, 10m by default.
+	Timeout string `json:"timeout,omitempty"`
+
+	timeout time.Duration
+}
+
+type resourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// resource returns the resource type in the fully qualified form of oc get,
+// like Deployment.v1.apps, or the kind of the core API.
+func (r resourceRef) resource() string {
+	group, version, found := strings.Cut(r.APIVersion, "/")
+	if !found {
+		return r.Kind
+	}
+
+	return r.Kind + "." + version + "." + group
+}
+
+func (r resourceRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + " " + r.Name
+	}
+
+	return r.Kind + " " + r.Namespace + "/" + r.Name
+}
+
+// validateWaitConditions checks the wait conditions and fills in the
+// defaults.
+func validateWaitConditions(conditions []waitCondition) error {
+	names := []string{}
+	for i := range conditions {
+		c := &conditions[i]
+		if c.Name == "" {
+			return fmt.Errorf("wait condition %d has no name", i)
+		}
+		if slices.Contains(names, c.Name) {
+			return fmt.Errorf("wait condition %s is defined more than once", c.Name)
+		}
+		names = append(names, c.Name)
+
+		if !slices.Contains(prepareStepNames(), c.After) {
+			return fmt.Errorf("wait condition %s is after unknown step %q, known steps: %s", c.Name, c.After,
+				strings.Join(prepareStepNames(), ", "))
+		}
+
+		if c.Resource.APIVersion == "" || c.Resource.Kind == "" || c.Resource.Name == "" {
+			return fmt.Errorf("wait condition %s needs the apiVersion, kind and name of the resource", c.Name)
+		}
+
+		if !strings.HasPrefix(c.JSONPath, "{") || !strings.HasSuffix(c.JSONPath, "}") {
+			return fmt.Errorf("wait condition %s has JSONPath %q, expected an expression like {.status.phase}", c.Name, c.JSONPath)
+		}
+
+		c.timeout = defaultWaitConditionTimeout
+		if c.Timeout != "" {
+			timeout, err := time.ParseDuration(c.Timeout)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("wait condition %s has invalid timeout %q", c.Name, c.Timeout)
+			}
+			c.timeout = timeout
+		}
+	}
+
+	return nil
+}
+
+// waitForCondition waits until the JSONPath of the resource has the expected
+// value. A missing resource is waited for like a wrong value, and so is a
+// kind the cluster does not serve yet, like the CRD of an operator that is
+// still installing.
+func waitForCondition(kconfig string, c waitCondition) error {
+	args := []string{"get", c.Resource.resource(), c.Resource.Name, "--ignore-not-found", "-o", "jsonpath=" + c.JSONPath}
+	if c.Resource.Namespace != "" {
+		args = append(args, "-n", c.Resource.Namespace)
+	}
+
+	unknownKind := false
+	err := waitFor(kconfig, "condition "+c.Name, c.timeout, 10*time.Second, func() (bool, error) {
+		output, err := ocCommand(kconfig, args...).Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && isUnknownKind(string(exitErr.Stderr)) {
+			unknownKind = true
+			return false, nil
+		}
+		unknownKind = false
+		if err != nil {
+			return false, fmt.Errorf("error getting %s: %v", c.Resource, err)
+		}
+
+		value := strings.TrimSpace(string(output))
+		if c.Value == "" {
+			return value != "", nil
+		}
+
+		return value == c.Value, nil
+	})
+	if err != nil && unknownKind {
+		return fmt.Errorf("%v, the cluster does not serve %s %s", err, c.Resource.APIVersion, c.Resource.Kind)
+	}
+
+	return err
+}
+
+// isUnknownKind reports whether the error output of oc is about a kind or
+// resource type the cluster does not serve.
+func isUnknownKind(stderr string) bool {
+	return strings.Contains(stderr, "no matches for kind") || strings.Contains(stderr, "doesn't have a resource type")
+}
+
+// withWaitConditions inserts a step for every wait condition after the step
+// it waits after.
+func withWaitConditions(kconfig string, steps []step, conditions []waitCondition) []step {
+	if len(conditions) == 0 {
+		return steps
+	}
+
+	result := []step{}
+	for _, s := range steps {
+		result = append(result, s)
+
+		for _, c := range conditions {
+			if c.After != s.name {
+				continue
+			}
+
+			result = append(result, step{
+				name:  "wait-" + c.Name,
+				after: []string{c.After},
+				run: func() error {
+					return waitForCondition(kconfig, c)
+				},
+				describe: func() string {
+					expected := "a value"
+					if c.Value != "" {
+						expected = fmt.Sprintf("%q", c.Value)
+					}
+
+					return fmt.Sprintf("Wait up to %s for %s of %s %s to be %s.", c.timeout, c.JSONPath, c.Resource.APIVersion, c.Resource, expected)
+				},
+			})
+		}
+	}
+
+	return result
+}
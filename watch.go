@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+)
+
+// minWatchInterval limits how often watchFor checks, however often the
+// watched resources change, so that many installers waiting on the same hub
+// do not flood its API server.
+const minWatchInterval = 2 * time.Second
+
+// watchResources starts watching the resources given by args, like
+// "clusterserviceversions -n ns", and returns a channel that receives a
+// value when they change. The channel is closed when the watch ends, like
+// when the connection is lost, and the returned stop function ends it.
+func watchResources(kconfig string, args []string) (<-chan struct{}, func()) {
+	changes := make(chan struct{}, 1)
+
+	// The watch runs oc directly, not through the fixture shim of
+	// -debug-capture or the trace shim: killing a shim would leave its oc
+	// watching, and a watch that never ends makes no useful fixture entry
+	// or span.
+	watchArgs := append(append([]string{"get"}, args...), "--watch-only", "-o", "name")
+	if printCommands {
+		printOCCommand(kconfig, watchArgs)
+	}
+	cmd := exec.Command("oc", connectionFor(kconfig).ocArgs(watchArgs)...)
+	cmd.Env = append(cmd.Environ(), "KUBECONFIG="+kconfig)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		slog.Debug("error starting watch, polling instead", "args", args, "error", err)
+		close(changes)
+		return changes, func() {}
+	}
+
+	go func() {
+		defer close(changes)
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case changes <- struct{}{}:
+			default:
+				// A check is pending already.
+			}
+		}
+
+		if err := cmd.Wait(); err != nil {
+			slog.Debug("watch ended, polling instead", "args", args, "error", err)
+		}
+	}()
+
+	return changes, func() {
+		_ = cmd.Process.Kill()
+	}
+}
+
+// watchFor is waitFor reacting to changes of the watched resources within
+// seconds rather than at the next poll. check runs when the resources given
+// by watch change, at most every minWatchInterval, and every resync without
+// changes, in case a change was missed. When the watch ends or cannot be
+// started, like in recorded or replayed runs, check is polled every resync.
+func watchFor(kconfig, description string, timeout, resync time.Duration, watch []string, check func() (bool, error)) (err error) {
+	span := startSpan(kconfig, "wait for "+description, nil)
+	defer func() { span.end(err) }()
+
+	deadline := time.Now().Add(timeout)
+	w := activeWaits.start(kconfig, description, deadline)
+	defer activeWaits.done(w)
+
+	var changes <-chan struct{}
+	if fixtureMode == "" {
+		var stop func()
+		changes, stop = watchResources(kconfig, watch)
+		defer stop()
+	}
+
+	for {
+		if err := siblingFailed(kconfig); err != nil {
+			return err
+		}
+
+		checked := time.Now()
+		done, err := check()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, description)
+		}
+
+		activeWaits.progress(w)
+
+		select {
+		case _, open := <-changes:
+			if !open {
+				// A nil channel never receives, check is polled from
+				// now on.
+				changes = nil
+			}
+			time.Sleep(time.Until(checked.Add(minWatchInterval)))
+		case <-time.After(resync):
+		}
+	}
+}